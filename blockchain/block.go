@@ -2,8 +2,13 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // A Block is used to represent an item of a blockchain.
@@ -12,6 +17,26 @@ type Block struct {
 	Transactions []*Transaction
 	PrevHash     []byte
 	Nonce        int
+	Height       int                   // number of blocks preceding the block, 0 for the Genesis
+	Timestamp    int64                 // unix time of the block creation
+	Difficulty   int                   // number of leading zero bits of the block hash
+	Signer       []byte                // public key of the proposer of a signed block
+	Signature    []byte                // signature of the block hash by the Signer
+	Evidence     []*DoubleSignEvidence // proofs of proposers signing two blocks at the same height
+}
+
+// A BlockHeader is the data a block hash commits to, the transactions and the evidence are replaced by their hashes.
+// Signed headers are enough to prove that a proposer signed two different blocks at the same height.
+type BlockHeader struct {
+	PrevHash         []byte
+	TransactionsHash []byte
+	EvidenceHash     []byte // empty if the block carries no evidence
+	Height           int
+	Timestamp        int64
+	Nonce            int
+	Difficulty       int
+	Signer           []byte
+	Signature        []byte
 }
 
 // Hash transactions within a block to provide unique has for our PoW algorithm.
@@ -27,21 +52,89 @@ func (b *Block) HashTransactions() []byte {
 	return txHash[:]
 }
 
-// Creates a new block from transactions and prevHash.
-func CreateBlock(txs []*Transaction, prevHash []byte) *Block {
-	block := &Block{[]byte{}, txs, prevHash, 0}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
+// Hashes the evidence within a block, returns nil if there is none so that blocks without evidence hash as before.
+func (b *Block) HashEvidence() []byte {
+	if len(b.Evidence) == 0 {
+		return nil
+	}
+	var hashes [][]byte
+	for _, evidence := range b.Evidence {
+		hashes = append(hashes, evidence.Hash())
+	}
+	hash := sha256.Sum256(bytes.Join(hashes, []byte{}))
+	return hash[:]
+}
+
+// Gets the header of a block.
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		PrevHash:         b.PrevHash,
+		TransactionsHash: b.HashTransactions(),
+		EvidenceHash:     b.HashEvidence(),
+		Height:           b.Height,
+		Timestamp:        b.Timestamp,
+		Nonce:            b.Nonce,
+		Difficulty:       b.Difficulty,
+		Signer:           b.Signer,
+		Signature:        b.Signature,
+	}
+}
+
+// Serializes the hashed fields of a header. The nonce is followed only by the difficulty,
+// so miners can replace it at a fixed offset.
+func (h *BlockHeader) data() []byte {
+	return bytes.Join(
+		[][]byte{
+			h.PrevHash,
+			h.TransactionsHash,
+			h.EvidenceHash,
+			ToBytes(int64(h.Height)),
+			ToBytes(h.Timestamp),
+			ToBytes(int64(h.Nonce)),
+			ToBytes(int64(h.Difficulty)),
+		},
+		[]byte{},
+	)
+}
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+// Computes the hash of the block the header belongs to.
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.data())
+	return hash[:]
+}
+
+// Creates a new block from transactions and prevHash at a height of the chain, its proof of work is not computed yet.
+func NewBlock(txs []*Transaction, prevHash []byte, height, difficulty int) *Block {
+	return &Block{[]byte{}, txs, prevHash, 0, height, time.Now().Unix(), difficulty, nil, nil, nil}
+}
+
+// Creates a new block from transactions and prevHash at a height of the chain and mines it on all CPU cores.
+func CreateBlock(txs []*Transaction, prevHash []byte, height, difficulty int) *Block {
+	block := NewBlock(txs, prevHash, height, difficulty)
+	err := NewMiner().Mine(context.Background(), block)
+	HandleError(err)
 
 	return block
 }
 
 // Creates a new Geneis block from a coinbase transaction.
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{})
+func Genesis(coinbase *Transaction, difficulty int) *Block {
+	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0, difficulty)
+}
+
+// Hashes the block header, which is the data sealed by consensus engines.
+func (b *Block) HeaderHash() []byte {
+	return NewProof(b).Hash()
+}
+
+// Sets the extra nonce of the block's coinbase transaction, returns false if the block has no coinbase.
+func (b *Block) SetExtraNonce(extraNonce uint64) bool {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return false
+	}
+	encoded := make([]byte, ExtraNonceSize)
+	binary.BigEndian.PutUint64(encoded, extraNonce)
+	return b.Transactions[0].SetExtraNonce(encoded) == nil
 }
 
 // Serialize a block using gob encoder.
@@ -53,6 +146,11 @@ func (b *Block) Serialize() []byte {
 	return res.Bytes()
 }
 
+// Gets the size of a serialized block without the type definitions gob sends ahead of it, see Transaction.Size.
+func (b *Block) Size() int {
+	return encodedSize(&Block{}, b)
+}
+
 // Deserialize a data into a new block using gob decoder.
 func Deserialize(data []byte) *Block {
 	var block Block
@@ -61,3 +159,12 @@ func Deserialize(data []byte) *Block {
 	HandleError(err)
 	return &block
 }
+
+// Decodes a block serialized by Serialize, unlike Deserialize it reports invalid data.
+func DecodeBlock(data []byte) (*Block, error) {
+	var block Block
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&block); err != nil {
+		return nil, errors.Wrap(err, "failed to decode block")
+	}
+	return &block, nil
+}
@@ -2,145 +2,325 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"encoding/gob"
 	"encoding/hex"
-	"os"
-	"runtime"
+	"fmt"
+	"sync"
+	"time"
 
-	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
+
+	"github.com/michaljirman/goblockchain/script"
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
 )
 
 const (
-	dbPath      = "./tmp/blocks"
-	dbFile      = "./tmp/blocks/MANIFEST"
 	genesisData = "First Transaction from Genesis"
 	lastHashKey = "last_hash"
+	paramsKey   = "params"
 )
 
-// A BlockChain definition with the BadgerDB configured as a DB.
+// A BlockChain definition with a storage backend configured as a DB.
+// A BlockChain is safe for concurrent use: queries read the chain as of the last block when they started,
+// while blocks are connected one at a time.
 type BlockChain struct {
-	LastHash []byte
-	Database *badger.DB
+	lastHash []byte
+	tipMu    sync.RWMutex // guards lastHash
+	// serializes connecting blocks, so a block is validated against the tip it is stored on
+	connectMu sync.Mutex
+	events    *eventBus
+	// hash of the first stored block if the chain starts from a UTXO snapshot or is pruned, nil if it starts with the Genesis
+	snapshotBase []byte
+	// guards snapshotBase and the blocks preceding the tip, which change when the chain is pruned
+	history historyGuard
+	// serializes pruning and changes of the UTXO snapshot, it is not held while blocks are connected
+	pruneMu sync.Mutex
+	// blocks kept when the chain is pruned, guarded by pruneMu
+	pruneTarget PruneTarget
+
+	Database storage.Store
+	Params   Params
+	Miner    *Miner
+	Engine   Engine
+	Wallets  *wallet.Wallets // wallets of local proposers, needed by engines selecting proposers
 }
 
 // A BlockChain iterator allowing to iterate over items in a BlockChain DB.
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Database    storage.Store
 }
 
-// Checks if a BlockChain DB exist by checking for a presence of a dbFile.
-func DBexists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
-		return false
+// Opens an existing Blockchain DB in a directory, its storage backend is detected from the files in it.
+func OpenBlockChain(dir string) (*BlockChain, error) {
+	backend := storage.Detect(dir)
+	if backend == "" {
+		return nil, errors.Errorf("no blockchain found in %s", dir)
+	}
+	store, err := storage.Open(backend, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blockchain DB")
+	}
+	chain, err := LoadBlockChain(store)
+	if err != nil {
+		store.Close()
+		return nil, err
 	}
-	return true
+	return chain, nil
 }
 
-// Initialise a Blockchain from an existing DB.
-func ContinueBlockChain(address string) *BlockChain {
-	if DBexists() == false {
-		log.Warn().Msg("No existing blockchain found. A new BlockChain needs to be created.")
-		runtime.Goexit()
+// Loads a Blockchain from a store holding one, the store is migrated to the current schema version first.
+func LoadBlockChain(store storage.Store) (*BlockChain, error) {
+	if _, err := store.Get([]byte(lastHashKey)); err != nil {
+		return nil, errors.Wrap(err, "failed to load blockchain")
+	}
+	if _, err := Migrate(store, false); err != nil {
+		return nil, err
+	}
+	// migrations may convert blocks, so the tip is loaded after them
+	lastHash, err := store.Get([]byte(lastHashKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load blockchain")
 	}
-	var lastHash []byte
-	opts := badger.DefaultOptions(dbPath)
-	db, err := badger.Open(opts)
-	HandleError(err)
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(lastHashKey))
-		HandleError(err)
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-			return nil
-		})
-		HandleError(err)
-		return err
-	})
-	HandleError(err)
-	return &BlockChain{lastHash, db}
+	encodedParams, err := store.Get([]byte(paramsKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load network parameters")
+	}
+	// gob leaves out zero fields, so they must not be decoded over another network's values
+	var params Params
+	if err := gob.NewDecoder(bytes.NewReader(encodedParams)).Decode(&params); err != nil {
+		return nil, errors.Wrap(err, "failed to decode network parameters")
+	}
+	chain, err := newBlockChain(lastHash, store, params)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := loadSnapshot(store)
+	if err == nil {
+		chain.snapshotBase = snapshot.BaseHash
+	} else if err != storage.ErrNotFound {
+		return nil, err
+	}
+	if chain.pruneTarget, err = loadPruneTarget(store); err != nil {
+		return nil, err
+	}
+	return chain, nil
 }
 
-// Initialise a new Blockchain using an address data provided.
-func InitBlockChain(address string) *BlockChain {
-	var lastHash []byte
+// Creates a BlockChain with the consensus engine of its network.
+func newBlockChain(lastHash []byte, store storage.Store, params Params) (*BlockChain, error) {
+	miner := NewMiner()
+	engine, err := NewEngine(params, miner)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockChain{
+		lastHash: lastHash,
+		events:   newEventBus(),
+		Database: store,
+		Params:   params,
+		Miner:    miner,
+		Engine:   engine,
+	}, nil
+}
 
-	if DBexists() {
-		log.Warn().Msg("Blockchain already exists")
-		runtime.Goexit()
+// Creates a new Blockchain DB in a directory with the Genesis block rewarding an address.
+func CreateBlockChain(dir, address string, params Params) (*BlockChain, error) {
+	return CreateBlockChainWithBackend(dir, storage.BadgerBackend, address, params)
+}
+
+// Creates a new Blockchain DB of a storage backend in a directory with the Genesis block rewarding an address.
+func CreateBlockChainWithBackend(dir, backend, address string, params Params) (*BlockChain, error) {
+	if backend == storage.MemoryBackend {
+		return nil, errors.New("blockchain in a directory can't be kept in memory")
+	}
+	if storage.Detect(dir) != "" {
+		return nil, errors.Errorf("blockchain already exists in %s", dir)
+	}
+	store, err := storage.Open(backend, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blockchain DB")
 	}
+	chain, err := NewBlockChain(store, address, params)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return chain, nil
+}
 
-	opts := badger.DefaultOptions(dbPath)
-	db, err := badger.Open(opts)
-	HandleError(err)
+// Creates a new Blockchain in an empty store with the Genesis block rewarding an address.
+func NewBlockChain(store storage.Store, address string, params Params) (*BlockChain, error) {
+	engine, err := NewEngine(params, NewMiner())
+	if err != nil {
+		return nil, err
+	}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
-		log.Debug().Msg("Genesis created")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		HandleError(err)
-		err = txn.Set([]byte(lastHashKey), genesis.Hash)
-		lastHash = genesis.Hash
-		return err
+	cbtx, err := CoinbaseTx(address, genesisData, 0)
+	if err != nil {
+		return nil, err
+	}
+	genesis := NewBlock([]*Transaction{cbtx}, []byte{}, 0, params.Difficulty)
+	if err := engine.Seal(context.Background(), genesis, nil); err != nil {
+		return nil, errors.Wrap(err, "failed to create blockchain")
+	}
+	log.Debug().Msg("Genesis created")
+
+	return newBlockChainFromGenesis(store, genesis, params)
+}
+
+// Creates a new Blockchain in an empty store starting with a sealed Genesis block.
+func newBlockChainFromGenesis(store storage.Store, genesis *Block, params Params) (*BlockChain, error) {
+	err := store.Update(func(batch storage.Batch) error {
+		if _, err := batch.Get([]byte(lastHashKey)); err != storage.ErrNotFound {
+			return errors.New("store already holds a blockchain")
+		}
+		if err := batch.Put(genesis.Hash, genesis.Serialize()); err != nil {
+			return err
+		}
+		if err := putParams(batch, params); err != nil {
+			return err
+		}
+		if err := putSchemaVersion(batch, SchemaVersion); err != nil {
+			return err
+		}
+		return batch.Put([]byte(lastHashKey), genesis.Hash)
 	})
-	HandleError(err)
-	return &BlockChain{lastHash, db}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create blockchain")
+	}
+	return newBlockChain(genesis.Hash, store, params)
 }
 
-// Adds a new block into the BlockChain DB.
-func (chain *BlockChain) AddBlock(transactions []*Transaction) {
-	var lastHash []byte
+// Returned when a block being mined no longer extends the last block of the chain.
+var ErrStaleBlock = errors.New("block doesn't extend the last block of the chain")
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(lastHashKey))
-		HandleError(errors.Wrap(err, ""))
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-			return nil
-		})
-		return err
-	})
-	HandleError(errors.Wrap(err, ""))
+// Adds a new block with transactions into the BlockChain DB, the first transaction has to be its coinbase.
+// The block is validated like a block mined outside of the node.
+func (chain *BlockChain) AddBlock(transactions []*Transaction) error {
+	_, err := chain.mineBlock(context.Background(), chain.LastBlock(), transactions)
+	return err
+}
 
-	newBlock := CreateBlock(transactions, lastHash)
+// Mines a block with transactions on top of lastBlock and connects it through SubmitBlock, so it is validated
+// against the chain while no other block is connected. Returns ErrStaleBlock if another block was added in the meantime.
+func (chain *BlockChain) mineBlock(ctx context.Context, lastBlock *Block, transactions []*Transaction) (*Block, error) {
+	proposer, err := chain.findProposerWallet(lastBlock, chain.Wallets)
+	if err != nil {
+		return nil, err
+	}
 
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		HandleError(errors.Wrap(err, ""))
-		err = txn.Set([]byte(lastHashKey), newBlock.Hash)
-		chain.LastHash = newBlock.Hash
-		return err
+	newBlock := NewBlock(transactions, lastBlock.Hash, lastBlock.Height+1, chain.Params.Difficulty)
+	// mining may move timestamps ahead of the clock, they must not decrease
+	if newBlock.Timestamp < lastBlock.Timestamp {
+		newBlock.Timestamp = lastBlock.Timestamp
+	}
+	if chain.Params.Consensus == ProofOfStakeConsensus {
+		newBlock.Evidence = chain.PendingEvidence()
+	}
+	if err := chain.Engine.Seal(ctx, newBlock, proposer); err != nil {
+		return nil, errors.Wrap(err, "failed to seal block")
+	}
+
+	if err := chain.SubmitBlock(newBlock); err != nil {
+		return nil, err
+	}
+	return newBlock, nil
+}
+
+// Stores a block extending the last block of the chain and makes it the new last block.
+// Returns ErrStaleBlock if the block doesn't extend the last block. The caller must hold connectMu.
+func (chain *BlockChain) storeBlock(newBlock *Block) error {
+	err := chain.Database.Update(func(batch storage.Batch) error {
+		lastHash, err := batch.Get([]byte(lastHashKey))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(lastHash, newBlock.PrevHash) {
+			return ErrStaleBlock
+		}
+
+		if err := batch.Put(newBlock.Hash, newBlock.Serialize()); err != nil {
+			return err
+		}
+		for _, evidence := range newBlock.Evidence {
+			if err := batch.Delete(evidenceKey(evidence.Signer())); err != nil {
+				return err
+			}
+		}
+		return batch.Put([]byte(lastHashKey), newBlock.Hash)
 	})
-	HandleError(errors.Wrap(err, ""))
+	if err == ErrStaleBlock {
+		return err
+	} else if err != nil {
+		return errors.Wrap(err, "failed to store block")
+	}
+
+	// the block is stored before it becomes the tip, so readers never see a tip missing from the DB
+	chain.tipMu.Lock()
+	chain.lastHash = newBlock.Hash
+	chain.tipMu.Unlock()
+
+	chain.publishBlockConnected(newBlock)
+	return nil
+}
+
+// Gets the hash of the last block of the BlockChain.
+func (chain *BlockChain) LastHash() []byte {
+	chain.tipMu.RLock()
+	defer chain.tipMu.RUnlock()
+	return chain.lastHash
+}
+
+// Gets the last block of the BlockChain.
+func (chain *BlockChain) LastBlock() *Block {
+	return chain.block(chain.LastHash())
+}
+
+// Gets a block of the BlockChain by its hash.
+func (chain *BlockChain) block(hash []byte) *Block {
+	return chain.iteratorAt(hash).Next()
+}
+
+// Mines a new block with transactions and a coinbase transaction rewarding the miner's address.
+// Mining is aborted when ctx is cancelled.
+func (chain *BlockChain) MineBlock(ctx context.Context, minerAddress string, transactions []*Transaction) (*Block, error) {
+	lastBlock := chain.LastBlock()
+	coinbase, err := CoinbaseTx(minerAddress, "", lastBlock.Height+1)
+	if err != nil {
+		return nil, err
+	}
+	return chain.mineBlock(ctx, lastBlock, append([]*Transaction{coinbase}, transactions...))
 }
 
 // Creates a new BlockChainIterator allowing easy iteration over a BlockChain DB.
-// This iterator is meant to iterates backwards by tracking a prevHash.
+// This iterator is meant to iterates backwards by tracking a prevHash. It starts at the last block
+// when it is created, so blocks added later are not seen by it.
 func (chain *BlockChain) Iterator() *BlockChainIterator {
-	iter := &BlockChainIterator{chain.LastHash, chain.Database}
-	return iter
+	return chain.iteratorAt(chain.LastHash())
+}
+
+// Creates a BlockChainIterator starting at the block with a hash.
+func (chain *BlockChain) iteratorAt(hash []byte) *BlockChainIterator {
+	return &BlockChainIterator{hash, chain.Database}
+}
+
+// Checks if a block is the first stored block of the chain, the Genesis or the base of the UTXO snapshot
+// the chain starts from. Iterations over the chain end with it.
+func (chain *BlockChain) IsFirstBlock(block *Block) bool {
+	return len(block.PrevHash) == 0 || chain.isSnapshotBase(block)
 }
 
 // Provides a next item from a currently used BlockChain DB using an BlockChainIterator.
 func (iter *BlockChainIterator) Next() *Block {
-	var block *Block
-
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		HandleError(errors.Wrap(err, ""))
-		var encodedBlock []byte
-		err = item.Value(func(val []byte) error {
-			encodedBlock = append([]byte{}, val...)
-			return nil
-		})
-		block = Deserialize(encodedBlock)
-		return err
-	})
+	encodedBlock, err := iter.Database.Get(iter.CurrentHash)
 	HandleError(errors.Wrap(err, ""))
+	block := Deserialize(encodedBlock)
 
 	iter.CurrentHash = block.PrevHash
 
@@ -163,10 +343,14 @@ func (iter *BlockChainIterator) Next() *Block {
 //      Inputs          Outputs
 //TX0     0               100
 //TX1     100              10
-func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
+func (chain *BlockChain) FindUnspentTransactions(lockingScript script.Script) []Transaction {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
 	var unspentTxs []Transaction
-	spentTXOs := make(map[string][]int)
-	iter := chain.Iterator()
+	tip := chain.LastHash()
+	spentTXOs := chain.findSpentOutputs(tip)
+	iter := chain.iteratorAt(tip)
 
 	for {
 		// Backward iteration, from the latest Block to the Genesis
@@ -183,85 +367,183 @@ func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transactio
 						}
 					}
 				}
-				if out.IsLockedWithKey(pubKeyHash) {
+				if out.IsLockedWithScript(lockingScript) {
 					unspentTxs = append(unspentTxs, *tx)
+					break
 				}
 			}
-			if tx.IsCoinbase() == false { // track (non coinbase) inputs in the spentTXOs
-				for _, in := range tx.Inputs {
-					if in.UsesKey(pubKeyHash) {
-						inTxID := hex.EncodeToString(in.ID)
-						spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
-					}
+		}
+
+		if chain.IsFirstBlock(block) {
+			break
+		}
+	}
+	return unspentTxs
+}
+
+// An UnspentOutput is an unspent transaction output together with the block it was created in.
+type UnspentOutput struct {
+	TxID     []byte
+	Index    int
+	Output   TxOutput
+	Height   int  // height of the block containing the transaction
+	Coinbase bool // the output belongs to a coinbase transaction
+}
+
+// Checks if an unspent output can be spent in a block at height.
+// Coinbase outputs have to reach the coinbase maturity first.
+func (utxo UnspentOutput) IsMature(height, coinbaseMaturity int) bool {
+	return !utxo.Coinbase || height-utxo.Height >= coinbaseMaturity
+}
+
+// Finds all unspent outputs locked with a locking script.
+func (chain *BlockChain) FindUnspentOutputs(lockingScript script.Script) []UnspentOutput {
+	return chain.findUnspentOutputs(chain.LastHash(), lockingScript)
+}
+
+// Finds all unspent outputs locked with a locking script in the chain ending with the block with the tip hash,
+// all unspent outputs if the locking script is nil.
+func (chain *BlockChain) findUnspentOutputs(tip []byte, lockingScript script.Script) []UnspentOutput {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	var unspentOuts []UnspentOutput
+	spentTXOs := chain.findSpentOutputs(tip)
+	iter := chain.iteratorAt(tip)
+
+	for {
+		block := iter.Next()
+		// outputs of the snapshot base are in the UTXO set of the snapshot
+		if chain.isSnapshotBase(block) {
+			break
+		}
+
+		for _, tx := range block.Transactions {
+			for outIdx, out := range tx.Outputs {
+				if (lockingScript == nil || out.IsLockedWithScript(lockingScript)) && !isSpent(spentTXOs, tx.ID, outIdx) {
+					unspentOuts = append(unspentOuts, UnspentOutput{tx.ID, outIdx, out, block.Height, tx.IsCoinbase()})
 				}
 			}
 		}
 
-		if len(block.PrevHash) == 0 {
+		if chain.IsFirstBlock(block) {
 			break
 		}
 	}
-	return unspentTxs
+
+	for _, utxo := range chain.snapshotOutputs() {
+		if (lockingScript == nil || utxo.Output.IsLockedWithScript(lockingScript)) && !isSpent(spentTXOs, utxo.TxID, utxo.Index) {
+			unspentOuts = append(unspentOuts, utxo)
+		}
+	}
+	return unspentOuts
 }
 
 // Finds all unspent transactions outputs.
-func (chain *BlockChain) FindUTXO(pubKeyHash []byte) []TxOutput {
+func (chain *BlockChain) FindUTXO(lockingScript script.Script) []TxOutput {
 	var UTXOs []TxOutput
-	unspentTransactions := chain.FindUnspentTransactions(pubKeyHash)
+	for _, utxo := range chain.FindUnspentOutputs(lockingScript) {
+		UTXOs = append(UTXOs, utxo.Output)
+	}
+	return UTXOs
+}
 
-	for _, tx := range unspentTransactions {
-		for _, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) {
-				UTXOs = append(UTXOs, out)
-			}
+// Gets the balance of outputs locked with a locking script. Coinbase outputs which can't be spent
+// in the next block yet are not part of the balance and are returned as the immature balance.
+func (chain *BlockChain) GetBalance(lockingScript script.Script) (int, int) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	balance := 0
+	immature := 0
+	tip := chain.LastHash()
+	nextHeight := chain.block(tip).Height + 1
+
+	for _, utxo := range chain.findUnspentOutputs(tip, lockingScript) {
+		if utxo.IsMature(nextHeight, chain.Params.CoinbaseMaturity) {
+			balance += utxo.Output.Value
+		} else {
+			immature += utxo.Output.Value
 		}
 	}
-	return UTXOs
+	return balance, immature
 }
 
-// Finds all unspent tokens which can be used as an spendable outputs.
-func (chain *BlockChain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+// Finds all unspent tokens which can be used as an spendable outputs in the next block.
+func (chain *BlockChain) FindSpendableOutputs(lockingScript script.Script, amount int) (int, map[string][]int) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
 	unspentOuts := make(map[string][]int)
-	unspentTxs := chain.FindUnspentTransactions(pubKeyHash)
+	tip := chain.LastHash()
+	nextHeight := chain.block(tip).Height + 1
 	accumulated := 0
 
-Work:
-	for _, tx := range unspentTxs {
-		txID := hex.EncodeToString(tx.ID)
+	for _, utxo := range chain.findUnspentOutputs(tip, lockingScript) {
+		if !utxo.IsMature(nextHeight, chain.Params.CoinbaseMaturity) {
+			continue
+		}
 
-		for outIdx, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-				accumulated += out.Value
-				unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+		txID := hex.EncodeToString(utxo.TxID)
+		accumulated += utxo.Output.Value
+		unspentOuts[txID] = append(unspentOuts[txID], utxo.Index)
 
-				if accumulated >= amount {
-					break Work
-				}
-			}
+		if accumulated >= amount {
+			break
 		}
 	}
 
 	return accumulated, unspentOuts
 }
 
+// Finds a transaction of the chain. Returns ErrBlockPruned if it is not found and the chain
+// doesn't store the blocks preceding its first block.
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := bc.Iterator()
+	tx, _, err := bc.FindTransactionBlock(ID)
+	return tx, err
+}
+
+// Finds an output of a transaction in the chain ending with the block with the tip hash, spent or not.
+// Outputs created before the base of a UTXO snapshot are found only if they were unspent at the base.
+func (chain *BlockChain) findOutput(tip []byte, txID []byte, index int) (UnspentOutput, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	tx, block, err := chain.findTransactionBlock(tip, txID)
+	if err != nil {
+		if utxo, snapshotErr := chain.snapshotOutput(txID, index); snapshotErr == nil {
+			return utxo, nil
+		}
+		return UnspentOutput{}, err
+	}
+	if index < 0 || index >= len(tx.Outputs) {
+		return UnspentOutput{}, errors.Errorf("output %x:%d does not exist", txID, index)
+	}
+	return UnspentOutput{tx.ID, index, tx.Outputs[index], block.Height, tx.IsCoinbase()}, nil
+}
+
+// Finds a transaction and the block containing it in the chain ending with the block with the tip hash.
+func (bc *BlockChain) findTransactionBlock(tip []byte, ID []byte) (Transaction, *Block, error) {
+	bc.history.rlock()
+	defer bc.history.runlock()
+
+	iter := bc.iteratorAt(tip)
 
 	for {
 		block := iter.Next()
 
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
+				return *tx, block, nil
 			}
 		}
 
-		if len(block.PrevHash) == 0 {
+		if bc.IsFirstBlock(block) {
 			break
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
+	return Transaction{}, nil, errors.New("Transaction does not exist")
 }
 
 func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
@@ -287,3 +569,151 @@ func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 
 	return tx.Verify(prevTXs)
 }
+
+// Finds all outputs referenced by inputs of transactions in the chain ending with the block with the tip hash.
+func (chain *BlockChain) findSpentOutputs(tip []byte) map[string][]int {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	spentTXOs := make(map[string][]int)
+	iter := chain.iteratorAt(tip)
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, in := range tx.Inputs {
+				inTxID := hex.EncodeToString(in.ID)
+				spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
+			}
+		}
+
+		if chain.IsFirstBlock(block) {
+			break
+		}
+	}
+	return spentTXOs
+}
+
+// Checks if an output is referenced by an input in spentTXOs.
+func isSpent(spentTXOs map[string][]int, txID []byte, outIdx int) bool {
+	for _, spentOut := range spentTXOs[hex.EncodeToString(txID)] {
+		if spentOut == outIdx {
+			return true
+		}
+	}
+	return false
+}
+
+// Validates a transaction against the current state of the chain.
+// A valid transaction spends only existing unspent outputs, carries a valid signature
+// for each of its inputs and does not create more value than it spends.
+func (chain *BlockChain) ValidateTransaction(tx *Transaction) error {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	lastBlock := chain.LastBlock()
+	_, err := chain.checkTransaction(tx, lastBlock.Hash, lastBlock.Height+1, time.Now().Unix())
+	return err
+}
+
+// Validates a transaction mined into a block at height with blockTime on top of the block with the tip hash,
+// returns the fee paid by the transaction.
+func (chain *BlockChain) checkTransaction(tx *Transaction, tip []byte, height int, blockTime int64) (int, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	if tx.IsCoinbase() {
+		return 0, errors.New("coinbase transaction cannot be submitted")
+	}
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return 0, errors.New("transaction has no inputs or outputs")
+	}
+	txCopy := tx.TrimmedCopy()
+	if bytes.Compare(tx.ID, txCopy.Hash()) != 0 {
+		return 0, errors.New("transaction id does not match its content")
+	}
+
+	if !tx.IsFinal(height, blockTime) {
+		return 0, errors.Errorf("transaction is not final, it can't be mined before %s", describeLockTime(tx.LockTime))
+	}
+
+	// signatures of blocks converted from the original storage format were made over its encoding
+	legacy := chain.Params.isLegacy(height)
+	spentTXOs := chain.findSpentOutputs(tip)
+	stakes := chain.stakesAt(tip)
+	prevOuts := make([]TxOutput, len(tx.Inputs))
+	usedOuts := make(map[string]bool)
+	inputValue := 0
+
+	for inIdx, in := range tx.Inputs {
+		inTxID := hex.EncodeToString(in.ID)
+		outpoint := fmt.Sprintf("%s:%d", inTxID, in.Out)
+		if usedOuts[outpoint] {
+			return 0, errors.Errorf("output %s is spent twice", outpoint)
+		}
+		usedOuts[outpoint] = true
+
+		if isSpent(spentTXOs, in.ID, in.Out) {
+			return 0, errors.Errorf("output %s is already spent", outpoint)
+		}
+
+		prevOut, err := chain.findOutput(tip, in.ID, in.Out)
+		if err != nil {
+			return 0, errors.Wrapf(err, "input %s", outpoint)
+		}
+		if !legacy && !prevOut.IsMature(height, chain.Params.CoinbaseMaturity) {
+			return 0, errors.Errorf("output %s is an immature coinbase, it can't be spent before block %d",
+				outpoint, prevOut.Height+chain.Params.CoinbaseMaturity)
+		}
+		if pubKey, ok := script.ExtractStakePubKey(prevOut.Output.LockingScript); ok && isSlashed(stakes, pubKey) {
+			return 0, errors.Errorf("output %s is a slashed stake", outpoint)
+		}
+		prevOuts[inIdx] = prevOut.Output
+		if inputValue, err = addValue(inputValue, prevOut.Output.Value); err != nil {
+			return 0, errors.Wrap(err, "transaction inputs")
+		}
+	}
+
+	outputValue := 0
+	for _, out := range tx.Outputs {
+		if out.Value <= 0 {
+			return 0, errors.New("transaction output value must be positive")
+		}
+		if len(out.LockingScript) == 0 {
+			return 0, errors.New("transaction output has no locking script")
+		}
+		var err error
+		if outputValue, err = addValue(outputValue, out.Value); err != nil {
+			return 0, errors.Wrap(err, "transaction outputs")
+		}
+	}
+	if outputValue > inputValue {
+		return 0, errors.Errorf("transaction spends %d but its inputs are worth only %d", outputValue, inputValue)
+	}
+
+	if legacy {
+		return inputValue - outputValue, nil
+	}
+	for inIdx := range tx.Inputs {
+		if err := tx.VerifyInput(inIdx, prevOuts[inIdx]); err != nil {
+			log.Debug().Err(err).Msgf("input %d of transaction %x is invalid", inIdx, tx.ID)
+			return 0, errors.New("invalid transaction signature")
+		}
+	}
+	return inputValue - outputValue, nil
+}
+
+// Adds a value to a sum of values, both have to be at most MaxMoney.
+func addValue(sum, value int) (int, error) {
+	if value < 0 || value > MaxMoney {
+		return 0, errors.Errorf("value %d is out of range, the maximum is %d", value, MaxMoney)
+	}
+	if sum += value; sum > MaxMoney {
+		return 0, errors.Errorf("total value exceeds the maximum of %d", MaxMoney)
+	}
+	return sum, nil
+}
@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// Walks the chain from the iterator to the Genesis block, checking that every block follows its parent.
+// Returns the height of the first block.
+func walkChain(t *testing.T, iter *BlockChainIterator) int {
+	t.Helper()
+
+	block := iter.Next()
+	height := block.Height
+	for len(block.PrevHash) != 0 {
+		parent := iter.Next()
+		if parent.Height != block.Height-1 {
+			t.Errorf("block %d follows block %d", block.Height, parent.Height)
+			return height
+		}
+		block = parent
+	}
+	if block.Height != 0 {
+		t.Errorf("chain ends at block %d instead of the Genesis", block.Height)
+	}
+	return height
+}
+
+func TestQueriesWhileMining(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	lockingScript, err := LockingScriptForAddress(miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lastTotal, lastHeight := 0, 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// every block rewards the miner, so a consistent balance is a multiple of the reward
+				balance, immature := chain.GetBalance(lockingScript)
+				total := balance + immature
+				if total%BlockReward != 0 || total < lastTotal {
+					t.Errorf("inconsistent balance %d after %d", total, lastTotal)
+					return
+				}
+				lastTotal = total
+
+				height := walkChain(t, chain.Iterator())
+				if height < lastHeight {
+					t.Errorf("chain went back from block %d to %d", lastHeight, height)
+					return
+				}
+				lastHeight = height
+				chain.LastBlock()
+			}
+		}()
+	}
+
+	generate(t, chain, miner, 30)
+	close(done)
+	wg.Wait()
+
+	if height := walkChain(t, chain.Iterator()); height != 30 {
+		t.Fatalf("expected 30 blocks, the last block is %d", height)
+	}
+}
+
+func TestConcurrentMiners(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+
+	var mined sync.WaitGroup
+	var mu sync.Mutex
+	connected := 0
+	for i := 0; i < 4; i++ {
+		mined.Add(1)
+		go func() {
+			defer mined.Done()
+			for j := 0; j < 10; j++ {
+				_, err := chain.MineBlock(context.Background(), miner, nil)
+				if err == ErrStaleBlock {
+					continue
+				} else if err != nil {
+					t.Errorf("failed to mine block: %v", err)
+					return
+				}
+				mu.Lock()
+				connected++
+				mu.Unlock()
+			}
+		}()
+	}
+	mined.Wait()
+
+	// blocks racing for the same height are stale except for one
+	if height := walkChain(t, chain.Iterator()); height != connected {
+		t.Fatalf("connected %d blocks, but the last block is %d", connected, height)
+	}
+}
+
+func TestConcurrentSubmitBlock(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+
+	lastBlock := chain.LastBlock()
+	block := NewBlock([]*Transaction{newCoinbase(t, miner, lastBlock.Height+1)}, lastBlock.Hash, lastBlock.Height+1, chain.Params.Difficulty)
+	if err := chain.Engine.Seal(context.Background(), block, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- chain.SubmitBlock(block)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	accepted := 0
+	for err := range errs {
+		if err == nil {
+			accepted++
+		} else if err != ErrStaleBlock {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("block was accepted %d times", accepted)
+	}
+	if height := walkChain(t, chain.Iterator()); height != 1 {
+		t.Fatalf("expected 1 block, the last block is %d", height)
+	}
+}
+
+func TestConflictingSubmitRawTransaction(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	var spends []*RawTransaction
+	for i := 0; i < 8; i++ {
+		spends = append(spends, spendGenesis(t, chain, wallets, wallets.AddWallet()))
+	}
+
+	errs := make(chan error, len(spends))
+	var wg sync.WaitGroup
+	for _, rawTx := range spends {
+		wg.Add(1)
+		go func(rawTx *RawTransaction) {
+			defer wg.Done()
+			_, err := chain.SubmitRawTransaction(context.Background(), rawTx, miner)
+			errs <- err
+		}(rawTx)
+	}
+	wg.Wait()
+	close(errs)
+
+	// every spend of the Genesis coinbase conflicts with the others, only one of them is mined
+	accepted := 0
+	for err := range errs {
+		if err == nil {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("conflicting spends were mined %d times", accepted)
+	}
+	if height := walkChain(t, chain.Iterator()); height != 1 {
+		t.Fatalf("expected 1 block, the last block is %d", height)
+	}
+}
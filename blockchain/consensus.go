@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Names of consensus engines in network parameters.
+const (
+	ProofOfWorkConsensus      = "pow"
+	ProofOfAuthorityConsensus = "poa"
+	ProofOfStakeConsensus     = "pos"
+)
+
+// An Engine is the consensus algorithm of a chain. It selects who may create the next block,
+// seals new blocks and verifies the seals of blocks created by others.
+type Engine interface {
+	// Selects the public key of the proposer allowed to create the block following parent,
+	// nil if anyone may create it.
+	SelectProposer(chain *BlockChain, parent *Block) ([]byte, error)
+	// Seals a block, e.g. by computing its proof of work or by signing it with the wallet of its proposer.
+	// Engines which don't select proposers ignore the wallet, the Genesis block is sealed without one.
+	Seal(ctx context.Context, block *Block, proposer *wallet.Wallet) error
+	// Verifies the seal and the hash of a block extending the chain.
+	VerifySeal(chain *BlockChain, block *Block) error
+}
+
+// Creates the consensus engine of a network, proof-of-work chains are mined by the miner.
+func NewEngine(params Params, miner *Miner) (Engine, error) {
+	switch params.Consensus {
+	case ProofOfWorkConsensus, "":
+		return &ProofOfWorkEngine{miner}, nil
+	case ProofOfAuthorityConsensus:
+		return NewProofOfAuthorityEngine(params.Signers)
+	case ProofOfStakeConsensus:
+		return NewProofOfStakeEngine(params.Signers)
+	default:
+		return nil, errors.Errorf("unknown consensus engine %s", params.Consensus)
+	}
+}
+
+// ProofOfWorkEngine lets anyone create blocks by finding a hash satisfying the difficulty of the block.
+type ProofOfWorkEngine struct {
+	Miner *Miner
+}
+
+func (e *ProofOfWorkEngine) SelectProposer(chain *BlockChain, parent *Block) ([]byte, error) {
+	return nil, nil
+}
+
+func (e *ProofOfWorkEngine) Seal(ctx context.Context, block *Block, proposer *wallet.Wallet) error {
+	return e.Miner.Mine(ctx, block)
+}
+
+func (e *ProofOfWorkEngine) VerifySeal(chain *BlockChain, block *Block) error {
+	if block.Nonce < 0 || block.Nonce > MaxNonce {
+		return errors.Errorf("block nonce %d is out of range", block.Nonce)
+	}
+	pow := NewProof(block)
+	if !bytes.Equal(block.Hash, pow.Hash()) {
+		return errors.New("block hash does not match its content")
+	}
+	if !pow.Validate() {
+		return errors.New("block hash does not satisfy the proof of work target")
+	}
+	return nil
+}
+
+// Finds the wallet of the proposer of the block following the last block, nil if anyone may create it.
+// Returns an error if the proposer's key is not one of the wallets.
+func (chain *BlockChain) FindProposerWallet(wallets *wallet.Wallets) (*wallet.Wallet, error) {
+	return chain.findProposerWallet(chain.LastBlock(), wallets)
+}
+
+// Finds the wallet of the proposer of the block following parent.
+func (chain *BlockChain) findProposerWallet(parent *Block, wallets *wallet.Wallets) (*wallet.Wallet, error) {
+	proposer, err := chain.Engine.SelectProposer(chain, parent)
+	if err != nil || proposer == nil {
+		return nil, err
+	}
+	if wallets != nil {
+		if w, ok := wallets.FindWallet(wallet.PublicKeyHash(proposer)); ok {
+			return w, nil
+		}
+	}
+	return nil, errors.Errorf("the next block has to be proposed by %x, which is not in the wallets", proposer)
+}
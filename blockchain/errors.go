@@ -1,7 +1,5 @@
 package blockchain
 
-import "github.com/rs/zerolog/log"
-
 func HandleError(err error) {
 	if err != nil {
 		log.Error().Stack().Err(err).Msg("unexpected error")
@@ -0,0 +1,166 @@
+package blockchain
+
+import "sync"
+
+// An EventType identifies what happened to the chain.
+type EventType string
+
+// Types of chain events.
+const (
+	BlockConnected    EventType = "blockconnected"    // a block became the last block
+	BlockDisconnected EventType = "blockdisconnected" // the last block was removed, the chain doesn't reorganize yet
+	TxAccepted        EventType = "txaccepted"        // a transaction was accepted into a mempool
+	AddressActivity   EventType = "addressactivity"   // a connected block or an accepted transaction pays or spends an address
+)
+
+// An Event describes a change of the chain or of a mempool.
+type Event struct {
+	Type    EventType
+	Block   *Block       // the connected or disconnected block, the block of address activity, nil for mempool activity
+	Tx      *Transaction // the accepted transaction or the transaction of address activity
+	Address string       // the address of address activity
+}
+
+// A Subscription receives events published after it was created until it is cancelled.
+// Events are dropped instead of blocking the chain when the subscriber doesn't keep up.
+type Subscription struct {
+	C <-chan Event
+
+	events  chan Event
+	bus     *eventBus
+	mu      sync.Mutex // guards dropped
+	dropped int
+}
+
+// Cancels the subscription and closes its channel.
+func (sub *Subscription) Unsubscribe() {
+	sub.bus.unsubscribe(sub)
+}
+
+// Gets the number of events dropped because the channel was full.
+func (sub *Subscription) Dropped() int {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.dropped
+}
+
+// An eventBus delivers published events to all subscriptions.
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*Subscription]bool)}
+}
+
+// Subscribes to events of the chain, the channel of the subscription buffers up to buffer events.
+func (chain *BlockChain) Subscribe(buffer int) *Subscription {
+	return chain.events.subscribe(buffer)
+}
+
+// Gets the number of subscriptions which are not cancelled.
+func (chain *BlockChain) Subscribers() int {
+	chain.events.mu.RLock()
+	defer chain.events.mu.RUnlock()
+	return len(chain.events.subs)
+}
+
+func (bus *eventBus) subscribe(buffer int) *Subscription {
+	events := make(chan Event, buffer)
+	sub := &Subscription{C: events, events: events, bus: bus}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.subs[sub] = true
+	return sub
+}
+
+func (bus *eventBus) unsubscribe(sub *Subscription) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.subs[sub] {
+		delete(bus.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Checks if anyone is subscribed, so events which are expensive to create can be skipped.
+func (bus *eventBus) active() bool {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	return len(bus.subs) > 0
+}
+
+// Delivers events to all subscriptions without blocking.
+func (bus *eventBus) publish(events ...Event) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	for sub := range bus.subs {
+		for _, event := range events {
+			select {
+			case sub.events <- event:
+			default:
+				sub.mu.Lock()
+				sub.dropped++
+				sub.mu.Unlock()
+				log.Warn().Str("event", string(event.Type)).Msg("event dropped, subscriber is too slow")
+			}
+		}
+	}
+}
+
+// Publishes a connected block followed by the activity of every address its transactions pay or spend.
+func (chain *BlockChain) publishBlockConnected(block *Block) {
+	if !chain.events.active() {
+		return
+	}
+	events := []Event{{Type: BlockConnected, Block: block}}
+	for _, tx := range block.Transactions {
+		for _, address := range chain.txAddresses(block.Hash, tx) {
+			events = append(events, Event{Type: AddressActivity, Block: block, Tx: tx, Address: address})
+		}
+	}
+	chain.events.publish(events...)
+}
+
+// Publishes a transaction accepted into a mempool followed by the activity of every address it pays or spends.
+func (chain *BlockChain) publishTxAccepted(tx *Transaction) {
+	if !chain.events.active() {
+		return
+	}
+	events := []Event{{Type: TxAccepted, Tx: tx}}
+	for _, address := range chain.txAddresses(chain.LastHash(), tx) {
+		events = append(events, Event{Type: AddressActivity, Tx: tx, Address: address})
+	}
+	chain.events.publish(events...)
+}
+
+// Gets the addresses whose outputs a transaction spends or pays to, spent outputs are looked up
+// in the chain ending with the block with the tip hash.
+func (chain *BlockChain) txAddresses(tip []byte, tx *Transaction) []string {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	var addresses []string
+	seen := make(map[string]bool)
+	add := func(out TxOutput) {
+		if address, ok := out.Address(); ok && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Inputs {
+			if prevOut, err := chain.findOutput(tip, in.ID, in.Out); err == nil {
+				add(prevOut.Output)
+			}
+		}
+	}
+	for _, out := range tx.Outputs {
+		add(out)
+	}
+	return addresses
+}
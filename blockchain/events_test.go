@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Receives the next event of a subscription, failing if there is none.
+func nextEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event := <-sub.C:
+		return event
+	default:
+		t.Fatal("expected an event")
+	}
+	return Event{}
+}
+
+func TestEvents(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	sub := chain.Subscribe(16)
+
+	generate(t, chain, miner, 1)
+	if event := nextEvent(t, sub); event.Type != BlockConnected || event.Block.Height != 1 {
+		t.Fatalf("expected block 1 to be connected, got %+v", event)
+	}
+	if event := nextEvent(t, sub); event.Type != AddressActivity || event.Address != miner || !event.Tx.IsCoinbase() {
+		t.Fatalf("expected activity of the miner, got %+v", event)
+	}
+
+	receiver := wallets.AddWallet()
+	rawTx := spendGenesis(t, chain, wallets, receiver)
+	if err := NewMempool().Add(&rawTx.Tx, chain); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, sub); event.Type != TxAccepted || !bytes.Equal(event.Tx.ID, rawTx.Tx.ID) {
+		t.Fatalf("expected the transaction to be accepted, got %+v", event)
+	}
+	// the spent output belongs to the miner
+	for _, address := range []string{miner, receiver} {
+		if event := nextEvent(t, sub); event.Type != AddressActivity || event.Address != address || event.Block != nil {
+			t.Fatalf("expected mempool activity of %s, got %+v", address, event)
+		}
+	}
+
+	sub.Unsubscribe()
+	generate(t, chain, miner, 1)
+	if _, ok := <-sub.C; ok {
+		t.Fatal("expected the channel of a cancelled subscription to be closed")
+	}
+}
+
+func TestSlowSubscriberDropsEvents(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	sub := chain.Subscribe(1)
+	defer sub.Unsubscribe()
+
+	// every block publishes its connection and the activity of the miner
+	generate(t, chain, miner, 2)
+	if dropped := sub.Dropped(); dropped != 3 {
+		t.Fatalf("expected 3 dropped events, got %d", dropped)
+	}
+	if event := nextEvent(t, sub); event.Type != BlockConnected || event.Block.Height != 1 {
+		t.Fatalf("expected the first event to be kept, got %+v", event)
+	}
+}
@@ -0,0 +1,283 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// A chain export is a stream of all blocks of a chain from the Genesis, integers are big endian:
+//
+//	magic    8 bytes, exportMagic
+//	version  uint32, exportVersion
+//	checksum 32 bytes, SHA-256 of everything following it
+//	params   uint32 length followed by the gob encoded network parameters
+//	count    uint64 number of blocks
+//	blocks   count times an uint32 length followed by a block serialized by Block.Serialize
+const (
+	exportMagic   = "GOBCHAIN"
+	exportVersion = 1
+	// Size of the fields preceding the checksum.
+	exportPrefixSize = len(exportMagic) + 4
+	// Largest block or parameters accepted by an import.
+	maxExportRecordSize = 32 << 20
+)
+
+// Writes all blocks of the chain from the Genesis to its last block into a chain export, returns the number of blocks.
+// The checksum is written once all blocks are, so w has to be seekable.
+func (chain *BlockChain) Export(w io.WriteSeeker) (int, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	if chain.snapshotBase != nil {
+		return 0, errors.New("chain is pruned or starts from a UTXO snapshot, the blocks preceding its first block are not stored")
+	}
+
+	// blocks are linked backwards, so their hashes are collected before the blocks are written in order
+	var hashes [][]byte
+	iter := chain.Iterator()
+	for {
+		hash := iter.CurrentHash
+		block := iter.Next()
+		hashes = append(hashes, hash)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	next := len(hashes) - 1
+	err := writeExport(w, chain.Params, len(hashes), func() *Block {
+		block := chain.block(hashes[next])
+		next--
+		return block
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+// Writes a chain export of count blocks returned by next.
+func writeExport(w io.WriteSeeker, params Params, count int, next func() *Block) error {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrap(err, "failed to write chain export")
+	}
+	var header bytes.Buffer
+	header.WriteString(exportMagic)
+	binary.Write(&header, binary.BigEndian, uint32(exportVersion))
+	header.Write(make([]byte, sha256.Size))
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return errors.Wrap(err, "failed to write chain export")
+	}
+
+	var encodedParams bytes.Buffer
+	if err := gob.NewEncoder(&encodedParams).Encode(params); err != nil {
+		return errors.Wrap(err, "failed to encode network parameters")
+	}
+
+	checksum := sha256.New()
+	buffered := bufio.NewWriter(io.MultiWriter(w, checksum))
+	writeRecord(buffered, encodedParams.Bytes())
+	binary.Write(buffered, binary.BigEndian, uint64(count))
+	for i := 0; i < count; i++ {
+		writeRecord(buffered, next().Serialize())
+	}
+	if err := buffered.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write chain export")
+	}
+
+	end, err := w.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = w.Seek(start+int64(exportPrefixSize), io.SeekStart)
+	}
+	if err == nil {
+		_, err = w.Write(checksum.Sum(nil))
+	}
+	if err == nil {
+		_, err = w.Seek(end, io.SeekStart)
+	}
+	return errors.Wrap(err, "failed to write chain export checksum")
+}
+
+// Writes a length prefixed record, errors are reported by Flush of the writer.
+func writeRecord(w *bufio.Writer, data []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(data)))
+	w.Write(data)
+}
+
+// Reads a length prefixed record.
+func readRecord(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > maxExportRecordSize {
+		return nil, errors.Errorf("record of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Reads the header of a chain export and verifies its checksum, returns the network parameters of the chain
+// and the number of blocks. r is left at the first block.
+func readExportHeader(r io.ReadSeeker) (Params, uint64, error) {
+	prefix := make([]byte, exportPrefixSize+sha256.Size)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return Params{}, 0, errors.Wrap(err, "failed to read chain export header")
+	}
+	if string(prefix[:len(exportMagic)]) != exportMagic {
+		return Params{}, 0, errors.New("file is not a chain export")
+	}
+	if version := binary.BigEndian.Uint32(prefix[len(exportMagic):]); version != exportVersion {
+		return Params{}, 0, errors.Errorf("unsupported chain export version %d", version)
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Params{}, 0, errors.Wrap(err, "failed to read chain export")
+	}
+	checksum := sha256.New()
+	if _, err := io.Copy(checksum, r); err != nil {
+		return Params{}, 0, errors.Wrap(err, "failed to read chain export")
+	}
+	if !bytes.Equal(checksum.Sum(nil), prefix[exportPrefixSize:]) {
+		return Params{}, 0, errors.New("chain export checksum mismatch, the file is corrupted")
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return Params{}, 0, errors.Wrap(err, "failed to read chain export")
+	}
+
+	encodedParams, err := readRecord(r)
+	if err != nil {
+		return Params{}, 0, errors.Wrap(err, "failed to read network parameters")
+	}
+	var params Params
+	if err := gob.NewDecoder(bytes.NewReader(encodedParams)).Decode(&params); err != nil {
+		return Params{}, 0, errors.Wrap(err, "failed to decode network parameters")
+	}
+	var count uint64
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return Params{}, 0, errors.Wrap(err, "failed to read number of blocks")
+	}
+	if count == 0 {
+		return Params{}, 0, errors.New("chain export has no Genesis block")
+	}
+	return params, count, nil
+}
+
+// Creates a new Blockchain DB of a storage backend in a directory from a chain export.
+// If a block is invalid, the DB keeps the valid blocks preceding it.
+func ImportBlockChainWithBackend(dir, backend string, r io.ReadSeeker) (*BlockChain, error) {
+	if backend == storage.MemoryBackend {
+		return nil, errors.New("blockchain in a directory can't be kept in memory")
+	}
+	if storage.Detect(dir) != "" {
+		return nil, errors.Errorf("blockchain already exists in %s", dir)
+	}
+	store, err := storage.Open(backend, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blockchain DB")
+	}
+	chain, err := ImportBlockChain(store, r)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return chain, nil
+}
+
+// Creates a new Blockchain in an empty store from a chain export. The checksum of the export is verified
+// before anything is stored, then every block is fully validated as it is connected.
+func ImportBlockChain(store storage.Store, r io.ReadSeeker) (*BlockChain, error) {
+	return importBlockChain(context.Background(), store, r, -1)
+}
+
+// Imports the blocks of a chain export up to maxHeight, all blocks if it is negative.
+// The import is aborted when ctx is cancelled.
+func importBlockChain(ctx context.Context, store storage.Store, r io.ReadSeeker, maxHeight int) (*BlockChain, error) {
+	params, count, err := readExportHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxHeight >= 0 && uint64(maxHeight) >= count {
+		return nil, errors.Errorf("chain export ends before block %d", maxHeight)
+	} else if maxHeight >= 0 {
+		count = uint64(maxHeight) + 1
+	}
+	buffered := bufio.NewReader(r)
+
+	genesis, err := readExportBlock(buffered, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGenesis(genesis, params); err != nil {
+		return nil, errors.Wrap(err, "invalid Genesis block")
+	}
+	chain, err := newBlockChainFromGenesis(store, genesis, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for height := uint64(1); height < count; height++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block, err := readExportBlock(buffered, height)
+		if err != nil {
+			return nil, err
+		}
+		if err := chain.SubmitBlock(block); err != nil {
+			return nil, errors.Wrapf(err, "invalid block %d", height)
+		}
+		if height%1000 == 0 {
+			log.Info().Uint64("height", height).Uint64("blocks", count).Msg("importing chain")
+		}
+	}
+	return chain, nil
+}
+
+func readExportBlock(r io.Reader, height uint64) (*Block, error) {
+	data, err := readRecord(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read block %d", height)
+	}
+	block, err := DecodeBlock(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "block %d", height)
+	}
+	return block, nil
+}
+
+// Validates the Genesis block of a chain with network parameters, it is sealed without a proposer.
+func checkGenesis(genesis *Block, params Params) error {
+	if len(genesis.PrevHash) != 0 || genesis.Height != 0 {
+		return errors.New("first block doesn't start the chain")
+	}
+	if genesis.Difficulty != params.Difficulty {
+		return errors.Errorf("block difficulty is %d, expected %d", genesis.Difficulty, params.Difficulty)
+	}
+	if len(genesis.Transactions) != 1 || !genesis.Transactions[0].IsCoinbase() || len(genesis.Evidence) != 0 {
+		return errors.New("Genesis block must hold only a coinbase")
+	}
+
+	engine, err := NewEngine(params, NewMiner())
+	if err != nil {
+		return err
+	}
+	if err := engine.VerifySeal(nil, genesis); err != nil {
+		return err
+	}
+	return checkCoinbase(genesis.Transactions[0], 0, BlockReward)
+}
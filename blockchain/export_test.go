@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// Exports a chain into a file in a temporary directory.
+func exportChain(t *testing.T, chain *BlockChain) *os.File {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "chain.export"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	if _, err := chain.Export(file); err != nil {
+		t.Fatalf("failed to export chain: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestExportImport(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	receiver := wallets.AddWallet()
+	rawTx := spendGenesis(t, chain, wallets, receiver)
+	if err := chain.AddBlock([]*Transaction{newCoinbase(t, miner, 1), &rawTx.Tx}); err != nil {
+		t.Fatal(err)
+	}
+	generate(t, chain, miner, 3)
+
+	file := exportChain(t, chain)
+	imported, err := ImportBlockChain(storage.NewMemoryStore(), file)
+	if err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	defer imported.Database.Close()
+
+	if !bytes.Equal(imported.LastHash(), chain.LastHash()) {
+		t.Fatalf("imported chain ends with %x, expected %x", imported.LastHash(), chain.LastHash())
+	}
+	if imported.Params.CoinbaseMaturity != chain.Params.CoinbaseMaturity {
+		t.Fatalf("imported chain has coinbase maturity %d, expected %d", imported.Params.CoinbaseMaturity, chain.Params.CoinbaseMaturity)
+	}
+	for _, address := range []string{miner, receiver} {
+		expected, _ := balanceOf(t, chain, address)
+		if balance, _ := balanceOf(t, imported, address); balance != expected {
+			t.Fatalf("imported balance of %s is %d, expected %d", address, balance, expected)
+		}
+	}
+}
+
+// Payments are mined by SubmitRawTransaction as the node mines them for send and submitrawtx.
+func TestExportImportPayments(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	receiver := wallets.AddWallet()
+	for _, payment := range []struct {
+		from, to string
+		amount   int
+	}{{miner, receiver, 30}, {receiver, miner, 10}, {miner, receiver, 50}} {
+		rawTx, err := chain.CreateRawTransaction(payment.from, payment.to, payment.amount, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawTx.Sign(wallets)
+		if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, payment.from); err != nil {
+			t.Fatalf("failed to submit payment: %v", err)
+		}
+	}
+
+	imported, err := ImportBlockChain(storage.NewMemoryStore(), exportChain(t, chain))
+	if err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	defer imported.Database.Close()
+	if !bytes.Equal(imported.LastHash(), chain.LastHash()) {
+		t.Fatalf("imported chain ends with %x, expected %x", imported.LastHash(), chain.LastHash())
+	}
+	for _, address := range []string{miner, receiver} {
+		expected, _ := balanceOf(t, chain, address)
+		if balance, _ := balanceOf(t, imported, address); balance != expected {
+			t.Fatalf("imported balance of %s is %d, expected %d", address, balance, expected)
+		}
+	}
+}
+
+func TestImportCorruptedExport(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, 2)
+
+	file := exportChain(t, chain)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+
+	store := storage.NewMemoryStore()
+	_, err = ImportBlockChain(store, bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := store.Get([]byte(lastHashKey)); err != storage.ErrNotFound {
+		t.Fatalf("expected nothing to be imported, got %v", err)
+	}
+}
+
+func TestImportValidatesBlocks(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, 2)
+
+	// the blocks are swapped, so the second block doesn't extend the first one
+	var blocks []*Block
+	for iter := chain.Iterator(); len(blocks) == 0 || len(blocks[0].PrevHash) != 0; {
+		blocks = append([]*Block{iter.Next()}, blocks...)
+	}
+	blocks[1], blocks[2] = blocks[2], blocks[1]
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "chain.export"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	next := 0
+	err = writeExport(file, chain.Params, len(blocks), func() *Block {
+		next++
+		return blocks[next-1]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Seek(0, io.SeekStart)
+
+	_, err = ImportBlockChain(storage.NewMemoryStore(), file)
+	if err == nil || !strings.Contains(err.Error(), "invalid block 1") {
+		t.Fatalf("expected block 1 to be invalid, got %v", err)
+	}
+}
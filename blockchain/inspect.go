@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Gets the hash of the block at a height of the chain. The headers of pruned blocks are searched as well.
+func (chain *BlockChain) HashAtHeight(height int) ([]byte, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	last := chain.LastBlock()
+	if height < 0 || height > last.Height {
+		return nil, errors.Errorf("block %d does not exist, the last block is %d", height, last.Height)
+	}
+	for hash := last.Hash; ; {
+		header, err := chain.GetBlockHeader(hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "block %d", height)
+		}
+		if header.Height == height {
+			return hash, nil
+		}
+		hash = header.PrevHash
+	}
+}
+
+// Gets the header of a block of the chain by its hash, pruned blocks included.
+func (chain *BlockChain) GetBlockHeader(hash []byte) (*BlockHeader, error) {
+	block, err := chain.GetBlock(hash)
+	if errors.Cause(err) == ErrBlockPruned {
+		return chain.loadHeader(hash)
+	} else if err != nil {
+		return nil, err
+	}
+	header := block.Header()
+	return &header, nil
+}
+
+// Gets the number of blocks confirming a block at height, the block itself included.
+func (chain *BlockChain) Confirmations(height int) int {
+	return chain.LastBlock().Height - height + 1
+}
+
+// Finds a transaction of the chain and the block containing it, see FindTransaction.
+func (chain *BlockChain) FindTransactionBlock(ID []byte) (Transaction, *Block, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	tx, block, err := chain.findTransactionBlock(chain.LastHash(), ID)
+	if err != nil && chain.snapshotBase != nil {
+		first := chain.block(chain.snapshotBase)
+		return tx, nil, errors.Wrapf(ErrBlockPruned, "transaction %x not found in blocks from height %d", ID, first.Height)
+	}
+	return tx, block, err
+}
+
+// Finds the outputs spent by the inputs of a transaction, spent[i] is the output spent by tx.Inputs[i].
+// It is nil for coinbase inputs and for outputs of pruned blocks or blocks preceding a UTXO snapshot
+// which were spent before its base.
+func (chain *BlockChain) PrevOutputs(tx *Transaction) []*TxOutput {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	tip := chain.LastHash()
+	spent := make([]*TxOutput, len(tx.Inputs))
+	if tx.IsCoinbase() {
+		return spent
+	}
+	for inIdx, in := range tx.Inputs {
+		if prevOut, err := chain.findOutput(tip, in.ID, in.Out); err == nil {
+			spent[inIdx] = &prevOut.Output
+		}
+	}
+	return spent
+}
+
+// Computes the fee of a transaction from the outputs spent by its inputs, see PrevOutputs.
+// Returns false if any of them is unknown, the fee of a coinbase is zero.
+func Fee(tx *Transaction, prevOuts []*TxOutput) (int, bool) {
+	if tx.IsCoinbase() {
+		return 0, true
+	}
+	fee := 0
+	for _, prevOut := range prevOuts {
+		if prevOut == nil {
+			return 0, false
+		}
+		fee += prevOut.Value
+	}
+	for _, out := range tx.Outputs {
+		fee -= out.Value
+	}
+	return fee, true
+}
+
+// Checks if the hash of a header satisfies the proof of work target of its difficulty.
+// Unlike the seal of a block it can be checked for pruned blocks too.
+func (h *BlockHeader) HasValidProofOfWork() bool {
+	var intHash big.Int
+	intHash.SetBytes(h.Hash())
+	return intHash.Cmp(TargetForDifficulty(h.Difficulty)) == -1
+}
+
+// Estimates the hash rate of the network in hashes per second from the work of the last blocks of the chain,
+// headers of pruned blocks included. A block of difficulty d takes 2^d hashes on average. The rate is zero
+// if the blocks don't span any time, e.g. on a chain with less than two blocks.
+func (chain *BlockChain) NetworkHashRate(blocks int) (float64, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	var headers []*BlockHeader
+	for hash := chain.LastHash(); len(headers) < blocks; {
+		header, err := chain.GetBlockHeader(hash)
+		if err != nil {
+			return 0, err
+		}
+		headers = append(headers, header)
+		if header.Height == 0 {
+			break
+		}
+		hash = header.PrevHash
+	}
+	return hashRate(headers), nil
+}
+
+// Computes the hash rate of blocks given by their headers from the last one. The work of the first block
+// was done before the time span of the blocks starts, so it is not counted.
+func hashRate(headers []*BlockHeader) float64 {
+	if len(headers) < 2 {
+		return 0
+	}
+	span := headers[0].Timestamp - headers[len(headers)-1].Timestamp
+	if span <= 0 {
+		return 0
+	}
+	var work float64
+	for _, header := range headers[:len(headers)-1] {
+		work += math.Exp2(float64(header.Difficulty))
+	}
+	return work / float64(span)
+}
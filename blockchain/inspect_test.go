@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInspectTransaction(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	receiver := wallets.AddWallet()
+	rawTx := spendGenesis(t, chain, wallets, receiver)
+	coinbase := newCoinbase(t, miner, 1)
+	if err := chain.AddBlock([]*Transaction{coinbase, &rawTx.Tx}); err != nil {
+		t.Fatal(err)
+	}
+	generate(t, chain, miner, 2)
+
+	tx, block, err := chain.FindTransactionBlock(rawTx.Tx.ID)
+	if err != nil || block.Height != 1 {
+		t.Fatalf("expected the transaction to be found in block 1, got %+v %v", block, err)
+	}
+	if confirmations := chain.Confirmations(block.Height); confirmations != 3 {
+		t.Fatalf("expected 3 confirmations, got %d", confirmations)
+	}
+	prevOuts := chain.PrevOutputs(&tx)
+	if prevOuts[0] == nil || prevOuts[0].Value != BlockReward {
+		t.Fatalf("expected the genesis reward to be spent, got %+v", prevOuts[0])
+	}
+	if fee, ok := Fee(&tx, prevOuts); !ok || fee != 0 {
+		t.Fatalf("expected no fee, got %d %t", fee, ok)
+	}
+	if _, ok := Fee(&tx, []*TxOutput{nil}); ok {
+		t.Fatal("expected the fee to be unknown if a spent output is unknown")
+	}
+	if prevOuts := chain.PrevOutputs(coinbase); prevOuts[0] != nil {
+		t.Fatalf("expected a coinbase to spend no output, got %+v", prevOuts[0])
+	}
+
+	// sizes don't depend on the types encoded before
+	size := tx.Size()
+	chain.LastBlock().Serialize()
+	if tx.Size() != size {
+		t.Fatalf("expected the size of the transaction to stay %d, got %d", size, tx.Size())
+	}
+}
+
+func TestInspectPrunedBlocks(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, 14)
+	hash, err := chain.HashAtHeight(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.HashAtHeight(15); err == nil {
+		t.Fatal("expected a block above the last block not to exist")
+	}
+
+	if err := chain.SetPruneTarget(PruneTarget{Blocks: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if pruned, err := chain.HashAtHeight(2); err != nil || !bytes.Equal(pruned, hash) {
+		t.Fatalf("expected the hash of a pruned block to be found, got %x %v", pruned, err)
+	}
+	header, err := chain.GetBlockHeader(hash)
+	if err != nil || header.Height != 2 {
+		t.Fatalf("expected the header of pruned block 2, got %+v %v", header, err)
+	}
+	if !bytes.Equal(header.Hash(), hash) || !header.HasValidProofOfWork() {
+		t.Fatal("expected the header of a pruned block to prove its work")
+	}
+	header.Nonce++
+	if bytes.Equal(header.Hash(), hash) {
+		t.Fatal("expected a changed header to change its hash")
+	}
+}
+
+func TestNetworkHashRate(t *testing.T) {
+	// 3 blocks of difficulty 10 mined in 30 seconds after block 0
+	headers := []*BlockHeader{
+		{Height: 3, Timestamp: 130, Difficulty: 10},
+		{Height: 2, Timestamp: 115, Difficulty: 10},
+		{Height: 1, Timestamp: 110, Difficulty: 10},
+		{Height: 0, Timestamp: 100, Difficulty: 20},
+	}
+	if rate := hashRate(headers); rate != 3*1024/30.0 {
+		t.Fatalf("expected 102.4 H/s, got %f", rate)
+	}
+	if rate := hashRate(headers[3:]); rate != 0 {
+		t.Fatalf("expected no hash rate of a single block, got %f", rate)
+	}
+
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, 3)
+	if _, err := chain.NetworkHashRate(10); err != nil {
+		t.Fatal(err)
+	}
+}
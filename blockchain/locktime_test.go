@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+func TestIsFinal(t *testing.T) {
+	const height, blockTime = 10, LockTimeThreshold + 1000
+	tests := []struct {
+		lockTime uint32
+		sequence uint32
+		final    bool
+	}{
+		{0, MaxSequence - 1, true},
+		{height - 1, MaxSequence - 1, true},
+		{height, MaxSequence - 1, true},
+		{height + 1, MaxSequence - 1, false},
+		{blockTime, MaxSequence - 1, true},
+		{blockTime + 1, MaxSequence - 1, false},
+		// inputs with MaxSequence disable the lock time
+		{height + 1, MaxSequence, true},
+		{blockTime + 1, MaxSequence, true},
+	}
+	for _, test := range tests {
+		tx := Transaction{Inputs: []TxInput{{Sequence: test.sequence}}, LockTime: test.lockTime}
+		if final := tx.IsFinal(height, blockTime); final != test.final {
+			t.Errorf("lock time %d with sequence %#x: expected final %v at height %d", test.lockTime, test.sequence, test.final, height)
+		}
+	}
+}
+
+// Mines a block with a transaction following the last block of the chain, bypassing the validation of mined transactions.
+func mineBlockWith(t *testing.T, chain *BlockChain, tx *Transaction) *Block {
+	t.Helper()
+	last := chain.LastBlock()
+	coinbase := newCoinbase(t, string(wallet.MakeWallet().Address()), last.Height+1)
+	block := NewBlock([]*Transaction{coinbase, tx}, last.Hash, last.Height+1, chain.Params.Difficulty)
+	if block.Timestamp < last.Timestamp {
+		block.Timestamp = last.Timestamp
+	}
+	if err := NewMiner().Mine(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestHeightLockedTransaction(t *testing.T) {
+	chain, wallets, owner := newRegTestChain(t, 0)
+	miner := string(wallet.MakeWallet().Address())
+	recipient := string(wallet.MakeWallet().Address())
+
+	// the transaction can be mined in block 3 at the earliest
+	rawTx, err := chain.CreateRawTransaction(owner, recipient, 30, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawTx.Sign(wallets) != 1 {
+		t.Fatal("failed to sign the transaction")
+	}
+
+	if err := chain.ValidateTransaction(&rawTx.Tx); err == nil || !strings.Contains(err.Error(), "not final") {
+		t.Fatalf("expected the transaction to be non-final in block 1, got %v", err)
+	}
+	if err := NewMempool().Add(&rawTx.Tx, chain); err == nil {
+		t.Fatal("expected the mempool to refuse a non-final transaction")
+	}
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, miner); err == nil {
+		t.Fatal("expected a non-final transaction not to be mined")
+	}
+	if err := chain.SubmitBlock(mineBlockWith(t, chain, &rawTx.Tx)); err == nil || !strings.Contains(err.Error(), "not final") {
+		t.Fatalf("expected a block with a non-final transaction to be rejected, got %v", err)
+	}
+	if height := chain.LastBlock().Height; height != 0 {
+		t.Fatalf("expected no block to be connected, got height %d", height)
+	}
+
+	generate(t, chain, miner, 2)
+	if err := chain.SubmitBlock(mineBlockWith(t, chain, &rawTx.Tx)); err != nil {
+		t.Fatalf("expected the transaction to be final in block 3, got %v", err)
+	}
+	if balance, _ := balanceOf(t, chain, recipient); balance != 30 {
+		t.Errorf("expected the recipient to receive 30, got %d", balance)
+	}
+}
+
+func TestTimeLockedTransaction(t *testing.T) {
+	chain, wallets, owner := newRegTestChain(t, 0)
+	recipient := string(wallet.MakeWallet().Address())
+
+	lockTime := uint32(time.Now().Add(time.Hour).Unix())
+	rawTx, err := chain.CreateRawTransaction(owner, recipient, 30, lockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawTx.Sign(wallets) != 1 {
+		t.Fatal("failed to sign the transaction")
+	}
+	if err := chain.ValidateTransaction(&rawTx.Tx); err == nil || !strings.Contains(err.Error(), "not final") {
+		t.Fatalf("expected a transaction locked for an hour to be non-final, got %v", err)
+	}
+
+	// the block is dated before the lock time
+	if err := chain.SubmitBlock(mineBlockWith(t, chain, &rawTx.Tx)); err == nil || !strings.Contains(err.Error(), "not final") {
+		t.Fatalf("expected a block with a time-locked transaction to be rejected, got %v", err)
+	}
+}
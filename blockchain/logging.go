@@ -0,0 +1,10 @@
+package blockchain
+
+import "github.com/michaljirman/goblockchain/logger"
+
+// Loggers of the package: blocks and transactions, mining and the DB.
+var (
+	log    = logger.Get(logger.Chain)
+	powLog = logger.Get(logger.PoW)
+	dbLog  = logger.Get(logger.DB)
+)
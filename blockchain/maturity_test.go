@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Creates a regtest chain in memory with the Genesis reward sent to a new wallet.
+func newRegTestChain(t *testing.T, maturity int) (*BlockChain, *wallet.Wallets, string) {
+	t.Helper()
+
+	w := wallet.MakeWallet()
+	address := string(w.Address())
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{address: w}}
+
+	params := RegTestParams
+	params.CoinbaseMaturity = maturity
+	chain, err := NewBlockChain(storage.NewMemoryStore(), address, params)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+
+	return chain, wallets, address
+}
+
+func generate(t *testing.T, chain *BlockChain, address string, blocks int) {
+	t.Helper()
+	for i := 0; i < blocks; i++ {
+		if _, err := chain.MineBlock(context.Background(), address, nil); err != nil {
+			t.Fatalf("failed to mine block: %v", err)
+		}
+	}
+}
+
+func balanceOf(t *testing.T, chain *BlockChain, address string) (int, int) {
+	t.Helper()
+	lockingScript, err := LockingScriptForAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain.GetBalance(lockingScript)
+}
+
+// Creates a coinbase transaction of a block at height rewarding an address.
+func newCoinbase(t *testing.T, address string, height int) *Transaction {
+	t.Helper()
+	coinbase, err := CoinbaseTx(address, "", height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coinbase
+}
+
+// Creates an output paying value to an address.
+func newOutput(t *testing.T, value int, address string) TxOutput {
+	t.Helper()
+	out, err := NewTxOutput(value, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *out
+}
+
+// Creates and signs a transaction spending the Genesis coinbase, bypassing the coin selection.
+func spendGenesis(t *testing.T, chain *BlockChain, wallets *wallet.Wallets, to string) *RawTransaction {
+	t.Helper()
+	return spendGenesisTo(t, chain, wallets, newOutput(t, BlockReward, to))
+}
+
+// Creates and signs a transaction spending the Genesis coinbase to any outputs.
+func spendGenesisTo(t *testing.T, chain *BlockChain, wallets *wallet.Wallets, outputs ...TxOutput) *RawTransaction {
+	t.Helper()
+
+	iter := chain.Iterator()
+	var genesis *Block
+	for genesis = iter.Next(); len(genesis.PrevHash) != 0; genesis = iter.Next() {
+	}
+	coinbase := genesis.Transactions[0]
+
+	tx := Transaction{nil, []TxInput{{coinbase.ID, 0, nil, MaxSequence}}, outputs, 0}
+	tx.ID = tx.Hash()
+	rawTx := &RawTransaction{tx, []RawInput{{PrevOut: coinbase.Outputs[0]}}}
+	if rawTx.Sign(wallets) != 1 {
+		t.Fatal("failed to sign the transaction")
+	}
+	return rawTx
+}
+
+func TestRegTestCoinbaseMaturity(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, RegTestParams.CoinbaseMaturity)
+
+	// the Genesis coinbase can be spent in block 100 at the earliest
+	generate(t, chain, miner, 98)
+	if balance, immature := balanceOf(t, chain, miner); balance != 0 || immature != 99*BlockReward {
+		t.Fatalf("expected 0 spendable and %d immature at height 98, got %d and %d", 99*BlockReward, balance, immature)
+	}
+
+	generate(t, chain, miner, 1)
+	if balance, immature := balanceOf(t, chain, miner); balance != BlockReward || immature != 99*BlockReward {
+		t.Fatalf("expected %d spendable and %d immature at height 99, got %d and %d",
+			BlockReward, 99*BlockReward, balance, immature)
+	}
+}
+
+func TestImmatureCoinbaseIsNotSelected(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 10)
+	recipient := string(wallet.MakeWallet().Address())
+
+	if _, err := chain.CreateRawTransaction(miner, recipient, 10, 0); err == nil {
+		t.Fatal("expected immature coinbase not to be selected")
+	}
+
+	generate(t, chain, recipient, 9)
+	rawTx, err := chain.CreateRawTransaction(miner, recipient, 10, 0)
+	if err != nil {
+		t.Fatalf("expected mature coinbase to be selected: %v", err)
+	}
+	if len(rawTx.Tx.Inputs) != 1 || rawTx.Inputs[0].PrevOut.Value != BlockReward {
+		t.Fatalf("expected the Genesis coinbase to be spent, got %d inputs", len(rawTx.Tx.Inputs))
+	}
+}
+
+func TestImmatureCoinbaseSpendIsRejected(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 10)
+	recipient := string(wallet.MakeWallet().Address())
+	other := string(wallet.MakeWallet().Address())
+
+	generate(t, chain, other, 8)
+	rawTx := spendGenesis(t, chain, wallets, recipient)
+	err := chain.ValidateTransaction(&rawTx.Tx)
+	if err == nil || !strings.Contains(err.Error(), "immature coinbase") {
+		t.Fatalf("expected immature coinbase spend to be rejected, got %v", err)
+	}
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, other); err == nil {
+		t.Fatal("expected immature coinbase spend not to be mined")
+	}
+
+	generate(t, chain, other, 1)
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, other); err != nil {
+		t.Fatalf("expected mature coinbase spend to be mined: %v", err)
+	}
+	if balance, immature := balanceOf(t, chain, miner); balance != 0 || immature != 0 {
+		t.Fatalf("expected miner to have nothing left, got %d and %d immature", balance, immature)
+	}
+	if balance, _ := balanceOf(t, chain, recipient); balance != BlockReward {
+		t.Fatalf("expected recipient to receive %d, got %d", BlockReward, balance)
+	}
+}
+
+func TestOverflowingOutputsAreRejected(t *testing.T) {
+	chain, wallets, _ := newRegTestChain(t, 1)
+	recipient := string(wallet.MakeWallet().Address())
+	generate(t, chain, recipient, 1)
+
+	tests := []struct {
+		values   []int
+		expected string
+	}{
+		{[]int{math.MaxInt64/2 + 1, math.MaxInt64/2 + 1}, "out of range"},
+		{[]int{MaxMoney, 1}, "total value exceeds the maximum"},
+	}
+	for _, test := range tests {
+		var outputs []TxOutput
+		for _, value := range test.values {
+			outputs = append(outputs, newOutput(t, value, recipient))
+		}
+		rawTx := spendGenesisTo(t, chain, wallets, outputs...)
+		err := chain.ValidateTransaction(&rawTx.Tx)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected outputs %v to be rejected with %q, got %v", test.values, test.expected, err)
+		}
+	}
+	if balance, _ := balanceOf(t, chain, recipient); balance != BlockReward {
+		t.Fatalf("expected recipient to have only the block reward, got %d", balance)
+	}
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A Mempool holds validated transactions waiting to be mined, in the order they were accepted.
+type Mempool struct {
+	mu    sync.Mutex
+	txs   map[string]*Transaction
+	order []string
+	spent map[string]string // outpoint => ID of the pool transaction spending it
+}
+
+// Creates a new empty Mempool.
+func NewMempool() *Mempool {
+	return &Mempool{
+		txs:   make(map[string]*Transaction),
+		spent: make(map[string]string),
+	}
+}
+
+// Validates a transaction against the chain and adds it to the pool, subscribers of the chain are notified.
+// Transactions spending an output already spent by a pool transaction are rejected.
+func (pool *Mempool) Add(tx *Transaction, chain *BlockChain) error {
+	if err := chain.ValidateTransaction(tx); err != nil {
+		return err
+	}
+	if err := pool.add(tx); err != nil {
+		return err
+	}
+	chain.publishTxAccepted(tx)
+	return nil
+}
+
+// Adds a validated transaction to the pool.
+func (pool *Mempool) add(tx *Transaction) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := pool.txs[txID]; ok {
+		return errors.Errorf("transaction %s is already in the mempool", txID)
+	}
+	for _, in := range tx.Inputs {
+		outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+		if spender, ok := pool.spent[outpoint]; ok {
+			return errors.Errorf("output %s is already spent by mempool transaction %s", outpoint, spender)
+		}
+	}
+
+	pool.txs[txID] = tx
+	pool.order = append(pool.order, txID)
+	for _, in := range tx.Inputs {
+		pool.spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = txID
+	}
+	return nil
+}
+
+// Gets the pool transactions in the order they were accepted.
+func (pool *Mempool) Transactions() []*Transaction {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	txs := make([]*Transaction, 0, len(pool.order))
+	for _, txID := range pool.order {
+		txs = append(txs, pool.txs[txID])
+	}
+	return txs
+}
+
+// Removes transactions from the pool, e.g. once they are mined or no longer valid.
+func (pool *Mempool) Remove(txs []*Transaction) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	removed := false
+	for _, tx := range txs {
+		txID := hex.EncodeToString(tx.ID)
+		poolTx, ok := pool.txs[txID]
+		if !ok {
+			continue
+		}
+		for _, in := range poolTx.Inputs {
+			delete(pool.spent, fmt.Sprintf("%x:%d", in.ID, in.Out))
+		}
+		delete(pool.txs, txID)
+		removed = true
+	}
+	if !removed {
+		return
+	}
+
+	order := pool.order[:0]
+	for _, txID := range pool.order {
+		if _, ok := pool.txs[txID]; ok {
+			order = append(order, txID)
+		}
+	}
+	pool.order = order
+}
+
+// Gets the number of transactions in the pool.
+func (pool *Mempool) Count() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return len(pool.txs)
+}
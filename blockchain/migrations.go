@@ -0,0 +1,360 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// Key of the schema version of a Blockchain DB. DBs created before the schema was versioned have no version, that is version 0.
+const schemaVersionKey = "schema_version"
+
+// Returned when a Blockchain DB was written by a newer binary than this one.
+var ErrNewerSchema = errors.New("blockchain DB was created by a newer version, upgrade the binary to open it")
+
+// A Migration upgrades a Blockchain DB from the preceding schema version to its own.
+type Migration struct {
+	Version     int
+	Description string
+	// Reads the DB at the preceding version and writes the changes of the migration through batch.
+	Migrate func(batch storage.Batch) error
+}
+
+// Migrations of the Blockchain DB schema in the order they are applied.
+// A change to the stored data adds a migration here, the last one is the current schema version.
+var migrations = []Migration{
+	{1, "convert blocks of the original storage format and store the network parameters", migrateLegacy},
+	{2, "store the consensus engine of chains created before engines were pluggable", migrateConsensus},
+}
+
+// Schema version of the Blockchain DBs written by this binary.
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// Gets the schema version of a Blockchain DB.
+func SchemaVersionOf(store storage.Store) (int, error) {
+	encoded, err := store.Get([]byte(schemaVersionKey))
+	if err == storage.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "failed to load schema version")
+	}
+	version, err := strconv.Atoi(string(encoded))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid schema version %q", encoded)
+	}
+	return version, nil
+}
+
+func putSchemaVersion(batch storage.Batch, version int) error {
+	return batch.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(version)))
+}
+
+func putParams(batch storage.Batch, params Params) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(params); err != nil {
+		return errors.Wrap(err, "failed to encode network parameters")
+	}
+	return batch.Put([]byte(paramsKey), encoded.Bytes())
+}
+
+// Gets the migrations a Blockchain DB needs to reach the current schema version.
+// Returns ErrNewerSchema if the DB has a newer schema version than the binary supports.
+func PendingMigrations(store storage.Store) ([]Migration, error) {
+	version, err := SchemaVersionOf(store)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, errors.Wrapf(ErrNewerSchema, "schema version %d, supported up to %d", version, SchemaVersion)
+	}
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Upgrades a Blockchain DB to the current schema version one migration at a time, returns the migrations applied.
+// Each migration is applied atomically together with its version, so a failed upgrade resumes from the last
+// applied migration. With dryRun the migrations are applied to a copy of the DB in memory and the DB is left intact.
+func Migrate(store storage.Store, dryRun bool) ([]Migration, error) {
+	pending, err := PendingMigrations(store)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	if dryRun {
+		copied := storage.NewMemoryStore()
+		defer copied.Close()
+		if err := storage.Copy(copied, store); err != nil {
+			return nil, err
+		}
+		store = copied
+	}
+	for _, migration := range pending {
+		err := store.Update(func(batch storage.Batch) error {
+			if err := migration.Migrate(batch); err != nil {
+				return err
+			}
+			return putSchemaVersion(batch, migration.Version)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration to schema version %d failed", migration.Version)
+		}
+		if !dryRun {
+			dbLog.Info().Int("version", migration.Version).Msgf("migrated blockchain DB: %s", migration.Description)
+		}
+	}
+	return pending, nil
+}
+
+// Copies a Blockchain DB into a new store of a backend in a directory, e.g. before it is migrated.
+func BackupBlockChain(store storage.Store, backend, dir string) error {
+	if storage.Detect(dir) != "" {
+		return errors.Errorf("blockchain already exists in %s", dir)
+	}
+	backup, err := storage.Open(backend, dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to open backup DB")
+	}
+	defer backup.Close()
+	return storage.Copy(backup, store)
+}
+
+// Chains created before the schema was versioned may hold blocks of the original storage format,
+// they are converted before the network parameters are stored.
+func migrateLegacy(batch storage.Batch) error {
+	tip, err := convertLegacyBlocks(batch)
+	if err != nil {
+		return err
+	} else if tip == nil {
+		return migrateParams(batch)
+	}
+	params := MainNetParams
+	params.LegacyHeight = tip.Height
+	params.LegacyTip = tip.Hash
+	return putParams(batch, params)
+}
+
+// Chains created before network parameters were stored belong to the main network.
+func migrateParams(batch storage.Batch) error {
+	if _, err := batch.Get([]byte(paramsKey)); err != storage.ErrNotFound {
+		return err
+	}
+	// chains without a tip are empty, they are created with their parameters
+	if _, err := batch.Get([]byte(lastHashKey)); err == storage.ErrNotFound {
+		return nil
+	}
+	return putParams(batch, MainNetParams)
+}
+
+// Chains created before consensus engines were pluggable use proof of work.
+func migrateConsensus(batch storage.Batch) error {
+	encoded, err := batch.Get([]byte(paramsKey))
+	if err == storage.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// gob leaves out zero fields, so they must not be decoded over the main network's values
+	var params Params
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&params); err != nil {
+		return errors.Wrap(err, "failed to decode network parameters")
+	}
+	if params.Consensus != "" {
+		return nil
+	}
+	params.Consensus = ProofOfWorkConsensus
+	return putParams(batch, params)
+}
+
+// Migrates a Blockchain DB in a directory to the current schema version, returns its schema version before
+// and the migrations applied. If backupDir is given and the DB needs migrating, it is copied there first
+// with the same storage backend. Blockchain DBs are migrated when they are opened as well, without a backup.
+func MigrateBlockChainIn(dir string, dryRun bool, backupDir string) (int, []Migration, error) {
+	backend := storage.Detect(dir)
+	if backend == "" {
+		return 0, nil, errors.Errorf("no blockchain found in %s", dir)
+	}
+	store, err := storage.Open(backend, dir)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to open blockchain DB")
+	}
+	defer store.Close()
+
+	version, err := SchemaVersionOf(store)
+	if err != nil {
+		return 0, nil, err
+	}
+	pending, err := PendingMigrations(store)
+	if err != nil {
+		return version, nil, err
+	}
+	if backupDir != "" && len(pending) > 0 {
+		if err := BackupBlockChain(store, backend, backupDir); err != nil {
+			return version, nil, err
+		}
+	}
+	migrated, err := Migrate(store, dryRun)
+	return version, migrated, err
+}
+
+// Blocks of the original storage format had no height, timestamp or difficulty. Outputs were locked
+// to a public key hash and inputs carried a signature and a public key instead of scripts.
+type legacyBlock struct {
+	Hash         []byte
+	Transactions []*legacyTransaction
+	PrevHash     []byte
+	Nonce        int
+}
+
+type legacyTransaction struct {
+	ID      []byte
+	Inputs  []legacyTxInput
+	Outputs []legacyTxOutput
+}
+
+type legacyTxInput struct {
+	ID        []byte
+	Out       int
+	Signature []byte
+	PubKey    []byte // data of a coinbase input
+}
+
+type legacyTxOutput struct {
+	Value      int
+	PubKeyHash []byte
+}
+
+// Checks if a block was stored in the original format. Blocks of the current format decode
+// into a legacyBlock too, but without public key hashes, signatures and public keys.
+func (b *legacyBlock) isLegacy() bool {
+	for _, tx := range b.Transactions {
+		for _, in := range tx.Inputs {
+			if len(in.Signature) > 0 || len(in.PubKey) > 0 {
+				return true
+			}
+		}
+		for _, out := range tx.Outputs {
+			if len(out.PubKeyHash) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Re-encodes the blocks of a chain of the original storage format, returns the converted last block or nil
+// if the chain is not of the original format. Outputs get pay-to-public-key-hash locking scripts and inputs
+// unlocking scripts of their signature and public key. Heights are assigned by walking from the tip, blocks get
+// the time of the migration and are mined again at the main network's difficulty, as their hashes change
+// together with the IDs of their transactions.
+//
+// Signatures of the original format signed its encoding of transactions and their keys are not at hand,
+// so they can't be made again. The converted blocks are trusted instead, see Params.LegacyTip.
+func convertLegacyBlocks(batch storage.Batch) (*Block, error) {
+	lastHash, err := batch.Get([]byte(lastHashKey))
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var legacy []*legacyBlock
+	for hash := lastHash; len(hash) > 0; {
+		encoded, err := batch.Get(hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load block %x", hash)
+		}
+		var block legacyBlock
+		if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&block); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode block %x", hash)
+		}
+		if !block.isLegacy() {
+			if len(legacy) > 0 {
+				return nil, errors.Errorf("block %x of the current format precedes blocks of the original format", hash)
+			}
+			return nil, nil
+		}
+		legacy = append(legacy, &block)
+		hash = block.PrevHash
+	}
+
+	// transaction IDs change with their encoding, inputs refer to the converted IDs
+	txIDs := make(map[string][]byte)
+	timestamp := time.Now().Unix()
+	var block *Block
+	for i := len(legacy) - 1; i >= 0; i-- {
+		var prevHash []byte
+		if block != nil {
+			prevHash = block.Hash
+		}
+		block = &Block{
+			PrevHash:   prevHash,
+			Height:     len(legacy) - 1 - i,
+			Timestamp:  timestamp,
+			Difficulty: MainNetParams.Difficulty,
+		}
+		for _, legacyTx := range legacy[i].Transactions {
+			tx, err := convertLegacyTransaction(legacyTx, block.Height, txIDs)
+			if err != nil {
+				return nil, errors.Wrapf(err, "block %x", legacy[i].Hash)
+			}
+			txIDs[string(legacyTx.ID)] = tx.ID
+			block.Transactions = append(block.Transactions, tx)
+		}
+		if err := NewMiner().Mine(context.Background(), block); err != nil {
+			return nil, errors.Wrapf(err, "failed to mine converted block %x", legacy[i].Hash)
+		}
+
+		if err := batch.Delete(legacy[i].Hash); err != nil {
+			return nil, err
+		}
+		if err := batch.Put(block.Hash, block.Serialize()); err != nil {
+			return nil, err
+		}
+	}
+	return block, batch.Put([]byte(lastHashKey), block.Hash)
+}
+
+// Converts a transaction of the original storage format of a block at height.
+// IDs of the preceding transactions are mapped to their converted IDs by txIDs.
+func convertLegacyTransaction(legacyTx *legacyTransaction, height int, txIDs map[string][]byte) (*Transaction, error) {
+	tx := &Transaction{}
+	for _, out := range legacyTx.Outputs {
+		tx.Outputs = append(tx.Outputs, TxOutput{out.Value, script.PayToPubKeyHash(out.PubKeyHash)})
+	}
+
+	if len(legacyTx.Inputs) == 1 && len(legacyTx.Inputs[0].ID) == 0 && legacyTx.Inputs[0].Out == -1 {
+		unlockingScript := script.NewBuilder().
+			AddInt(int64(height)).
+			AddData(make([]byte, ExtraNonceSize)).
+			AddData(legacyTx.Inputs[0].PubKey).
+			Script()
+		tx.Inputs = []TxInput{{[]byte{}, -1, unlockingScript, MaxSequence}}
+		tx.SetID()
+		return tx, nil
+	}
+
+	for _, in := range legacyTx.Inputs {
+		prevID, ok := txIDs[string(in.ID)]
+		if !ok {
+			return nil, errors.Errorf("transaction %x spends unknown transaction %x", legacyTx.ID, in.ID)
+		}
+		tx.Inputs = append(tx.Inputs, TxInput{prevID, in.Out, nil, MaxSequence})
+	}
+	tx.ID = tx.Hash()
+	for i, in := range legacyTx.Inputs {
+		tx.Inputs[i].UnlockingScript = script.PayToPubKeyHashUnlock(in.Signature, in.PubKey)
+	}
+	return tx, nil
+}
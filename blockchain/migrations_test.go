@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// Addresses of the wallets used by testdata/legacy_chain.txt.
+const (
+	legacyAlice = "19UKytgdkzLU9qmJN4CbAwjtSAYhMMvTqB"
+	legacyBob   = "1GAxzcsmnD5FjfVp6D4Gt5qwmJr7oKYPmm"
+)
+
+// Loads the DB of testdata/legacy_chain.txt, written in the original storage format before the schema
+// was versioned, into memory.
+func loadLegacyDB(t *testing.T) storage.Store {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", "legacy_chain.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	err = store.Update(func(batch storage.Batch) error {
+		for _, line := range strings.Split(string(content), "\n") {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return errors.Errorf("invalid line %q", line)
+			}
+			key, err := hex.DecodeString(fields[0])
+			if err != nil {
+				return err
+			}
+			value, err := hex.DecodeString(fields[1])
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	store := loadLegacyDB(t)
+	if version, err := SchemaVersionOf(store); err != nil || version != 0 {
+		t.Fatalf("expected a legacy DB to have schema version 0, got %d %v", version, err)
+	}
+
+	loaded, err := LoadBlockChain(store)
+	if err != nil {
+		t.Fatalf("failed to load legacy DB: %v", err)
+	}
+	if loaded.Params.Name != MainNetParams.Name || loaded.Params.Consensus != ProofOfWorkConsensus {
+		t.Fatalf("expected a legacy chain to belong to the main network, got %+v", loaded.Params)
+	}
+	if version, err := SchemaVersionOf(store); err != nil || version != SchemaVersion {
+		t.Fatalf("expected schema version %d after migrating, got %d %v", SchemaVersion, version, err)
+	}
+
+	height := 2
+	for iter := loaded.Iterator(); ; height-- {
+		block := iter.Next()
+		if block.Height != height {
+			t.Fatalf("expected block %x to have height %d, got %d", block.Hash, height, block.Height)
+		}
+		if err := loaded.Engine.VerifySeal(loaded, block); err != nil {
+			t.Fatalf("expected the converted block %d to be sealed: %v", height, err)
+		}
+		for _, tx := range block.Transactions {
+			for _, in := range tx.Inputs {
+				if len(in.UnlockingScript) == 0 {
+					t.Fatalf("expected inputs of block %d to have unlocking scripts", height)
+				}
+			}
+			for _, out := range tx.Outputs {
+				if !script.IsPayToPubKeyHash(out.LockingScript) {
+					t.Fatalf("expected outputs of block %d to be locked to public key hashes, got %s", height, out.LockingScript)
+				}
+			}
+		}
+		if height == 0 {
+			if err := checkCoinbase(block.Transactions[0], 0, BlockReward); err != nil {
+				t.Fatalf("expected the converted genesis coinbase to be valid: %v", err)
+			}
+			if len(block.PrevHash) != 0 {
+				t.Fatal("expected the genesis block to be reached")
+			}
+			break
+		}
+	}
+	for address, expected := range map[string]int{legacyAlice: 80, legacyBob: 20} {
+		if balance, _ := balanceOf(t, loaded, address); balance != expected {
+			t.Fatalf("expected the balance of %s to be %d after migrating, got %d", address, expected, balance)
+		}
+	}
+	if loaded.Params.LegacyHeight != 2 || !bytes.Equal(loaded.Params.LegacyTip, loaded.LastHash()) {
+		t.Fatalf("expected the converted blocks to end with the last block, got %+v", loaded.Params)
+	}
+}
+
+// Converted blocks keep the signatures of the original storage format, they are trusted by the node's own validation.
+func TestMigratedLegacyDBIsValid(t *testing.T) {
+	migrated, err := LoadBlockChain(loadLegacyDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportBlockChain(storage.NewMemoryStore(), exportChain(t, migrated))
+	if err != nil {
+		t.Fatalf("failed to import the migrated chain: %v", err)
+	}
+	defer imported.Database.Close()
+	if !bytes.Equal(imported.LastHash(), migrated.LastHash()) {
+		t.Fatalf("imported chain ends with %x, expected %x", imported.LastHash(), migrated.LastHash())
+	}
+
+	loaded, _ := loadSnapshotOf(t, migrated, 1)
+	if err := loaded.ValidateSnapshot(context.Background(), exportChain(t, migrated)); err != nil {
+		t.Fatalf("failed to validate a snapshot of the migrated chain: %v", err)
+	}
+
+	// the converted blocks are pinned by the hash of the last one
+	migrated.Params.LegacyTip = make([]byte, len(migrated.Params.LegacyTip))
+	if _, err := ImportBlockChain(storage.NewMemoryStore(), exportChain(t, migrated)); err == nil {
+		t.Fatal("expected blocks other than the converted ones not to be trusted")
+	}
+}
+
+func TestMigrateConsensus(t *testing.T) {
+	chain, _, _ := newRegTestChain(t, 0)
+	params := chain.Params
+	params.Consensus = ""
+	err := chain.Database.Update(func(batch storage.Batch) error {
+		if err := putParams(batch, params); err != nil {
+			return err
+		}
+		return putSchemaVersion(batch, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadBlockChain(chain.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Params.Name != RegTestParams.Name || loaded.Params.Consensus != ProofOfWorkConsensus {
+		t.Fatalf("expected a regtest proof-of-work chain, got %+v", loaded.Params)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	store := loadLegacyDB(t)
+	lastHash, _ := store.Get([]byte(lastHashKey))
+
+	applied, err := Migrate(store, true)
+	if err != nil || len(applied) != len(migrations) {
+		t.Fatalf("expected %d migrations to be applied, got %d %v", len(migrations), len(applied), err)
+	}
+	if version, _ := SchemaVersionOf(store); version != 0 {
+		t.Fatalf("expected a dry run to leave the schema version, got %d", version)
+	}
+	if _, err := store.Get([]byte(paramsKey)); err != storage.ErrNotFound {
+		t.Fatalf("expected a dry run not to store network parameters, got %v", err)
+	}
+	if hash, _ := store.Get([]byte(lastHashKey)); !bytes.Equal(hash, lastHash) {
+		t.Fatalf("expected a dry run not to convert blocks, got tip %x", hash)
+	}
+
+	backup := storage.NewMemoryStore()
+	if err := storage.Copy(backup, store); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := PendingMigrations(backup); err != nil || len(pending) != len(migrations) {
+		t.Fatalf("expected the backup to need %d migrations, got %d %v", len(migrations), len(pending), err)
+	}
+}
+
+func TestNewerSchema(t *testing.T) {
+	chain, _, _ := newRegTestChain(t, 0)
+	err := chain.Database.Update(func(batch storage.Batch) error {
+		return putSchemaVersion(batch, SchemaVersion+1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBlockChain(chain.Database); errors.Cause(err) != ErrNewerSchema {
+		t.Fatalf("expected a newer schema to be rejected, got %v", err)
+	}
+}
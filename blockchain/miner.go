@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Miner computes the proof of work of blocks, splitting the nonce space between concurrent workers.
+type Miner struct {
+	Workers          int                    // number of concurrent workers
+	HashRateInterval time.Duration          // how often OnHashRate is called
+	OnHashRate       func(hashRate float64) // receives the hash rate in hashes per second, if set
+	maxNonce         uint64                 // largest nonce tried before the extra nonce changes, MaxNonce if zero
+}
+
+// Creates a new Miner with a worker for every CPU core usable by the process.
+func NewMiner() *Miner {
+	return &Miner{
+		Workers:          runtime.GOMAXPROCS(0),
+		HashRateInterval: time.Second,
+	}
+}
+
+// Mines a block, setting its nonce and hash. Once all nonces are tried the extra nonce of the block's
+// coinbase is incremented, which changes the block data, blocks without a coinbase get a newer timestamp.
+// Mining stops with the context error when ctx is cancelled, e.g. when a new tip arrives.
+func (m *Miner) Mine(ctx context.Context, block *Block) error {
+	var hashes uint64
+	if m.OnHashRate != nil {
+		stop := m.reportHashRate(&hashes)
+		defer stop()
+	}
+
+	for extraNonce := uint64(1); ; extraNonce++ {
+		pow := NewProof(block)
+		if m.maxNonce > 0 {
+			pow.maxNonce = m.maxNonce
+		}
+		nonce, hash, err := pow.Run(ctx, m.Workers, &hashes)
+		if err == nil {
+			block.Nonce = nonce
+			block.Hash = hash
+			powLog.Debug().Hex("hash", hash).Int("nonce", nonce).Msg("proof of work found")
+			return nil
+		}
+		if err != ErrNonceSpaceExhausted {
+			return err
+		}
+
+		if !block.SetExtraNonce(extraNonce) {
+			block.Timestamp++
+		}
+	}
+}
+
+// Periodically reports the hash rate computed from the hashes counter until the returned function is called.
+func (m *Miner) reportHashRate(hashes *uint64) func() {
+	interval := m.HashRateInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last, lastTime := uint64(0), time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				current := atomic.LoadUint64(hashes)
+				m.OnHashRate(float64(current-last) / now.Sub(lastTime).Seconds())
+				last, lastTime = current, now
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Difficulty no hash satisfies in practice, so mining only stops when it is cancelled.
+const impossibleDifficulty = 256
+
+// Creates a block at height with a coinbase rewarding a new address.
+func newMinerTestBlock(t *testing.T, height, difficulty int) *Block {
+	t.Helper()
+	coinbase := newCoinbase(t, string(wallet.MakeWallet().Address()), height)
+	return NewBlock([]*Transaction{coinbase}, []byte("previous"), height, difficulty)
+}
+
+func TestMineIsCancelled(t *testing.T) {
+	block := newMinerTestBlock(t, 1, impossibleDifficulty)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := NewProof(block).Run(ctx, 2, nil); err != context.Canceled {
+		t.Fatalf("expected a cancelled search to fail with %v, got %v", context.Canceled, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- (&Miner{Workers: 2}).Mine(ctx, block) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected mining to stop with %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("mining did not stop after the context was cancelled")
+	}
+	if len(block.Hash) > 0 {
+		t.Errorf("expected a cancelled block to stay unmined, got hash %x", block.Hash)
+	}
+}
+
+func TestMinerReportsHashRate(t *testing.T) {
+	block := newMinerTestBlock(t, 1, impossibleDifficulty)
+
+	reports := make(chan float64, 100)
+	miner := &Miner{
+		Workers:          2,
+		HashRateInterval: 10 * time.Millisecond,
+		OnHashRate: func(hashRate float64) {
+			select {
+			case reports <- hashRate:
+			default:
+			}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := miner.Mine(ctx, block); err != context.DeadlineExceeded {
+		t.Fatalf("expected mining to stop at the deadline, got %v", err)
+	}
+
+	close(reports)
+	count, positive := 0, false
+	for hashRate := range reports {
+		count++
+		if hashRate < 0 {
+			t.Errorf("expected a non-negative hash rate, got %f", hashRate)
+		}
+		positive = positive || hashRate > 0
+	}
+	if count == 0 || !positive {
+		t.Fatalf("expected hash rate reports while mining, got %d reports", count)
+	}
+}
+
+func TestMinerBumpsExtraNonce(t *testing.T) {
+	const difficulty, maxNonce = 8, 63
+
+	// find a block whose first nonce space has no solution
+	var block *Block
+	for height := 1; block == nil; height++ {
+		candidate := newMinerTestBlock(t, height, difficulty)
+		pow := NewProof(candidate)
+		pow.maxNonce = maxNonce
+		if _, _, err := pow.Run(context.Background(), 1, nil); err == ErrNonceSpaceExhausted {
+			block = candidate
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	coinbaseID := block.Transactions[0].ID
+
+	miner := &Miner{Workers: 2, maxNonce: maxNonce}
+	if err := miner.Mine(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := block.Transactions[0]
+	if bytes.Equal(coinbase.ID, coinbaseID) {
+		t.Errorf("expected the coinbase to change once the nonce space was exhausted")
+	}
+	instructions, err := coinbase.Inputs[0].UnlockingScript.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(instructions[1].Data, make([]byte, ExtraNonceSize)) {
+		t.Errorf("expected the extra nonce to be bumped, got %x", instructions[1].Data)
+	}
+	if block.Nonce > maxNonce || !NewProof(block).Validate() {
+		t.Errorf("expected a valid proof of work within the nonce space, got nonce %d", block.Nonce)
+	}
+	if !bytes.Equal(block.Hash, NewProof(block).Hash()) {
+		t.Errorf("expected the block hash to cover the new coinbase")
+	}
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+func TestMultiSigSigning(t *testing.T) {
+	chain, wallets, _ := newRegTestChain(t, 0)
+	miner := string(wallet.MakeWallet().Address())
+	recipient := string(wallet.MakeWallet().Address())
+
+	// every signer holds only their own key and the multisig address
+	var pubKeys [][]byte
+	var signers []*wallet.Wallets
+	for i := 0; i < 3; i++ {
+		w := wallet.MakeWallet()
+		pubKeys = append(pubKeys, w.PublicKey)
+		signers = append(signers, &wallet.Wallets{
+			Wallets:   map[string]*wallet.Wallet{string(w.Address()): w},
+			MultiSigs: make(map[string]*wallet.MultiSig),
+		})
+	}
+	var address string
+	for _, signer := range signers {
+		var err error
+		if address, err = signer.AddMultiSig(2, pubKeys); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	funding := spendGenesis(t, chain, wallets, address)
+	if _, err := chain.SubmitRawTransaction(context.Background(), funding, miner); err != nil {
+		t.Fatal(err)
+	}
+	rawTx, err := chain.CreateRawTransaction(address, recipient, 60, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file travels from one signer to the next
+	file := filepath.Join(t.TempDir(), "multisig.json")
+	if err := rawTx.SaveFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []string{"1 of 2 signatures", "signed"} {
+		rawTx, err = LoadRawTransaction(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// signing twice with the same key adds no signature
+		for j := 0; j < 2; j++ {
+			if signed := rawTx.Sign(signers[i]); signed != 1 {
+				t.Fatalf("expected signer %d to sign the input, signed %d inputs", i, signed)
+			}
+		}
+		if status := strings.Join(rawTx.SigningStatus(), ","); status != expected {
+			t.Fatalf("expected %q after signer %d, got %q", expected, i, status)
+		}
+		if err := rawTx.SaveFile(file); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, miner); err == nil {
+				t.Fatal("expected a transaction with a single signature to be refused")
+			}
+			if err := chain.ValidateTransaction(&rawTx.Tx); err == nil {
+				t.Fatal("expected a transaction with a single signature to be invalid")
+			}
+		}
+	}
+
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, miner); err != nil {
+		t.Fatalf("expected a transaction with 2 of 3 signatures to be mined, got %v", err)
+	}
+	if balance, _ := balanceOf(t, chain, recipient); balance != 60 {
+		t.Errorf("expected the recipient to receive 60, got %d", balance)
+	}
+	if balance, _ := balanceOf(t, chain, address); balance != BlockReward-60 {
+		t.Errorf("expected the change of %d to return to the multisig address, got %d", BlockReward-60, balance)
+	}
+}
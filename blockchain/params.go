@@ -0,0 +1,55 @@
+package blockchain
+
+import "github.com/pkg/errors"
+
+// Params defines the rules of the network a chain belongs to.
+// They are stored with the chain when it is created, so that all blocks are validated by the same rules.
+type Params struct {
+	// Name of the network.
+	Name string
+	// Number of leading zero bits of a block hash required by the proof of work.
+	Difficulty int
+	// Number of blocks which have to be mined on top of a block before its coinbase outputs can be spent.
+	CoinbaseMaturity int
+	// Consensus engine of the chain, ProofOfWorkConsensus if empty.
+	Consensus string
+	// Public keys of the signers taking turns in creating blocks of a proof-of-authority chain,
+	// or of the validators creating blocks of a proof-of-stake chain until coins are staked.
+	Signers [][]byte
+	// Height and hash of the last block converted from the original storage format, which had no scripts,
+	// heights or coinbases after the Genesis. Blocks up to it are trusted: their signatures were made over
+	// the original encoding, so their transactions are checked without scripts and coinbase maturity.
+	LegacyHeight int
+	LegacyTip    []byte
+}
+
+// Checks if a block at height was converted from the original storage format.
+func (params Params) isLegacy(height int) bool {
+	return len(params.LegacyTip) > 0 && height <= params.LegacyHeight
+}
+
+// Parameters of the main network.
+var MainNetParams = Params{
+	Name:             "main",
+	Difficulty:       Difficulty,
+	CoinbaseMaturity: 100,
+	Consensus:        ProofOfWorkConsensus,
+}
+
+// Parameters of the regression test network, blocks are mined instantly so any chain state can be built quickly.
+var RegTestParams = Params{
+	Name:             "regtest",
+	Difficulty:       1,
+	CoinbaseMaturity: 100,
+	Consensus:        ProofOfWorkConsensus,
+}
+
+// Gets parameters of a network by its name.
+func ParamsForNetwork(name string) (Params, error) {
+	for _, params := range []Params{MainNetParams, RegTestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return Params{}, errors.Errorf("unknown network %s", name)
+}
@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// ProofOfAuthorityEngine lets a configured set of signers take turns in creating blocks, the block at height h
+// is proposed by Signers[h % len(Signers)]. Blocks are signed by their proposer instead of being mined,
+// so their difficulty and nonce are zero. A signer which is offline stops the chain until it is back.
+type ProofOfAuthorityEngine struct {
+	Signers [][]byte // public keys encoded by wallet.PublicKeyBytes
+}
+
+// Creates a proof-of-authority engine with a set of signer public keys.
+func NewProofOfAuthorityEngine(signers [][]byte) (*ProofOfAuthorityEngine, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("proof of authority needs at least one signer")
+	}
+	if err := checkPublicKeys(signers); err != nil {
+		return nil, err
+	}
+	return &ProofOfAuthorityEngine{signers}, nil
+}
+
+// Checks that keys are public keys encoded by wallet.PublicKeyBytes.
+func checkPublicKeys(pubKeys [][]byte) error {
+	for _, pubKey := range pubKeys {
+		if len(pubKey) != 64 {
+			return errors.Errorf("invalid signer public key %x", pubKey)
+		}
+	}
+	return nil
+}
+
+// Gets the signer whose turn it is to propose the block at height.
+func (e *ProofOfAuthorityEngine) proposer(height int) []byte {
+	return e.Signers[height%len(e.Signers)]
+}
+
+func (e *ProofOfAuthorityEngine) SelectProposer(chain *BlockChain, parent *Block) ([]byte, error) {
+	return e.proposer(parent.Height + 1), nil
+}
+
+func (e *ProofOfAuthorityEngine) Seal(ctx context.Context, block *Block, proposer *wallet.Wallet) error {
+	block.Nonce = 0
+	if block.Height == 0 {
+		block.Hash = block.HeaderHash()
+		return nil
+	}
+
+	if proposer == nil || !bytes.Equal(proposer.PublicKey, e.proposer(block.Height)) {
+		return errors.Errorf("block %d has to be signed by %x", block.Height, e.proposer(block.Height))
+	}
+	signBlock(block, proposer)
+	return nil
+}
+
+func (e *ProofOfAuthorityEngine) VerifySeal(chain *BlockChain, block *Block) error {
+	if block.Nonce != 0 {
+		return errors.New("signed block must have a zero nonce")
+	}
+	if !bytes.Equal(block.Hash, block.HeaderHash()) {
+		return errors.New("block hash does not match its content")
+	}
+	if block.Height == 0 {
+		return nil
+	}
+
+	return verifyBlockSigner(block, e.proposer(block.Height))
+}
+
+// Signs the hash of a block with the wallet of its proposer.
+func signBlock(block *Block, proposer *wallet.Wallet) {
+	block.Signer = proposer.PublicKey
+	block.Hash = block.HeaderHash()
+	block.Signature = signHash(proposer.PrivateKey, block.Hash)
+}
+
+// Checks that a block is signed by the expected proposer.
+func verifyBlockSigner(block *Block, expected []byte) error {
+	if !bytes.Equal(block.Signer, expected) {
+		return errors.Errorf("block %d has to be signed by %x, not %x", block.Height, expected, block.Signer)
+	}
+	if !verifySignature(block.Signer, block.Hash, block.Signature) {
+		return errors.New("invalid block signature")
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Creates a proof-of-authority regtest chain with a number of signers, whose wallets are the chain's wallets.
+func newProofOfAuthorityChain(t *testing.T, signers int) (*BlockChain, []*wallet.Wallet) {
+	t.Helper()
+
+	wallets := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet)}
+	params := RegTestParams
+	params.Difficulty = 0
+	params.Consensus = ProofOfAuthorityConsensus
+	var signerWallets []*wallet.Wallet
+	for i := 0; i < signers; i++ {
+		signer := wallet.MakeWallet()
+		wallets.Wallets[string(signer.Address())] = signer
+		signerWallets = append(signerWallets, signer)
+		params.Signers = append(params.Signers, signer.PublicKey)
+	}
+
+	chain, err := NewBlockChain(storage.NewMemoryStore(), string(signerWallets[0].Address()), params)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+	chain.Wallets = wallets
+
+	return chain, signerWallets
+}
+
+// Creates the block following the last block of a chain, signed by a wallet which may not be its proposer.
+func newSignedBlock(t *testing.T, chain *BlockChain, signer *wallet.Wallet) *Block {
+	t.Helper()
+	last := chain.LastBlock()
+	coinbase := newCoinbase(t, string(signer.Address()), last.Height+1)
+	block := NewBlock([]*Transaction{coinbase}, last.Hash, last.Height+1, chain.Params.Difficulty)
+	if block.Timestamp < last.Timestamp {
+		block.Timestamp = last.Timestamp
+	}
+	signBlock(block, signer)
+	return block
+}
+
+func TestProofOfAuthorityRoundRobin(t *testing.T) {
+	chain, signers := newProofOfAuthorityChain(t, 3)
+
+	generate(t, chain, string(signers[0].Address()), 7)
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if block.Height == 0 {
+			break
+		}
+		expected := signers[block.Height%len(signers)]
+		if !bytes.Equal(block.Signer, expected.PublicKey) {
+			t.Errorf("expected block %d to be signed by signer %d", block.Height, block.Height%len(signers))
+		}
+		if block.Nonce != 0 || block.Difficulty != 0 {
+			t.Errorf("expected signed block %d to have no proof of work", block.Height)
+		}
+	}
+
+	proposer, err := chain.Engine.SelectProposer(chain, chain.LastBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(proposer, signers[8%len(signers)].PublicKey) {
+		t.Errorf("expected block 8 to be proposed by signer %d", 8%len(signers))
+	}
+	proposerWallet, err := chain.FindProposerWallet(chain.Wallets)
+	if err != nil || proposerWallet != signers[8%len(signers)] {
+		t.Errorf("expected the wallet of signer %d to propose the next block, got %v", 8%len(signers), err)
+	}
+}
+
+func TestProofOfAuthorityRejectsWrongSigners(t *testing.T) {
+	chain, signers := newProofOfAuthorityChain(t, 2)
+	// block 1 is proposed by signers[1]
+	outOfTurn, unknown := signers[0], wallet.MakeWallet()
+
+	if err := chain.Engine.Seal(context.Background(), newSignedBlock(t, chain, outOfTurn), outOfTurn); err == nil {
+		t.Error("expected sealing a block out of turn to fail")
+	}
+	for name, signer := range map[string]*wallet.Wallet{"out of turn": outOfTurn, "unknown": unknown} {
+		err := chain.SubmitBlock(newSignedBlock(t, chain, signer))
+		if err == nil || !strings.Contains(err.Error(), "has to be signed by") {
+			t.Errorf("expected a block signed by an %s signer to be rejected, got %v", name, err)
+		}
+	}
+
+	if err := chain.SubmitBlock(newSignedBlock(t, chain, signers[1])); err != nil {
+		t.Fatalf("expected a block signed in turn to be accepted, got %v", err)
+	}
+	if last := chain.LastBlock(); last.Height != 1 {
+		t.Errorf("expected only the block signed in turn to be connected, got height %d", last.Height)
+	}
+}
+
+func TestProofOfAuthorityRejectsBadSignatures(t *testing.T) {
+	chain, signers := newProofOfAuthorityChain(t, 2)
+	proposer, other := signers[1], signers[0]
+
+	corrupted := newSignedBlock(t, chain, proposer)
+	corrupted.Signature[len(corrupted.Signature)-1] ^= 0xff
+
+	// the block claims the proposer's key but is signed with another key
+	forged := newSignedBlock(t, chain, other)
+	forged.Signer = proposer.PublicKey
+	forged.Hash = forged.HeaderHash()
+	forged.Signature = signHash(other.PrivateKey, forged.Hash)
+
+	// the content changes after the block was signed
+	tampered := newSignedBlock(t, chain, proposer)
+	tampered.Timestamp++
+
+	tests := map[string]struct {
+		block    *Block
+		expected string
+	}{
+		"corrupted": {corrupted, "invalid block signature"},
+		"forged":    {forged, "invalid block signature"},
+		"tampered":  {tampered, "block hash does not match its content"},
+	}
+	for name, test := range tests {
+		err := chain.SubmitBlock(test.block)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected a %s block to be rejected with %q, got %v", name, test.expected, err)
+		}
+	}
+	if last := chain.LastBlock(); last.Height != 0 {
+		t.Errorf("expected no block to be connected, got height %d", last.Height)
+	}
+}
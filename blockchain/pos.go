@@ -0,0 +1,257 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Prefix of keys of double sign evidence waiting to be included in a block.
+const evidencePrefix = "evidence-"
+
+// ProofOfStakeEngine lets holders of coins propose blocks with a probability proportional to their stake.
+// Coins are staked by locking them in stake outputs of the proposer's public key (script.PayToStake), they count
+// from the block following the one they were mined in until they are spent. The proposer of a block is drawn
+// from a seed derived from the parent block, so every node selects the same one. Until coins are staked
+// the genesis validators propose blocks with equal weights. Blocks are signed by their proposer instead of
+// being mined, so their difficulty and nonce are zero.
+//
+// A proposer signing two blocks at the same height is slashed by evidence included in a later block:
+// its stake is no longer counted and can't be withdrawn.
+type ProofOfStakeEngine struct {
+	Validators [][]byte // public keys proposing blocks until coins are staked
+}
+
+// Creates a proof-of-stake engine with the public keys of the genesis validators.
+func NewProofOfStakeEngine(validators [][]byte) (*ProofOfStakeEngine, error) {
+	if len(validators) == 0 {
+		return nil, errors.New("proof of stake needs at least one genesis validator")
+	}
+	if err := checkPublicKeys(validators); err != nil {
+		return nil, err
+	}
+	return &ProofOfStakeEngine{validators}, nil
+}
+
+// Draws the proposer of the block following parent, every staker is weighted by its stake.
+// The seed can be influenced by the proposer of the parent, which is good enough for an experimental chain.
+func (e *ProofOfStakeEngine) SelectProposer(chain *BlockChain, parent *Block) ([]byte, error) {
+	stakes := chain.stakesAt(parent.Hash)
+
+	var candidates []Stake
+	total := 0
+	for _, stake := range stakes {
+		if !stake.Slashed && stake.Amount > 0 {
+			candidates = append(candidates, stake)
+			total += stake.Amount
+		}
+	}
+	if total == 0 {
+		for _, validator := range e.Validators {
+			if !isSlashed(stakes, validator) {
+				candidates = append(candidates, Stake{PubKey: validator, Amount: 1})
+				total++
+			}
+		}
+	}
+	if total == 0 {
+		return nil, errors.New("no coins are staked and all genesis validators were slashed")
+	}
+
+	seed := sha256.Sum256(append(append([]byte{}, parent.Hash...), ToBytes(int64(parent.Height+1))...))
+	draw := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), big.NewInt(int64(total))).Int64()
+	for _, candidate := range candidates {
+		if draw < int64(candidate.Amount) {
+			return candidate.PubKey, nil
+		}
+		draw -= int64(candidate.Amount)
+	}
+	return nil, errors.New("failed to draw a proposer")
+}
+
+func (e *ProofOfStakeEngine) Seal(ctx context.Context, block *Block, proposer *wallet.Wallet) error {
+	block.Nonce = 0
+	if block.Height == 0 {
+		block.Hash = block.HeaderHash()
+		return nil
+	}
+
+	if proposer == nil {
+		return errors.Errorf("block %d has to be signed by its proposer", block.Height)
+	}
+	signBlock(block, proposer)
+	return nil
+}
+
+func (e *ProofOfStakeEngine) VerifySeal(chain *BlockChain, block *Block) error {
+	if block.Nonce != 0 {
+		return errors.New("signed block must have a zero nonce")
+	}
+	if !bytes.Equal(block.Hash, block.HeaderHash()) {
+		return errors.New("block hash does not match its content")
+	}
+	if block.Height == 0 {
+		return nil
+	}
+
+	parent := (&BlockChainIterator{block.PrevHash, chain.Database}).Next()
+	expected, err := e.SelectProposer(chain, parent)
+	if err != nil {
+		return err
+	}
+	if err := verifyBlockSigner(block, expected); err != nil {
+		return err
+	}
+	return chain.verifyEvidence(block)
+}
+
+// DoubleSignEvidence proves that a proposer signed two different blocks at the same height.
+type DoubleSignEvidence struct {
+	First  BlockHeader
+	Second BlockHeader
+}
+
+// Creates evidence of a proposer signing two blocks, returns an error if the blocks don't prove it.
+func NewDoubleSignEvidence(first, second *Block) (*DoubleSignEvidence, error) {
+	evidence := &DoubleSignEvidence{first.Header(), second.Header()}
+	if err := evidence.Verify(); err != nil {
+		return nil, err
+	}
+	return evidence, nil
+}
+
+// Gets the public key of the proposer who signed both blocks.
+func (e *DoubleSignEvidence) Signer() []byte {
+	return e.First.Signer
+}
+
+// Verifies that both headers are different blocks at the same height signed by the same proposer.
+func (e *DoubleSignEvidence) Verify() error {
+	if len(e.First.Signer) == 0 || !bytes.Equal(e.First.Signer, e.Second.Signer) {
+		return errors.New("evidence blocks are not signed by the same proposer")
+	}
+	if e.First.Height != e.Second.Height {
+		return errors.New("evidence blocks are not at the same height")
+	}
+	first, second := e.First.Hash(), e.Second.Hash()
+	if bytes.Equal(first, second) {
+		return errors.New("evidence blocks are the same block")
+	}
+	if !verifySignature(e.First.Signer, first, e.First.Signature) ||
+		!verifySignature(e.Second.Signer, second, e.Second.Signature) {
+		return errors.New("invalid signature of an evidence block")
+	}
+	return nil
+}
+
+// Hashes the evidence together with the signatures of both blocks.
+func (e *DoubleSignEvidence) Hash() []byte {
+	hash := sha256.Sum256(bytes.Join(
+		[][]byte{e.First.Hash(), e.First.Signer, e.First.Signature, e.Second.Hash(), e.Second.Signature},
+		[]byte{},
+	))
+	return hash[:]
+}
+
+// Checks the evidence within a block, every evidence has to slash a proposer which is not slashed yet.
+func (chain *BlockChain) verifyEvidence(block *Block) error {
+	stakes := chain.stakesAt(block.PrevHash)
+	slashed := make(map[string]bool)
+	for _, evidence := range block.Evidence {
+		if err := evidence.Verify(); err != nil {
+			return errors.Wrap(err, "invalid double sign evidence")
+		}
+		if evidence.First.Height > block.Height {
+			return errors.New("double sign evidence of a future block")
+		}
+		signer := hex.EncodeToString(evidence.Signer())
+		if slashed[signer] || isSlashed(stakes, evidence.Signer()) {
+			return errors.Errorf("proposer %s is already slashed", signer)
+		}
+		slashed[signer] = true
+	}
+	return nil
+}
+
+// Finds the block of the chain at the height of a block signed by the same proposer.
+// Returns evidence of the proposer signing both if they differ, nil if the block doesn't conflict with the chain.
+func (chain *BlockChain) FindDoubleSign(block *Block) (*DoubleSignEvidence, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	if len(block.Signer) == 0 {
+		return nil, nil
+	}
+
+	iter := chain.Iterator()
+	for {
+		chainBlock := iter.Next()
+		if chainBlock.Height == block.Height {
+			if !bytes.Equal(chainBlock.Signer, block.Signer) || bytes.Equal(chainBlock.Hash, block.HeaderHash()) {
+				return nil, nil
+			}
+			return NewDoubleSignEvidence(chainBlock, block)
+		}
+		if chainBlock.Height < block.Height || chain.IsFirstBlock(chainBlock) {
+			return nil, nil
+		}
+	}
+}
+
+// Records double sign evidence, it is included in the next block proposed by the node.
+func (chain *BlockChain) AddEvidence(evidence *DoubleSignEvidence) error {
+	if chain.Params.Consensus != ProofOfStakeConsensus {
+		return errors.New("double sign evidence is used only by proof of stake")
+	}
+	if err := evidence.Verify(); err != nil {
+		return err
+	}
+	if isSlashed(chain.Stakes(), evidence.Signer()) {
+		return errors.Errorf("proposer %x is already slashed", evidence.Signer())
+	}
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(evidence); err != nil {
+		return errors.Wrap(err, "failed to encode evidence")
+	}
+	err := chain.Database.Update(func(batch storage.Batch) error {
+		return batch.Put(evidenceKey(evidence.Signer()), encoded.Bytes())
+	})
+	return errors.Wrap(err, "failed to store evidence")
+}
+
+// Gets the recorded evidence against proposers which are not slashed yet.
+func (chain *BlockChain) PendingEvidence() []*DoubleSignEvidence {
+	var pending []*DoubleSignEvidence
+	err := chain.Database.Iterate([]byte(evidencePrefix), func(key, value []byte) error {
+		var evidence DoubleSignEvidence
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&evidence); err != nil {
+			return err
+		}
+		pending = append(pending, &evidence)
+		return nil
+	})
+	HandleError(errors.Wrap(err, "failed to load evidence"))
+
+	stakes := chain.Stakes()
+	var unslashed []*DoubleSignEvidence
+	for _, evidence := range pending {
+		if !isSlashed(stakes, evidence.Signer()) {
+			unslashed = append(unslashed, evidence)
+		}
+	}
+	return unslashed
+}
+
+// Gets the key of evidence against a proposer, only one evidence is needed to slash it.
+func evidenceKey(signer []byte) []byte {
+	return []byte(evidencePrefix + hex.EncodeToString(signer))
+}
@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Creates a proof-of-stake regtest chain with a genesis validator, the Genesis reward is sent to a staker.
+func newProofOfStakeChain(t *testing.T) (*BlockChain, *wallet.Wallet, *wallet.Wallet) {
+	t.Helper()
+
+	validator := wallet.MakeWallet()
+	staker := wallet.MakeWallet()
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{
+		string(validator.Address()): validator,
+		string(staker.Address()):    staker,
+	}}
+
+	params := RegTestParams
+	params.Difficulty = 0
+	params.CoinbaseMaturity = 0
+	params.Consensus = ProofOfStakeConsensus
+	params.Signers = [][]byte{validator.PublicKey}
+	chain, err := NewBlockChain(storage.NewMemoryStore(), string(staker.Address()), params)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+	chain.Wallets = wallets
+
+	return chain, validator, staker
+}
+
+func stake(t *testing.T, chain *BlockChain, staker *wallet.Wallet, amount int) {
+	t.Helper()
+	rawTx, err := chain.CreateRawStakeTransaction(string(staker.Address()), staker.PublicKey, amount)
+	if err != nil {
+		t.Fatalf("failed to create stake transaction: %v", err)
+	}
+	rawTx.Sign(chain.Wallets)
+	// the block reward goes to another address, so the staker's balance shows only the stake
+	rewardAddress := string(wallet.MakeWallet().Address())
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, rewardAddress); err != nil {
+		t.Fatalf("failed to stake: %v", err)
+	}
+}
+
+func TestProofOfStakeProposers(t *testing.T) {
+	chain, validator, staker := newProofOfStakeChain(t)
+
+	// the genesis validator proposes blocks until coins are staked
+	generate(t, chain, string(validator.Address()), 2)
+	if last := chain.LastBlock(); !bytes.Equal(last.Signer, validator.PublicKey) {
+		t.Fatalf("expected block %d to be signed by the genesis validator", last.Height)
+	}
+
+	stake(t, chain, staker, 40)
+	if stakes := chain.Stakes(); len(stakes) != 1 || stakes[0].Amount != 40 {
+		t.Fatalf("expected a single stake of 40, got %+v", stakes)
+	}
+	if balance, _ := balanceOf(t, chain, string(staker.Address())); balance != BlockReward-40 {
+		t.Fatalf("expected staked coins to leave the balance, got %d", balance)
+	}
+
+	generate(t, chain, string(staker.Address()), 3)
+	for iter, i := chain.Iterator(), 0; i < 3; i++ {
+		block := iter.Next()
+		if !bytes.Equal(block.Signer, staker.PublicKey) {
+			t.Fatalf("expected block %d to be signed by the only staker", block.Height)
+		}
+		if err := chain.Engine.VerifySeal(chain, block); err != nil {
+			t.Fatalf("expected block %d to have a valid seal: %v", block.Height, err)
+		}
+	}
+
+	rawTx, err := chain.CreateRawUnstakeTransaction(staker.PublicKey, string(staker.Address()))
+	if err != nil {
+		t.Fatalf("failed to create unstake transaction: %v", err)
+	}
+	rawTx.Sign(chain.Wallets)
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, string(validator.Address())); err != nil {
+		t.Fatalf("failed to unstake: %v", err)
+	}
+	if stakes := chain.Stakes(); len(stakes) != 0 {
+		t.Fatalf("expected no stakes after unstaking, got %+v", stakes)
+	}
+}
+
+func TestProofOfStakeSelectionIsWeighted(t *testing.T) {
+	chain, validator, light := newProofOfStakeChain(t)
+	heavy := wallet.MakeWallet()
+	chain.Wallets.Wallets[string(heavy.Address())] = heavy
+
+	generate(t, chain, string(heavy.Address()), 1)
+	stake(t, chain, light, 25)
+	stake(t, chain, heavy, 75)
+
+	counts := make(map[string]int)
+	generate(t, chain, string(validator.Address()), 100)
+	for iter, i := chain.Iterator(), 0; i < 100; i++ {
+		counts[string(iter.Next().Signer)]++
+	}
+	if counts[string(light.PublicKey)] == 0 || counts[string(heavy.PublicKey)] <= counts[string(light.PublicKey)] {
+		t.Fatalf("expected proposers weighted by stake, got %d blocks by 25 and %d blocks by 75",
+			counts[string(light.PublicKey)], counts[string(heavy.PublicKey)])
+	}
+
+	parent := chain.LastBlock()
+	first, err := chain.Engine.SelectProposer(chain, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := chain.Engine.SelectProposer(chain, parent)
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected proposer selection to be deterministic")
+	}
+}
+
+func TestDoubleSignIsSlashed(t *testing.T) {
+	chain, validator, staker := newProofOfStakeChain(t)
+	stake(t, chain, staker, 50)
+	generate(t, chain, string(staker.Address()), 1)
+
+	// the staker signs another block at the height of the last block
+	signed := chain.LastBlock()
+	conflicting := NewBlock([]*Transaction{newCoinbase(t, string(validator.Address()), signed.Height)},
+		signed.PrevHash, signed.Height, signed.Difficulty)
+	signBlock(conflicting, staker)
+
+	if evidence, err := chain.FindDoubleSign(signed); evidence != nil || err != nil {
+		t.Fatalf("expected a block of the chain not to be a double sign, got %v", err)
+	}
+	evidence, err := chain.FindDoubleSign(conflicting)
+	if err != nil || evidence == nil {
+		t.Fatalf("expected double sign evidence, got %v", err)
+	}
+	if err := chain.AddEvidence(evidence); err != nil {
+		t.Fatalf("failed to add evidence: %v", err)
+	}
+	if pending := chain.PendingEvidence(); len(pending) != 1 {
+		t.Fatalf("expected one pending evidence, got %d", len(pending))
+	}
+
+	generate(t, chain, string(staker.Address()), 1)
+	if last := chain.LastBlock(); len(last.Evidence) != 1 {
+		t.Fatal("expected the evidence to be included in the next block")
+	}
+	if pending := chain.PendingEvidence(); len(pending) != 0 {
+		t.Fatalf("expected no pending evidence, got %d", len(pending))
+	}
+	if stakes := chain.Stakes(); len(stakes) != 1 || !stakes[0].Slashed {
+		t.Fatalf("expected the staker to be slashed, got %+v", stakes)
+	}
+
+	// slashed stake is neither counted nor withdrawable
+	generate(t, chain, string(validator.Address()), 1)
+	if last := chain.LastBlock(); !bytes.Equal(last.Signer, validator.PublicKey) {
+		t.Fatal("expected the genesis validator to propose blocks once the only staker is slashed")
+	}
+	if _, err := chain.CreateRawUnstakeTransaction(staker.PublicKey, string(staker.Address())); err == nil {
+		t.Fatal("expected slashed stake not to be withdrawable")
+	}
+
+	// the same proposer can't be slashed twice
+	block := NewBlock([]*Transaction{newCoinbase(t, string(validator.Address()), chain.LastBlock().Height+1)},
+		chain.LastHash(), chain.LastBlock().Height+1, 0)
+	block.Evidence = []*DoubleSignEvidence{evidence}
+	if err := chain.Engine.Seal(context.Background(), block, validator); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.SubmitBlock(block); err == nil || !strings.Contains(err.Error(), "already slashed") {
+		t.Fatalf("expected repeated evidence to be rejected, got %v", err)
+	}
+}
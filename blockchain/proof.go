@@ -2,12 +2,15 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
-	"log"
 	"math"
 	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
 )
 
 // Take the data from the block
@@ -21,77 +24,150 @@ import (
 // Requirements:
 // The First few bytes must contain 0s
 
+// Difficulty of the main network
 const Difficulty = 18
 
+// The largest nonce of a block, miners change the extra nonce of the coinbase once all nonces are tried.
+const MaxNonce = math.MaxUint32
+
+// Number of hashes a worker computes between checks for cancellation.
+const hashBatch = 4096
+
+// Returned by Run when no nonce satisfies the target.
+var ErrNonceSpaceExhausted = errors.New("nonce space exhausted")
+
 type ProofOfWork struct {
-	Block  *Block
-	Target *big.Int
+	Block    *Block
+	Target   *big.Int
+	maxNonce uint64 // largest nonce tried by Run
 }
 
 func NewProof(b *Block) *ProofOfWork {
-	target := big.NewInt(1)                  //target: 1
-	target.Lsh(target, uint(256-Difficulty)) //target: 10000000000000000000000000000000000000000000000000000000000000
-
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{b, TargetForDifficulty(b.Difficulty), MaxNonce}
 
 	return pow
 }
 
+// Computes the target a hash has to be below to have difficulty leading zero bits.
+func TargetForDifficulty(difficulty int) *big.Int {
+	target := big.NewInt(1)                  //target: 1
+	target.Lsh(target, uint(256-difficulty)) //target: 10000000000000000000000000000000000000000000000000000000000000
+	return target
+}
+
 func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToBytes(int64(nonce)),
-			ToBytes(int64(Difficulty)),
-		},
-		[]byte{},
-	)
-	return data
+	header := pow.Block.Header()
+	header.Nonce = nonce
+	return header.data()
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
+// Searches for a nonce satisfying the target, the nonce space is split between workers running concurrently.
+// The number of computed hashes is atomically added to hashes, if it is not nil.
+// Returns ErrNonceSpaceExhausted if there is no such nonce or the context error if it is cancelled.
+func (pow *ProofOfWork) Run(ctx context.Context, workers int, hashes *uint64) (int, []byte, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if hashes == nil {
+		hashes = new(uint64)
+	}
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type solution struct {
+		nonce int
+		hash  []byte
+	}
+	solutions := make(chan solution, workers)
+
+	var wg sync.WaitGroup
+	chunk := (pow.maxNonce + 1) / uint64(workers)
+	for i := 0; i < workers; i++ {
+		start := uint64(i) * chunk
+		end := start + chunk
+		if i == workers-1 {
+			end = pow.maxNonce + 1
+		}
+
+		wg.Add(1)
+		go func(start, end uint64) {
+			defer wg.Done()
+			if nonce, hash, ok := pow.search(searchCtx, start, end, hashes); ok {
+				solutions <- solution{nonce, hash}
+				cancel()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	select {
+	case s := <-solutions:
+		return s.nonce, s.hash, nil
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, ErrNonceSpaceExhausted
+}
 
-	nonce := 0
+// Tries nonces from start up to end (exclusive) until one satisfies the target or the context is cancelled.
+func (pow *ProofOfWork) search(ctx context.Context, start, end uint64, hashes *uint64) (int, []byte, bool) {
+	var intHash big.Int
 
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
+	// the nonce is followed only by the difficulty, so the rest of the data is computed once
+	data := pow.InitData(0)
+	offset := len(data) - 16
+
+	counted := start
+	for nonce := start; nonce < end; nonce++ {
+		if nonce-counted == hashBatch {
+			atomic.AddUint64(hashes, hashBatch)
+			counted = nonce
+			select {
+			case <-ctx.Done():
+				return 0, nil, false
+			default:
+			}
+		}
 
-		fmt.Printf("\r%x", hash)
+		binary.BigEndian.PutUint64(data[offset:], nonce)
+		hash := sha256.Sum256(data)
 		intHash.SetBytes(hash[:])
-		//fmt.Printf("\n%x\n%x\n", &intHash, pow.Target)
-		// intHash =>    1bfe265c1bc0712103ef108dd011269f3a322d31dc339321ff29c4cdc6852878
-		// pow.Target =>   10000000000000000000000000000000000000000000000000000000000000
 		if intHash.Cmp(pow.Target) == -1 { // intHash < pow.Target == -1
-			break
-		} else {
-			nonce++
+			atomic.AddUint64(hashes, nonce+1-counted)
+			return int(nonce), hash[:], true
 		}
 	}
-	fmt.Println()
+	atomic.AddUint64(hashes, end-counted)
+	return 0, nil, false
+}
 
-	return nonce, hash[:]
+// Computes the hash of the block with its nonce.
+func (pow *ProofOfWork) Hash() []byte {
+	hash := sha256.Sum256(pow.InitData(pow.Block.Nonce))
+	return hash[:]
 }
 
 func (pow *ProofOfWork) Validate() bool {
-	var intHash big.Int
+	return pow.ValidateTarget(pow.Target)
+}
 
-	data := pow.InitData(pow.Block.Nonce)
+// Checks if the hash of the block is below a target, e.g. an easier target of mining pool shares.
+func (pow *ProofOfWork) ValidateTarget(target *big.Int) bool {
+	var intHash big.Int
 
-	hash := sha256.Sum256(data)
-	intHash.SetBytes(hash[:])
+	intHash.SetBytes(pow.Hash())
 
-	return intHash.Cmp(pow.Target) == -1
+	return intHash.Cmp(target) == -1
 }
 
 func ToBytes(num int64) []byte {
 	buff := new(bytes.Buffer)
 	err := binary.Write(buff, binary.BigEndian, num)
 	if err != nil {
-		log.Panic(err)
+		panic(err)
 	}
 	return buff.Bytes()
 }
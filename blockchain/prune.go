@@ -0,0 +1,337 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+const (
+	// Key of the prune target of a chain.
+	pruneTargetKey = "prune_target"
+	// Prefix of keys of headers of pruned blocks, followed by the block hash.
+	headerPrefix = "header-"
+	// Fewest blocks kept by a prune target. Queries may start at a tip a few blocks old,
+	// it must not be pruned before they finish.
+	MinPruneBlocks = 10
+)
+
+// Returned when the body of a block was pruned, only its header is kept.
+var ErrBlockPruned = errors.New("block is pruned")
+
+// A PruneTarget limits the blocks whose bodies are kept by a chain. Older blocks are pruned down to
+// their headers, the outputs they created which are still unspent are kept in the UTXO set of the chain.
+// The zero PruneTarget keeps all blocks.
+type PruneTarget struct {
+	Blocks int   // number of the latest blocks kept
+	Bytes  int64 // total size of the latest blocks kept, at least MinPruneBlocks are kept
+}
+
+// Parses a prune target given as a number of blocks, e.g. 288, or as a size in megabytes, e.g. 550MB.
+// Zero disables pruning.
+func ParsePruneTarget(value string) (PruneTarget, error) {
+	value = strings.TrimSpace(value)
+	if megabytes := strings.TrimSuffix(strings.ToUpper(value), "MB"); len(megabytes) < len(value) {
+		size, err := strconv.ParseInt(megabytes, 10, 64)
+		if err != nil || size < 0 {
+			return PruneTarget{}, errors.Errorf("invalid prune target %s", value)
+		}
+		return PruneTarget{Bytes: size << 20}, nil
+	}
+	blocks, err := strconv.Atoi(value)
+	if err != nil || blocks < 0 {
+		return PruneTarget{}, errors.Errorf("invalid prune target %s, expected a number of blocks or megabytes like 550MB", value)
+	}
+	if blocks > 0 && blocks < MinPruneBlocks {
+		return PruneTarget{}, errors.Errorf("prune target must keep at least %d blocks", MinPruneBlocks)
+	}
+	return PruneTarget{Blocks: blocks}, nil
+}
+
+// Checks if the target prunes any blocks.
+func (target PruneTarget) Enabled() bool {
+	return target.Blocks > 0 || target.Bytes > 0
+}
+
+func (target PruneTarget) String() string {
+	switch {
+	case target.Blocks > 0:
+		return fmt.Sprintf("%d blocks", target.Blocks)
+	case target.Bytes > 0:
+		return fmt.Sprintf("%dMB", target.Bytes>>20)
+	default:
+		return "disabled"
+	}
+}
+
+// Gets the prune target of the chain.
+func (chain *BlockChain) PruneTarget() PruneTarget {
+	chain.pruneMu.Lock()
+	defer chain.pruneMu.Unlock()
+	return chain.pruneTarget
+}
+
+// Stores the prune target of the chain and prunes its blocks down to it, blocks connected later are pruned as well.
+// Pruned blocks can't be restored, disabling pruning only stops pruning more blocks.
+func (chain *BlockChain) SetPruneTarget(target PruneTarget) error {
+	chain.pruneMu.Lock()
+	defer chain.pruneMu.Unlock()
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(target); err != nil {
+		return errors.Wrap(err, "failed to encode prune target")
+	}
+	err := chain.Database.Update(func(batch storage.Batch) error {
+		return batch.Put([]byte(pruneTargetKey), encoded.Bytes())
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to store prune target")
+	}
+	chain.pruneTarget = target
+	return chain.prune()
+}
+
+func loadPruneTarget(store storage.Store) (PruneTarget, error) {
+	var target PruneTarget
+	encoded, err := store.Get([]byte(pruneTargetKey))
+	if err == storage.ErrNotFound {
+		return target, nil
+	} else if err != nil {
+		return target, errors.Wrap(err, "failed to load prune target")
+	}
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&target); err != nil {
+		return target, errors.Wrap(err, "failed to decode prune target")
+	}
+	return target, nil
+}
+
+// Prunes the blocks down to the prune target after a block was connected. Pruning waits for the queries reading
+// the pruned blocks, so it runs once other blocks can be connected. If another prune is in progress, the block is
+// left to it and to the prune of the next block. The block is connected even if pruning fails.
+func (chain *BlockChain) pruneConnected() {
+	if !chain.pruneMu.TryLock() {
+		return
+	}
+	defer chain.pruneMu.Unlock()
+	if err := chain.prune(); err != nil {
+		log.Error().Err(err).Msg("failed to prune blocks")
+	}
+}
+
+// Prunes the blocks older than the prune target. The UTXO set at the oldest kept block becomes the snapshot
+// the chain starts from, the blocks preceding it are replaced by their headers. The caller must hold pruneMu.
+func (chain *BlockChain) prune() error {
+	if !chain.pruneTarget.Enabled() {
+		return nil
+	}
+	height, err := chain.pruneHeight(chain.pruneTarget)
+	if err != nil || height < 0 {
+		return err
+	}
+
+	// only pruning changes the blocks preceding the tip and it holds pruneMu too,
+	// so everything is prepared before readers are blocked
+	previous, err := chain.Snapshot()
+	if err != nil {
+		return err
+	}
+	snapshot, base, utxos, err := chain.utxoSnapshot(height)
+	if err != nil {
+		return err
+	}
+	snapshot.Validated = previous == nil || previous.Validated
+	snapshot.Pruned = true
+	var encodedSnapshot bytes.Buffer
+	if err := gob.NewEncoder(&encodedSnapshot).Encode(snapshot); err != nil {
+		return errors.Wrap(err, "failed to encode UTXO snapshot")
+	}
+
+	kept := make(map[string]bool)
+	for _, utxo := range utxos {
+		kept[string(snapshotOutputKey(utxo.TxID, utxo.Index))] = true
+	}
+	stored := make(map[string]bool)
+	for _, utxo := range chain.snapshotOutputs() {
+		stored[string(snapshotOutputKey(utxo.TxID, utxo.Index))] = true
+	}
+	var pruned []*Block
+	for iter := chain.iteratorAt(base.PrevHash); ; {
+		block := iter.Next()
+		pruned = append(pruned, block)
+		if chain.IsFirstBlock(block) {
+			break
+		}
+	}
+
+	chain.history.lock()
+	defer chain.history.unlock()
+	err = chain.Database.Update(func(batch storage.Batch) error {
+		for _, utxo := range utxos {
+			key := snapshotOutputKey(utxo.TxID, utxo.Index)
+			if stored[string(key)] {
+				continue
+			}
+			var encoded bytes.Buffer
+			if err := gob.NewEncoder(&encoded).Encode(utxo); err != nil {
+				return err
+			}
+			if err := batch.Put(key, encoded.Bytes()); err != nil {
+				return err
+			}
+		}
+		for key := range stored {
+			if !kept[key] {
+				if err := batch.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, block := range pruned {
+			var header bytes.Buffer
+			if err := gob.NewEncoder(&header).Encode(block.Header()); err != nil {
+				return err
+			}
+			if err := batch.Put(headerKey(block.Hash), header.Bytes()); err != nil {
+				return err
+			}
+			if err := batch.Delete(block.Hash); err != nil {
+				return err
+			}
+		}
+		return batch.Put([]byte(snapshotKey), encodedSnapshot.Bytes())
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to prune blocks")
+	}
+	chain.snapshotBase = snapshot.BaseHash
+
+	log.Info().Int("height", height).Int("blocks", len(pruned)).Msg("pruned blocks")
+	return nil
+}
+
+// Gets the height of the oldest block kept by a prune target, -1 if no block has to be pruned.
+func (chain *BlockChain) pruneHeight(target PruneTarget) (int, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	kept := 0
+	var size int64
+	for hash := chain.LastHash(); ; {
+		encoded, err := chain.Database.Get(hash)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to load block")
+		}
+		block, err := DecodeBlock(encoded)
+		if err != nil {
+			return 0, err
+		}
+		kept++
+		size += int64(len(encoded))
+
+		if target.Bytes > 0 && size > target.Bytes && kept > MinPruneBlocks {
+			return block.Height + 1, nil
+		}
+		if chain.IsFirstBlock(block) {
+			return -1, nil
+		}
+		if kept == target.Blocks || (target.Bytes > 0 && size == target.Bytes && kept >= MinPruneBlocks) {
+			return block.Height, nil
+		}
+		hash = block.PrevHash
+	}
+}
+
+// Gets a block of the chain by its hash, returns ErrBlockPruned if only its header is kept.
+func (chain *BlockChain) GetBlock(hash []byte) (*Block, error) {
+	encoded, err := chain.Database.Get(hash)
+	if err == nil {
+		return DecodeBlock(encoded)
+	} else if err != storage.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to load block")
+	}
+
+	header, err := chain.loadHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.Wrapf(ErrBlockPruned, "block %d %x", header.Height, hash)
+}
+
+// Loads the header of a pruned block.
+func (chain *BlockChain) loadHeader(hash []byte) (*BlockHeader, error) {
+	encoded, err := chain.Database.Get(headerKey(hash))
+	if err == storage.ErrNotFound {
+		return nil, errors.Errorf("block %x is not stored", hash)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to load block header")
+	}
+	var header BlockHeader
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&header); err != nil {
+		return nil, errors.Wrap(err, "failed to decode block header")
+	}
+	return &header, nil
+}
+
+func headerKey(hash []byte) []byte {
+	return append([]byte(headerPrefix), hash...)
+}
+
+// A historyGuard guards the blocks preceding the tip against pruning. Readers may acquire it repeatedly,
+// as they wait only for a prune in progress, not for a waiting one.
+type historyGuard struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	readers int
+	pruning bool
+}
+
+func (g *historyGuard) rlock() {
+	g.mu.Lock()
+	for g.pruning {
+		g.wait()
+	}
+	g.readers++
+	g.mu.Unlock()
+}
+
+func (g *historyGuard) runlock() {
+	g.mu.Lock()
+	g.readers--
+	if g.readers == 0 && g.cond != nil {
+		g.cond.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+func (g *historyGuard) lock() {
+	g.mu.Lock()
+	for g.pruning || g.readers > 0 {
+		g.wait()
+	}
+	g.pruning = true
+	g.mu.Unlock()
+}
+
+func (g *historyGuard) unlock() {
+	g.mu.Lock()
+	g.pruning = false
+	if g.cond != nil {
+		g.cond.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+// Waits for a change of the guard, the caller holds mu.
+func (g *historyGuard) wait() {
+	if g.cond == nil {
+		g.cond = sync.NewCond(&g.mu)
+	}
+	g.cond.Wait()
+}
@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestParsePruneTarget(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected PruneTarget
+		invalid  bool
+	}{
+		{value: "0", expected: PruneTarget{}},
+		{value: "288", expected: PruneTarget{Blocks: 288}},
+		{value: "550MB", expected: PruneTarget{Bytes: 550 << 20}},
+		{value: "1mb", expected: PruneTarget{Bytes: 1 << 20}},
+		{value: "5", invalid: true},
+		{value: "-1", invalid: true},
+		{value: "MB", invalid: true},
+		{value: "10GB", invalid: true},
+	}
+	for _, test := range tests {
+		target, err := ParsePruneTarget(test.value)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("expected %q to be invalid, got %+v", test.value, target)
+			}
+		} else if err != nil || target != test.expected {
+			t.Errorf("expected %q to be %+v, got %+v %v", test.value, test.expected, target, err)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	receiver := wallets.AddWallet()
+	rawTx := spendGenesis(t, chain, wallets, receiver)
+	if err := chain.AddBlock([]*Transaction{newCoinbase(t, miner, 1), &rawTx.Tx}); err != nil {
+		t.Fatal(err)
+	}
+	prunedBlock := chain.LastHash()
+	generate(t, chain, miner, 14)
+	expected := map[string]int{}
+	for _, address := range []string{miner, receiver} {
+		expected[address], _ = balanceOf(t, chain, address)
+	}
+
+	if err := chain.SetPruneTarget(PruneTarget{Blocks: 10}); err != nil {
+		t.Fatalf("failed to prune chain: %v", err)
+	}
+	snapshot, err := chain.Snapshot()
+	if err != nil || snapshot == nil || !snapshot.Pruned || !snapshot.Validated || snapshot.Height != 6 {
+		t.Fatalf("expected a validated snapshot of the pruned chain at block 6, got %+v %v", snapshot, err)
+	}
+	for address, balance := range expected {
+		if pruned, _ := balanceOf(t, chain, address); pruned != balance {
+			t.Fatalf("balance of %s is %d after pruning, expected %d", address, pruned, balance)
+		}
+	}
+	if _, err := chain.GetBlock(prunedBlock); errors.Cause(err) != ErrBlockPruned {
+		t.Fatalf("expected block 1 to be pruned, got %v", err)
+	}
+	if _, err := chain.FindTransaction(rawTx.Tx.ID); errors.Cause(err) != ErrBlockPruned {
+		t.Fatalf("expected the transaction to be pruned, got %v", err)
+	}
+
+	// outputs created by pruned blocks are spent by new blocks, which move the pruned base
+	other := wallets.AddWallet()
+	spend, err := chain.CreateRawTransaction(receiver, other, BlockReward/2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spend.Sign(wallets)
+	if err := chain.AddBlock([]*Transaction{newCoinbase(t, miner, 16), &spend.Tx}); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := balanceOf(t, chain, other); balance != BlockReward/2 {
+		t.Fatalf("expected %d to be received, got %d", BlockReward/2, balance)
+	}
+
+	// the prune target is kept by the DB
+	loaded, err := LoadBlockChain(chain.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PruneTarget() != (PruneTarget{Blocks: 10}) {
+		t.Fatalf("expected the prune target to be loaded, got %+v", loaded.PruneTarget())
+	}
+	generate(t, loaded, miner, 1)
+	if snapshot, err := loaded.Snapshot(); err != nil || snapshot.Height != 8 {
+		t.Fatalf("expected the chain to be pruned up to block 8, got %+v %v", snapshot, err)
+	}
+	if balance, _ := balanceOf(t, loaded, receiver); balance != BlockReward/2 {
+		t.Fatalf("expected the change of %d to be kept, got %d", BlockReward/2, balance)
+	}
+}
+
+// A pruner waiting for a long query doesn't keep other blocks from being connected.
+func TestPruneWaitsForQueries(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, MinPruneBlocks)
+	if err := chain.SetPruneTarget(PruneTarget{Blocks: MinPruneBlocks}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a query reading the blocks to be pruned
+	chain.history.rlock()
+	pruned := make(chan error)
+	go func() {
+		_, err := chain.MineBlock(context.Background(), miner, nil)
+		pruned <- err
+	}()
+	// the first block waits for the query to prune, the next ones are connected meanwhile
+	for chain.LastBlock().Height != MinPruneBlocks+1 {
+		time.Sleep(time.Millisecond)
+	}
+	connected := make(chan error)
+	go func() {
+		for i := 0; i < 2; i++ {
+			if _, err := chain.MineBlock(context.Background(), miner, nil); err != nil {
+				connected <- err
+				return
+			}
+		}
+		connected <- nil
+	}()
+	select {
+	case err := <-connected:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("blocks are not connected while a prune waits for a query")
+	}
+
+	chain.history.runlock()
+	if err := <-pruned; err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err := chain.Snapshot(); err != nil || snapshot == nil || !snapshot.Pruned {
+		t.Fatalf("expected the chain to be pruned once the query finished, got %+v %v", snapshot, err)
+	}
+}
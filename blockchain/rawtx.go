@@ -0,0 +1,263 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// A RawTransaction is an unsigned or partially signed transaction together with the outputs
+// its inputs spend. It carries everything needed to sign the transaction, so it can be exported
+// to a file, signed on an offline machine holding only the wallet and submitted back to the chain.
+// Multisignature inputs collect signatures of their signers, each signer adds theirs to the file.
+type RawTransaction struct {
+	Tx     Transaction
+	Inputs []RawInput // Inputs[i] describes Tx.Inputs[i]
+}
+
+// A RawInput describes the output spent by an input of a raw transaction and its collected signatures.
+type RawInput struct {
+	PrevOut      TxOutput
+	RedeemScript script.Script     `json:",omitempty"`
+	Signatures   map[string][]byte `json:",omitempty"` // hex encoded public key => signature
+}
+
+// Creates an unsigned transaction sending amount from one address to another, which can't be mined before lockTime.
+// Only the chain is needed, the sender's keys are not required until the transaction is signed.
+func (chain *BlockChain) CreateRawTransaction(from, to string, amount int, lockTime uint32) (*RawTransaction, error) {
+	out, err := NewTxOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	return chain.createRawTransaction(from, *out, lockTime)
+}
+
+// Creates an unsigned transaction paying an output with coins of an address, the change returns to the address.
+func (chain *BlockChain) createRawTransaction(from string, out TxOutput, lockTime uint32) (*RawTransaction, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	var inputs []TxInput
+	var rawInputs []RawInput
+	var outputs []TxOutput
+
+	lockingScript, err := LockingScriptForAddress(from)
+	if err != nil {
+		return nil, err
+	}
+	amount := out.Value
+	acc, validOutputs := chain.FindSpendableOutputs(lockingScript, amount)
+	if acc < amount {
+		return nil, errors.New("not enough funds")
+	}
+
+	// inputs with MaxSequence would disable the lock time
+	sequence := uint32(MaxSequence)
+	if lockTime != 0 {
+		sequence = MaxSequence - 1
+	}
+
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid transaction id")
+		}
+		for _, outIdx := range outs {
+			prevOut, err := chain.findOutput(chain.LastHash(), txID, outIdx)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to find output %s:%d", txid, outIdx)
+			}
+			inputs = append(inputs, TxInput{txID, outIdx, nil, sequence})
+			rawInputs = append(rawInputs, RawInput{PrevOut: prevOut.Output})
+		}
+	}
+
+	outputs = append(outputs, out)
+
+	if acc > amount {
+		change, err := NewTxOutput(acc-amount, from)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
+	}
+
+	tx := Transaction{nil, inputs, outputs, lockTime}
+	tx.ID = tx.Hash()
+
+	return &RawTransaction{tx, rawInputs}, nil
+}
+
+// Signs every input of a raw transaction which can be signed by one of the wallets.
+// Pay-to-public-key-hash inputs are signed by the wallet owning the key, multisignature inputs collect
+// a signature of every key of the wallets and are completed once they have enough signatures.
+// Returns the number of inputs signed.
+func (rawTx *RawTransaction) Sign(wallets *wallet.Wallets) int {
+	signed := 0
+	for inIdx := range rawTx.Inputs {
+		if rawTx.signInput(inIdx, wallets) {
+			signed++
+		}
+	}
+	return signed
+}
+
+// Signs a single input of a raw transaction with the wallets.
+func (rawTx *RawTransaction) signInput(inIdx int, wallets *wallet.Wallets) bool {
+	input := &rawTx.Inputs[inIdx]
+	lockingScript := input.PrevOut.LockingScript
+
+	if pubKeyHash, ok := script.ExtractPubKeyHash(lockingScript); ok {
+		w, ok := wallets.FindWallet(pubKeyHash)
+		if !ok {
+			return false
+		}
+		return rawTx.Tx.SignInput(inIdx, w.PrivateKey, w.PublicKey, input.PrevOut) == nil
+	}
+
+	if pubKey, ok := script.ExtractStakePubKey(lockingScript); ok {
+		w, ok := wallets.FindWallet(wallet.PublicKeyHash(pubKey))
+		if !ok {
+			return false
+		}
+		return rawTx.Tx.SignInput(inIdx, w.PrivateKey, w.PublicKey, input.PrevOut) == nil
+	}
+
+	scriptHash, ok := script.ExtractScriptHash(lockingScript)
+	if !ok {
+		return false
+	}
+	if input.RedeemScript == nil {
+		if ms, ok := wallets.FindMultiSig(scriptHash); ok {
+			input.RedeemScript = ms.RedeemScript
+		}
+	}
+	if bytes.Compare(script.Hash160(input.RedeemScript), scriptHash) != 0 {
+		return false
+	}
+	_, pubKeys, ok := script.ExtractMultiSig(input.RedeemScript)
+	if !ok {
+		return false
+	}
+
+	signed := false
+	for _, pubKey := range pubKeys {
+		w, ok := wallets.FindWallet(wallet.PublicKeyHash(pubKey))
+		if !ok {
+			continue
+		}
+		if input.Signatures == nil {
+			input.Signatures = make(map[string][]byte)
+		}
+		input.Signatures[hex.EncodeToString(pubKey)] = rawTx.Tx.CreateSignature(inIdx, w.PrivateKey, input.RedeemScript, input.PrevOut.Value)
+		signed = true
+	}
+	rawTx.completeMultiSig(inIdx)
+	return signed
+}
+
+// Sets the unlocking script of a multisignature input once it has enough signatures.
+func (rawTx *RawTransaction) completeMultiSig(inIdx int) {
+	input := rawTx.Inputs[inIdx]
+	m, pubKeys, ok := script.ExtractMultiSig(input.RedeemScript)
+	if !ok {
+		return
+	}
+
+	var sigs [][]byte
+	for _, pubKey := range pubKeys {
+		if sig, ok := input.Signatures[hex.EncodeToString(pubKey)]; ok && len(sigs) < m {
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) < m {
+		return
+	}
+	rawTx.Tx.Inputs[inIdx].UnlockingScript = script.MultiSigUnlock(sigs, input.RedeemScript)
+}
+
+// Describes the signing progress of every input of a raw transaction, e.g. "signed" or "1 of 2 signatures".
+func (rawTx *RawTransaction) SigningStatus() []string {
+	var status []string
+	for inIdx, input := range rawTx.Inputs {
+		switch {
+		case rawTx.Tx.VerifyInput(inIdx, input.PrevOut) == nil:
+			status = append(status, "signed")
+		case script.IsPayToScriptHash(input.PrevOut.LockingScript):
+			m, _, ok := script.ExtractMultiSig(input.RedeemScript)
+			if !ok {
+				status = append(status, "missing redeem script")
+				continue
+			}
+			status = append(status, fmt.Sprintf("%d of %d signatures", len(input.Signatures), m))
+		default:
+			status = append(status, "unsigned")
+		}
+	}
+	return status
+}
+
+// Gets the outputs spent by the inputs of a raw transaction.
+func (rawTx *RawTransaction) PrevOuts() []*TxOutput {
+	prevOuts := make([]*TxOutput, len(rawTx.Inputs))
+	for inIdx := range rawTx.Inputs {
+		prevOuts[inIdx] = &rawTx.Inputs[inIdx].PrevOut
+	}
+	return prevOuts
+}
+
+// Checks if unlocking scripts of all inputs of a raw transaction are valid.
+func (rawTx *RawTransaction) IsSigned() bool {
+	if len(rawTx.Inputs) != len(rawTx.Tx.Inputs) {
+		return false
+	}
+	for inIdx, input := range rawTx.Inputs {
+		if rawTx.Tx.VerifyInput(inIdx, input.PrevOut) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Saves a raw transaction into a portable JSON file.
+func (rawTx *RawTransaction) SaveFile(path string) error {
+	content, err := json.MarshalIndent(rawTx, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode raw transaction")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, content, 0644), "failed to write raw transaction")
+}
+
+// Loads a raw transaction from a file created by SaveFile.
+func LoadRawTransaction(path string) (*RawTransaction, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read raw transaction")
+	}
+
+	var rawTx RawTransaction
+	if err := json.Unmarshal(content, &rawTx); err != nil {
+		return nil, errors.Wrap(err, "failed to decode raw transaction")
+	}
+	if len(rawTx.Inputs) != len(rawTx.Tx.Inputs) {
+		return nil, errors.New("raw transaction does not describe all spent outputs")
+	}
+	return &rawTx, nil
+}
+
+// Mines a fully signed raw transaction into a new block rewarding the miner's address.
+// The block is validated together with the transaction when it is connected.
+func (chain *BlockChain) SubmitRawTransaction(ctx context.Context, rawTx *RawTransaction, minerAddress string) (*Block, error) {
+	if !rawTx.IsSigned() {
+		return nil, errors.New("transaction is not fully signed")
+	}
+	return chain.MineBlock(ctx, minerAddress, []*Transaction{&rawTx.Tx})
+}
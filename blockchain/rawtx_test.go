@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+func TestOfflineSigning(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	recipient := string(wallet.MakeWallet().Address())
+	other := string(wallet.MakeWallet().Address())
+
+	// the chain creates the transaction without the sender's keys
+	rawTx, err := chain.CreateRawTransaction(miner, recipient, 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawTx.IsSigned() || strings.Join(rawTx.SigningStatus(), ",") != "unsigned" {
+		t.Fatalf("expected a new transaction to be unsigned, got %v", rawTx.SigningStatus())
+	}
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, other); err == nil ||
+		!strings.Contains(err.Error(), "not fully signed") {
+		t.Fatalf("expected an unsigned transaction to be refused, got %v", err)
+	}
+	unsignedFile := filepath.Join(t.TempDir(), "unsigned.json")
+	if err := rawTx.SaveFile(unsignedFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// the offline machine has only the wallet and the file
+	offline, err := LoadRawTransaction(unsignedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed := offline.Sign(&wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}); signed != 0 {
+		t.Fatalf("expected wallets without the sender's key to sign nothing, signed %d inputs", signed)
+	}
+	if signed := offline.Sign(wallets); signed != 1 || !offline.IsSigned() {
+		t.Fatalf("expected the sender's wallet to sign the transaction, signed %d inputs", signed)
+	}
+	signedFile := filepath.Join(t.TempDir(), "signed.json")
+	if err := offline.SaveFile(signedFile); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := LoadRawTransaction(signedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.SubmitRawTransaction(context.Background(), signed, other); err != nil {
+		t.Fatalf("expected the signed transaction to be mined, got %v", err)
+	}
+	if balance, _ := balanceOf(t, chain, recipient); balance != 30 {
+		t.Errorf("expected the recipient to receive 30, got %d", balance)
+	}
+	if balance, _ := balanceOf(t, chain, miner); balance != BlockReward-30 {
+		t.Errorf("expected the change of %d to return to the sender, got %d", BlockReward-30, balance)
+	}
+}
+
+func TestLoadIncompleteRawTransaction(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	rawTx, err := chain.CreateRawTransaction(miner, string(wallet.MakeWallet().Address()), 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawTx.Inputs = nil
+	file := filepath.Join(t.TempDir(), "tx.json")
+	if err := rawTx.SaveFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRawTransaction(file); err == nil || !strings.Contains(err.Error(), "does not describe all spent outputs") {
+		t.Fatalf("expected a file without the spent outputs to be rejected, got %v", err)
+	}
+}
+
+func TestSignatureCommitsToValue(t *testing.T) {
+	chain, wallets, _ := newRegTestChain(t, 0)
+	rawTx := spendGenesis(t, chain, wallets, wallets.AddWallet())
+	if err := rawTx.Tx.VerifyInput(0, rawTx.Inputs[0].PrevOut); err != nil {
+		t.Fatalf("expected the signed input to be valid: %v", err)
+	}
+
+	// a signer shown a lower value than the output holds doesn't authorize spending the output
+	prevOut := rawTx.Inputs[0].PrevOut
+	prevOut.Value--
+	if err := rawTx.Tx.VerifyInput(0, prevOut); err == nil {
+		t.Fatal("expected the signature to be valid only for the signed value")
+	}
+
+	rawTx.Inputs[0].PrevOut.Value = BlockReward / 2
+	rawTx.Tx.Inputs[0].UnlockingScript = nil
+	if rawTx.Sign(wallets) != 1 {
+		t.Fatal("failed to sign the transaction")
+	}
+	if err := chain.ValidateTransaction(&rawTx.Tx); err == nil {
+		t.Fatal("expected a transaction signed for another value to be rejected")
+	}
+}
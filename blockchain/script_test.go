@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/script"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Creates a transaction spending the first output of a transaction with an unlocking script.
+func spendOutput(t *testing.T, prevTx *Transaction, unlockingScript script.Script, outputs ...TxOutput) *RawTransaction {
+	t.Helper()
+	tx := Transaction{nil, []TxInput{{prevTx.ID, 0, nil, MaxSequence}}, outputs, 0}
+	tx.ID = tx.Hash()
+	tx.Inputs[0].UnlockingScript = unlockingScript
+	return &RawTransaction{tx, []RawInput{{PrevOut: prevTx.Outputs[0]}}}
+}
+
+func TestPayToScriptHashOutputs(t *testing.T) {
+	chain, wallets, _ := newRegTestChain(t, 0)
+	miner := string(wallet.MakeWallet().Address())
+	recipient := string(wallet.MakeWallet().Address())
+
+	// the output can be spent by anyone knowing the preimage of a hash
+	preimage := []byte("secret")
+	redeemScript := script.NewBuilder().AddOp(script.OP_HASH160).AddData(script.Hash160(preimage)).AddOp(script.OP_EQUAL).Script()
+	funding := spendGenesisTo(t, chain, wallets, TxOutput{BlockReward, script.PayToScriptHash(script.Hash160(redeemScript))})
+	if _, err := chain.SubmitRawTransaction(context.Background(), funding, miner); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		unlockingScript script.Script
+		expected        string
+	}{
+		{script.NewBuilder().AddData([]byte("guess")).AddData(redeemScript).Script(), "script finished with a false value"},
+		// the pushed script doesn't match the script hash of the output
+		{script.NewBuilder().AddData(preimage).Script(), "script finished with a false value"},
+		{nil, "locking script failed"},
+	}
+	for _, test := range tests {
+		rawTx := spendOutput(t, &funding.Tx, test.unlockingScript, newOutput(t, BlockReward, recipient))
+		if err := rawTx.Tx.VerifyInput(0, rawTx.Inputs[0].PrevOut); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected the script to fail with %q, got %v", test.unlockingScript, test.expected, err)
+		}
+		if err := chain.ValidateTransaction(&rawTx.Tx); err == nil || !strings.Contains(err.Error(), "invalid transaction signature") {
+			t.Errorf("%s: expected the spend to be rejected, got %v", test.unlockingScript, err)
+		}
+	}
+
+	unlockingScript := script.NewBuilder().AddData(preimage).AddData(redeemScript).Script()
+	rawTx := spendOutput(t, &funding.Tx, unlockingScript, newOutput(t, BlockReward, recipient))
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, miner); err != nil {
+		t.Fatalf("expected the spend with the preimage to be mined, got %v", err)
+	}
+	if balance, _ := balanceOf(t, chain, recipient); balance != BlockReward {
+		t.Errorf("expected the recipient to receive %d, got %d", BlockReward, balance)
+	}
+}
+
+func TestPayToPubKeyHashNeedsOwnersSignature(t *testing.T) {
+	chain, wallets, owner := newRegTestChain(t, 0)
+	recipient := string(wallet.MakeWallet().Address())
+
+	rawTx, err := chain.CreateRawTransaction(owner, recipient, 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another key unlocks a script paying to its own key hash only
+	thief := wallet.MakeWallet()
+	if err := rawTx.Tx.SignInput(0, thief.PrivateKey, thief.PublicKey, rawTx.Inputs[0].PrevOut); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.ValidateTransaction(&rawTx.Tx); err == nil {
+		t.Fatal("expected a spend signed by another key to be rejected")
+	}
+
+	// the owner's public key with another key's signature
+	sig := rawTx.Tx.CreateSignature(0, thief.PrivateKey, rawTx.Inputs[0].PrevOut.LockingScript, rawTx.Inputs[0].PrevOut.Value)
+	rawTx.Tx.Inputs[0].UnlockingScript = script.PayToPubKeyHashUnlock(sig, wallets.GetWallet(owner).PublicKey)
+	if err := chain.ValidateTransaction(&rawTx.Tx); err == nil {
+		t.Fatal("expected a spend with a signature of another key to be rejected")
+	}
+
+	if rawTx.Sign(wallets) != 1 {
+		t.Fatal("failed to sign the transaction")
+	}
+	if err := chain.ValidateTransaction(&rawTx.Tx); err != nil {
+		t.Fatalf("expected the owner's spend to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,548 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// A UTXO snapshot is the UTXO set of a chain at a base block, integers are big endian:
+//
+//	magic      8 bytes, snapshotMagic
+//	version    uint32, snapshotVersion
+//	commitment 32 bytes, SHA-256 of the committed fields
+//	base       uint32 length followed by the base block serialized by Block.Serialize
+//
+// followed by the committed fields:
+//
+//	params     network parameters, see writeSnapshotParams
+//	base hash  uint32 length followed by the hash of the base block
+//	height     uint64 height of the base block
+//	count      uint64 number of unspent outputs
+//	outputs    count times an unspent output sorted by transaction ID and index, see writeSnapshotOutput
+//	slashed    uint64 number of slashed proof-of-stake proposers followed by their length prefixed public keys
+const (
+	snapshotMagic   = "GOBUTXOS"
+	snapshotVersion = 2
+	// Key of the snapshot a chain starts from.
+	snapshotKey = "utxo_snapshot"
+	// Prefix of keys of the unspent outputs of the snapshot, followed by the transaction ID and the output index.
+	snapshotOutputPrefix = "snapshot-utxo-"
+	// Number of unspent outputs stored in a single update when a snapshot is loaded.
+	snapshotBatchSize = 1000
+)
+
+// A UTXOSnapshot is the UTXO set of a chain at a base block. A chain started from a snapshot or pruned doesn't store
+// the blocks preceding the base, outputs they created and which were unspent at the base come from the snapshot.
+type UTXOSnapshot struct {
+	BaseHash   []byte
+	Height     int
+	Commitment []byte   // commits to the network, the base block and the UTXO set
+	Outputs    int      // number of unspent outputs
+	Slashed    [][]byte // public keys of proof-of-stake proposers slashed before the base
+	Validated  bool     // the blocks preceding the base were validated and produce the same UTXO set
+	Pruned     bool     // the snapshot was taken by pruning the chain, headers of the blocks preceding the base are kept
+}
+
+// Writes the UTXO set of the chain at the block at height into a UTXO snapshot, a negative height is the last block.
+func (chain *BlockChain) DumpUTXOSet(w io.Writer, height int) (*UTXOSnapshot, error) {
+	snapshot, base, utxos, err := chain.utxoSnapshot(height)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewWriter(w)
+	buffered.WriteString(snapshotMagic)
+	binary.Write(buffered, binary.BigEndian, uint32(snapshotVersion))
+	buffered.Write(snapshot.Commitment)
+	writeRecord(buffered, base.Serialize())
+	if err := buffered.Flush(); err != nil {
+		return nil, errors.Wrap(err, "failed to write UTXO snapshot")
+	}
+	if err := writeSnapshotBody(w, chain.Params, snapshot, utxos); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Computes the UTXO snapshot of the chain at the block at height, returns it with its base block and unspent outputs.
+func (chain *BlockChain) utxoSnapshot(height int) (*UTXOSnapshot, *Block, []UnspentOutput, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	base, err := chain.blockAtHeight(height)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	utxos := chain.findUnspentOutputs(base.Hash, nil)
+	sort.Slice(utxos, func(i, j int) bool {
+		if c := bytes.Compare(utxos[i].TxID, utxos[j].TxID); c != 0 {
+			return c < 0
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+	var slashed [][]byte
+	for _, stake := range chain.stakesAt(base.Hash) {
+		if stake.Slashed {
+			slashed = append(slashed, stake.PubKey)
+		}
+	}
+	snapshot := &UTXOSnapshot{BaseHash: base.Hash, Height: base.Height, Outputs: len(utxos), Slashed: slashed}
+
+	// the committed fields are written twice, first to compute the commitment preceding them
+	commitment := sha256.New()
+	if err := writeSnapshotBody(commitment, chain.Params, snapshot, utxos); err != nil {
+		return nil, nil, nil, err
+	}
+	snapshot.Commitment = commitment.Sum(nil)
+	return snapshot, base, utxos, nil
+}
+
+// Gets the block of the chain at height, the last block if height is negative.
+func (chain *BlockChain) blockAtHeight(height int) (*Block, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if height < 0 || block.Height == height {
+			return block, nil
+		}
+		if block.Height < height || chain.IsFirstBlock(block) {
+			return nil, errors.Errorf("block %d is not stored", height)
+		}
+	}
+}
+
+// Writes the committed fields of a UTXO snapshot.
+func writeSnapshotBody(w io.Writer, params Params, snapshot *UTXOSnapshot, utxos []UnspentOutput) error {
+	buffered := bufio.NewWriter(w)
+	writeSnapshotParams(buffered, params)
+	writeRecord(buffered, snapshot.BaseHash)
+	binary.Write(buffered, binary.BigEndian, uint64(snapshot.Height))
+	binary.Write(buffered, binary.BigEndian, uint64(len(utxos)))
+	for _, utxo := range utxos {
+		writeSnapshotOutput(buffered, utxo)
+	}
+	binary.Write(buffered, binary.BigEndian, uint64(len(snapshot.Slashed)))
+	for _, pubKey := range snapshot.Slashed {
+		writeRecord(buffered, pubKey)
+	}
+	return errors.Wrap(buffered.Flush(), "failed to write UTXO snapshot")
+}
+
+// Writes network parameters: the length prefixed name, the uint64 difficulty, the uint64 coinbase maturity,
+// the length prefixed consensus, the uint64 number of signers followed by their length prefixed public keys,
+// the uint64 legacy height and the length prefixed legacy tip.
+// Gob encodings depend on the types encoded before them, so they can't be committed to.
+func writeSnapshotParams(w *bufio.Writer, params Params) {
+	writeRecord(w, []byte(params.Name))
+	binary.Write(w, binary.BigEndian, uint64(params.Difficulty))
+	binary.Write(w, binary.BigEndian, uint64(params.CoinbaseMaturity))
+	writeRecord(w, []byte(params.Consensus))
+	binary.Write(w, binary.BigEndian, uint64(len(params.Signers)))
+	for _, signer := range params.Signers {
+		writeRecord(w, signer)
+	}
+	binary.Write(w, binary.BigEndian, uint64(params.LegacyHeight))
+	writeRecord(w, params.LegacyTip)
+}
+
+func readSnapshotParams(r io.Reader) (Params, error) {
+	var params Params
+	var difficulty, maturity, signers, legacyHeight uint64
+
+	name, err := readRecord(r)
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &difficulty)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &maturity)
+	}
+	var consensus []byte
+	if err == nil {
+		consensus, err = readRecord(r)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &signers)
+	}
+	for i := uint64(0); err == nil && i < signers; i++ {
+		var signer []byte
+		if signer, err = readRecord(r); err == nil {
+			params.Signers = append(params.Signers, signer)
+		}
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &legacyHeight)
+	}
+	var legacyTip []byte
+	if err == nil {
+		legacyTip, err = readRecord(r)
+	}
+	if err != nil {
+		return params, errors.Wrap(err, "failed to read network parameters")
+	}
+	params.Name = string(name)
+	params.Difficulty = int(difficulty)
+	params.CoinbaseMaturity = int(maturity)
+	params.Consensus = string(consensus)
+	params.LegacyHeight = int(legacyHeight)
+	if len(legacyTip) > 0 {
+		params.LegacyTip = legacyTip
+	}
+	return params, nil
+}
+
+// Writes an unspent output: the length prefixed transaction ID, the uint32 index, the uint64 height,
+// a coinbase byte, the uint64 value and the length prefixed locking script.
+func writeSnapshotOutput(w *bufio.Writer, utxo UnspentOutput) {
+	writeRecord(w, utxo.TxID)
+	binary.Write(w, binary.BigEndian, uint32(utxo.Index))
+	binary.Write(w, binary.BigEndian, uint64(utxo.Height))
+	coinbase := byte(0)
+	if utxo.Coinbase {
+		coinbase = 1
+	}
+	w.WriteByte(coinbase)
+	binary.Write(w, binary.BigEndian, uint64(utxo.Output.Value))
+	writeRecord(w, utxo.Output.LockingScript)
+}
+
+func readSnapshotOutput(r io.Reader) (UnspentOutput, error) {
+	var utxo UnspentOutput
+	var index uint32
+	var height, value uint64
+	var coinbase byte
+
+	txID, err := readRecord(r)
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &index)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &height)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &coinbase)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &value)
+	}
+	if err == nil {
+		utxo.Output.LockingScript, err = readRecord(r)
+	}
+	if err != nil {
+		return utxo, errors.Wrap(err, "failed to read unspent output")
+	}
+	utxo.TxID = txID
+	utxo.Index = int(index)
+	utxo.Height = int(height)
+	utxo.Coinbase = coinbase == 1
+	utxo.Output.Value = int(value)
+	return utxo, nil
+}
+
+// Creates a new Blockchain DB of a storage backend in a directory from a UTXO snapshot.
+func LoadUTXOSetWithBackend(dir, backend string, r io.ReadSeeker, commitment []byte) (*BlockChain, error) {
+	if backend == storage.MemoryBackend {
+		return nil, errors.New("blockchain in a directory can't be kept in memory")
+	}
+	if storage.Detect(dir) != "" {
+		return nil, errors.Errorf("blockchain already exists in %s", dir)
+	}
+	store, err := storage.Open(backend, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blockchain DB")
+	}
+	chain, err := LoadUTXOSet(store, r, commitment)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return chain, nil
+}
+
+// Creates a new Blockchain in an empty store starting from a UTXO snapshot, new blocks are connected
+// on top of its base block. The snapshot is verified against its commitment before anything is stored,
+// and against the expected commitment unless it is nil.
+func LoadUTXOSet(store storage.Store, r io.ReadSeeker, commitment []byte) (*BlockChain, error) {
+	snapshot, base, err := readSnapshotHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if commitment != nil && !bytes.Equal(commitment, snapshot.Commitment) {
+		return nil, errors.Errorf("UTXO snapshot commitment is %x, expected %x", snapshot.Commitment, commitment)
+	}
+	buffered := bufio.NewReader(r)
+
+	params, err := readSnapshotParams(buffered)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readRecord(buffered); err != nil {
+		return nil, errors.Wrap(err, "failed to read base hash")
+	}
+	var height, count uint64
+	if err := binary.Read(buffered, binary.BigEndian, &height); err != nil {
+		return nil, errors.Wrap(err, "failed to read base height")
+	}
+	if err := binary.Read(buffered, binary.BigEndian, &count); err != nil {
+		return nil, errors.Wrap(err, "failed to read number of unspent outputs")
+	}
+	snapshot.Height = int(height)
+	snapshot.Outputs = int(count)
+
+	if _, err := store.Get([]byte(lastHashKey)); err != storage.ErrNotFound {
+		return nil, errors.New("store already holds a blockchain")
+	}
+	// the chain is created only once all outputs are stored, so a failed load leaves no chain behind
+	for stored := uint64(0); stored < count; {
+		err := store.Update(func(batch storage.Batch) error {
+			for i := 0; i < snapshotBatchSize && stored < count; i++ {
+				utxo, err := readSnapshotOutput(buffered)
+				if err != nil {
+					return err
+				}
+				var encoded bytes.Buffer
+				if err := gob.NewEncoder(&encoded).Encode(utxo); err != nil {
+					return err
+				}
+				if err := batch.Put(snapshotOutputKey(utxo.TxID, utxo.Index), encoded.Bytes()); err != nil {
+					return err
+				}
+				stored++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to store unspent outputs")
+		}
+	}
+
+	var slashed uint64
+	if err := binary.Read(buffered, binary.BigEndian, &slashed); err != nil {
+		return nil, errors.Wrap(err, "failed to read slashed proposers")
+	}
+	for i := uint64(0); i < slashed; i++ {
+		pubKey, err := readRecord(buffered)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read slashed proposers")
+		}
+		snapshot.Slashed = append(snapshot.Slashed, pubKey)
+	}
+
+	var encodedSnapshot bytes.Buffer
+	if err := gob.NewEncoder(&encodedSnapshot).Encode(snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to encode UTXO snapshot")
+	}
+	err = store.Update(func(batch storage.Batch) error {
+		if err := batch.Put(base.Hash, base.Serialize()); err != nil {
+			return err
+		}
+		if err := putParams(batch, params); err != nil {
+			return err
+		}
+		if err := putSchemaVersion(batch, SchemaVersion); err != nil {
+			return err
+		}
+		if err := batch.Put([]byte(snapshotKey), encodedSnapshot.Bytes()); err != nil {
+			return err
+		}
+		return batch.Put([]byte(lastHashKey), base.Hash)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store UTXO snapshot")
+	}
+	log.Info().Int("height", snapshot.Height).Int("outputs", snapshot.Outputs).Msg("UTXO snapshot loaded")
+	return LoadBlockChain(store)
+}
+
+// Reads the header of a UTXO snapshot and verifies its commitment, returns the snapshot and its base block.
+// r is left at the committed fields.
+func readSnapshotHeader(r io.ReadSeeker) (*UTXOSnapshot, *Block, error) {
+	prefix := make([]byte, len(snapshotMagic)+4+sha256.Size)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read UTXO snapshot header")
+	}
+	if string(prefix[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, nil, errors.New("file is not a UTXO snapshot")
+	}
+	if version := binary.BigEndian.Uint32(prefix[len(snapshotMagic):]); version != snapshotVersion {
+		return nil, nil, errors.Errorf("unsupported UTXO snapshot version %d", version)
+	}
+	snapshot := &UTXOSnapshot{Commitment: prefix[len(snapshotMagic)+4:]}
+
+	encodedBase, err := readRecord(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read base block")
+	}
+	base, err := DecodeBlock(encodedBase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read UTXO snapshot")
+	}
+	commitment := sha256.New()
+	if _, err := io.Copy(commitment, r); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read UTXO snapshot")
+	}
+	if !bytes.Equal(commitment.Sum(nil), snapshot.Commitment) {
+		return nil, nil, errors.New("UTXO snapshot doesn't match its commitment, the file is corrupted")
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read UTXO snapshot")
+	}
+
+	// the committed base hash binds the base block
+	if _, err := readSnapshotParams(r); err != nil {
+		return nil, nil, err
+	}
+	baseHash, err := readRecord(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read base hash")
+	}
+	if !bytes.Equal(base.Hash, baseHash) || !bytes.Equal(base.Hash, base.HeaderHash()) {
+		return nil, nil, errors.New("base block doesn't match the UTXO snapshot")
+	}
+	snapshot.BaseHash = baseHash
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read UTXO snapshot")
+	}
+	return snapshot, base, nil
+}
+
+// Gets the UTXO snapshot the chain starts from, nil if it starts with the Genesis.
+func (chain *BlockChain) Snapshot() (*UTXOSnapshot, error) {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	if chain.snapshotBase == nil {
+		return nil, nil
+	}
+	return loadSnapshot(chain.Database)
+}
+
+func loadSnapshot(store storage.Store) (*UTXOSnapshot, error) {
+	encoded, err := store.Get([]byte(snapshotKey))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot UTXOSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to decode UTXO snapshot")
+	}
+	return &snapshot, nil
+}
+
+// Checks if a block is the base of the snapshot the chain starts from.
+func (chain *BlockChain) isSnapshotBase(block *Block) bool {
+	return chain.snapshotBase != nil && bytes.Equal(block.Hash, chain.snapshotBase)
+}
+
+// Gets the unspent outputs of the snapshot the chain starts from.
+func (chain *BlockChain) snapshotOutputs() []UnspentOutput {
+	if chain.snapshotBase == nil {
+		return nil
+	}
+	var utxos []UnspentOutput
+	err := chain.Database.Iterate([]byte(snapshotOutputPrefix), func(key, value []byte) error {
+		var utxo UnspentOutput
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&utxo); err != nil {
+			return err
+		}
+		utxos = append(utxos, utxo)
+		return nil
+	})
+	HandleError(errors.Wrap(err, "failed to load UTXO snapshot"))
+	return utxos
+}
+
+// Gets an unspent output of the snapshot the chain starts from.
+func (chain *BlockChain) snapshotOutput(txID []byte, index int) (UnspentOutput, error) {
+	if chain.snapshotBase == nil {
+		return UnspentOutput{}, storage.ErrNotFound
+	}
+	encoded, err := chain.Database.Get(snapshotOutputKey(txID, index))
+	if err != nil {
+		return UnspentOutput{}, err
+	}
+	var utxo UnspentOutput
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&utxo); err != nil {
+		return UnspentOutput{}, errors.Wrap(err, "failed to decode unspent output")
+	}
+	return utxo, nil
+}
+
+func snapshotOutputKey(txID []byte, index int) []byte {
+	return []byte(fmt.Sprintf("%s%s-%d", snapshotOutputPrefix, hex.EncodeToString(txID), index))
+}
+
+// Validates the blocks preceding the base of the snapshot the chain starts from, e.g. in the background
+// while the chain is in use. The blocks are read from a chain export, fully validated in memory and
+// the UTXO set they produce at the base has to match the snapshot. The snapshot is marked as validated if it does.
+func (chain *BlockChain) ValidateSnapshot(ctx context.Context, r io.ReadSeeker) error {
+	snapshot, err := chain.Snapshot()
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return errors.New("chain doesn't start from a UTXO snapshot")
+	}
+
+	history, err := importBlockChain(ctx, storage.NewMemoryStore(), r, snapshot.Height)
+	if err != nil {
+		return err
+	}
+	defer history.Database.Close()
+	if !reflect.DeepEqual(history.Params, chain.Params) {
+		return errors.New("exported chain belongs to another network")
+	}
+	if !bytes.Equal(history.LastHash(), snapshot.BaseHash) {
+		return errors.Errorf("block %d of the exported chain is not the base of the UTXO snapshot", snapshot.Height)
+	}
+
+	validated, _, _, err := history.utxoSnapshot(snapshot.Height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(validated.Commitment, snapshot.Commitment) {
+		return errors.Errorf("UTXO set of the exported chain has commitment %x, the snapshot %x", validated.Commitment, snapshot.Commitment)
+	}
+
+	// pruning replaces the snapshot while holding pruneMu, the validated one has to be still in place
+	chain.pruneMu.Lock()
+	defer chain.pruneMu.Unlock()
+	current, err := chain.Snapshot()
+	if err != nil {
+		return err
+	}
+	if current == nil || !bytes.Equal(current.BaseHash, snapshot.BaseHash) {
+		return errors.New("UTXO snapshot was replaced by pruning while it was validated, validate the new snapshot")
+	}
+
+	current.Validated = true
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(current); err != nil {
+		return errors.Wrap(err, "failed to encode UTXO snapshot")
+	}
+	err = chain.Database.Update(func(batch storage.Batch) error {
+		return batch.Put([]byte(snapshotKey), encoded.Bytes())
+	})
+	return errors.Wrap(err, "failed to store UTXO snapshot")
+}
@@ -0,0 +1,176 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// Dumps the UTXO set of a chain at height and loads it into a new chain in memory.
+func loadSnapshotOf(t *testing.T, chain *BlockChain, height int) (*BlockChain, *UTXOSnapshot) {
+	t.Helper()
+	var dump bytes.Buffer
+	snapshot, err := chain.DumpUTXOSet(&dump, height)
+	if err != nil {
+		t.Fatalf("failed to dump UTXO set: %v", err)
+	}
+	loaded, err := LoadUTXOSet(storage.NewMemoryStore(), bytes.NewReader(dump.Bytes()), snapshot.Commitment)
+	if err != nil {
+		t.Fatalf("failed to load UTXO set: %v", err)
+	}
+	t.Cleanup(func() { loaded.Database.Close() })
+	return loaded, snapshot
+}
+
+func TestLoadUTXOSet(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	receiver := wallets.AddWallet()
+	rawTx := spendGenesis(t, chain, wallets, receiver)
+	if err := chain.AddBlock([]*Transaction{newCoinbase(t, miner, 1), &rawTx.Tx}); err != nil {
+		t.Fatal(err)
+	}
+	generate(t, chain, miner, 2)
+
+	loaded, snapshot := loadSnapshotOf(t, chain, -1)
+	if !bytes.Equal(loaded.LastHash(), chain.LastHash()) || snapshot.Height != 3 {
+		t.Fatalf("expected the loaded chain to end with block 3 %x, got %x", chain.LastHash(), loaded.LastHash())
+	}
+	for _, address := range []string{miner, receiver} {
+		expected, _ := balanceOf(t, chain, address)
+		if balance, _ := balanceOf(t, loaded, address); balance != expected {
+			t.Fatalf("loaded balance of %s is %d, expected %d", address, balance, expected)
+		}
+	}
+
+	// outputs of the snapshot are spent by new blocks
+	other := wallets.AddWallet()
+	spend, err := loaded.CreateRawTransaction(receiver, other, BlockReward/2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spend.Sign(wallets)
+	if err := loaded.AddBlock([]*Transaction{newCoinbase(t, miner, 4), &spend.Tx}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.ValidateTransaction(&spend.Tx); err == nil || !strings.Contains(err.Error(), "already spent") {
+		t.Fatalf("expected the snapshot output to be spent, got %v", err)
+	}
+	if balance, _ := balanceOf(t, loaded, other); balance != BlockReward/2 {
+		t.Fatalf("expected %d to be received, got %d", BlockReward/2, balance)
+	}
+	if _, err := loaded.Export(exportChain(t, chain)); err == nil {
+		t.Fatal("expected a chain started from a snapshot not to be exported")
+	}
+}
+
+func TestLoadUTXOSetChecksCommitment(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, 2)
+
+	var dump bytes.Buffer
+	snapshot, err := chain.DumpUTXOSet(&dump, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadUTXOSet(storage.NewMemoryStore(), bytes.NewReader(dump.Bytes()), make([]byte, 32)); err == nil {
+		t.Fatal("expected a snapshot with another commitment to be rejected")
+	}
+
+	data := dump.Bytes()
+	data[len(data)-10] ^= 0xff
+	store := storage.NewMemoryStore()
+	if _, err := LoadUTXOSet(store, bytes.NewReader(data), snapshot.Commitment); err == nil || !strings.Contains(err.Error(), "commitment") {
+		t.Fatalf("expected a corrupted snapshot to be rejected, got %v", err)
+	}
+	if _, err := store.Get([]byte(lastHashKey)); err != storage.ErrNotFound {
+		t.Fatalf("expected nothing to be loaded, got %v", err)
+	}
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, 3)
+	loaded, _ := loadSnapshotOf(t, chain, 2)
+
+	other, _, otherMiner := newRegTestChain(t, 0)
+	generate(t, other, otherMiner, 3)
+	if err := loaded.ValidateSnapshot(context.Background(), exportChain(t, other)); err == nil {
+		t.Fatal("expected blocks of another chain not to validate the snapshot")
+	}
+
+	if err := loaded.ValidateSnapshot(context.Background(), exportChain(t, chain)); err != nil {
+		t.Fatalf("failed to validate snapshot: %v", err)
+	}
+	snapshot, err := loaded.Snapshot()
+	if err != nil || !snapshot.Validated {
+		t.Fatalf("expected the snapshot to be validated, got %+v %v", snapshot, err)
+	}
+}
+
+// The preceding blocks hold a payment mined by SubmitRawTransaction, as the node mines payments.
+func TestValidateSnapshotWithPayments(t *testing.T) {
+	chain, wallets, miner := newRegTestChain(t, 0)
+	receiver := wallets.AddWallet()
+	rawTx, err := chain.CreateRawTransaction(miner, receiver, 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawTx.Sign(wallets)
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, miner); err != nil {
+		t.Fatal(err)
+	}
+	generate(t, chain, miner, 2)
+	loaded, _ := loadSnapshotOf(t, chain, 2)
+
+	if err := loaded.ValidateSnapshot(context.Background(), exportChain(t, chain)); err != nil {
+		t.Fatalf("failed to validate snapshot: %v", err)
+	}
+	if snapshot, err := loaded.Snapshot(); err != nil || !snapshot.Validated {
+		t.Fatalf("expected the snapshot to be validated, got %+v %v", snapshot, err)
+	}
+}
+
+// A blockingReader signals its first read and blocks it until it is released.
+type blockingReader struct {
+	io.ReadSeeker
+	started, release chan struct{}
+	once             sync.Once
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	r.once.Do(func() {
+		close(r.started)
+		<-r.release
+	})
+	return r.ReadSeeker.Read(p)
+}
+
+func TestValidateSnapshotWhilePruning(t *testing.T) {
+	chain, _, miner := newRegTestChain(t, 0)
+	generate(t, chain, miner, 3)
+	loaded, _ := loadSnapshotOf(t, chain, 2)
+	generate(t, loaded, miner, 10)
+
+	// the chain is pruned after the validation has read the snapshot
+	export := &blockingReader{ReadSeeker: exportChain(t, chain), started: make(chan struct{}), release: make(chan struct{})}
+	validated := make(chan error)
+	go func() { validated <- loaded.ValidateSnapshot(context.Background(), export) }()
+	<-export.started
+	if err := loaded.SetPruneTarget(PruneTarget{Blocks: 5}); err != nil {
+		t.Fatal(err)
+	}
+	close(export.release)
+	if err := <-validated; err == nil || !strings.Contains(err.Error(), "replaced by pruning") {
+		t.Fatalf("expected the validation of the replaced snapshot to fail, got %v", err)
+	}
+
+	snapshot, err := loaded.Snapshot()
+	if err != nil || snapshot.Height != 8 || snapshot.Validated {
+		t.Fatalf("expected the unvalidated snapshot of the pruned chain at block 8, got %+v %v", snapshot, err)
+	}
+}
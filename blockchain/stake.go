@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
+)
+
+// A Stake is the amount of coins staked by a public key.
+type Stake struct {
+	PubKey  []byte
+	Amount  int
+	Slashed bool // the staker was caught signing two blocks at the same height
+}
+
+// Gets the stakes of the chain, sorted by public key.
+func (chain *BlockChain) Stakes() []Stake {
+	return chain.stakesAt(chain.LastHash())
+}
+
+// Gets the stakes of the chain ending with the block hash, sorted by public key.
+// Slashed stakers are included even if they have no stake left.
+func (chain *BlockChain) stakesAt(hash []byte) []Stake {
+	chain.history.rlock()
+	defer chain.history.runlock()
+
+	type stakeOutput struct {
+		outpoint string
+		pubKey   []byte
+		value    int
+	}
+	var outputs []stakeOutput
+	spent := make(map[string]bool)
+	stakes := make(map[string]*Stake)
+
+	iter := &BlockChainIterator{hash, chain.Database}
+	for {
+		block := iter.Next()
+		if chain.isSnapshotBase(block) {
+			break
+		}
+
+		for _, evidence := range block.Evidence {
+			stakes[hex.EncodeToString(evidence.Signer())] = &Stake{PubKey: evidence.Signer(), Slashed: true}
+		}
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					spent[fmt.Sprintf("%s:%d", hex.EncodeToString(in.ID), in.Out)] = true
+				}
+			}
+			for outIdx, out := range tx.Outputs {
+				if pubKey, ok := script.ExtractStakePubKey(out.LockingScript); ok {
+					outpoint := fmt.Sprintf("%s:%d", hex.EncodeToString(tx.ID), outIdx)
+					outputs = append(outputs, stakeOutput{outpoint, pubKey, out.Value})
+				}
+			}
+		}
+
+		if chain.IsFirstBlock(block) {
+			break
+		}
+	}
+
+	// stakes created up to the snapshot base are in the UTXO set of the snapshot
+	for _, utxo := range chain.snapshotOutputs() {
+		if pubKey, ok := script.ExtractStakePubKey(utxo.Output.LockingScript); ok {
+			outpoint := fmt.Sprintf("%s:%d", hex.EncodeToString(utxo.TxID), utxo.Index)
+			outputs = append(outputs, stakeOutput{outpoint, pubKey, utxo.Output.Value})
+		}
+	}
+	if snapshot, err := loadSnapshot(chain.Database); err == nil {
+		for _, pubKey := range snapshot.Slashed {
+			stakes[hex.EncodeToString(pubKey)] = &Stake{PubKey: pubKey, Slashed: true}
+		}
+	}
+
+	for _, out := range outputs {
+		if spent[out.outpoint] {
+			continue
+		}
+		key := hex.EncodeToString(out.pubKey)
+		if stakes[key] == nil {
+			stakes[key] = &Stake{PubKey: out.pubKey}
+		}
+		stakes[key].Amount += out.value
+	}
+
+	var sorted []Stake
+	for _, stake := range stakes {
+		sorted = append(sorted, *stake)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].PubKey, sorted[j].PubKey) < 0
+	})
+	return sorted
+}
+
+// Checks if a public key is slashed in stakes.
+func isSlashed(stakes []Stake, pubKey []byte) bool {
+	for _, stake := range stakes {
+		if stake.Slashed && bytes.Equal(stake.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates an unsigned transaction staking amount of an address's coins for a public key,
+// the change returns to the address.
+func (chain *BlockChain) CreateRawStakeTransaction(from string, pubKey []byte, amount int) (*RawTransaction, error) {
+	if amount <= 0 {
+		return nil, errors.New("staked amount must be positive")
+	}
+	if err := checkPublicKeys([][]byte{pubKey}); err != nil {
+		return nil, err
+	}
+	return chain.createRawTransaction(from, TxOutput{amount, script.PayToStake(pubKey)}, 0)
+}
+
+// Creates an unsigned transaction withdrawing the whole stake of a public key to an address.
+// Slashed stake can't be withdrawn.
+func (chain *BlockChain) CreateRawUnstakeTransaction(pubKey []byte, to string) (*RawTransaction, error) {
+	if isSlashed(chain.Stakes(), pubKey) {
+		return nil, errors.Errorf("stake of %x was slashed", pubKey)
+	}
+
+	var inputs []TxInput
+	var rawInputs []RawInput
+	total := 0
+	for _, utxo := range chain.FindUnspentOutputs(script.PayToStake(pubKey)) {
+		inputs = append(inputs, TxInput{utxo.TxID, utxo.Index, nil, MaxSequence})
+		rawInputs = append(rawInputs, RawInput{PrevOut: utxo.Output})
+		total += utxo.Output.Value
+	}
+	if total == 0 {
+		return nil, errors.Errorf("%x has no stake", pubKey)
+	}
+
+	out, err := NewTxOutput(total, to)
+	if err != nil {
+		return nil, err
+	}
+	tx := Transaction{nil, inputs, []TxOutput{*out}, 0}
+	tx.ID = tx.Hash()
+
+	return &RawTransaction{tx, rawInputs}, nil
+}
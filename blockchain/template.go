@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// A BlockTemplate describes the next block to be mined on top of the chain, so that its proof of work
+// can be computed outside of the node. Miners add a coinbase paying CoinbaseValue and submit the mined block.
+type BlockTemplate struct {
+	PrevHash      []byte
+	Height        int
+	Timestamp     int64
+	Difficulty    int
+	Target        *big.Int
+	Transactions  []*Transaction
+	Fees          []int // Fees[i] is paid by Transactions[i]
+	CoinbaseValue int   // the block reward together with all fees
+}
+
+// Creates a template of the next block with transactions selected from the mempool.
+// Pool transactions which are no longer valid, e.g. because a block spent their inputs, are removed from the pool.
+func (chain *BlockChain) NewBlockTemplate(pool *Mempool) *BlockTemplate {
+	lastBlock := chain.LastBlock()
+	template := &BlockTemplate{
+		PrevHash:      lastBlock.Hash,
+		Height:        lastBlock.Height + 1,
+		Timestamp:     time.Now().Unix(),
+		Difficulty:    chain.Params.Difficulty,
+		CoinbaseValue: BlockReward,
+	}
+	if template.Timestamp < lastBlock.Timestamp {
+		template.Timestamp = lastBlock.Timestamp
+	}
+	template.Target = NewProof(&Block{Difficulty: template.Difficulty}).Target
+
+	var invalid []*Transaction
+	usedOuts := make(map[string]bool)
+	for _, tx := range pool.Transactions() {
+		fee, err := chain.checkTransaction(tx, lastBlock.Hash, template.Height, template.Timestamp)
+		if err != nil {
+			invalid = append(invalid, tx)
+			continue
+		}
+		if spendsAny(tx, usedOuts) {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			usedOuts[fmt.Sprintf("%s:%d", hex.EncodeToString(in.ID), in.Out)] = true
+		}
+
+		template.Transactions = append(template.Transactions, tx)
+		template.Fees = append(template.Fees, fee)
+		template.CoinbaseValue += fee
+	}
+	pool.Remove(invalid)
+
+	return template
+}
+
+// Checks if a transaction spends any of the outputs.
+func spendsAny(tx *Transaction, outpoints map[string]bool) bool {
+	for _, in := range tx.Inputs {
+		if outpoints[fmt.Sprintf("%s:%d", hex.EncodeToString(in.ID), in.Out)] {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates a block from the template with a coinbase paying the block reward and fees to the miner's address.
+// The proof of work of the block still has to be computed.
+func (t *BlockTemplate) NewBlock(minerAddress string) (*Block, error) {
+	coinbase, err := coinbaseTx(minerAddress, "", t.Height, t.CoinbaseValue)
+	if err != nil {
+		return nil, err
+	}
+	txs := append([]*Transaction{coinbase}, t.Transactions...)
+
+	block := NewBlock(txs, t.PrevHash, t.Height, t.Difficulty)
+	if block.Timestamp < t.Timestamp {
+		block.Timestamp = t.Timestamp
+	}
+	return block, nil
+}
@@ -11,17 +11,36 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
 	"github.com/michaljirman/goblockchain/wallet"
+)
 
-	"github.com/rs/zerolog/log"
+const (
+	// Value of the coinbase output of every block.
+	BlockReward = 100
+	// Largest value of an output and of the outputs or inputs of a transaction. Sums of values are checked
+	// against it on every addition, so they can't overflow.
+	MaxMoney = 21000000 * 100000000
+	// Lock times below the threshold are block heights, lock times above it are unix times.
+	LockTimeThreshold = 500000000
+	// Sequence of an input which doesn't allow the transaction lock time to be enforced.
+	MaxSequence = 0xffffffff
+	// Size of the extra nonce in the unlocking script of a coinbase transaction.
+	ExtraNonceSize = 8
 )
 
-// Base transaction struct containing id, inputs and outputs.
+// Base transaction struct containing id, inputs, outputs and a lock time.
+// A transaction with a non-zero LockTime can't be mined before the block height or the unix time
+// of the lock time, unless all of its inputs have MaxSequence.
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime uint32
 }
 
 // Serializes a transaction into a byte slice.
@@ -36,6 +55,32 @@ func (tx *Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
+// Gets the size of a serialized transaction without the type definitions gob sends ahead of it.
+// They are the same for all transactions, but gob numbers types in the order a process first encodes them.
+func (tx *Transaction) Size() int {
+	return encodedSize(&Transaction{}, tx)
+}
+
+// Encodes a value after its zero value, so that the encoder already sent the type definitions,
+// and gets the size of the value alone.
+func encodedSize(zero, value interface{}) int {
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	HandleError(enc.Encode(zero))
+	encoded.Reset()
+	HandleError(enc.Encode(value))
+	return encoded.Len()
+}
+
+// Decodes a transaction serialized by Serialize.
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil {
+		return nil, errors.Wrap(err, "failed to decode transaction")
+	}
+	return &tx, nil
+}
+
 // Hashes a transaction's inputs and outputs (not tx.ID).
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
@@ -58,57 +103,89 @@ func (tx *Transaction) SetID() {
 	tx.ID = hash[:]
 }
 
-// Creates a coinbase transaction.
-func CoinbaseTx(to, data string) *Transaction {
+// Creates a coinbase transaction rewarding an address for a block at height.
+// The height is part of the unlocking script so that coinbase transactions of different blocks never share an ID.
+func CoinbaseTx(to, data string, height int) (*Transaction, error) {
+	return coinbaseTx(to, data, height, BlockReward)
+}
+
+// Creates a coinbase transaction paying value, the block reward together with fees of the block's transactions.
+func coinbaseTx(to, data string, height, value int) (*Transaction, error) {
 	if data == "" {
 		data = fmt.Sprintf("Coins to %s", to)
 	}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTxOutput(100, to)
+	unlockingScript := script.NewBuilder().
+		AddInt(int64(height)).
+		AddData(make([]byte, ExtraNonceSize)).
+		AddData([]byte(data)).
+		Script()
+	txin := TxInput{[]byte{}, -1, unlockingScript, MaxSequence}
+	txout, err := NewTxOutput(value, to)
+	if err != nil {
+		return nil, err
+	}
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, 0}
 	tx.SetID()
 
-	return &tx
+	return &tx, nil
 }
 
-// Creates a new transaction for an existing blockchain.
-func NewTransaction(from, to string, amount int, chain *BlockChain) *Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
-
-	wallets, err := wallet.CreateWallets()
-	HandleError(err)
-	w := wallets.GetWallet(from)
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	acc, validOutputs := chain.FindSpendableOutputs(pubKeyHash, amount)
+// Replaces the extra nonce of a coinbase transaction and updates its ID.
+// Miners change the extra nonce once they exhaust the nonces of a block.
+func (tx *Transaction) SetExtraNonce(extraNonce []byte) error {
+	if !tx.IsCoinbase() {
+		return errors.New("only a coinbase transaction has an extra nonce")
+	}
+	instructions, err := tx.Inputs[0].UnlockingScript.Parse()
+	if err != nil || len(instructions) != 3 {
+		return errors.New("coinbase transaction has no extra nonce")
+	}
 
-	if acc < amount {
-		log.Panic().Msg("Error: not enough funds")
+	// the script is <height> <extra nonce> <data>
+	builder := script.NewBuilder()
+	if height := instructions[0]; height.Data != nil {
+		builder.AddData(height.Data)
+	} else {
+		builder.AddOp(height.Opcode)
 	}
+	tx.Inputs[0].UnlockingScript = builder.AddData(extraNonce).AddData(instructions[2].Data).Script()
 
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		HandleError(err)
+	tx.ID = nil
+	tx.SetID()
+	return nil
+}
 
-		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, input)
-		}
+// Checks if a transaction can be mined in a block at height created at blockTime.
+func (tx *Transaction) IsFinal(height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
 	}
 
-	outputs = append(outputs, *NewTxOutput(amount, to))
-
-	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
+	limit := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		limit = blockTime
+	}
+	if int64(tx.LockTime) <= limit {
+		return true
 	}
 
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	chain.SignTransaction(&tx, w.PrivateKey)
+	// the lock time is enforced only if at least one of the inputs allows it
+	for _, in := range tx.Inputs {
+		if in.Sequence != MaxSequence {
+			return false
+		}
+	}
+	return true
+}
 
-	return &tx
+// Describes a lock time as a block height or a time.
+func describeLockTime(lockTime uint32) string {
+	if lockTime < LockTimeThreshold {
+		return fmt.Sprintf("block %d", lockTime)
+	}
+	return time.Unix(int64(lockTime), 0).UTC().Format(time.RFC3339)
 }
 
 // Checks if a transaction is coinbase transaction.
@@ -129,41 +206,89 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for txInIdx, txIn := range txCopy.Inputs {
+	pubKey := wallet.PublicKeyBytes(privKey.PublicKey)
+	for txInIdx, txIn := range tx.Inputs {
 		prevTX := prevTXs[hex.EncodeToString(txIn.ID)]
-		txCopy.Inputs[txInIdx].Signature = nil
-		txCopy.Inputs[txInIdx].PubKey = prevTX.Outputs[txIn.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[txInIdx].PubKey = nil
+		HandleError(tx.SignInput(txInIdx, privKey, pubKey, prevTX.Outputs[txIn.Out]))
+	}
+}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		HandleError(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+// Signs a single input spending a pay-to-public-key-hash or a stake output and sets its unlocking script.
+// The prevOut is the output the input spends, which is all that is needed from the chain to produce the signature.
+func (tx *Transaction) SignInput(inIdx int, privKey ecdsa.PrivateKey, pubKey []byte, prevOut TxOutput) error {
+	switch {
+	case script.IsPayToPubKeyHash(prevOut.LockingScript):
+		signature := tx.CreateSignature(inIdx, privKey, prevOut.LockingScript, prevOut.Value)
+		tx.Inputs[inIdx].UnlockingScript = script.PayToPubKeyHashUnlock(signature, pubKey)
+	case script.IsPayToStake(prevOut.LockingScript):
+		signature := tx.CreateSignature(inIdx, privKey, prevOut.LockingScript, prevOut.Value)
+		tx.Inputs[inIdx].UnlockingScript = script.PayToStakeUnlock(signature)
+	default:
+		return errors.Errorf("input %d doesn't spend a pay-to-public-key-hash or a stake output", inIdx)
+	}
+	return nil
+}
+
+// Creates a signature of an input with a privKey. The subscript is the script which checks the signature,
+// the locking script of the spent output or the redeem script of a pay-to-script-hash output.
+// The value of the spent output is signed too, so a signer knows the amount it authorizes.
+func (tx *Transaction) CreateSignature(inIdx int, privKey ecdsa.PrivateKey, subscript script.Script, value int) []byte {
+	return signHash(privKey, tx.signatureHash(inIdx, subscript, value))
+}
 
-		tx.Inputs[txInIdx].Signature = signature
+// Signs a hash with a private key.
+func signHash(privKey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	HandleError(err)
+	// both halves are padded to the same length so that the signature can be split in the middle
+	return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+}
+
+// Verifies a signature created by signHash with a public key encoded by wallet.PublicKeyBytes.
+func verifySignature(pubKey, hash, sig []byte) bool {
+	if len(sig) != 64 || len(pubKey) != 64 {
+		return false
 	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	x := new(big.Int).SetBytes(pubKey[:32])
+	y := new(big.Int).SetBytes(pubKey[32:])
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	return ecdsa.Verify(&rawPubKey, hash, r, s)
+}
+
+// Computes the hash signed by an input spending an output of a value. Unlocking scripts can't sign themselves
+// so all of them are removed and the signed input carries the subscript instead. Transactions don't carry
+// the values they spend, the value is appended, so a signature is valid only for the value its signer saw.
+func (tx *Transaction) signatureHash(inIdx int, subscript script.Script, value int) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Inputs[inIdx].UnlockingScript = subscript
+	txCopy.ID = []byte{}
+	hash := sha256.Sum256(append(txCopy.Serialize(), ToBytes(int64(value))...))
+	return hash[:]
 }
 
-// Creates a trimmed copy of a transaction.
+// Creates a trimmed copy of a transaction without unlocking scripts.
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.LockingScript})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 
 	return txCopy
 }
 
+// Verifies unlocking scripts of all inputs of a transaction against previous transactions.
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
@@ -175,31 +300,10 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for inId, in := range tx.Inputs {
 		prevTx := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
+		if err := tx.VerifyInput(inId, prevTx.Outputs[in.Out]); err != nil {
+			log.Debug().Err(err).Msgf("input %d of transaction %x is invalid", inId, tx.ID)
 			return false
 		}
 	}
@@ -207,22 +311,61 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	return true
 }
 
+// Verifies that the unlocking script of an input satisfies the locking script of the output it spends.
+func (tx *Transaction) VerifyInput(inIdx int, prevOut TxOutput) error {
+	checker := txChecker{tx, inIdx, prevOut.Value}
+	return script.Execute(tx.Inputs[inIdx].UnlockingScript, prevOut.LockingScript, checker)
+}
+
+// A txChecker provides the script engine with signatures and lock time of a transaction input.
+type txChecker struct {
+	tx    *Transaction
+	inIdx int
+	value int // value of the output spent by the input
+}
+
+// Checks an ECDSA signature (r || s) of a P256 public key (x || y) over the transaction.
+func (c txChecker) CheckSig(sig, pubKey []byte, subscript script.Script) bool {
+	if len(sig) != 64 || len(pubKey) != 64 {
+		return false
+	}
+	return verifySignature(pubKey, c.tx.signatureHash(c.inIdx, subscript, c.value), sig)
+}
+
+// Checks that the transaction lock time is at least lockTime, so the transaction can't be mined earlier.
+func (c txChecker) CheckLockTime(lockTime int64) error {
+	txLockTime := int64(c.tx.LockTime)
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return errors.New("lock time is a block height and a time at once")
+	}
+	if lockTime > txLockTime {
+		return errors.Errorf("output is locked until %s", describeLockTime(uint32(lockTime)))
+	}
+	if c.tx.Inputs[c.inIdx].Sequence == MaxSequence {
+		return errors.New("input sequence doesn't allow the lock time")
+	}
+	return nil
+}
+
 func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     Lock time: %s", describeLockTime(tx.LockTime)))
+	}
 	for i, input := range tx.Inputs {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		lines = append(lines, fmt.Sprintf("       Script:    %s", input.UnlockingScript))
+		lines = append(lines, fmt.Sprintf("       Sequence:  %#x", input.Sequence))
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", output.LockingScript))
 	}
 
 	return strings.Join(lines, "\n")
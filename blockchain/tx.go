@@ -3,42 +3,81 @@ package blockchain
 import (
 	"bytes"
 
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
 	"github.com/michaljirman/goblockchain/wallet"
 )
 
 type TxOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value         int
+	LockingScript script.Script
 }
 
 type TxInput struct {
-	ID        []byte
-	Out       int
-	Signature []byte
-	PubKey    []byte
+	ID              []byte
+	Out             int
+	UnlockingScript script.Script
+	Sequence        uint32
 }
 
-// Creates a new tx output from value and address
-func NewTxOutput(value int, address string) *TxOutput {
+// Creates a new tx output from value and address, fails if the address can't be paid.
+func NewTxOutput(value int, address string) (*TxOutput, error) {
 	txo := &TxOutput{value, nil}
-	txo.Lock([]byte(address))
-	return txo
+	if err := txo.Lock([]byte(address)); err != nil {
+		return nil, err
+	}
+	return txo, nil
 }
 
-// Text if tx input uses pubKeyHash
-func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PublicKeyHash(in.PubKey)
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
+// Creates a locking script paying to an address.
+// Addresses of public keys are paid with pay-to-public-key-hash scripts, script addresses with pay-to-script-hash scripts.
+func LockingScriptForAddress(address string) (script.Script, error) {
+	if !wallet.ValidateAddress(address) {
+		return nil, errors.Errorf("invalid address %s", address)
+	}
+	version, hash := wallet.DecodeAddress(address)
+	switch version {
+	case wallet.PubKeyHashVersion:
+		return script.PayToPubKeyHash(hash), nil
+	case wallet.ScriptHashVersion:
+		return script.PayToScriptHash(hash), nil
+	}
+	return nil, errors.Errorf("unknown version %#x of address %s", version, address)
 }
 
 // Locks the tx output
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-wallet.ChecksumLength]
-	out.PubKeyHash = pubKeyHash
+func (out *TxOutput) Lock(address []byte) error {
+	lockingScript, err := LockingScriptForAddress(string(address))
+	if err != nil {
+		return err
+	}
+	out.LockingScript = lockingScript
+	return nil
 }
 
 // Checks if tx output is locked with a key
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	hash, ok := script.ExtractPubKeyHash(out.LockingScript)
+	return ok && bytes.Compare(hash, pubKeyHash) == 0
+}
+
+// Gets the address the tx output pays to, stakes belong to the address of their public key.
+// Returns false for scripts which are not paying to an address.
+func (out *TxOutput) Address() (string, bool) {
+	if hash, ok := script.ExtractPubKeyHash(out.LockingScript); ok {
+		return string(wallet.PubKeyHashAddress(hash)), true
+	}
+	if hash, ok := script.ExtractScriptHash(out.LockingScript); ok {
+		return string(wallet.ScriptAddress(hash)), true
+	}
+	if pubKey, ok := script.ExtractStakePubKey(out.LockingScript); ok {
+		return string(wallet.PubKeyHashAddress(wallet.PublicKeyHash(pubKey))), true
+	}
+	return "", false
+}
+
+// Checks if tx output is locked with a locking script
+func (out *TxOutput) IsLockedWithScript(lockingScript script.Script) bool {
+	return bytes.Compare(out.LockingScript, lockingScript) == 0
 }
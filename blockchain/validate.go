@@ -0,0 +1,140 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
+)
+
+// How far in the future a block timestamp may be.
+const maxFutureBlockTime = 2 * time.Hour
+
+// Fully validates a block extending the last block of the chain: its header, seal,
+// coinbase and every transaction. Returns ErrStaleBlock if the block doesn't extend the last block.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	lastBlock := chain.LastBlock()
+	if !bytes.Equal(block.PrevHash, lastBlock.Hash) {
+		return ErrStaleBlock
+	}
+	if block.Height != lastBlock.Height+1 {
+		return errors.Errorf("block height is %d, expected %d", block.Height, lastBlock.Height+1)
+	}
+	if block.Difficulty != chain.Params.Difficulty {
+		return errors.Errorf("block difficulty is %d, expected %d", block.Difficulty, chain.Params.Difficulty)
+	}
+	if block.Timestamp < lastBlock.Timestamp {
+		return errors.New("block timestamp is before the timestamp of the previous block")
+	}
+	if block.Timestamp > time.Now().Add(maxFutureBlockTime).Unix() {
+		return errors.New("block timestamp is too far in the future")
+	}
+
+	if len(block.Evidence) > 0 && chain.Params.Consensus != ProofOfStakeConsensus {
+		return errors.New("double sign evidence is used only by proof of stake")
+	}
+	if err := chain.Engine.VerifySeal(chain, block); err != nil {
+		return err
+	}
+
+	// the conversion of the original storage format keeps its blocks, they are pinned by the hash of the last one
+	legacy := chain.Params.isLegacy(block.Height)
+	if legacy && block.Height == chain.Params.LegacyHeight && !bytes.Equal(block.Hash, chain.Params.LegacyTip) {
+		return errors.Errorf("block %d is not the last block converted from the original storage format", block.Height)
+	}
+	// blocks of the original storage format got no coinbase
+	transactions := block.Transactions
+	if len(transactions) > 0 && transactions[0].IsCoinbase() {
+		transactions = transactions[1:]
+	} else if !legacy {
+		return errors.New("first transaction of a block must be a coinbase")
+	}
+
+	fees := 0
+	txIDs := make(map[string]bool)
+	usedOuts := make(map[string]bool)
+	for _, tx := range transactions {
+		txID := hex.EncodeToString(tx.ID)
+		if tx.IsCoinbase() {
+			return errors.Errorf("transaction %s is a second coinbase", txID)
+		}
+		if txIDs[txID] {
+			return errors.Errorf("transaction %s is included twice", txID)
+		}
+		txIDs[txID] = true
+
+		if spendsAny(tx, usedOuts) {
+			return errors.Errorf("transaction %s spends an output spent by another transaction of the block", txID)
+		}
+		for _, in := range tx.Inputs {
+			usedOuts[fmt.Sprintf("%s:%d", hex.EncodeToString(in.ID), in.Out)] = true
+		}
+
+		fee, err := chain.checkTransaction(tx, lastBlock.Hash, block.Height, block.Timestamp)
+		if err != nil {
+			return errors.Wrapf(err, "transaction %s", txID)
+		}
+		if fees, err = addValue(fees, fee); err != nil {
+			return errors.Wrap(err, "fees of the block")
+		}
+	}
+
+	if len(transactions) == len(block.Transactions) {
+		return nil
+	}
+	return checkCoinbase(block.Transactions[0], block.Height, BlockReward+fees)
+}
+
+// Checks that a coinbase transaction commits to the block height and pays at most the block reward and fees.
+func checkCoinbase(coinbase *Transaction, height, maxValue int) error {
+	txCopy := *coinbase
+	txCopy.ID = nil
+	txCopy.SetID()
+	if !bytes.Equal(coinbase.ID, txCopy.ID) {
+		return errors.New("coinbase id does not match its content")
+	}
+
+	heightScript := script.NewBuilder().AddInt(int64(height)).Script()
+	if !bytes.HasPrefix(coinbase.Inputs[0].UnlockingScript, heightScript) {
+		return errors.Errorf("coinbase does not start with the block height %d", height)
+	}
+
+	value := 0
+	for _, out := range coinbase.Outputs {
+		if out.Value <= 0 {
+			return errors.New("coinbase output value must be positive")
+		}
+		var err error
+		if value, err = addValue(value, out.Value); err != nil {
+			return errors.Wrap(err, "coinbase outputs")
+		}
+	}
+	if value > maxValue {
+		return errors.Errorf("coinbase pays %d but the block reward and fees are only %d", value, maxValue)
+	}
+	return nil
+}
+
+// Validates a block mined outside of the node and connects it to the chain as the new last block.
+func (chain *BlockChain) SubmitBlock(block *Block) error {
+	if err := chain.connectBlock(block); err != nil {
+		return err
+	}
+	chain.pruneConnected()
+	return nil
+}
+
+// Validates a block and stores it while no other block is connected.
+func (chain *BlockChain) connectBlock(block *Block) error {
+	chain.connectMu.Lock()
+	defer chain.connectMu.Unlock()
+
+	if err := chain.ValidateBlock(block); err != nil {
+		return err
+	}
+	return chain.storeBlock(block)
+}
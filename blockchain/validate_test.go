@@ -0,0 +1,54 @@
+package blockchain
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+func TestOverflowingCoinbaseIsRejected(t *testing.T) {
+	address := string(wallet.MakeWallet().Address())
+	tests := []struct {
+		values   []int
+		expected string
+	}{
+		{[]int{math.MaxInt64/2 + 1, math.MaxInt64/2 + 1}, "out of range"},
+		{[]int{MaxMoney, MaxMoney}, "total value exceeds the maximum"},
+		{[]int{BlockReward, 1}, "coinbase pays 101"},
+	}
+
+	for _, test := range tests {
+		coinbase := newCoinbase(t, address, 1)
+		coinbase.Outputs = nil
+		for _, value := range test.values {
+			coinbase.Outputs = append(coinbase.Outputs, newOutput(t, value, address))
+		}
+		coinbase.ID = nil
+		coinbase.SetID()
+		err := checkCoinbase(coinbase, 1, BlockReward)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected outputs %v to be rejected with %q, got %v", test.values, test.expected, err)
+		}
+	}
+}
+
+func TestUnlockedOutputsAreRejected(t *testing.T) {
+	chain, wallets, _ := newRegTestChain(t, 1)
+	recipient := wallet.MakeWallet()
+	generate(t, chain, string(recipient.Address()), 1)
+
+	// a valid checksum doesn't make an address of an unknown version payable
+	payload := append([]byte{0x42}, wallet.PublicKeyHash(recipient.PublicKey)...)
+	address := string(wallet.Base58Encode(append(payload, wallet.Checksum(payload)...)))
+	if _, err := NewTxOutput(BlockReward, address); err == nil {
+		t.Fatalf("expected address %s of version 0x42 to be rejected", address)
+	}
+
+	rawTx := spendGenesisTo(t, chain, wallets, TxOutput{BlockReward, nil})
+	err := chain.ValidateTransaction(&rawTx.Tx)
+	if err == nil || !strings.Contains(err.Error(), "no locking script") {
+		t.Fatalf("expected an output without a locking script to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,806 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// Gets the commands of the blockchain in the data directory.
+func chainCommands() []*command {
+	return []*command{
+		{
+			name:    "createblockchain",
+			args:    "-address ADDRESS [-maturity BLOCKS] [-storage badger|bolt] [-consensus pow|poa|pos -signers PUBKEY,...] [-prune BLOCKS|SIZEMB]",
+			summary: "Creates a blockchain of the -network, main by default, and sends the genesis reward to address",
+			help: []string{
+				"-consensus poa creates a proof-of-authority chain whose signers take turns signing blocks,",
+				"-consensus pos creates a proof-of-stake chain, the signers propose blocks until coins are staked.",
+				"-prune keeps only the latest blocks, see prunechain.",
+			},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "The address to send genesis block reward to")
+				maturity := fs.Int("maturity", -1, "Number of blocks before a coinbase can be spent (defaults to the network's)")
+				consensus := fs.String("consensus", "", "Consensus engine, pow, poa or pos (defaults to the network's)")
+				backend := fs.String("storage", storage.BadgerBackend, "Storage backend of the blockchain, badger or bolt")
+				prune := fs.String("prune", "0", "Number of latest blocks or megabytes of blocks kept, e.g. 288 or 550MB (0 keeps all blocks)")
+				signers := fs.String("signers", "", "Comma separated hex encoded public keys of proof-of-authority signers or genesis proof-of-stake validators")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					return cli.createBlockChain(w, *address, *maturity, *consensus, *signers, *backend, *prune)
+				}
+			},
+		},
+		{
+			name:    "getbalance",
+			args:    "-address ADDRESS",
+			summary: "Gets the balance of an address",
+			help:    []string{"Coinbase rewards which can't be spent yet are shown as an immature balance."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "The address to get balance for")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					return cli.getBalance(w, *address)
+				}
+			},
+		},
+		{
+			name:    "generate",
+			args:    "-address ADDRESS [-blocks BLOCKS] [-workers N]",
+			summary: "Mines blocks sending their rewards to address",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", cli.cfg.Mining.Address, "The address to send block rewards to (env MINING_ADDRESS)")
+				blocks := fs.Int("blocks", 1, "Number of blocks to mine")
+				workers := fs.Int("workers", cli.cfg.Mining.Workers, "Number of mining workers, GOMAXPROCS if 0 (env MINING_WORKERS)")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					if *blocks <= 0 {
+						return usagef("-blocks has to be positive")
+					}
+					return cli.generate(w, *address, *blocks, *workers)
+				}
+			},
+		},
+		{
+			name:    "send",
+			args:    "-from FROM -to TO -amount AMOUNT [-locktime LOCKTIME]",
+			summary: "Sends an amount of coins, the transaction is mined into a new block rewarding the sender",
+			help:    []string{"LOCKTIME is a block height (or a unix time if above 500000000) before which the transaction can't be mined."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				from := fs.String("from", "", "Source wallet address")
+				to := fs.String("to", "", "Destination wallet address")
+				amount := fs.Int("amount", 0, "Amount to send")
+				lockTime := fs.Uint("locktime", 0, "Block height or unix time before which the transaction can't be mined")
+				return func(w io.Writer) error {
+					if *from == "" || *to == "" {
+						return usagef("-from and -to are required")
+					}
+					if *amount <= 0 {
+						return usagef("-amount has to be positive")
+					}
+					if *lockTime > math.MaxUint32 {
+						return usagef("-locktime %d is out of range", *lockTime)
+					}
+					return cli.send(w, *from, *to, *amount, uint32(*lockTime))
+				}
+			},
+		},
+		{
+			name:    "stake",
+			args:    "-address ADDRESS -amount AMOUNT",
+			summary: "Stakes coins of an address for its public key on a proof-of-stake chain",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "The address whose coins are staked")
+				amount := fs.Int("amount", 0, "Amount to stake")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					if *amount <= 0 {
+						return usagef("-amount has to be positive")
+					}
+					return cli.stake(w, *address, *amount)
+				}
+			},
+		},
+		{
+			name:    "unstake",
+			args:    "-address ADDRESS",
+			summary: "Withdraws the whole stake of an address, slashed stake can't be withdrawn",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "The address whose stake is withdrawn")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					return cli.unstake(w, *address)
+				}
+			},
+		},
+		{
+			name:    "liststakes",
+			summary: "Lists the stakes of proof-of-stake proposers",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				return cli.listStakes
+			},
+		},
+		{
+			name:    "printchain",
+			args:    "[-from HEIGHT] [-limit N] [-reverse]",
+			summary: "Prints the blocks in the chain from the last one down",
+			help:    []string{"-reverse prints them from the first stored block, or HEIGHT, up."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				from := fs.Int("from", -1, "Height of the block to start at (defaults to the last block, or the first with -reverse)")
+				limit := fs.Int("limit", 0, "Number of blocks to print (0 prints all)")
+				reverse := fs.Bool("reverse", false, "Print the blocks from older to newer")
+				return func(w io.Writer) error {
+					if *limit < 0 {
+						return usagef("-limit can't be negative")
+					}
+					return cli.printChain(w, *from, *limit, *reverse)
+				}
+			},
+		},
+		{
+			name:    "getblock",
+			args:    "-hash HASH|-height HEIGHT",
+			summary: "Prints a block with the addresses, amounts and fees of its transactions",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				hash := fs.String("hash", "", "Hex encoded hash of the block")
+				height := fs.Int("height", -1, "Height of the block")
+				return func(w io.Writer) error {
+					if (*hash == "") == (*height < 0) {
+						return usagef("either -hash or -height is required")
+					}
+					return cli.getBlock(w, *hash, *height)
+				}
+			},
+		},
+		{
+			name:    "getblockheader",
+			args:    "-hash HASH|-height HEIGHT",
+			summary: "Prints a block header, headers of pruned blocks are kept",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				hash := fs.String("hash", "", "Hex encoded hash of the block")
+				height := fs.Int("height", -1, "Height of the block")
+				return func(w io.Writer) error {
+					if (*hash == "") == (*height < 0) {
+						return usagef("either -hash or -height is required")
+					}
+					return cli.getBlockHeader(w, *hash, *height)
+				}
+			},
+		},
+		{
+			name:    "gettx",
+			aliases: []string{"gettransaction"},
+			args:    "-txid TXID",
+			summary: "Prints a transaction of the chain with the outputs it spends, its fee and confirmations",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				txid := fs.String("txid", "", "Hex encoded ID of the transaction")
+				return func(w io.Writer) error {
+					if *txid == "" {
+						return usagef("-txid is required")
+					}
+					return cli.getTx(w, *txid)
+				}
+			},
+		},
+		{
+			name:    "prunechain",
+			args:    "-prune BLOCKS|SIZEMB",
+			summary: "Deletes blocks older than the latest BLOCKS or SIZE megabytes of blocks, e.g. 288 or 550MB",
+			help:    []string{"Headers and unspent outputs of pruned blocks are kept, new blocks are pruned as they are connected, 0 stops pruning."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				prune := fs.String("prune", "", "Number of latest blocks or megabytes of blocks kept, e.g. 288 or 550MB (0 stops pruning)")
+				return func(w io.Writer) error {
+					if *prune == "" {
+						return usagef("-prune is required")
+					}
+					return cli.pruneChain(w, *prune)
+				}
+			},
+		},
+		{
+			name:    "migratedb",
+			args:    "[-dryrun] [-backup DIR]",
+			summary: "Upgrades the blockchain DB to the schema of this version, DBs are upgraded when opened as well",
+			help:    []string{"-dryrun applies the migrations to a copy in memory, -backup copies the DB to DIR before it is upgraded."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				dryRun := fs.Bool("dryrun", false, "Check the migrations without changing the DB")
+				backup := fs.String("backup", "", "Directory to copy the DB to before it is migrated")
+				return func(w io.Writer) error {
+					return cli.migrateDB(w, *dryRun, *backup)
+				}
+			},
+		},
+		{
+			name:    "exportchain",
+			args:    "-file FILE",
+			summary: "Writes all blocks from the Genesis to FILE with a checksum, e.g. to back up the chain",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				file := fs.String("file", "", "File to write the blocks to")
+				return func(w io.Writer) error {
+					if *file == "" {
+						return usagef("-file is required")
+					}
+					return cli.exportChain(w, *file)
+				}
+			},
+		},
+		{
+			name:    "importchain",
+			args:    "-file FILE [-storage badger|bolt]",
+			summary: "Creates a blockchain from an export, validating every block",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				file := fs.String("file", "", "File with the exported blocks")
+				backend := fs.String("storage", storage.BadgerBackend, "Storage backend of the blockchain, badger or bolt")
+				return func(w io.Writer) error {
+					if *file == "" {
+						return usagef("-file is required")
+					}
+					return cli.importChain(w, *file, *backend)
+				}
+			},
+		},
+		{
+			name:    "dumputxoset",
+			args:    "-file FILE [-height HEIGHT]",
+			summary: "Writes the unspent outputs at a block, the last by default, to FILE with a commitment hash",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				file := fs.String("file", "", "File to write the UTXO snapshot to")
+				height := fs.Int("height", -1, "Height of the block to dump the unspent outputs at (defaults to the last block)")
+				return func(w io.Writer) error {
+					if *file == "" {
+						return usagef("-file is required")
+					}
+					return cli.dumpUTXOSet(w, *file, *height)
+				}
+			},
+		},
+		{
+			name:    "loadutxoset",
+			args:    "-file FILE [-commitment HASH] [-storage badger|bolt]",
+			summary: "Creates a blockchain starting from a UTXO snapshot",
+			help:    []string{"rpcserver -validatesnapshot EXPORT validates the blocks preceding the snapshot from a chain export in the background."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				file := fs.String("file", "", "File with the UTXO snapshot")
+				commitment := fs.String("commitment", "", "Hex encoded commitment hash the snapshot has to match")
+				backend := fs.String("storage", storage.BadgerBackend, "Storage backend of the blockchain, badger or bolt")
+				return func(w io.Writer) error {
+					if *file == "" {
+						return usagef("-file is required")
+					}
+					return cli.loadUTXOSet(w, *file, *commitment, *backend)
+				}
+			},
+		},
+	}
+}
+
+func (cli *CommandLine) createBlockChain(w io.Writer, address string, maturity int, consensus, signers, backend, prune string) error {
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+	pruneTarget, err := blockchain.ParsePruneTarget(prune)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	network := cli.cfg.Network
+	if network == "" {
+		network = blockchain.MainNetParams.Name
+	}
+	params, err := blockchain.ParamsForNetwork(network)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	if maturity >= 0 {
+		params.CoinbaseMaturity = maturity
+	}
+	if consensus != "" {
+		params.Consensus = consensus
+	}
+	if params.Consensus == blockchain.ProofOfAuthorityConsensus || params.Consensus == blockchain.ProofOfStakeConsensus {
+		// signed blocks have no proof of work
+		params.Difficulty = 0
+		for _, signer := range strings.Split(signers, ",") {
+			pubKey, err := hex.DecodeString(strings.TrimSpace(signer))
+			if err != nil {
+				return usagef("invalid signer public key %s", signer)
+			}
+			params.Signers = append(params.Signers, pubKey)
+		}
+	}
+
+	chain, err := blockchain.CreateBlockChainWithBackend(cli.blocksDir(), backend, address, params)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	if pruneTarget.Enabled() {
+		if err := chain.SetPruneTarget(pruneTarget); err != nil {
+			return err
+		}
+	}
+	cli.emit(w, createBlockChainResult{hex.EncodeToString(chain.LastHash()), params.Name, params.Consensus, pruneTarget.String()})
+	return nil
+}
+
+func (cli *CommandLine) getBalance(w io.Writer, address string) error {
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	lockingScript, err := blockchain.LockingScriptForAddress(address)
+	if err != nil {
+		return err
+	}
+	balance, immature := chain.GetBalance(lockingScript)
+	cli.emit(w, balanceResult{address, balance, immature})
+	return nil
+}
+
+func (cli *CommandLine) generate(w io.Writer, address string, blocks, workers int) error {
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	if err := cli.useProposerWallets(chain); err != nil {
+		return err
+	}
+
+	if workers > 0 {
+		chain.Miner.Workers = workers
+	}
+	chain.Miner.OnHashRate = func(hashRate float64) {
+		fmt.Fprintf(cli.stderr(), "Hash rate: %.0f H/s\n", hashRate)
+	}
+
+	// interrupting the command stops mining of the current block
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r := minedBlocksResult{Blocks: []minedBlock{}}
+	for i := 0; i < blocks; i++ {
+		block, err := chain.MineBlock(ctx, address, nil)
+		if err != nil {
+			fmt.Fprintln(cli.stderr(), err)
+			break
+		}
+		cli.progress(w, "Block %d: %x", block.Height, block.Hash)
+		r.Blocks = append(r.Blocks, minedBlock{block.Height, hex.EncodeToString(block.Hash), len(block.Transactions)})
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+// Lets the chain sign blocks with keys of our wallet file, if its consensus engine selects proposers.
+func (cli *CommandLine) useProposerWallets(chain *blockchain.BlockChain) error {
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	chain.Wallets = wallets
+	return nil
+}
+
+func (cli *CommandLine) send(w io.Writer, from, to string, amount int, lockTime uint32) error {
+	if err := validateAddress("from", from); err != nil {
+		return err
+	}
+	if err := validateAddress("to", to); err != nil {
+		return err
+	}
+
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	if err := cli.useProposerWallets(chain); err != nil {
+		return err
+	}
+
+	rawTx, err := chain.CreateRawTransaction(from, to, amount, lockTime)
+	if err != nil {
+		return errors.Wrap(err, "failed to create transaction")
+	}
+	rawTx.Sign(chain.Wallets)
+	if !rawTx.IsSigned() {
+		return errors.Errorf("wallet of %s can't sign the transaction", from)
+	}
+	// the sender mines the transaction and receives the block reward
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, from); err != nil {
+		return err
+	}
+	cli.emit(w, txSubmittedResult{TxID: hex.EncodeToString(rawTx.Tx.ID)})
+	return nil
+}
+
+func (cli *CommandLine) stake(w io.Writer, address string, amount int) error {
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	if err := cli.useProposerWallets(chain); err != nil {
+		return err
+	}
+
+	wlt, ok := chain.Wallets.Wallets[address]
+	if !ok {
+		return errors.Errorf("address %s is not in our wallet file", address)
+	}
+	rawTx, err := chain.CreateRawStakeTransaction(address, wlt.PublicKey, amount)
+	if err != nil {
+		return err
+	}
+	if err := submitWalletTx(chain, rawTx, address); err != nil {
+		return err
+	}
+	cli.emit(w, txSubmittedResult{TxID: hex.EncodeToString(rawTx.Tx.ID)})
+	return nil
+}
+
+func (cli *CommandLine) unstake(w io.Writer, address string) error {
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	if err := cli.useProposerWallets(chain); err != nil {
+		return err
+	}
+
+	wlt, ok := chain.Wallets.Wallets[address]
+	if !ok {
+		return errors.Errorf("address %s is not in our wallet file", address)
+	}
+	rawTx, err := chain.CreateRawUnstakeTransaction(wlt.PublicKey, address)
+	if err != nil {
+		return err
+	}
+	if err := submitWalletTx(chain, rawTx, address); err != nil {
+		return err
+	}
+	cli.emit(w, txSubmittedResult{TxID: hex.EncodeToString(rawTx.Tx.ID)})
+	return nil
+}
+
+// Signs a raw transaction with the chain's wallets and mines it into a new block rewarding the miner's address.
+func submitWalletTx(chain *blockchain.BlockChain, rawTx *blockchain.RawTransaction, minerAddress string) error {
+	rawTx.Sign(chain.Wallets)
+	_, err := chain.SubmitRawTransaction(context.Background(), rawTx, minerAddress)
+	return err
+}
+
+func (cli *CommandLine) listStakes(w io.Writer) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	r := stakesResult{Stakes: []stakeResult{}}
+	for _, stake := range chain.Stakes() {
+		r.Stakes = append(r.Stakes, stakeResult{hex.EncodeToString(stake.PubKey), stake.Amount, stake.Slashed})
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+// Prints the blocks from the block at height from down, or up with reverse, at most limit blocks if it is positive.
+// A negative from starts at the last block, or at the first stored block with reverse.
+func (cli *CommandLine) printChain(w io.Writer, from, limit int, reverse bool) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	// the chain is walked from the last block down, with reverse the blocks are collected down to from
+	var blocks []*blockchain.Block
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if (reverse && block.Height >= from) || (!reverse && (from < 0 || block.Height <= from)) {
+			blocks = append(blocks, block)
+		}
+		if chain.IsFirstBlock(block) || (reverse && block.Height <= from) || (!reverse && limit > 0 && len(blocks) == limit) {
+			break
+		}
+	}
+	if reverse {
+		for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+			blocks[i], blocks[j] = blocks[j], blocks[i]
+		}
+		if limit > 0 && len(blocks) > limit {
+			blocks = blocks[:limit]
+		}
+	}
+
+	r := chainResult{Blocks: []blockResult{}}
+	for _, block := range blocks {
+		// the chain starts with another block than the Genesis if it is pruned or loaded from a UTXO snapshot
+		var prevErr error
+		if chain.IsFirstBlock(block) && len(block.PrevHash) != 0 {
+			_, prevErr = chain.GetBlock(block.PrevHash)
+		}
+
+		blockResult := blockResult{
+			Hash:     hex.EncodeToString(block.Hash),
+			PrevHash: hex.EncodeToString(block.PrevHash),
+			Height:   block.Height,
+			Time:     block.Timestamp,
+			Slashed:  []string{},
+			evidence: block.Evidence,
+		}
+		if block.Signer != nil {
+			blockResult.Signer = hex.EncodeToString(block.Signer)
+		}
+		for _, evidence := range block.Evidence {
+			blockResult.Slashed = append(blockResult.Slashed, hex.EncodeToString(evidence.Signer()))
+		}
+		if errors.Cause(prevErr) == blockchain.ErrBlockPruned {
+			blockResult.Seal, blockResult.sealText = "not verified", "not verified, the preceding blocks were pruned"
+		} else if prevErr != nil {
+			blockResult.Seal, blockResult.sealText = "not verified", "not verified, the preceding blocks were loaded from a UTXO snapshot"
+		} else if err := chain.Engine.VerifySeal(chain, block); err != nil {
+			blockResult.Seal, blockResult.sealText = err.Error(), err.Error()
+		} else {
+			blockResult.Seal, blockResult.sealText = "valid", fmt.Sprintf("valid (%s)", chain.Params.Consensus)
+		}
+		for _, tx := range block.Transactions {
+			blockResult.Transactions = append(blockResult.Transactions, newTxResult(tx))
+		}
+		r.Blocks = append(r.Blocks, blockResult)
+
+		if prevErr != nil {
+			r.Missing = prevErr.Error()
+		}
+	}
+
+	cli.emit(w, r)
+	// the blocks preceding the first block are reported as missing by the JSON output
+	if r.Missing != "" && cli.cfg.Output != JSONOutput {
+		return errors.New(r.Missing)
+	}
+	return nil
+}
+
+// Gets the hash of a block given by its hex encoded hash or, if it is empty, by its height.
+func blockHash(chain *blockchain.BlockChain, hash string, height int) ([]byte, error) {
+	if hash != "" {
+		decoded, err := hex.DecodeString(hash)
+		if err != nil {
+			return nil, usagef("-hash %s is not a valid block hash", hash)
+		}
+		return decoded, nil
+	}
+	return chain.HashAtHeight(height)
+}
+
+func (cli *CommandLine) getBlock(w io.Writer, hash string, height int) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	decoded, err := blockHash(chain, hash, height)
+	if err != nil {
+		return err
+	}
+	block, err := chain.GetBlock(decoded)
+	if err != nil {
+		return err
+	}
+	cli.emit(w, newBlockDetailsResult(chain, block))
+	return nil
+}
+
+func (cli *CommandLine) getBlockHeader(w io.Writer, hash string, height int) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	decoded, err := blockHash(chain, hash, height)
+	if err != nil {
+		return err
+	}
+	header, err := chain.GetBlockHeader(decoded)
+	if err != nil {
+		return err
+	}
+	// the block is nil if only its header is stored
+	block, _ := chain.GetBlock(decoded)
+	cli.emit(w, newBlockHeaderResult(chain, decoded, header, block))
+	return nil
+}
+
+func (cli *CommandLine) getTx(w io.Writer, txid string) error {
+	txID, err := hex.DecodeString(txid)
+	if err != nil {
+		return usagef("-txid %s is not a valid transaction ID", txid)
+	}
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	tx, block, err := chain.FindTransactionBlock(txID)
+	if err != nil {
+		return err
+	}
+	r := newTxDetailsResult(&tx, chain.PrevOutputs(&tx))
+	r.Block = &txBlock{hex.EncodeToString(block.Hash), block.Height, block.Timestamp, chain.Confirmations(block.Height)}
+	cli.emit(w, r)
+	return nil
+}
+
+func (cli *CommandLine) pruneChain(w io.Writer, prune string) error {
+	target, err := blockchain.ParsePruneTarget(prune)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	if err := chain.SetPruneTarget(target); err != nil {
+		return err
+	}
+	snapshot, err := chain.Snapshot()
+	if err != nil {
+		return err
+	}
+	r := pruneResult{PruneTarget: target.String()}
+	if snapshot != nil {
+		r.FirstHeight, r.FirstHash, r.Pruned = snapshot.Height, hex.EncodeToString(snapshot.BaseHash), snapshot.Pruned
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+func (cli *CommandLine) migrateDB(w io.Writer, dryRun bool, backupDir string) error {
+	version, migrated, err := blockchain.MigrateBlockChainIn(cli.blocksDir(), dryRun, backupDir)
+	if err != nil {
+		return err
+	}
+	r := migrateResult{Version: version, Current: blockchain.SchemaVersion, DryRun: dryRun, Migrations: []migrationResult{}}
+	if len(migrated) > 0 {
+		r.Backup = backupDir
+	}
+	for _, migration := range migrated {
+		r.Migrations = append(r.Migrations, migrationResult{migration.Version, migration.Description})
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+func (cli *CommandLine) exportChain(w io.Writer, file string) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	blocks, err := chain.Export(f)
+	if err != nil {
+		return err
+	}
+	cli.emit(w, exportResult{file, blocks})
+	return nil
+}
+
+func (cli *CommandLine) importChain(w io.Writer, file, backend string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chain, err := blockchain.ImportBlockChainWithBackend(cli.blocksDir(), backend, f)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	cli.emit(w, importResult{chain.LastBlock().Height, hex.EncodeToString(chain.LastHash())})
+	return nil
+}
+
+func (cli *CommandLine) dumpUTXOSet(w io.Writer, file string, height int) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	snapshot, err := chain.DumpUTXOSet(f, height)
+	if err != nil {
+		return err
+	}
+	cli.emit(w, newSnapshotResult(snapshot, "Dumped"))
+	return nil
+}
+
+func (cli *CommandLine) loadUTXOSet(w io.Writer, file, commitmentHex, backend string) error {
+	var commitment []byte
+	if commitmentHex != "" {
+		var err error
+		if commitment, err = hex.DecodeString(commitmentHex); err != nil {
+			return usagef("-commitment %s is not a valid hash", commitmentHex)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chain, err := blockchain.LoadUTXOSetWithBackend(cli.blocksDir(), backend, f, commitment)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	snapshot, err := chain.Snapshot()
+	if err != nil {
+		return err
+	}
+	cli.emit(w, newSnapshotResult(snapshot, "Loaded"))
+	return nil
+}
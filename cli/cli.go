@@ -3,209 +3,406 @@ package cli
 import (
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
-	"strconv"
+	"strings"
 
-	"github.com/michaljirman/goblockchain/wallet"
+	"github.com/pkg/errors"
 
 	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/config"
+	"github.com/michaljirman/goblockchain/logger"
+	"github.com/michaljirman/goblockchain/rpc"
+	"github.com/michaljirman/goblockchain/wallet"
 )
 
-type CommandLine struct{}
+// Exit codes of the command line tool.
+const (
+	ExitOK      = 0 // the command succeeded
+	ExitFailure = 1 // the command failed
+	ExitUsage   = 2 // the command line arguments are invalid
+)
 
-// Prints a help usage message.
-func (cli *CommandLine) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
-	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
-	fmt.Println(" createwallet - Creates a new Wallet")
-	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
+type CommandLine struct {
+	cfg        config.Config // configuration overridden by the global flags
+	configFile string        // config file the configuration was loaded from, none if empty
+	in         io.Reader     // reader of interactive commands, stdin if nil
+	out        io.Writer     // writer of command results, stdout if nil
+	errOut     io.Writer     // writer of usage messages, progress and errors, stderr if nil
 }
 
-// Validates command line arguments.
-func (cli *CommandLine) validateArgs() {
-	if len(os.Args) < 2 {
-		cli.printUsage()
-		runtime.Goexit()
-	}
+// A command of the command line tool. Its flags are parsed by a flag set of its own, which accepts
+// the global flags too, so they can be given before or after the command name.
+type command struct {
+	name    string
+	aliases []string
+	args    string   // synopsis of the flags of the command
+	summary string   // one line description of the command
+	help    []string // further lines of the help of the command
+	operand bool     // the command reads an argument following its flags
+	// defines the flags of the command and returns its handler, which reads them once they are parsed
+	flags func(cli *CommandLine, fs *flag.FlagSet) handler
 }
 
-func (cli *CommandLine) listAddresses() {
-	wallets, _ := wallet.CreateWallets()
-	addresses := wallets.GetAllAddresses()
+// A handler runs a command writing its result to w. Invalid flags are reported by a usageError.
+type handler func(w io.Writer) error
 
-	for _, address := range addresses {
-		fmt.Println(address)
-	}
+// A usageError reports invalid command line arguments, the command line tool exits with ExitUsage.
+type usageError struct {
+	message string
 }
 
-func (cli *CommandLine) createWallet() {
-	wallets, _ := wallet.CreateWallets()
-	address := wallets.AddWallet()
-	wallets.SaveFile()
-
-	fmt.Printf("New address is: %s\n", address)
+func (err usageError) Error() string {
+	return err.message
 }
 
-func (cli *CommandLine) printChain() {
-	chain := blockchain.ContinueBlockChain("")
-	defer chain.Database.Close()
-	iter := chain.Iterator()
-
-	for {
-		block := iter.Next()
+// Creates a usageError.
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
 
-		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
-		fmt.Printf("Hash: %x\n", block.Hash)
-		pow := blockchain.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
-		}
-		fmt.Println()
+// A group of commands listed together by the usage message.
+type commandGroup struct {
+	title    string
+	commands []*command
+}
 
-		if len(block.PrevHash) == 0 {
-			break
-		}
+// Gets the commands of the command line tool.
+func commandGroups() []commandGroup {
+	return []commandGroup{
+		{"Blockchain commands", chainCommands()},
+		{"Wallet and transaction commands", walletCommands()},
+		{"Network commands", networkCommands()},
+		{"Configuration commands", configCommands()},
 	}
 }
 
-func (cli *CommandLine) createBlockChain(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+// Gets the help command.
+func helpCommand() *command {
+	return &command{
+		name:    "help",
+		args:    "[COMMAND]",
+		summary: "Prints the usage of the command line tool or the help of a command",
+		operand: true,
+		flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+			return func(w io.Writer) error {
+				if fs.NArg() == 0 {
+					cli.printUsage(w)
+					return nil
+				}
+				cmd := findCommand(fs.Arg(0))
+				if cmd == nil {
+					return usagef("unknown command %s", fs.Arg(0))
+				}
+				cli.printHelp(w, cmd)
+				return nil
+			}
+		},
 	}
-	chain := blockchain.InitBlockChain(address)
-	chain.Database.Close()
-	fmt.Println("Finished!")
 }
 
-func (cli *CommandLine) getBalance(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+// Finds a command by its name or one of its aliases, nil if there is none.
+func findCommand(name string) *command {
+	for _, group := range append(commandGroups(), commandGroup{commands: []*command{helpCommand()}}) {
+		for _, cmd := range group.commands {
+			if cmd.name == name {
+				return cmd
+			}
+			for _, alias := range cmd.aliases {
+				if alias == name {
+					return cmd
+				}
+			}
+		}
 	}
+	return nil
+}
 
-	chain := blockchain.ContinueBlockChain(address)
-	defer chain.Database.Close()
+// Gets the name of the program in usage messages.
+func program() string {
+	return filepath.Base(os.Args[0])
+}
 
-	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := chain.FindUTXO(pubKeyHash)
+// Defines the global flags on a flag set, their defaults are the values of the configuration.
+func (cli *CommandLine) globalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cli.configFile, "config", cli.configFile, "Config file, "+config.DefaultFile+" if it exists (env CONFIG_FILE)")
+	fs.StringVar(&cli.cfg.DataDir, "datadir", cli.cfg.DataDir, "Directory of the blockchain and the wallet file (env DATA_DIR)")
+	fs.StringVar(&cli.cfg.Network, "network", cli.cfg.Network, "Network of the blockchain, main or regtest, opened blockchains have to belong to it if given (env NETWORK)")
+	fs.StringVar(&cli.cfg.Output, "output", cli.cfg.Output, "Output format of commands, text or json (env OUTPUT_FORMAT)")
+}
 
-	for _, out := range UTXOs {
-		balance += out.Value
-	}
+// Checks if a flag is one of the global flags.
+func isGlobalFlag(name string) bool {
+	return name == "config" || name == "datadir" || name == "network" || name == "output"
+}
 
-	fmt.Printf("Balance of %s: %d\n", address, balance)
+// Finds the value of a global flag before the flags are parsed, the flag can follow the command name.
+func findFlag(args []string, name string) string {
+	value := ""
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name && i+1 < len(args) {
+			value = args[i+1]
+		} else if strings.HasPrefix(arg, name+"=") {
+			value = strings.TrimPrefix(arg, name+"=")
+		}
+	}
+	return value
 }
 
-func (cli *CommandLine) send(from, to string, amount int) {
-	if !wallet.ValidateAddress(from) {
-		log.Panic("`from address` is not Valid")
+// Finds the config file given by the -config flag or by the CONFIG_FILE environment variable.
+// Otherwise the default file is used if it exists in the working directory.
+func findConfigFile(args []string) string {
+	if file := findFlag(args, "config"); file != "" {
+		return file
+	}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		return file
+	}
+	if _, err := os.Stat(config.DefaultFile); err == nil {
+		return config.DefaultFile
 	}
+	return ""
+}
 
-	if !wallet.ValidateAddress(to) {
-		log.Panic("`to address` is not Valid")
+// Prints the usage message of the command line tool.
+func (cli *CommandLine) printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [GLOBAL FLAGS] COMMAND [FLAGS]\n", program())
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags, accepted after the command name as well:")
+	global := flag.NewFlagSet(program(), flag.ContinueOnError)
+	cli.globalFlags(global)
+	global.SetOutput(w)
+	global.PrintDefaults()
+	fmt.Fprintln(w, "With -output json every command writes a single JSON document to stdout, {\"error\": MESSAGE} if it fails,")
+	fmt.Fprintln(w, "logs, usage and progress go to stderr; subscribe writes a JSON document per event.")
+	fmt.Fprintln(w, "Flags default to the environment variables and the config file, see config show, e.g. the -rpc flags")
+	fmt.Fprintln(w, "of commands talking to an RPC server default to RPC_URL and to url of the [rpc] table.")
+	for _, group := range commandGroups() {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%s:\n", group.title)
+		for _, cmd := range group.commands {
+			fmt.Fprintf(w, "  %-18s %s\n", cmd.name, cmd.summary)
+		}
 	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Run '%s help COMMAND' or '%s COMMAND -h' for the flags of a command.\n", program(), program())
+	fmt.Fprintf(w, "Exit codes: %d success, %d the command failed, %d invalid arguments.\n", ExitOK, ExitFailure, ExitUsage)
+}
 
-	chain := blockchain.ContinueBlockChain(from)
-	defer chain.Database.Close()
+// Prints the help of a command with its flags.
+func (cli *CommandLine) printHelp(w io.Writer, cmd *command) {
+	fmt.Fprintf(w, "Usage: %s [GLOBAL FLAGS] %s %s\n", program(), cmd.name, cmd.args)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%s.\n", cmd.summary)
+	for _, line := range cmd.help {
+		fmt.Fprintln(w, line)
+	}
+	if len(cmd.aliases) > 0 {
+		fmt.Fprintf(w, "Aliases: %s\n", strings.Join(cmd.aliases, ", "))
+	}
 
-	tx := blockchain.NewTransaction(from, to, amount, chain)
-	chain.AddBlock([]*blockchain.Transaction{tx})
-	fmt.Println("Success!")
+	// the flag set of a command defines the global flags too, they are listed separately
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cli.globalFlags(fs)
+	cmd.flags(cli, fs)
+	own := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	global := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.VisitAll(func(f *flag.Flag) {
+		if isGlobalFlag(f.Name) {
+			global.Var(f.Value, f.Name, f.Usage)
+		} else {
+			own.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	own.SetOutput(w)
+	global.SetOutput(w)
+	if hasFlags(own) {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Flags:")
+		own.PrintDefaults()
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global flags:")
+	global.PrintDefaults()
 }
 
-// Runs command line tool.
-func (cli *CommandLine) Run() {
-	cli.validateArgs()
+// Checks if a flag set defines any flag.
+func hasFlags(fs *flag.FlagSet) bool {
+	defined := false
+	fs.VisitAll(func(*flag.Flag) { defined = true })
+	return defined
+}
 
-	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
-	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
-	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
-	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
-	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
-	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+// Runs the command line tool with the arguments following the program name and returns its exit code.
+// The global flags and the flags of commands default to the configuration, see config.Load.
+func (cli *CommandLine) Run(args []string) int {
+	cli.configFile = findConfigFile(args)
+	cfg, err := config.Load(cli.configFile)
+	if err != nil {
+		// the error is reported in the output format of the flags or the environment if they give one
+		cli.cfg = config.Default()
+		if output := findFlag(args, "output"); output != "" {
+			cli.cfg.Output = output
+		} else if output := os.Getenv("OUTPUT_FORMAT"); output != "" {
+			cli.cfg.Output = output
+		}
+		return cli.exit(nil, usageError{err.Error()})
+	}
+	cli.cfg = cfg
+
+	global := flag.NewFlagSet(program(), flag.ContinueOnError)
+	global.SetOutput(ioutil.Discard)
+	cli.globalFlags(global)
+	if err := global.Parse(args); err == flag.ErrHelp {
+		cli.printUsage(cli.stdout())
+		return ExitOK
+	} else if err != nil {
+		return cli.exit(nil, usageError{err.Error()})
+	}
+	args = global.Args()
+	if len(args) == 0 {
+		return cli.exit(nil, usagef("no command given"))
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return cli.exit(nil, usagef("unknown command %s", args[0]))
+	}
 
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cli.globalFlags(fs)
+	run := cmd.flags(cli, fs)
+	if err := fs.Parse(args[1:]); err == flag.ErrHelp {
+		cli.printHelp(cli.stdout(), cmd)
+		return ExitOK
+	} else if err != nil {
+		return cli.exit(cmd, usageError{err.Error()})
+	}
+	if err := cli.validateGlobalFlags(); err != nil {
+		return cli.exit(cmd, err)
+	}
+	if fs.NArg() > 0 && !cmd.operand {
+		return cli.exit(cmd, usagef("unexpected argument %s", fs.Arg(0)))
+	}
+	return cli.exit(cmd, cli.call(run))
+}
 
-	switch os.Args[1] {
-	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
+// Checks the configuration overridden by the global flags and sets up the logger with it.
+func (cli *CommandLine) validateGlobalFlags() error {
+	if err := cli.cfg.Validate(); err != nil {
+		if cli.cfg.Output != JSONOutput {
+			cli.cfg.Output = TextOutput
 		}
-	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
-		}
-	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
+		return usageError{err.Error()}
+	}
+	if cli.cfg.Network != "" {
+		if _, err := blockchain.ParamsForNetwork(cli.cfg.Network); err != nil {
+			return usagef("invalid network (env NETWORK): %s", err)
 		}
-	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
+	}
+	return logger.SetupLogger(&cli.cfg.Log)
+}
+
+// Runs a handler writing to stdout. Packages logging errors panic after logging them, such panics
+// are returned as errors while runtime errors are not recovered.
+func (cli *CommandLine) call(run handler) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
 		}
-	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
+		if _, ok := r.(runtime.Error); ok {
+			panic(r)
 		}
-	case "send":
-		err := sendCmd.Parse(os.Args[2:])
-		if err != nil {
-			log.Panic(err)
+		var ok bool
+		if err, ok = r.(error); !ok {
+			err = errors.New(fmt.Sprint(r))
 		}
-	default:
-		cli.printUsage()
-		runtime.Goexit()
-	}
+	}()
+	return run(cli.stdout())
+}
 
-	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			runtime.Goexit()
-		}
-		cli.getBalance(*getBalanceAddress)
+// Reports the error of a command and gets the exit code of the command line tool. Errors are written
+// to stderr, or as an errorResult with JSON output, usage errors are followed by the usage of the command.
+func (cli *CommandLine) exit(cmd *command, err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	code := ExitFailure
+	if _, ok := errors.Cause(err).(usageError); ok {
+		code = ExitUsage
 	}
 
-	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
-			runtime.Goexit()
+	if cli.cfg.Output == JSONOutput {
+		cli.emit(cli.stdout(), errorResult{err.Error()})
+	} else {
+		fmt.Fprintf(cli.stderr(), "Error: %s\n", err)
+	}
+	if code == ExitUsage {
+		fmt.Fprintln(cli.stderr())
+		if cmd != nil {
+			cli.printHelp(cli.stderr(), cmd)
+		} else {
+			cli.printUsage(cli.stderr())
 		}
-		cli.createBlockChain(*createBlockchainAddress)
 	}
+	return code
+}
 
-	if printChainCmd.Parsed() {
-		cli.printChain()
-	}
+// Creates a client of an RPC server authenticated with the user and password of the configuration.
+func (cli *CommandLine) rpcClient(url string) *rpc.Client {
+	client := rpc.NewClient(url)
+	client.User, client.Password = cli.cfg.RPC.User, cli.cfg.RPC.Password
+	return client
+}
+
+// Gets the directory of the blockchain DB.
+func (cli *CommandLine) blocksDir() string {
+	return filepath.Join(cli.cfg.DataDir, "blocks")
+}
 
-	if createWalletCmd.Parsed() {
-		cli.createWallet()
+// Opens the blockchain in the data directory, it has to belong to the -network if it is given.
+func (cli *CommandLine) openChain() (*blockchain.BlockChain, error) {
+	chain, err := blockchain.OpenBlockChain(cli.blocksDir())
+	if err != nil {
+		return nil, err
+	}
+	if cli.cfg.Network != "" && chain.Params.Name != cli.cfg.Network {
+		chain.Database.Close()
+		return nil, errors.Errorf("blockchain in %s belongs to the %s network, not %s", cli.blocksDir(), chain.Params.Name, cli.cfg.Network)
 	}
-	if listAddressesCmd.Parsed() {
-		cli.listAddresses()
+	return chain, nil
+}
+
+// Loads the wallet file of the data directory, there are no wallets if it doesn't exist yet.
+func (cli *CommandLine) loadWallets() (*wallet.Wallets, error) {
+	wallets, err := wallet.LoadWallets(filepath.Join(cli.cfg.DataDir, "wallets.data"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
+	return wallets, nil
+}
 
-	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
-			sendCmd.Usage()
-			runtime.Goexit()
-		}
+// Saves wallets to the wallet file of the data directory, which is created if it doesn't exist.
+func (cli *CommandLine) saveWallets(wallets *wallet.Wallets) error {
+	if err := os.MkdirAll(cli.cfg.DataDir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create data directory")
+	}
+	wallets.SaveFile()
+	return nil
+}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+// Checks if a flag value is a valid address.
+func validateAddress(flagName, address string) error {
+	if !wallet.ValidateAddress(address) {
+		return usagef("-%s %s is not a valid address", flagName, address)
 	}
+	return nil
 }
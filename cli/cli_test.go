@@ -0,0 +1,387 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/rpc"
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Runs the command line tool with JSON output, returning its exit code, stdout and stderr.
+func run(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	cli := CommandLine{out: &out, errOut: &errOut}
+	code := cli.Run(append([]string{"-output", JSONOutput}, args...))
+	return code, out.String(), errOut.String()
+}
+
+// Runs a command expected to succeed and decodes its JSON document into v.
+func runJSON(t *testing.T, v interface{}, args ...string) {
+	t.Helper()
+	code, out, errOut := run(t, args...)
+	if code != ExitOK {
+		t.Fatalf("%v: expected exit code %d, got %d: %s %s", args, ExitOK, code, out, errOut)
+	}
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		t.Fatalf("%v: invalid JSON output %q: %v", args, out, err)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, ExitUsage},
+		{[]string{"nosuchcommand"}, ExitUsage},
+		{[]string{"-nosuchflag", "listaddresses"}, ExitUsage},
+		{[]string{"getbalance"}, ExitUsage},
+		{[]string{"getbalance", "-address", "invalid"}, ExitUsage},
+		{[]string{"-network", "nosuchnetwork", "liststakes"}, ExitUsage},
+		{[]string{"listaddresses", "extra"}, ExitUsage},
+		{[]string{"help", "nosuchcommand"}, ExitUsage},
+		{[]string{"-datadir", dir, "getbalance", "-address", testAddress}, ExitFailure},
+		{[]string{"-datadir", dir, "listaddresses"}, ExitOK},
+	}
+
+	for _, test := range tests {
+		code, out, _ := run(t, test.args...)
+		if code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d", test.args, test.code, code)
+		}
+		var r errorResult
+		if code != ExitOK && (json.Unmarshal([]byte(out), &r) != nil || r.Error == "") {
+			t.Errorf("%v: expected an error document, got %q", test.args, out)
+		}
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	for _, args := range [][]string{{"help", "getbalance"}, {"getbalance", "-h"}} {
+		code, out, _ := run(t, args...)
+		if code != ExitOK {
+			t.Fatalf("%v: expected exit code %d, got %d", args, ExitOK, code)
+		}
+		for _, expected := range []string{"getbalance -address ADDRESS", "Flags:", "-address", "Global flags:", "-datadir"} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("%v: expected help containing %q, got\n%s", args, expected, out)
+			}
+		}
+	}
+
+	code, out, _ := run(t, "help")
+	if code != ExitOK || !strings.Contains(out, "createblockchain") || !strings.Contains(out, "stratumworker") {
+		t.Fatalf("expected the usage to list all commands, got %d\n%s", code, out)
+	}
+	// usage messages are not part of the JSON output
+	_, out, errOut := run(t, "getbalance")
+	if strings.Contains(out, "Flags:") || !strings.Contains(errOut, "Flags:") {
+		t.Fatalf("expected the usage of a failed command on stderr, got %q", out)
+	}
+}
+
+func TestRunRegTestChain(t *testing.T) {
+	// the global flags default to environment variables and can follow the command name
+	t.Setenv("DATA_DIR", t.TempDir())
+
+	var wallet newAddressResult
+	runJSON(t, &wallet, "createwallet")
+	var created createBlockChainResult
+	runJSON(t, &created, "createblockchain", "-network", "regtest", "-address", wallet.Address)
+	if created.Network != "regtest" {
+		t.Fatalf("expected a regtest chain, got %+v", created)
+	}
+	code, out, _ := run(t, "createblockchain", "-address", wallet.Address)
+	if code != ExitFailure || !strings.Contains(out, "already exists") {
+		t.Fatalf("expected creating a second chain to fail, got %d %s", code, out)
+	}
+
+	var mined minedBlocksResult
+	runJSON(t, &mined, "generate", "-address", wallet.Address, "-blocks", "2")
+	if len(mined.Blocks) != 2 || mined.Blocks[1].Height != 2 {
+		t.Fatalf("expected blocks 1 and 2 to be mined, got %+v", mined)
+	}
+	var balance balanceResult
+	runJSON(t, &balance, "getbalance", "-address", wallet.Address)
+	if balance.Balance+balance.Immature != 300 {
+		t.Fatalf("expected the rewards of 3 blocks, got %+v", balance)
+	}
+
+	code, out, _ = run(t, "-network", "main", "getbalance", "-address", wallet.Address)
+	if code != ExitFailure || !strings.Contains(out, "regtest network") {
+		t.Fatalf("expected opening a chain of another network to fail, got %d %s", code, out)
+	}
+}
+
+// Payments made with send are mined into blocks which import validates like any other block.
+func TestRunExportImportPayments(t *testing.T) {
+	dir := t.TempDir()
+	var sender, receiver newAddressResult
+	runJSON(t, &sender, "-datadir", dir, "createwallet")
+	runJSON(t, &receiver, "-datadir", dir, "createwallet")
+	runJSON(t, &createBlockChainResult{}, "-datadir", dir, "createblockchain", "-network", "regtest", "-maturity", "0",
+		"-address", sender.Address)
+	runJSON(t, &txSubmittedResult{}, "-datadir", dir, "send", "-from", sender.Address, "-to", receiver.Address, "-amount", "30")
+	runJSON(t, &txSubmittedResult{}, "-datadir", dir, "send", "-from", receiver.Address, "-to", sender.Address, "-amount", "10")
+
+	file := filepath.Join(t.TempDir(), "chain.export")
+	runJSON(t, &exportResult{}, "-datadir", dir, "exportchain", "-file", file)
+	importDir := t.TempDir()
+	var imported importResult
+	runJSON(t, &imported, "-datadir", importDir, "importchain", "-file", file)
+	if imported.Height != 2 {
+		t.Fatalf("expected both payment blocks to be imported, got %+v", imported)
+	}
+	// the sender mines each payment and receives its block reward
+	for address, expected := range map[string]int{sender.Address: 180, receiver.Address: 120} {
+		var balance balanceResult
+		runJSON(t, &balance, "-datadir", importDir, "getbalance", "-address", address)
+		if balance.Balance != expected {
+			t.Fatalf("expected the imported balance of %s to be %d, got %+v", address, expected, balance)
+		}
+	}
+}
+
+func TestRunConsole(t *testing.T) {
+	chain, err := blockchain.NewBlockChain(storage.NewMemoryStore(), testAddress, blockchain.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Database.Close()
+	server := httptest.NewServer(rpc.NewServer(chain))
+	defer server.Close()
+
+	dir := t.TempDir()
+	input := "getblockcount\n\ngetbalance " + testAddress + "\nnosuchmethod\nexit\ngetblockcount\n"
+	var out, errOut bytes.Buffer
+	cli := CommandLine{in: strings.NewReader(input), out: &out, errOut: &errOut}
+	if code := cli.Run([]string{"-datadir", dir, "console", "-rpc", server.URL}); code != ExitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", ExitOK, code, errOut.String())
+	}
+
+	expected := []string{
+		"Connected to " + server.URL + ", the last block is 0.",
+		"0",
+		`"address": "` + testAddress + `"`,
+		`"immature": 100`,
+		"error: method not found: nosuchmethod",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected the console output to contain %q, got\n%s", line, out.String())
+		}
+	}
+	// the console ends with exit, so the last line is not called
+	history, err := ioutil.ReadFile(filepath.Join(dir, "console_history"))
+	if err != nil || string(history) != "getblockcount\ngetbalance "+testAddress+"\nnosuchmethod\nexit\n" {
+		t.Fatalf("expected the lines to be saved to the history, got %q %v", history, err)
+	}
+
+	if code, out, _ := run(t, "console", "-rpc", "http://127.0.0.1:1"); code != ExitFailure || !strings.Contains(out, "failed to connect") {
+		t.Fatalf("expected connecting to a stopped server to fail, got %d %s", code, out)
+	}
+}
+
+func TestRunDashboard(t *testing.T) {
+	chain, err := blockchain.NewBlockChain(storage.NewMemoryStore(), testAddress, blockchain.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Database.Close()
+	server := httptest.NewServer(rpc.NewServer(chain).Handler())
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := rpc.NewClient(server.URL).SubscribeEvents(ctx, []string{"blockconnected"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the subscriber is registered after the WebSocket handshake
+	for i := 0; i < 100; i++ {
+		if peers, _ := rpc.NewClient(server.URL).GetPeerInfo(); len(peers) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dir := t.TempDir()
+	var wallet newAddressResult
+	runJSON(t, &wallet, "-datadir", dir, "createwallet")
+	// the output isn't a terminal, so a snapshot is written
+	var r dashboardResult
+	runJSON(t, &r, "-datadir", dir, "dashboard", "-rpc", server.URL)
+	if r.Mining.Network != "regtest" || r.Mining.Blocks != 0 || len(r.Blocks) != 1 || len(r.Blocks[0].Transactions) != 1 {
+		t.Fatalf("expected the genesis block to be the tip, got %+v %+v", r.Mining, r.Blocks)
+	}
+	if len(r.Peers) != 1 || len(r.Peers[0].Types) != 1 || r.Peers[0].Types[0] != "blockconnected" {
+		t.Fatalf("expected the subscriber to be a peer, got %+v", r.Peers)
+	}
+	if len(r.Balances) != 1 || r.Balances[0].Address != wallet.Address || len(r.Mempool) != 0 {
+		t.Fatalf("expected the balance of the wallet and an empty mempool, got %+v %+v", r.Balances, r.Mempool)
+	}
+
+	var out bytes.Buffer
+	cli := CommandLine{out: &out}
+	if code := cli.Run([]string{"-datadir", dir, "dashboard", "-rpc", server.URL}); code != ExitOK {
+		t.Fatalf("expected exit code %d, got %d", ExitOK, code)
+	}
+	for _, expected := range []string{"regtest network", "Tip: block 0 " + r.Blocks[0].Hash, "Peers: 1 subscribed", wallet.Address} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the dashboard to contain %q, got\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestRunConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "node.toml")
+	data := "datadir = \"" + filepath.Join(dir, "file") + "\"\nnetwork = \"regtest\"\n\n[mining]\nworkers = 2\nsharedifficulty = 8\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("MINING_WORKERS", "4")
+
+	// flags override the environment, which overrides the file
+	var r configResult
+	runJSON(t, &r, "-datadir", filepath.Join(dir, "flag"), "config", "show")
+	expected := map[string]interface{}{
+		"datadir":                filepath.Join(dir, "flag"),
+		"network":                "regtest",
+		"mining.workers":         float64(4),
+		"mining.sharedifficulty": float64(8),
+		"rpc.addr":               "localhost:8332",
+	}
+	if r.File != file {
+		t.Fatalf("expected the config file %s, got %s", file, r.File)
+	}
+	for key, value := range expected {
+		if r.Config[key] != value {
+			t.Errorf("expected %s = %v, got %v", key, value, r.Config[key])
+		}
+	}
+
+	var out bytes.Buffer
+	cli := CommandLine{out: &out}
+	if code := cli.Run([]string{"config", "show"}); code != ExitOK || !strings.Contains(out.String(), "[mining]\naddress = \"\"\nworkers = 4\n") {
+		t.Fatalf("expected the configuration in the config file format, got %d\n%s", code, out.String())
+	}
+
+	// errors name the offending key
+	if err := ioutil.WriteFile(file, []byte("[rpc]\nadr = \"localhost:8332\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code, out, _ := run(t, "config", "show"); code != ExitUsage || !strings.Contains(out, "line 2: unknown key rpc.adr") {
+		t.Fatalf("expected an unknown key to be reported, got %d %s", code, out)
+	}
+	if code, out, _ := run(t, "-config", filepath.Join(dir, "missing.toml"), "config", "show"); code != ExitUsage || !strings.Contains(out, "failed to open config file") {
+		t.Fatalf("expected a missing config file to be reported, got %d %s", code, out)
+	}
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("STRATUM_ADDR", "3333")
+	if code, out, _ := run(t, "config", "show"); code != ExitUsage || !strings.Contains(out, "invalid mining.stratumaddr (env STRATUM_ADDR)") {
+		t.Fatalf("expected an invalid environment variable to be reported, got %d %s", code, out)
+	}
+}
+
+func TestRunRPCAuth(t *testing.T) {
+	chain, err := blockchain.NewBlockChain(storage.NewMemoryStore(), testAddress, blockchain.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Database.Close()
+	rpcServer := rpc.NewServer(chain)
+	rpcServer.RequireAuth("operator", "secret")
+	server := httptest.NewServer(rpcServer.Handler())
+	defer server.Close()
+	t.Setenv("DATA_DIR", t.TempDir())
+
+	if code, out, _ := run(t, "dashboard", "-rpc", server.URL); code != ExitFailure || !strings.Contains(out, "requires an RPC user and password") {
+		t.Fatalf("expected an unauthenticated call to fail, got %d %s", code, out)
+	}
+	client := rpc.NewClient(server.URL)
+	if _, err := client.SubscribeEvents(context.Background(), nil, nil); err == nil {
+		t.Fatal("expected an unauthenticated subscription to fail")
+	}
+
+	t.Setenv("RPC_USER", "operator")
+	t.Setenv("RPC_PASSWORD", "secret")
+	var r dashboardResult
+	runJSON(t, &r, "dashboard", "-rpc", server.URL)
+	client.User, client.Password = "operator", "secret"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := client.SubscribeEvents(ctx, nil, nil); err != nil {
+		t.Fatalf("expected an authenticated subscription, got %v", err)
+	}
+}
+
+func TestRunMultiSig(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+
+	// redeem scripts of more keys than fit into a pushed element are refused
+	var pubKeys []string
+	for i := 0; i <= wallet.MaxMultiSigKeys; i++ {
+		pubKeys = append(pubKeys, hex.EncodeToString(wallet.MakeWallet().PublicKey))
+	}
+	code, out, _ := run(t, "createmultisig", "-m", "1", "-pubkeys", strings.Join(pubKeys, ","))
+	if code != ExitUsage || !strings.Contains(out, "at most 7 public keys") {
+		t.Fatalf("expected %d public keys to be refused, got %d %s", len(pubKeys), code, out)
+	}
+	var largest multiSigResult
+	runJSON(t, &largest, "createmultisig", "-m", "1", "-pubkeys", strings.Join(pubKeys[1:], ","))
+	if largest.N != wallet.MaxMultiSigKeys {
+		t.Fatalf("expected a multisig address of %d public keys, got %+v", wallet.MaxMultiSigKeys, largest)
+	}
+
+	// a 2 of 3 multisig address receives coins and spends them
+	var signers []string
+	for i := 0; i < 3; i++ {
+		var address newAddressResult
+		runJSON(t, &address, "createwallet")
+		var pubKey pubKeyResult
+		runJSON(t, &pubKey, "getpubkey", "-address", address.Address)
+		signers = append(signers, pubKey.PubKey)
+		if i == 0 {
+			runJSON(t, &createBlockChainResult{}, "createblockchain", "-network", "regtest", "-maturity", "0",
+				"-address", address.Address)
+		}
+	}
+	var ms multiSigResult
+	runJSON(t, &ms, "createmultisig", "-m", "2", "-pubkeys", strings.Join(signers, ","))
+	runJSON(t, &minedBlocksResult{}, "generate", "-address", ms.Address, "-blocks", "1")
+
+	recipient := string(wallet.MakeWallet().Address())
+	file := filepath.Join(t.TempDir(), "spend.json")
+	runJSON(t, &rawTxResult{}, "createrawtx", "-from", ms.Address, "-to", recipient, "-amount", "60", "-file", file)
+	var signed signResult
+	runJSON(t, &signed, "signrawtx", "-file", file)
+	if !signed.Complete {
+		t.Fatalf("expected the multisig input to be complete, got %+v", signed)
+	}
+	miner := string(wallet.MakeWallet().Address())
+	runJSON(t, &txSubmittedResult{}, "submitrawtx", "-file", file, "-address", miner)
+
+	var balance balanceResult
+	runJSON(t, &balance, "getbalance", "-address", recipient)
+	if balance.Balance != 60 {
+		t.Fatalf("expected the recipient to receive 60, got %+v", balance)
+	}
+	runJSON(t, &balance, "getbalance", "-address", ms.Address)
+	if balance.Balance != 40 {
+		t.Fatalf("expected 40 change of the multisig address, got %+v", balance)
+	}
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/michaljirman/goblockchain/config"
+)
+
+// Shown instead of the RPC password.
+const maskedPassword = "********"
+
+// Gets the commands of the configuration.
+func configCommands() []*command {
+	return []*command{
+		{
+			name:    "config",
+			args:    "show",
+			summary: "Prints the effective configuration in the config file format",
+			help: []string{
+				"Values are layered: flags override environment variables, which override the config file,",
+				"which overrides the defaults. The RPC password is masked.",
+			},
+			operand: true,
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				return func(w io.Writer) error {
+					if fs.NArg() != 1 || fs.Arg(0) != "show" {
+						return usagef("expected config show")
+					}
+					return cli.showConfig(w)
+				}
+			},
+		},
+	}
+}
+
+func (cli *CommandLine) showConfig(w io.Writer) error {
+	cfg := cli.cfg
+	if cfg.RPC.Password != "" {
+		cfg.RPC.Password = maskedPassword
+	}
+	cli.emit(w, configResult{File: cli.configFile, Config: cfg.Values(), cfg: cfg})
+	return nil
+}
+
+type configResult struct {
+	File   string                 `json:"file"` // config file the configuration was loaded from, none if empty
+	Config map[string]interface{} `json:"config"`
+	cfg    config.Config
+}
+
+func (r configResult) writeText(w io.Writer) {
+	if r.File != "" {
+		fmt.Fprintf(w, "# effective configuration, loaded from %s\n", r.File)
+	} else {
+		fmt.Fprintln(w, "# effective configuration, no config file")
+	}
+	r.cfg.WriteFile(w)
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/console"
+	"github.com/michaljirman/goblockchain/rpc"
+)
+
+// Commands of the console which are not methods of the server.
+var consoleCommands = []string{"exit", "quit"}
+
+// Runs an interactive console calling methods of an RPC server, the node holds the DB while it runs.
+func (cli *CommandLine) console(w io.Writer, rpcURL string) error {
+	client := cli.rpcClient(rpcURL)
+	methods, err := client.Help()
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to %s", rpcURL)
+	}
+	height, err := client.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	addresses := wallets.GetAllAddresses()
+	addresses = append(addresses, wallets.GetMultiSigAddresses()...)
+
+	editor := console.NewEditor(cli.stdin(), w)
+	editor.Prompt = "> "
+	// the method is completed first, then the addresses it is called with
+	editor.Complete = func(args []string, word string) []string {
+		if len(args) == 0 {
+			return append(append([]string{}, methods...), consoleCommands...)
+		}
+		return addresses
+	}
+	historyFile := filepath.Join(cli.cfg.DataDir, "console_history")
+	if history, err := ioutil.ReadFile(historyFile); err == nil {
+		editor.SetHistory(strings.Split(strings.TrimSpace(string(history)), "\n"))
+	}
+
+	cli.progress(w, "Connected to %s, the last block is %d. Type help for the methods, exit to leave.", rpcURL, height)
+	for {
+		line, err := editor.ReadLine()
+		if err == console.ErrInterrupt {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		editor.AddHistory(line)
+		if err := appendHistory(historyFile, line); err != nil {
+			cli.progress(w, "failed to save history: %s", err)
+		}
+		if fields[0] == "exit" || fields[0] == "quit" {
+			return nil
+		}
+
+		if err := callConsole(w, client, fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(w, "error: %s\n", err)
+		}
+	}
+}
+
+// Calls a method of the server with params typed in the console and prints its result as indented JSON.
+// Params which are valid JSON are sent as they are, other params as strings, e.g. hashes.
+func callConsole(w io.Writer, client *rpc.Client, method string, args []string) error {
+	params := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if json.Valid([]byte(arg)) {
+			params = append(params, json.RawMessage(arg))
+		} else {
+			params = append(params, arg)
+		}
+	}
+
+	var result json.RawMessage
+	if err := client.Call(method, &result, params...); err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, result, "", "  "); err != nil {
+		return errors.Wrap(err, "invalid result")
+	}
+	fmt.Fprintf(w, "%s\n", indented.Bytes())
+	return nil
+}
+
+// Appends a line to the history file of the console, its directory is created if it doesn't exist.
+func appendHistory(file, line string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
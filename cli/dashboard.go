@@ -0,0 +1,258 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/console"
+	"github.com/michaljirman/goblockchain/rpc"
+)
+
+const (
+	// Number of mempool transactions shown with their values and fees.
+	dashboardMempool = 10
+	// Number of chain events shown.
+	dashboardEvents = 8
+)
+
+// A dashboard shows the state of a running node, reloaded over RPC whenever it sends a chain event.
+type dashboard struct {
+	client    *rpc.Client
+	addresses []string // addresses of our wallet file
+	blocks    int      // number of recent blocks shown
+}
+
+// Loads the state of the node.
+func (d *dashboard) load() (*dashboardResult, error) {
+	r := &dashboardResult{URL: d.client.URL, Updated: time.Now().Unix()}
+	var err error
+	if r.Mining, err = d.client.GetMiningInfo(); err != nil {
+		return nil, err
+	}
+
+	r.Blocks = []*rpc.BlockResult{}
+	for height := r.Mining.Blocks; height >= 0 && height > r.Mining.Blocks-d.blocks; height-- {
+		hash, err := d.client.GetBlockHash(height)
+		if err != nil {
+			return nil, err
+		}
+		block, err := d.client.GetBlock(hash)
+		// pruned blocks only have headers
+		if rpcErr, ok := err.(*rpc.Error); ok && rpcErr.Code == rpc.ErrCodeInvalidParams {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r.Blocks = append(r.Blocks, block)
+	}
+
+	mempool, err := d.client.GetMempoolInfo()
+	if err != nil {
+		return nil, err
+	}
+	r.Mempool = []*rpc.TxResult{}
+	for _, txID := range mempool.Transactions {
+		if len(r.Mempool) == dashboardMempool {
+			break
+		}
+		tx, err := d.client.GetTransaction(txID)
+		if err != nil {
+			// mined since the mempool was listed
+			continue
+		}
+		r.Mempool = append(r.Mempool, tx)
+	}
+
+	if r.Peers, err = d.client.GetPeerInfo(); err != nil {
+		return nil, err
+	}
+	r.Balances = []*rpc.BalanceResult{}
+	for _, address := range d.addresses {
+		balance, err := d.client.GetBalance(address)
+		if err != nil {
+			return nil, err
+		}
+		r.Balances = append(r.Balances, balance)
+	}
+	return r, nil
+}
+
+// Shows a dashboard of a running node. Terminals are redrawn in full screen until q is pressed,
+// other outputs get a single snapshot.
+func (cli *CommandLine) dashboard(w io.Writer, rpcURL string, blocks int, refresh time.Duration) error {
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	d := &dashboard{
+		client:    cli.rpcClient(rpcURL),
+		addresses: append(wallets.GetAllAddresses(), wallets.GetMultiSigAddresses()...),
+		blocks:    blocks,
+	}
+	r, err := d.load()
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to %s", rpcURL)
+	}
+
+	screen, err := console.NewScreen(cli.stdin(), w)
+	if err != nil {
+		return err
+	}
+	defer screen.Close()
+	if !screen.Interactive() || cli.cfg.Output == JSONOutput {
+		cli.emit(w, r)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifications, err := d.client.SubscribeEvents(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+	keys := screen.Keys()
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	var events []string
+	status := "live"
+	for {
+		screen.Draw(r.lines(events, status))
+		select {
+		case key, ok := <-keys:
+			// q, Esc and Ctrl-C quit
+			if !ok || key == 'q' || key == 27 || key == 3 {
+				return nil
+			}
+			if key != 'r' {
+				continue
+			}
+		case notification, ok := <-notifications:
+			if !ok {
+				notifications, status = nil, "event stream disconnected, refreshing every "+refresh.String()
+				continue
+			}
+			events = append(events, time.Now().Format("15:04:05")+" "+eventText(notification))
+			if len(events) > dashboardEvents {
+				events = events[len(events)-dashboardEvents:]
+			}
+		case <-ticker.C:
+		}
+
+		if next, err := d.load(); err != nil {
+			status = "failed to refresh: " + err.Error()
+		} else {
+			r = next
+			if notifications != nil {
+				status = "live"
+			}
+		}
+	}
+}
+
+// A dashboardResult is the state of a node shown by the dashboard.
+type dashboardResult struct {
+	URL      string                `json:"url"`
+	Updated  int64                 `json:"updated"` // unix time the state was loaded
+	Mining   *rpc.MiningInfoResult `json:"mining"`
+	Blocks   []*rpc.BlockResult    `json:"blocks"`  // the last blocks, the tip first
+	Mempool  []*rpc.TxResult       `json:"mempool"` // the oldest transactions of the mempool
+	Peers    []*rpc.PeerResult     `json:"peers"`   // clients subscribed to chain events
+	Balances []*rpc.BalanceResult  `json:"balances"`
+}
+
+func (r dashboardResult) writeText(w io.Writer) {
+	for _, line := range r.lines(nil, "") {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// Renders the dashboard into lines, events are the latest chain events and status describes the connection.
+func (r dashboardResult) lines(events []string, status string) []string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	m := r.Mining
+
+	fmt.Fprintf(tw, "Node %s, %s network, %s consensus, updated at %s\n", r.URL, m.Network, m.Consensus, time.Unix(r.Updated, 0).Format("15:04:05"))
+	if len(r.Blocks) > 0 {
+		tip := r.Blocks[0]
+		fmt.Fprintf(tw, "Tip: block %d %s at %s\n", tip.Height, tip.Hash, time.Unix(tip.Time, 0).Format("2006-01-02 15:04:05"))
+	}
+	fmt.Fprintf(tw, "Mining: difficulty %d, network hash rate %s\n", m.Difficulty, formatHashRate(m.NetworkHash))
+
+	fmt.Fprintf(tw, "\nRecent blocks\n")
+	fmt.Fprintf(tw, "  HEIGHT\tHASH\tTIME\tTXS\n")
+	for _, block := range r.Blocks {
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t%d\n", block.Height, shortHash(block.Hash), time.Unix(block.Time, 0).Format("15:04:05"), len(block.Transactions))
+	}
+
+	fmt.Fprintf(tw, "\nMempool: %d transactions\n", m.PooledTx)
+	if len(r.Mempool) > 0 {
+		fmt.Fprintf(tw, "  TXID\tINPUTS\tVALUE\tFEE\n")
+	}
+	for _, tx := range r.Mempool {
+		value := 0
+		for _, out := range tx.Outputs {
+			value += out.Value
+		}
+		fee := "unknown"
+		if tx.Fee != nil {
+			fee = fmt.Sprint(*tx.Fee)
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\n", shortHash(tx.TxID), len(tx.Inputs), value, fee)
+	}
+
+	fmt.Fprintf(tw, "\nPeers: %d subscribed to events\n", len(r.Peers))
+	for _, peer := range r.Peers {
+		types := "all events"
+		if len(peer.Types) > 0 {
+			types = strings.Join(peer.Types, ",")
+		}
+		fmt.Fprintf(tw, "  %s\tsince %s\t%s\n", peer.Addr, time.Unix(peer.ConnTime, 0).Format("15:04:05"), types)
+	}
+
+	fmt.Fprintf(tw, "\nWallet balances\n")
+	if len(r.Balances) > 0 {
+		fmt.Fprintf(tw, "  ADDRESS\tBALANCE\tIMMATURE\n")
+	}
+	for _, balance := range r.Balances {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\n", balance.Address, balance.Balance, balance.Immature)
+	}
+	tw.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if status == "" {
+		return lines
+	}
+	lines = append(lines, "", "Events")
+	for _, event := range events {
+		lines = append(lines, "  "+event)
+	}
+	return append(lines, "", "q quits, r refreshes, "+status)
+}
+
+// Shortens a hex encoded hash for tables.
+func shortHash(hash string) string {
+	if len(hash) <= 16 {
+		return hash
+	}
+	return hash[:16]
+}
+
+// Formats a hash rate with a unit prefix, e.g. 1.5 MH/s.
+func formatHashRate(rate float64) string {
+	units := []string{"H/s", "kH/s", "MH/s", "GH/s", "TH/s"}
+	unit := 0
+	for rate >= 1000 && unit < len(units)-1 {
+		rate /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", rate, units[unit])
+}
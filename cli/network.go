@@ -0,0 +1,484 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/rpc"
+	"github.com/michaljirman/goblockchain/stratum"
+	"github.com/michaljirman/goblockchain/webhook"
+)
+
+// Gets the commands serving the blockchain or talking to a server, and of webhooks.
+func networkCommands() []*command {
+	return []*command{
+		{
+			name:    "rpcserver",
+			args:    "[-addr ADDR] [-confirmations N] [-validatesnapshot EXPORT]",
+			summary: "Serves the blockchain over JSON-RPC, e.g. block templates for external miners",
+			help: []string{
+				"It also POSTs signed JSON to webhooks of watched addresses when they receive coins and on every confirmation.",
+				"Requests authenticate with HTTP basic authentication if the user and password of the [rpc] table are set.",
+			},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				addr := fs.String("addr", cli.cfg.RPC.Addr, "Address to serve JSON-RPC on (env RPC_ADDR)")
+				confirmations := fs.Int("confirmations", 6, "Number of confirmations notified to webhooks")
+				validateSnapshot := fs.String("validatesnapshot", "", "Chain export to validate the UTXO snapshot the chain starts from with")
+				return func(w io.Writer) error {
+					if *confirmations <= 0 {
+						return usagef("-confirmations has to be positive")
+					}
+					return cli.rpcServer(w, *addr, *confirmations, *validateSnapshot)
+				}
+			},
+		},
+		{
+			name:    "console",
+			args:    "[-rpc URL]",
+			summary: "Opens an interactive console calling methods of a running RPC server",
+			help: []string{
+				"Lines are METHOD [PARAM...], params are JSON values or plain strings, e.g. getblockhash 5 or getblock HASH.",
+				"Tab completes methods and the addresses of our wallet file, up and down browse the history of DATADIR/console_history.",
+				"help lists the methods of the server, exit or Ctrl-D leaves the console. Results are printed as indented JSON.",
+			},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				rpcURL := fs.String("rpc", cli.cfg.RPC.URL, "URL of the RPC server to connect to (env RPC_URL)")
+				return func(w io.Writer) error {
+					return cli.console(w, *rpcURL)
+				}
+			},
+		},
+		{
+			name:    "dashboard",
+			args:    "[-rpc URL] [-blocks N] [-refresh DURATION]",
+			summary: "Shows the state of a running RPC server in a full-screen terminal UI",
+			help: []string{
+				"The chain tip, recent blocks, the mempool, event subscribers, the network hash rate and the balances",
+				"of our wallet file are reloaded on every chain event and every -refresh. q quits, r refreshes.",
+				"When the output isn't a terminal or is JSON a single snapshot is written.",
+			},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				rpcURL := fs.String("rpc", cli.cfg.RPC.URL, "URL of the RPC server to connect to (env RPC_URL)")
+				blocks := fs.Int("blocks", 10, "Number of recent blocks shown")
+				refresh := fs.Duration("refresh", 5*time.Second, "How often the state is reloaded without chain events")
+				return func(w io.Writer) error {
+					if *blocks <= 0 {
+						return usagef("-blocks has to be positive")
+					}
+					if *refresh <= 0 {
+						return usagef("-refresh has to be positive")
+					}
+					return cli.dashboard(w, *rpcURL, *blocks, *refresh)
+				}
+			},
+		},
+		{
+			name:    "watchaddress",
+			args:    "-address ADDRESS -url URL [-rpc URL]",
+			summary: "Registers a webhook of an address, with -rpc at a running RPC server",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "The address to watch")
+				webhookURL := fs.String("url", "", "URL of the webhook")
+				rpcURL := fs.String("rpc", "", "URL of a running RPC server to register the webhook at")
+				return func(w io.Writer) error {
+					if *address == "" || *webhookURL == "" {
+						return usagef("-address and -url are required")
+					}
+					return cli.watchAddress(w, *address, *webhookURL, *rpcURL)
+				}
+			},
+		},
+		{
+			name:    "unwatchaddress",
+			args:    "-address ADDRESS -url URL",
+			summary: "Removes a webhook of an address",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "The watched address")
+				webhookURL := fs.String("url", "", "URL of the webhook")
+				return func(w io.Writer) error {
+					if *address == "" || *webhookURL == "" {
+						return usagef("-address and -url are required")
+					}
+					return cli.unwatchAddress(w, *address, *webhookURL)
+				}
+			},
+		},
+		{
+			name:    "listwatches",
+			args:    "[-address ADDRESS]",
+			summary: "Lists the webhooks of watched addresses",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "List webhooks of this address only")
+				return func(w io.Writer) error {
+					return cli.listWatches(w, *address)
+				}
+			},
+		},
+		{
+			name:    "webhooklog",
+			args:    "[-limit N]",
+			summary: "Prints the latest webhook delivery attempts",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				limit := fs.Int("limit", 20, "Number of latest delivery attempts to print, all if not positive")
+				return func(w io.Writer) error {
+					return cli.webhookLog(w, *limit)
+				}
+			},
+		},
+		{
+			name:    "subscribe",
+			args:    "[-rpc URL] [-types TYPE,...] [-address ADDRESS]",
+			summary: "Prints chain events of an RPC server as they happen",
+			help:    []string{"TYPE is blockconnected, blockdisconnected, txaccepted or addressactivity, all types by default."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				rpcURL := fs.String("rpc", cli.cfg.RPC.URL, "URL of the RPC server to subscribe to (env RPC_URL)")
+				types := fs.String("types", "", "Comma separated event types to print (defaults to all)")
+				address := fs.String("address", "", "Print activity of this address only")
+				return func(w io.Writer) error {
+					return cli.subscribe(w, *rpcURL, *types, *address)
+				}
+			},
+		},
+		{
+			name:    "mine",
+			args:    "-address ADDRESS [-rpc URL] [-blocks BLOCKS] [-workers N]",
+			summary: "Mines blocks from templates of an RPC server",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				rpcURL := fs.String("rpc", cli.cfg.RPC.URL, "URL of the RPC server to get block templates from (env RPC_URL)")
+				address := fs.String("address", cli.cfg.Mining.Address, "The address to send block rewards to (env MINING_ADDRESS)")
+				blocks := fs.Int("blocks", 1, "Number of blocks to mine")
+				workers := fs.Int("workers", cli.cfg.Mining.Workers, "Number of mining workers, GOMAXPROCS if 0 (env MINING_WORKERS)")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					if *blocks <= 0 {
+						return usagef("-blocks has to be positive")
+					}
+					return cli.mine(w, *rpcURL, *address, *blocks, *workers)
+				}
+			},
+		},
+		{
+			name:    "stratum",
+			args:    "-address ADDRESS [-rpc URL] [-addr ADDR] [-sharedifficulty BITS]",
+			summary: "Runs a Stratum mining pool paying blocks to address",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				rpcURL := fs.String("rpc", cli.cfg.RPC.URL, "URL of the RPC server to get block templates from (env RPC_URL)")
+				address := fs.String("address", cli.cfg.Mining.Address, "The pool address to send block rewards to (env MINING_ADDRESS)")
+				addr := fs.String("addr", cli.cfg.Mining.StratumAddr, "Address to accept workers on (env STRATUM_ADDR)")
+				shareDifficulty := fs.Int("sharedifficulty", cli.cfg.Mining.ShareDifficulty, "Number of leading zero bits of share hashes (env SHARE_DIFFICULTY)")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					if *shareDifficulty <= 0 {
+						return usagef("-sharedifficulty has to be positive")
+					}
+					return cli.stratumServer(w, *rpcURL, *address, *addr, *shareDifficulty)
+				}
+			},
+		},
+		{
+			name:    "stratumworker",
+			args:    "-name NAME [-url HOST:PORT] [-workers N]",
+			summary: "Mines shares for a Stratum mining pool",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				url := fs.String("url", cli.cfg.Mining.StratumAddr, "Address of the Stratum server (env STRATUM_ADDR)")
+				name := fs.String("name", "", "Worker name used for share accounting")
+				workers := fs.Int("workers", cli.cfg.Mining.Workers, "Number of mining workers, GOMAXPROCS if 0 (env MINING_WORKERS)")
+				return func(w io.Writer) error {
+					if *name == "" {
+						return usagef("-name is required")
+					}
+					return cli.stratumWorker(w, *url, *name, *workers)
+				}
+			},
+		},
+	}
+}
+
+func (cli *CommandLine) rpcServer(w io.Writer, addr string, confirmations int, validateSnapshotFile string) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r := rpcServerResult{Addr: addr}
+	validated := make(chan struct{})
+	if validateSnapshotFile != "" {
+		f, err := os.Open(validateSnapshotFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// the snapshot is validated in the background, the chain is served meanwhile
+		go func() {
+			defer close(validated)
+			if err := chain.ValidateSnapshot(ctx, f); err != nil {
+				r.SnapshotValidation = err.Error()
+				cli.progress(w, "UTXO snapshot validation failed: %s", err)
+			} else {
+				r.SnapshotValidation = "validated"
+				cli.progress(w, "UTXO snapshot validated")
+			}
+		}()
+	} else {
+		close(validated)
+	}
+
+	dispatched := webhook.NewDispatcher(chain, confirmations).Start(ctx)
+	server := rpc.NewServer(chain)
+	if cli.cfg.RPC.User != "" {
+		server.RequireAuth(cli.cfg.RPC.User, cli.cfg.RPC.Password)
+	}
+	err = server.ListenAndServe(ctx, addr)
+	stop()
+	<-dispatched
+	<-validated
+	if err != nil {
+		return err
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+func (cli *CommandLine) watchAddress(w io.Writer, address, webhookURL, rpcURL string) error {
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+
+	var secret string
+	if rpcURL != "" {
+		// a running node holds the DB, so the watch is registered by it
+		result, err := cli.rpcClient(rpcURL).WatchAddress(address, webhookURL)
+		if err != nil {
+			return err
+		}
+		secret = result.Secret
+	} else {
+		chain, err := cli.openChain()
+		if err != nil {
+			return err
+		}
+		defer chain.Database.Close()
+
+		watch, err := webhook.AddWatch(chain.Database, address, webhookURL)
+		if err != nil {
+			return err
+		}
+		secret = watch.Secret
+	}
+	cli.emit(w, watchResult{Address: address, URL: webhookURL, Secret: secret})
+	return nil
+}
+
+func (cli *CommandLine) unwatchAddress(w io.Writer, address, webhookURL string) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	if err := webhook.RemoveWatch(chain.Database, address, webhookURL); err != nil {
+		return err
+	}
+	cli.emit(w, unwatchResult{address, webhookURL})
+	return nil
+}
+
+func (cli *CommandLine) listWatches(w io.Writer, address string) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	watches, err := webhook.Watches(chain.Database, address)
+	if err != nil {
+		return err
+	}
+	cli.emit(w, newWatchesResult(watches))
+	return nil
+}
+
+func (cli *CommandLine) webhookLog(w io.Writer, limit int) error {
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	deliveries, err := webhook.Deliveries(chain.Database, limit)
+	if err != nil {
+		return err
+	}
+	cli.emit(w, newDeliveriesResult(deliveries))
+	return nil
+}
+
+func (cli *CommandLine) subscribe(w io.Writer, rpcURL, types, address string) error {
+	var eventTypes, addresses []string
+	if types != "" {
+		eventTypes = strings.Split(types, ",")
+	}
+	if address != "" {
+		if err := validateAddress("address", address); err != nil {
+			return err
+		}
+		addresses = append(addresses, address)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	notifications, err := cli.rpcClient(rpcURL).SubscribeEvents(ctx, eventTypes, addresses)
+	if err != nil {
+		return err
+	}
+	for notification := range notifications {
+		cli.stream(w, notification, eventText(notification))
+	}
+	return nil
+}
+
+func (cli *CommandLine) mine(w io.Writer, rpcURL, address string, blocks, workers int) error {
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+
+	client := cli.rpcClient(rpcURL)
+	miner := blockchain.NewMiner()
+	if workers > 0 {
+		miner.Workers = workers
+	}
+	miner.OnHashRate = func(hashRate float64) {
+		fmt.Fprintf(cli.stderr(), "Hash rate: %.0f H/s\n", hashRate)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// the blocks mined until mining is interrupted are the result
+	r := minedBlocksResult{Blocks: []minedBlock{}}
+	for mined := 0; mined < blocks; {
+		template, err := client.GetBlockTemplate()
+		if err != nil {
+			return err
+		}
+		block, err := template.NewBlock(address)
+		if err != nil {
+			return err
+		}
+
+		if err := mineOnTip(ctx, client, miner, block); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Fprintln(cli.stderr(), "New block found, mining on the new tip")
+			continue
+		}
+		if err := client.SubmitBlock(block); err != nil {
+			fmt.Fprintln(cli.stderr(), err)
+			continue
+		}
+		mined++
+		cli.progress(w, "Block %d: %x (%d transactions)", block.Height, block.Hash, len(block.Transactions))
+		r.Blocks = append(r.Blocks, minedBlock{block.Height, hex.EncodeToString(block.Hash), len(block.Transactions)})
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+// Mines a block, mining is aborted once the server's last block changes as the block would be stale.
+func mineOnTip(ctx context.Context, client *rpc.Client, miner *blockchain.Miner, block *blockchain.Block) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if hash, err := client.GetBestBlockHash(); err == nil && !bytes.Equal(hash, block.PrevHash) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return miner.Mine(ctx, block)
+}
+
+func (cli *CommandLine) stratumServer(w io.Writer, rpcURL, poolAddress, addr string, shareDifficulty int) error {
+	if err := validateAddress("address", poolAddress); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	server := stratum.NewServer(cli.rpcClient(rpcURL), poolAddress, shareDifficulty)
+	if err := server.ListenAndServe(ctx, addr); err != nil {
+		return err
+	}
+
+	stats := server.Stats()
+	payouts := server.Payouts(server.Rewards())
+	var names []string
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r := poolResult{Rewards: server.Rewards(), Workers: []workerResult{}}
+	for _, name := range names {
+		workerStats := stats[name]
+		r.Workers = append(r.Workers, workerResult{
+			name, workerStats.Accepted, workerStats.Rejected, workerStats.Stale, workerStats.Blocks, payouts[name],
+		})
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+func (cli *CommandLine) stratumWorker(w io.Writer, url, name string, workers int) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	worker := stratum.NewWorker(name)
+	if workers > 0 {
+		worker.Workers = workers
+	}
+	worker.OnHashRate = func(hashRate float64) {
+		fmt.Fprintf(cli.stderr(), "Hash rate: %.0f H/s\n", hashRate)
+	}
+
+	err := worker.Run(ctx, url)
+	accepted, rejected := worker.Shares()
+	if err != nil {
+		fmt.Fprintf(cli.stderr(), "Shares: %d accepted, %d rejected\n", accepted, rejected)
+		return err
+	}
+	cli.emit(w, sharesResult{accepted, rejected})
+	return nil
+}
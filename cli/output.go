@@ -0,0 +1,760 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/rpc"
+	"github.com/michaljirman/goblockchain/webhook"
+)
+
+// Output formats of commands. With JSON output every command writes a single JSON document to stdout,
+// an errorResult if it fails, while logs, usage and progress go to stderr. Commands streaming until
+// they are interrupted, like subscribe, write a JSON document per line instead.
+const (
+	TextOutput = "text"
+	JSONOutput = "json"
+)
+
+// A result is the output of a command, written as text or as a JSON document.
+type result interface {
+	writeText(w io.Writer)
+}
+
+// Gets the reader of interactive commands.
+func (cli *CommandLine) stdin() io.Reader {
+	if cli.in == nil {
+		return os.Stdin
+	}
+	return cli.in
+}
+
+// Gets the writer of command results.
+func (cli *CommandLine) stdout() io.Writer {
+	if cli.out == nil {
+		return os.Stdout
+	}
+	return cli.out
+}
+
+// Gets the writer of usage messages, progress and errors, they are not part of the JSON output.
+func (cli *CommandLine) stderr() io.Writer {
+	if cli.errOut == nil {
+		return os.Stderr
+	}
+	return cli.errOut
+}
+
+// Writes the result of a command to w.
+func (cli *CommandLine) emit(w io.Writer, r result) {
+	if cli.cfg.Output != JSONOutput {
+		r.writeText(w)
+		return
+	}
+	encoded, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(w, "%s\n", encoded)
+}
+
+// Writes a line of a command's progress, to w with text output and to stderr with JSON output.
+func (cli *CommandLine) progress(w io.Writer, format string, args ...interface{}) {
+	if cli.cfg.Output == JSONOutput {
+		w = cli.stderr()
+	}
+	fmt.Fprintf(w, format+"\n", args...)
+}
+
+// Writes an event of a streaming command to w, a JSON document per line with JSON output.
+func (cli *CommandLine) stream(w io.Writer, event interface{}, text string) {
+	if cli.cfg.Output != JSONOutput {
+		fmt.Fprintln(w, text)
+		return
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(w, "%s\n", encoded)
+}
+
+// An errorResult is written instead of the result of a command which failed.
+type errorResult struct {
+	Error string `json:"error"`
+}
+
+func (r errorResult) writeText(w io.Writer) {
+	fmt.Fprintln(w, r.Error)
+}
+
+type balanceResult struct {
+	Address  string `json:"address"`
+	Balance  int    `json:"balance"`
+	Immature int    `json:"immature"` // coinbase rewards which can't be spent yet
+}
+
+func (r balanceResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Balance of %s: %d\n", r.Address, r.Balance)
+	fmt.Fprintf(w, "Immature balance of %s: %d\n", r.Address, r.Immature)
+}
+
+type createBlockChainResult struct {
+	Genesis     string `json:"genesis"`
+	Network     string `json:"network"`
+	Consensus   string `json:"consensus"`
+	PruneTarget string `json:"prunetarget"`
+}
+
+func (r createBlockChainResult) writeText(w io.Writer) {
+	fmt.Fprintln(w, "Finished!")
+}
+
+// A minedBlock is a block mined by generate or mine, printed as it is mined with text output.
+type minedBlock struct {
+	Height       int    `json:"height"`
+	Hash         string `json:"hash"`
+	Transactions int    `json:"transactions"`
+}
+
+type minedBlocksResult struct {
+	Blocks []minedBlock `json:"blocks"`
+}
+
+func (r minedBlocksResult) writeText(w io.Writer) {}
+
+type txInputResult struct {
+	TxID            string `json:"txid"`
+	Out             int    `json:"out"`
+	UnlockingScript string `json:"unlockingscript"`
+	Sequence        uint32 `json:"sequence"`
+}
+
+type txOutputResult struct {
+	Value         int    `json:"value"`
+	LockingScript string `json:"lockingscript"`
+	Address       string `json:"address,omitempty"` // empty if the script doesn't pay to an address
+}
+
+type txResult struct {
+	TxID     string           `json:"txid"`
+	LockTime uint32           `json:"locktime"`
+	Inputs   []txInputResult  `json:"inputs"`
+	Outputs  []txOutputResult `json:"outputs"`
+
+	tx *blockchain.Transaction
+}
+
+func newTxResult(tx *blockchain.Transaction) txResult {
+	r := txResult{TxID: hex.EncodeToString(tx.ID), LockTime: tx.LockTime, tx: tx}
+	r.Inputs = []txInputResult{}
+	for _, in := range tx.Inputs {
+		r.Inputs = append(r.Inputs, txInputResult{hex.EncodeToString(in.ID), in.Out, in.UnlockingScript.String(), in.Sequence})
+	}
+	r.Outputs = []txOutputResult{}
+	for _, out := range tx.Outputs {
+		address, _ := out.Address()
+		r.Outputs = append(r.Outputs, txOutputResult{out.Value, out.LockingScript.String(), address})
+	}
+	return r
+}
+
+func (r txResult) writeText(w io.Writer) {
+	fmt.Fprintln(w, *r.tx)
+}
+
+type blockResult struct {
+	Hash         string     `json:"hash"`
+	PrevHash     string     `json:"prevhash"`
+	Height       int        `json:"height"`
+	Time         int64      `json:"time"`
+	Signer       string     `json:"signer,omitempty"`
+	Slashed      []string   `json:"slashed"` // signers of two blocks at the same height, slashed by the block
+	Seal         string     `json:"seal"`    // "valid", "not verified" or why the seal is invalid
+	Transactions []txResult `json:"transactions"`
+
+	evidence []*blockchain.DoubleSignEvidence
+	sealText string
+}
+
+func (r blockResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Prev. hash: %s\n", r.PrevHash)
+	fmt.Fprintf(w, "Hash: %s\n", r.Hash)
+	fmt.Fprintf(w, "Height: %d\n", r.Height)
+	fmt.Fprintf(w, "Time: %s\n", time.Unix(r.Time, 0).Format(time.RFC3339))
+	if r.Signer != "" {
+		fmt.Fprintf(w, "Signer: %s\n", r.Signer)
+	}
+	for _, evidence := range r.evidence {
+		fmt.Fprintf(w, "Slashed: %x signed two blocks at height %d\n", evidence.Signer(), evidence.First.Height)
+	}
+	fmt.Fprintf(w, "Seal: %s\n", r.sealText)
+	for _, tx := range r.Transactions {
+		tx.writeText(w)
+	}
+	fmt.Fprintln(w)
+}
+
+type chainResult struct {
+	Blocks []blockResult `json:"blocks"`
+	// why the blocks preceding the first block are missing, empty if it is the Genesis
+	Missing string `json:"missing,omitempty"`
+}
+
+func (r chainResult) writeText(w io.Writer) {
+	for _, block := range r.Blocks {
+		block.writeText(w)
+	}
+}
+
+type pruneResult struct {
+	PruneTarget string `json:"prunetarget"`
+	// the first block of the chain if the blocks preceding it were pruned or loaded from a UTXO snapshot
+	FirstHeight int    `json:"firstheight"`
+	FirstHash   string `json:"firsthash,omitempty"`
+	Pruned      bool   `json:"pruned"`
+}
+
+func (r pruneResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Prune target: %s\n", r.PruneTarget)
+	if r.Pruned {
+		fmt.Fprintf(w, "Blocks preceding block %d %s are pruned\n", r.FirstHeight, r.FirstHash)
+	} else if r.FirstHash != "" {
+		fmt.Fprintf(w, "Blocks preceding block %d %s were loaded from a UTXO snapshot\n", r.FirstHeight, r.FirstHash)
+	}
+}
+
+type migrationResult struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+}
+
+type migrateResult struct {
+	Version    int               `json:"version"` // schema version of the DB before it was migrated
+	Current    int               `json:"current"`
+	DryRun     bool              `json:"dryrun"`
+	Backup     string            `json:"backup,omitempty"`
+	Migrations []migrationResult `json:"migrations"`
+}
+
+func (r migrateResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Schema version: %d, current: %d\n", r.Version, r.Current)
+	if len(r.Migrations) == 0 {
+		fmt.Fprintln(w, "Blockchain DB is up to date")
+		return
+	}
+	if r.Backup != "" {
+		fmt.Fprintf(w, "Backed up the blockchain DB to %s\n", r.Backup)
+	}
+	for _, migration := range r.Migrations {
+		if r.DryRun {
+			fmt.Fprintf(w, "Would migrate to %d: %s\n", migration.Version, migration.Description)
+		} else {
+			fmt.Fprintf(w, "Migrated to %d: %s\n", migration.Version, migration.Description)
+		}
+	}
+}
+
+type exportResult struct {
+	File   string `json:"file"`
+	Blocks int    `json:"blocks"`
+}
+
+func (r exportResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Exported %d blocks to %s\n", r.Blocks, r.File)
+}
+
+type importResult struct {
+	Height   int    `json:"height"`
+	LastHash string `json:"lasthash"`
+}
+
+func (r importResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Imported blocks up to %d, last hash: %s\n", r.Height, r.LastHash)
+}
+
+type snapshotResult struct {
+	Outputs    int    `json:"outputs"`
+	Height     int    `json:"height"`
+	BaseHash   string `json:"basehash"`
+	Commitment string `json:"commitment"`
+
+	verb string
+}
+
+func newSnapshotResult(snapshot *blockchain.UTXOSnapshot, verb string) snapshotResult {
+	return snapshotResult{snapshot.Outputs, snapshot.Height, hex.EncodeToString(snapshot.BaseHash), hex.EncodeToString(snapshot.Commitment), verb}
+}
+
+func (r snapshotResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "%s %d unspent outputs at block %d %s\n", r.verb, r.Outputs, r.Height, r.BaseHash)
+	fmt.Fprintf(w, "Commitment: %s\n", r.Commitment)
+}
+
+type newAddressResult struct {
+	Address string `json:"address"`
+}
+
+func (r newAddressResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "New address is: %s\n", r.Address)
+}
+
+type multiSigResult struct {
+	Address      string `json:"address"`
+	M            int    `json:"m"`
+	N            int    `json:"n"`
+	RedeemScript string `json:"redeemscript,omitempty"`
+}
+
+func (r multiSigResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "New %d of %d multisig address is: %s\n", r.M, r.N, r.Address)
+	fmt.Fprintf(w, "Redeem script: %s\n", r.RedeemScript)
+}
+
+type addressesResult struct {
+	Addresses []string         `json:"addresses"`
+	MultiSigs []multiSigResult `json:"multisigs"`
+}
+
+func (r addressesResult) writeText(w io.Writer) {
+	for _, address := range r.Addresses {
+		fmt.Fprintln(w, address)
+	}
+	for _, ms := range r.MultiSigs {
+		fmt.Fprintf(w, "%s (%d of %d multisig)\n", ms.Address, ms.M, ms.N)
+	}
+}
+
+type pubKeyResult struct {
+	Address string `json:"address"`
+	PubKey  string `json:"pubkey"`
+}
+
+func (r pubKeyResult) writeText(w io.Writer) {
+	fmt.Fprintln(w, r.PubKey)
+}
+
+// A txSubmittedResult is the result of commands creating a transaction and mining it into a block
+// or sending it to the mempool of an RPC server.
+type txSubmittedResult struct {
+	TxID    string `json:"txid"`
+	Mempool bool   `json:"mempool"`
+
+	printTxID bool
+}
+
+func (r txSubmittedResult) writeText(w io.Writer) {
+	if r.Mempool {
+		fmt.Fprintf(w, "Transaction %s added to the mempool\n", r.TxID)
+		return
+	}
+	if r.printTxID {
+		fmt.Fprintf(w, "Transaction %s submitted\n", r.TxID)
+	}
+	fmt.Fprintln(w, "Success!")
+}
+
+type stakeResult struct {
+	PubKey  string `json:"pubkey"`
+	Amount  int    `json:"amount"`
+	Slashed bool   `json:"slashed"`
+}
+
+type stakesResult struct {
+	Stakes []stakeResult `json:"stakes"`
+}
+
+func (r stakesResult) writeText(w io.Writer) {
+	for _, stake := range r.Stakes {
+		if stake.Slashed {
+			fmt.Fprintf(w, "%s: %d (slashed)\n", stake.PubKey, stake.Amount)
+		} else {
+			fmt.Fprintf(w, "%s: %d\n", stake.PubKey, stake.Amount)
+		}
+	}
+}
+
+type rawTxResult struct {
+	TxID string `json:"txid"`
+	File string `json:"file"`
+}
+
+func (r rawTxResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Unsigned transaction %s saved to %s\n", r.TxID, r.File)
+}
+
+type signResult struct {
+	TxID     string   `json:"txid"`
+	File     string   `json:"file"`
+	Signed   int      `json:"signed"` // inputs signed by our wallet file
+	Inputs   []string `json:"inputs"` // signing status of every input
+	Values   []int    `json:"values"` // values of the outputs spent by every input, as committed to by the signatures
+	Total    int      `json:"total"`  // total value of the inputs
+	Fee      int      `json:"fee"`
+	Complete bool     `json:"complete"`
+}
+
+func newSignResult(rawTx *blockchain.RawTransaction, file string, signed int) signResult {
+	r := signResult{TxID: hex.EncodeToString(rawTx.Tx.ID), File: file, Signed: signed, Inputs: rawTx.SigningStatus(),
+		Values: []int{}, Complete: rawTx.IsSigned()}
+	for _, input := range rawTx.Inputs {
+		r.Values = append(r.Values, input.PrevOut.Value)
+		r.Total += input.PrevOut.Value
+	}
+	r.Fee, _ = blockchain.Fee(&rawTx.Tx, rawTx.PrevOuts())
+	return r
+}
+
+func (r signResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Signed %d of %d inputs, transaction saved to %s\n", r.Signed, len(r.Inputs), r.File)
+	for inIdx, status := range r.Inputs {
+		fmt.Fprintf(w, "Input %d: %s, spends %d\n", inIdx, status, r.Values[inIdx])
+	}
+	fmt.Fprintf(w, "Total: %d\n", r.Total)
+	fmt.Fprintf(w, "Fee: %d\n", r.Fee)
+	fmt.Fprintf(w, "Complete: %s\n", strconv.FormatBool(r.Complete))
+}
+
+type rpcServerResult struct {
+	Addr string `json:"addr"`
+	// "validated" or why validation of the UTXO snapshot failed, empty if it wasn't validated
+	SnapshotValidation string `json:"snapshotvalidation,omitempty"`
+}
+
+func (r rpcServerResult) writeText(w io.Writer) {}
+
+type watchResult struct {
+	Address string `json:"address"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"`
+	Created int64  `json:"created,omitempty"`
+}
+
+func (r watchResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Watching %s, payloads are signed with secret %s\n", r.Address, r.Secret)
+}
+
+type unwatchResult struct {
+	Address string `json:"address"`
+	URL     string `json:"url"`
+}
+
+func (r unwatchResult) writeText(w io.Writer) {
+	fmt.Fprintln(w, "Success!")
+}
+
+type watchesResult struct {
+	Watches []watchResult `json:"watches"`
+}
+
+func newWatchesResult(watches []*webhook.Watch) watchesResult {
+	r := watchesResult{Watches: []watchResult{}}
+	for _, watch := range watches {
+		// the secret is printed only when the watch is registered
+		r.Watches = append(r.Watches, watchResult{Address: watch.Address, URL: watch.URL, Created: watch.Created})
+	}
+	return r
+}
+
+func (r watchesResult) writeText(w io.Writer) {
+	for _, watch := range r.Watches {
+		fmt.Fprintf(w, "%s => %s (since %s)\n", watch.Address, watch.URL, time.Unix(watch.Created, 0).Format(time.RFC3339))
+	}
+}
+
+type deliveryResult struct {
+	Time          int64  `json:"time"`
+	URL           string `json:"url"`
+	Address       string `json:"address"`
+	TxID          string `json:"txid"`
+	Event         string `json:"event"`
+	Confirmations int    `json:"confirmations"`
+	Attempt       int    `json:"attempt"`
+	StatusCode    int    `json:"statuscode,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Delivered     bool   `json:"delivered"`
+
+	delivery *webhook.Delivery
+}
+
+type deliveriesResult struct {
+	Deliveries []deliveryResult `json:"deliveries"`
+}
+
+func newDeliveriesResult(deliveries []*webhook.Delivery) deliveriesResult {
+	r := deliveriesResult{Deliveries: []deliveryResult{}}
+	for _, d := range deliveries {
+		r.Deliveries = append(r.Deliveries, deliveryResult{
+			d.Time, d.URL, d.Address, d.TxID, d.Event, d.Confirmations, d.Attempt, d.StatusCode, d.Error, d.Delivered, d,
+		})
+	}
+	return r
+}
+
+func (r deliveriesResult) writeText(w io.Writer) {
+	for _, delivery := range r.Deliveries {
+		fmt.Fprintln(w, delivery.delivery)
+	}
+}
+
+// Gets the text line of a chain event printed by subscribe.
+func eventText(notification *rpc.EventNotification) string {
+	switch blockchain.EventType(notification.Type) {
+	case blockchain.BlockConnected, blockchain.BlockDisconnected:
+		return fmt.Sprintf("%s: block %d %s", notification.Type, notification.Height, notification.BlockHash)
+	case blockchain.TxAccepted:
+		return fmt.Sprintf("%s: transaction %s", notification.Type, notification.TxID)
+	case blockchain.AddressActivity:
+		if notification.BlockHash == "" {
+			return fmt.Sprintf("%s: %s in mempool transaction %s", notification.Type, notification.Address, notification.TxID)
+		}
+		return fmt.Sprintf("%s: %s in transaction %s of block %d", notification.Type, notification.Address, notification.TxID, notification.Height)
+	}
+	return notification.Type
+}
+
+type workerResult struct {
+	Name     string `json:"name"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Stale    int    `json:"stale"`
+	Blocks   int    `json:"blocks"`
+	Payout   int    `json:"payout"`
+}
+
+type poolResult struct {
+	Rewards int            `json:"rewards"`
+	Workers []workerResult `json:"workers"`
+}
+
+func (r poolResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Pool rewards: %d\n", r.Rewards)
+	for _, worker := range r.Workers {
+		fmt.Fprintf(w, "Worker %s: %d accepted, %d rejected, %d stale, %d blocks, payout %d\n",
+			worker.Name, worker.Accepted, worker.Rejected, worker.Stale, worker.Blocks, worker.Payout)
+	}
+}
+
+type sharesResult struct {
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"`
+}
+
+func (r sharesResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Shares: %d accepted, %d rejected\n", r.Accepted, r.Rejected)
+}
+
+// A txDetailsResult is a transaction together with the outputs spent by its inputs, see gettx and decoderawtx.
+type txDetailsResult struct {
+	TxID     string           `json:"txid"`
+	Size     int              `json:"size"` // bytes of the serialized transaction
+	LockTime uint32           `json:"locktime"`
+	Coinbase bool             `json:"coinbase"`
+	Inputs   []txInputDetails `json:"inputs"`
+	Outputs  []txOutputResult `json:"outputs"`
+	Fee      *int             `json:"fee"` // null if an output spent by the transaction is unknown
+	Block    *txBlock         `json:"block,omitempty"`
+	Signing  []string         `json:"signing,omitempty"` // signing status of the inputs of a raw transaction
+}
+
+type txInputDetails struct {
+	TxID            string `json:"txid"`
+	Out             int    `json:"out"`
+	UnlockingScript string `json:"unlockingscript"`
+	Sequence        uint32 `json:"sequence"`
+	Value           *int   `json:"value"`             // value of the spent output, null if it is unknown
+	Address         string `json:"address,omitempty"` // address the spent output pays to
+}
+
+// A txBlock is the block containing a transaction.
+type txBlock struct {
+	Hash          string `json:"hash"`
+	Height        int    `json:"height"`
+	Time          int64  `json:"time"`
+	Confirmations int    `json:"confirmations"`
+}
+
+// Creates the details of a transaction, prevOuts[i] is the output spent by tx.Inputs[i] or nil if it is unknown.
+func newTxDetailsResult(tx *blockchain.Transaction, prevOuts []*blockchain.TxOutput) txDetailsResult {
+	r := txDetailsResult{
+		TxID:     hex.EncodeToString(tx.ID),
+		Size:     tx.Size(),
+		LockTime: tx.LockTime,
+		Coinbase: tx.IsCoinbase(),
+		Inputs:   []txInputDetails{},
+		Outputs:  newTxResult(tx).Outputs,
+	}
+	for inIdx, in := range tx.Inputs {
+		input := txInputDetails{TxID: hex.EncodeToString(in.ID), Out: in.Out, UnlockingScript: in.UnlockingScript.String(), Sequence: in.Sequence}
+		if prevOut := prevOuts[inIdx]; prevOut != nil {
+			value := prevOut.Value
+			input.Value = &value
+			input.Address, _ = prevOut.Address()
+		}
+		r.Inputs = append(r.Inputs, input)
+	}
+	if fee, ok := blockchain.Fee(tx, prevOuts); ok {
+		r.Fee = &fee
+	}
+	return r
+}
+
+func (r txDetailsResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "--- Transaction %s:\n", r.TxID)
+	fmt.Fprintf(w, "     Size: %d bytes\n", r.Size)
+	if r.LockTime != 0 {
+		fmt.Fprintf(w, "     Lock time: %d\n", r.LockTime)
+	}
+	if r.Block != nil {
+		fmt.Fprintf(w, "     Block: %d %s (%d confirmations)\n", r.Block.Height, r.Block.Hash, r.Block.Confirmations)
+	}
+	for inIdx, in := range r.Inputs {
+		fmt.Fprintf(w, "     Input %d:\n", inIdx)
+		if r.Coinbase {
+			fmt.Fprintln(w, "       Coinbase")
+		} else {
+			fmt.Fprintf(w, "       Spends:    %s:%d\n", in.TxID, in.Out)
+			if in.Value == nil {
+				fmt.Fprintln(w, "       Value:     unknown, the spent output was pruned")
+			} else {
+				fmt.Fprintf(w, "       Value:     %d\n", *in.Value)
+			}
+			if in.Address != "" {
+				fmt.Fprintf(w, "       From:      %s\n", in.Address)
+			}
+		}
+		fmt.Fprintf(w, "       Script:    %s\n", in.UnlockingScript)
+		fmt.Fprintf(w, "       Sequence:  %#x\n", in.Sequence)
+		if inIdx < len(r.Signing) {
+			fmt.Fprintf(w, "       Signing:   %s\n", r.Signing[inIdx])
+		}
+	}
+	for outIdx, out := range r.Outputs {
+		fmt.Fprintf(w, "     Output %d:\n", outIdx)
+		fmt.Fprintf(w, "       Value:  %d\n", out.Value)
+		if out.Address != "" {
+			fmt.Fprintf(w, "       To:     %s\n", out.Address)
+		}
+		fmt.Fprintf(w, "       Script: %s\n", out.LockingScript)
+	}
+	if r.Fee == nil {
+		fmt.Fprintln(w, "     Fee: unknown")
+	} else {
+		fmt.Fprintf(w, "     Fee: %d\n", *r.Fee)
+	}
+}
+
+type blockHeaderResult struct {
+	Hash             string `json:"hash"`
+	PrevHash         string `json:"prevhash"`
+	Height           int    `json:"height"`
+	Time             int64  `json:"time"`
+	Difficulty       int    `json:"difficulty"`
+	Nonce            int    `json:"nonce"`
+	Signer           string `json:"signer,omitempty"`
+	TransactionsHash string `json:"transactionshash"`
+	Confirmations    int    `json:"confirmations"`
+	Seal             string `json:"seal"`   // "valid", "not verified" or why the seal is invalid
+	Pruned           bool   `json:"pruned"` // only the header of the block is stored
+}
+
+// Creates the result of the header of the block with a hash, block is nil if it is pruned.
+// The seal of blocks whose parent isn't stored can't be verified, only their proof of work is checked.
+func newBlockHeaderResult(chain *blockchain.BlockChain, hash []byte, header *blockchain.BlockHeader, block *blockchain.Block) blockHeaderResult {
+	r := blockHeaderResult{
+		Hash:             hex.EncodeToString(hash),
+		PrevHash:         hex.EncodeToString(header.PrevHash),
+		Height:           header.Height,
+		Time:             header.Timestamp,
+		Difficulty:       header.Difficulty,
+		Nonce:            header.Nonce,
+		TransactionsHash: hex.EncodeToString(header.TransactionsHash),
+		Confirmations:    chain.Confirmations(header.Height),
+		Seal:             "valid",
+		Pruned:           block == nil,
+	}
+	if header.Signer != nil {
+		r.Signer = hex.EncodeToString(header.Signer)
+	}
+	switch {
+	case block != nil && !(chain.IsFirstBlock(block) && len(block.PrevHash) != 0):
+		if err := chain.Engine.VerifySeal(chain, block); err != nil {
+			r.Seal = err.Error()
+		}
+	case chain.Params.Consensus != blockchain.ProofOfWorkConsensus:
+		r.Seal = "not verified"
+	case !bytes.Equal(header.Hash(), hash):
+		r.Seal = "block hash does not match its content"
+	case !header.HasValidProofOfWork():
+		r.Seal = "block hash does not satisfy the proof of work target"
+	}
+	return r
+}
+
+func (r blockHeaderResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Hash: %s\n", r.Hash)
+	fmt.Fprintf(w, "Prev. hash: %s\n", r.PrevHash)
+	fmt.Fprintf(w, "Height: %d\n", r.Height)
+	fmt.Fprintf(w, "Time: %s\n", time.Unix(r.Time, 0).Format(time.RFC3339))
+	fmt.Fprintf(w, "Difficulty: %d\n", r.Difficulty)
+	fmt.Fprintf(w, "Nonce: %d\n", r.Nonce)
+	if r.Signer != "" {
+		fmt.Fprintf(w, "Signer: %s\n", r.Signer)
+	}
+	fmt.Fprintf(w, "Transactions hash: %s\n", r.TransactionsHash)
+	fmt.Fprintf(w, "Confirmations: %d\n", r.Confirmations)
+	fmt.Fprintf(w, "Seal: %s\n", r.Seal)
+	if r.Pruned {
+		fmt.Fprintln(w, "Pruned: only the header is stored")
+	}
+}
+
+type blockDetailsResult struct {
+	blockHeaderResult
+	Size         int               `json:"size"` // bytes of the serialized block
+	Fees         *int              `json:"fees"` // null if an output spent by a transaction of the block is unknown
+	Transactions []txDetailsResult `json:"transactions"`
+}
+
+func newBlockDetailsResult(chain *blockchain.BlockChain, block *blockchain.Block) blockDetailsResult {
+	header := block.Header()
+	r := blockDetailsResult{
+		blockHeaderResult: newBlockHeaderResult(chain, block.Hash, &header, block),
+		Size:              block.Size(),
+		Transactions:      []txDetailsResult{},
+	}
+	fees, known := 0, true
+	for _, tx := range block.Transactions {
+		txResult := newTxDetailsResult(tx, chain.PrevOutputs(tx))
+		if txResult.Fee == nil {
+			known = false
+		} else {
+			fees += *txResult.Fee
+		}
+		r.Transactions = append(r.Transactions, txResult)
+	}
+	if known {
+		r.Fees = &fees
+	}
+	return r
+}
+
+func (r blockDetailsResult) writeText(w io.Writer) {
+	r.blockHeaderResult.writeText(w)
+	fmt.Fprintf(w, "Size: %d bytes\n", r.Size)
+	if r.Fees == nil {
+		fmt.Fprintln(w, "Fees: unknown")
+	} else {
+		fmt.Fprintf(w, "Fees: %d\n", *r.Fees)
+	}
+	for _, tx := range r.Transactions {
+		tx.writeText(w)
+	}
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/config"
+	"github.com/michaljirman/goblockchain/webhook"
+)
+
+var update = flag.Bool("update", false, "update the golden files of JSON output")
+
+const (
+	testAddress = "1437h8BZEGY32iDbQTnAkjtHMTKqaNtvvw"
+	testHash    = "0029c11996b955efa90a7d33885147aef45c5efb7711c76fd1fe29b42ab68314"
+)
+
+// The JSON documents of command results are the schema scripts depend on, they are compared with golden files
+// in testdata. A deliberate change of the schema is recorded by go test ./cli -update.
+func TestJSONOutput(t *testing.T) {
+	// gob numbers types in the order a process first encodes them, so transaction IDs depend on the tests run before
+	testID, _ := hex.DecodeString(testHash)
+	coinbaseTx, err := blockchain.CoinbaseTx(testAddress, "golden", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := blockchain.NewTxOutput(90, testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOut, err := blockchain.NewTxOutput(100, testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbaseTx.ID = testID
+	coinbase := newTxResult(coinbaseTx)
+	gettx := newTxDetailsResult(coinbaseTx, []*blockchain.TxOutput{nil})
+	gettx.Block = &txBlock{testHash, 1, 1792431519, 3}
+	spend := blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: make([]byte, 32), Out: 0, Sequence: blockchain.MaxSequence}},
+		Outputs: []blockchain.TxOutput{*out},
+	}
+	spend.ID = testID
+	decodeRawTx := newTxDetailsResult(&spend, []*blockchain.TxOutput{prevOut})
+	decodeRawTx.Signing = []string{"unsigned"}
+	fee := 0
+	header := blockHeaderResult{
+		Hash: testHash, PrevHash: testHash, Height: 1, Time: 1792431519, Difficulty: 1, Nonce: 7,
+		TransactionsHash: testHash, Confirmations: 3, Seal: "valid",
+	}
+	tests := map[string]result{
+		"error":            errorResult{"blockchain already exists"},
+		"getbalance":       balanceResult{testAddress, 100, 300},
+		"createblockchain": createBlockChainResult{testHash, "regtest", "pow", "disabled"},
+		"generate":         minedBlocksResult{[]minedBlock{{1, testHash, 1}}},
+		"gettx":            gettx,
+		"decoderawtx":      decodeRawTx,
+		"getblockheader":   header,
+		"getblock":         blockDetailsResult{header, 362, &fee, []txDetailsResult{gettx}},
+		"printchain": chainResult{
+			Blocks: []blockResult{{
+				Hash: testHash, PrevHash: testHash, Height: 1, Time: 1792431519, Slashed: []string{},
+				Seal: "not verified", Transactions: []txResult{coinbase},
+			}},
+			Missing: "block 0 " + testHash + ": block is pruned",
+		},
+		"prunechain":     pruneResult{"288 blocks", 12, testHash, true},
+		"migratedb":      migrateResult{0, 2, true, "", []migrationResult{{1, "store the network parameters"}}},
+		"exportchain":    exportResult{"chain.export", 3},
+		"importchain":    importResult{2, testHash},
+		"dumputxoset":    snapshotResult{Outputs: 3, Height: 2, BaseHash: testHash, Commitment: testHash},
+		"createwallet":   newAddressResult{testAddress},
+		"createmultisig": multiSigResult{testAddress, 2, 3, "OP_2 OP_3 OP_CHECKMULTISIG"},
+		"listaddresses":  addressesResult{[]string{testAddress}, []multiSigResult{{Address: testAddress, M: 2, N: 3}}},
+		"getpubkey":      pubKeyResult{testAddress, "3da86f10"},
+		"send":           txSubmittedResult{TxID: testHash},
+		"liststakes":     stakesResult{[]stakeResult{{"3da86f10", 100, false}}},
+		"createrawtx":    rawTxResult{testHash, "tx.json"},
+		"signrawtx":      signResult{testHash, "tx.json", 1, []string{"signed"}, []int{100}, 100, 0, true},
+		"rpcserver":      rpcServerResult{"localhost:8332", "validated"},
+		"watchaddress":   watchResult{Address: testAddress, URL: "http://localhost/hook", Secret: "00ff"},
+		"unwatchaddress": unwatchResult{testAddress, "http://localhost/hook"},
+		"listwatches": newWatchesResult([]*webhook.Watch{
+			{Address: testAddress, URL: "http://localhost/hook", Secret: "00ff", Created: 1792431519},
+		}),
+		"webhooklog": newDeliveriesResult([]*webhook.Delivery{
+			{Time: 1792431519, URL: "http://localhost/hook", Address: testAddress, TxID: testHash, Event: "received",
+				Confirmations: 1, Attempt: 2, StatusCode: 500, Error: "status 500"},
+		}),
+		"stratum":       poolResult{300, []workerResult{{"alice", 5, 1, 0, 1, 300}}},
+		"stratumworker": sharesResult{5, 1},
+	}
+
+	for name, r := range tests {
+		var out bytes.Buffer
+		cli := CommandLine{cfg: config.Config{Output: JSONOutput}, out: &out}
+		cli.emit(&out, r)
+
+		if !json.Valid(out.Bytes()) {
+			t.Errorf("%s: invalid JSON %s", name, out.Bytes())
+			continue
+		}
+		golden := filepath.Join("testdata", name+".json")
+		if *update {
+			if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), expected) {
+			t.Errorf("%s: JSON output changed, expected\n%s\ngot\n%s", name, expected, out.Bytes())
+		}
+	}
+}
+
+func TestTextOutput(t *testing.T) {
+	var out bytes.Buffer
+	cli := CommandLine{out: &out}
+	cli.emit(&out, balanceResult{testAddress, 100, 300})
+	expected := "Balance of " + testAddress + ": 100\nImmature balance of " + testAddress + ": 300\n"
+	if out.String() != expected {
+		t.Fatalf("expected text output %q, got %q", expected, out.String())
+	}
+}
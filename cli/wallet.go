@@ -0,0 +1,312 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Gets the commands of the wallet file in the data directory and of raw transactions.
+func walletCommands() []*command {
+	return []*command{
+		{
+			name:    "createwallet",
+			summary: "Creates a new wallet and prints its address",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				return cli.createWallet
+			},
+		},
+		{
+			name:    "listaddresses",
+			summary: "Lists the addresses in our wallet file",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				return cli.listAddresses
+			},
+		},
+		{
+			name:    "getpubkey",
+			args:    "-address ADDRESS",
+			summary: "Prints the public key of an address in our wallet file",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				address := fs.String("address", "", "The address to get public key for")
+				return func(w io.Writer) error {
+					if *address == "" {
+						return usagef("-address is required")
+					}
+					return cli.getPubKey(w, *address)
+				}
+			},
+		},
+		{
+			name:    "createmultisig",
+			args:    "-m M -pubkeys PUBKEY,...",
+			summary: "Creates an address spendable with M signatures of the public keys",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				m := fs.Int("m", 0, "Number of signatures required to spend")
+				pubKeys := fs.String("pubkeys", "", "Comma separated hex encoded public keys of the signers")
+				return func(w io.Writer) error {
+					if *m <= 0 {
+						return usagef("-m has to be positive")
+					}
+					if *pubKeys == "" {
+						return usagef("-pubkeys is required")
+					}
+					return cli.createMultiSig(w, *m, *pubKeys)
+				}
+			},
+		},
+		{
+			name:    "createrawtx",
+			args:    "-from FROM -to TO -amount AMOUNT [-locktime LOCKTIME] -file FILE",
+			summary: "Creates an unsigned transaction and saves it to FILE",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				from := fs.String("from", "", "Source wallet address")
+				to := fs.String("to", "", "Destination wallet address")
+				amount := fs.Int("amount", 0, "Amount to send")
+				lockTime := fs.Uint("locktime", 0, "Block height or unix time before which the transaction can't be mined")
+				file := fs.String("file", "", "File to save the unsigned transaction to")
+				return func(w io.Writer) error {
+					if *from == "" || *to == "" || *file == "" {
+						return usagef("-from, -to and -file are required")
+					}
+					if *amount <= 0 {
+						return usagef("-amount has to be positive")
+					}
+					if *lockTime > math.MaxUint32 {
+						return usagef("-locktime %d is out of range", *lockTime)
+					}
+					return cli.createRawTx(w, *from, *to, *amount, uint32(*lockTime), *file)
+				}
+			},
+		},
+		{
+			name:    "signrawtx",
+			args:    "-file FILE [-out OUT]",
+			summary: "Signs a transaction in FILE with keys from our wallet file, no blockchain needed",
+			help:    []string{"Multisignature inputs are completed once enough signers have signed the file."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				file := fs.String("file", "", "File with the transaction to sign")
+				out := fs.String("out", "", "File to save the signed transaction to (defaults to -file)")
+				return func(w io.Writer) error {
+					if *file == "" {
+						return usagef("-file is required")
+					}
+					if *out == "" {
+						*out = *file
+					}
+					return cli.signRawTx(w, *file, *out)
+				}
+			},
+		},
+		{
+			name:    "decoderawtx",
+			args:    "-file FILE",
+			summary: "Prints a raw transaction with the outputs it spends, its fee and signing status",
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				file := fs.String("file", "", "File with the raw transaction")
+				return func(w io.Writer) error {
+					if *file == "" {
+						return usagef("-file is required")
+					}
+					return cli.decodeRawTx(w, *file)
+				}
+			},
+		},
+		{
+			name:    "submitrawtx",
+			args:    "-file FILE (-address ADDRESS | -rpc URL)",
+			summary: "Validates a signed transaction in FILE and mines it into a new block rewarding ADDRESS",
+			help:    []string{"With -rpc the transaction is sent to the mempool of a running RPC server instead."},
+			flags: func(cli *CommandLine, fs *flag.FlagSet) handler {
+				file := fs.String("file", "", "File with the signed transaction")
+				address := fs.String("address", cli.cfg.Mining.Address, "The address to send the block reward to (env MINING_ADDRESS)")
+				rpcURL := fs.String("rpc", "", "URL of an RPC server to send the transaction to")
+				return func(w io.Writer) error {
+					if *file == "" {
+						return usagef("-file is required")
+					}
+					if *address == "" && *rpcURL == "" {
+						return usagef("-address is required to mine the transaction")
+					}
+					return cli.submitRawTx(w, *file, *address, *rpcURL)
+				}
+			},
+		},
+	}
+}
+
+func (cli *CommandLine) createWallet(w io.Writer) error {
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	address := wallets.AddWallet()
+	if err := cli.saveWallets(wallets); err != nil {
+		return err
+	}
+
+	cli.emit(w, newAddressResult{address})
+	return nil
+}
+
+func (cli *CommandLine) listAddresses(w io.Writer) error {
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	r := addressesResult{Addresses: wallets.GetAllAddresses(), MultiSigs: []multiSigResult{}}
+	for _, address := range wallets.GetMultiSigAddresses() {
+		ms := wallets.MultiSigs[address]
+		r.MultiSigs = append(r.MultiSigs, multiSigResult{Address: address, M: ms.M, N: len(ms.PubKeys)})
+	}
+	cli.emit(w, r)
+	return nil
+}
+
+func (cli *CommandLine) getPubKey(w io.Writer, address string) error {
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	wlt, ok := wallets.Wallets[address]
+	if !ok {
+		return errors.Errorf("address %s is not in our wallet file", address)
+	}
+
+	cli.emit(w, pubKeyResult{address, hex.EncodeToString(wlt.PublicKey)})
+	return nil
+}
+
+func (cli *CommandLine) createMultiSig(w io.Writer, m int, pubKeysList string) error {
+	var pubKeys [][]byte
+	for _, pubKeyHex := range strings.Split(pubKeysList, ",") {
+		pubKey, err := hex.DecodeString(strings.TrimSpace(pubKeyHex))
+		if err != nil {
+			return usagef("public key %s is not valid", pubKeyHex)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	if len(pubKeys) > wallet.MaxMultiSigKeys {
+		return usagef("at most %d public keys fit into a multisig redeem script, got %d", wallet.MaxMultiSigKeys, len(pubKeys))
+	}
+	if m > len(pubKeys) {
+		return usagef("-m %d is more than the %d public keys", m, len(pubKeys))
+	}
+
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	address, err := wallets.AddMultiSig(m, pubKeys)
+	if err != nil {
+		return err
+	}
+	if err := cli.saveWallets(wallets); err != nil {
+		return err
+	}
+
+	cli.emit(w, multiSigResult{address, m, len(pubKeys), wallets.MultiSigs[address].RedeemScript.String()})
+	return nil
+}
+
+func (cli *CommandLine) createRawTx(w io.Writer, from, to string, amount int, lockTime uint32, file string) error {
+	if err := validateAddress("from", from); err != nil {
+		return err
+	}
+	if err := validateAddress("to", to); err != nil {
+		return err
+	}
+
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	rawTx, err := chain.CreateRawTransaction(from, to, amount, lockTime)
+	if err != nil {
+		return err
+	}
+	if err := rawTx.SaveFile(file); err != nil {
+		return err
+	}
+	cli.emit(w, rawTxResult{hex.EncodeToString(rawTx.Tx.ID), file})
+	return nil
+}
+
+func (cli *CommandLine) signRawTx(w io.Writer, file, out string) error {
+	rawTx, err := blockchain.LoadRawTransaction(file)
+	if err != nil {
+		return err
+	}
+
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	signed := rawTx.Sign(wallets)
+	if err := rawTx.SaveFile(out); err != nil {
+		return err
+	}
+
+	cli.emit(w, newSignResult(rawTx, out, signed))
+	return nil
+}
+
+// Decodes a raw transaction file, the outputs spent by its inputs are described by the file, no blockchain needed.
+func (cli *CommandLine) decodeRawTx(w io.Writer, file string) error {
+	rawTx, err := blockchain.LoadRawTransaction(file)
+	if err != nil {
+		return err
+	}
+
+	r := newTxDetailsResult(&rawTx.Tx, rawTx.PrevOuts())
+	r.Signing = rawTx.SigningStatus()
+	cli.emit(w, r)
+	return nil
+}
+
+func (cli *CommandLine) submitRawTx(w io.Writer, file, address, rpcURL string) error {
+	rawTx, err := blockchain.LoadRawTransaction(file)
+	if err != nil {
+		return err
+	}
+
+	if rpcURL != "" {
+		if !rawTx.IsSigned() {
+			return errors.New("transaction is not fully signed")
+		}
+		txID, err := cli.rpcClient(rpcURL).SendRawTransaction(&rawTx.Tx)
+		if err != nil {
+			return err
+		}
+		cli.emit(w, txSubmittedResult{TxID: txID, Mempool: true})
+		return nil
+	}
+	if err := validateAddress("address", address); err != nil {
+		return err
+	}
+
+	chain, err := cli.openChain()
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	if err := cli.useProposerWallets(chain); err != nil {
+		return err
+	}
+
+	if _, err := chain.SubmitRawTransaction(context.Background(), rawTx, address); err != nil {
+		return err
+	}
+	cli.emit(w, txSubmittedResult{TxID: hex.EncodeToString(rawTx.Tx.ID), printTxID: true})
+	return nil
+}
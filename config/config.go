@@ -1,28 +1,195 @@
 package config
 
 import (
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+
 	"github.com/caarlos0/env"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 )
 
-// Global configuration struct.
+// Name of the config file read from the working directory if no other file is given.
+const DefaultFile = "goblockchain.toml"
+
+// Global configuration struct. Its values are layered: the defaults are overridden by a config file,
+// the file by environment variables and those by command line flags. Keys of the config file are the
+// toml tags of the fields, fields of nested structs are keys of a table, e.g. addr of [rpc] is rpc.addr.
 type Config struct {
-	Log LogConf
+	DataDir string     `toml:"datadir" env:"DATA_DIR"`
+	Network string     `toml:"network" env:"NETWORK"`
+	Output  string     `toml:"output" env:"OUTPUT_FORMAT"`
+	RPC     RPCConf    `toml:"rpc"`
+	Mining  MiningConf `toml:"mining"`
+	Log     LogConf    `toml:"log"`
+}
+
+// RPCConf - JSON-RPC configuration struct, of the server and of the commands talking to one.
+type RPCConf struct {
+	Addr     string `toml:"addr" env:"RPC_ADDR"` // address the server listens on
+	URL      string `toml:"url" env:"RPC_URL"`   // URL of the server commands talk to
+	User     string `toml:"user" env:"RPC_USER"` // HTTP basic authentication of requests, none if empty
+	Password string `toml:"password" env:"RPC_PASSWORD"`
+}
+
+// MiningConf - mining configuration struct, the defaults of the flags of mining commands.
+type MiningConf struct {
+	Address         string `toml:"address" env:"MINING_ADDRESS"` // address block rewards are sent to
+	Workers         int    `toml:"workers" env:"MINING_WORKERS"` // GOMAXPROCS if zero
+	StratumAddr     string `toml:"stratumaddr" env:"STRATUM_ADDR"`
+	ShareDifficulty int    `toml:"sharedifficulty" env:"SHARE_DIFFICULTY"`
 }
 
-// LogConf - logging configuration struct.
+// LogConf - logging configuration struct. Subsystems without a level of their own log at Level.
 type LogConf struct {
-	Level             string `env:"LOG_LEVEL" envDefault:"debug"`
-	DevelopmentLogger bool   `env:"DEVELOPMENT_LOGGER" envDefault:"FALSE"`
+	Level             string `toml:"level" env:"LOG_LEVEL"`
+	Chain             string `toml:"chain" env:"LOG_LEVEL_CHAIN"`
+	Wallet            string `toml:"wallet" env:"LOG_LEVEL_WALLET"`
+	PoW               string `toml:"pow" env:"LOG_LEVEL_POW"`
+	DB                string `toml:"db" env:"LOG_LEVEL_DB"`
+	Net               string `toml:"net" env:"LOG_LEVEL_NET"`
+	RPC               string `toml:"rpc" env:"LOG_LEVEL_RPC"`
+	File              string `toml:"file" env:"LOG_FILE"`          // stderr if empty
+	MaxSize           int    `toml:"maxsize" env:"LOG_MAX_SIZE"`   // megabytes the file is rotated at
+	MaxFiles          int    `toml:"maxfiles" env:"LOG_MAX_FILES"` // rotated files kept
+	DevelopmentLogger bool   `toml:"development" env:"DEVELOPMENT_LOGGER"`
 }
 
-// Gets a configuration struct from an environment.
-func GetConfigFromEnv() (Config, error) {
-	config := Config{}
+// Gets the configuration used when no config file or environment variable overrides it.
+func Default() Config {
+	return Config{
+		DataDir: "./tmp",
+		Output:  "text",
+		RPC: RPCConf{
+			Addr: "localhost:8332",
+			URL:  "http://localhost:8332",
+		},
+		Mining: MiningConf{
+			StratumAddr:     "localhost:3333",
+			ShareDifficulty: 12,
+		},
+		Log: LogConf{
+			Level:    "debug",
+			DB:       "warn", // Badger logs every compaction at info
+			MaxSize:  100,
+			MaxFiles: 5,
+		},
+	}
+}
 
-	if err := env.Parse(&config.Log); err != nil {
-		return Config{}, errors.Wrap(err, "failed to load Log config")
+// Loads the configuration: the defaults overridden by a config file, unless file is empty,
+// overridden by the environment variables which are set. It isn't validated, flags may override it.
+func Load(file string) (Config, error) {
+	config := Default()
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return Config{}, errors.Wrap(err, "failed to open config file")
+		}
+		defer f.Close()
+		if err := config.ReadFile(f); err != nil {
+			return Config{}, errors.Wrapf(err, "invalid config file %s", file)
+		}
 	}
 
+	for _, conf := range []interface{}{&config, &config.RPC, &config.Mining, &config.Log} {
+		if err := env.Parse(conf); err != nil {
+			return Config{}, errors.Wrap(err, "failed to load config from environment")
+		}
+	}
 	return config, nil
 }
+
+// Overrides the configuration with the keys set by a config file.
+func (c *Config) ReadFile(r io.Reader) error {
+	values, err := parseTOML(r)
+	if err != nil {
+		return err
+	}
+	return decodeFile(values, c)
+}
+
+// Writes the configuration as a config file.
+func (c Config) WriteFile(w io.Writer) {
+	encodeTOML(w, c)
+}
+
+// Gets the values of the configuration by their keys, e.g. rpc.addr.
+func (c Config) Values() map[string]interface{} {
+	values := make(map[string]interface{})
+	visit(reflect.ValueOf(c), "", func(key string, field reflect.Value, _ reflect.StructField) {
+		values[key] = field.Interface()
+	})
+	return values
+}
+
+// Checks the values of the configuration, an invalid value is reported with its key and environment variable.
+func (c Config) Validate() error {
+	var err error
+	visit(reflect.ValueOf(c), "", func(key string, field reflect.Value, sf reflect.StructField) {
+		if err != nil {
+			return
+		}
+		if problem := validateValue(key, field.Interface(), c); problem != "" {
+			err = errors.Errorf("invalid %s (env %s): %s", key, sf.Tag.Get("env"), problem)
+		}
+	})
+	return err
+}
+
+// Checks the value of a key, returns what is wrong with it.
+func validateValue(key string, value interface{}, c Config) string {
+	switch key {
+	case "datadir":
+		if value == "" {
+			return "the data directory is required"
+		}
+	case "output":
+		if value != "text" && value != "json" {
+			return "expected text or json, got " + value.(string)
+		}
+	case "rpc.addr", "mining.stratumaddr":
+		if _, _, err := net.SplitHostPort(value.(string)); err != nil {
+			return err.Error()
+		}
+	case "rpc.url":
+		u, err := url.Parse(value.(string))
+		if err != nil {
+			return err.Error()
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "expected an http or https URL, got " + value.(string)
+		}
+	case "rpc.password":
+		if (c.RPC.User == "") != (value == "") {
+			return "the user and the password have to be set together"
+		}
+	case "mining.workers":
+		if value.(int) < 0 {
+			return "the number of workers can't be negative"
+		}
+	case "mining.sharedifficulty":
+		if value.(int) <= 0 {
+			return "the share difficulty has to be positive"
+		}
+	case "log.level", "log.chain", "log.wallet", "log.pow", "log.db", "log.net", "log.rpc":
+		if value == "" && key != "log.level" {
+			return ""
+		}
+		if _, err := zerolog.ParseLevel(value.(string)); err != nil || value == "" {
+			return "expected debug, info, warn, error, fatal or panic, got " + value.(string)
+		}
+	case "log.maxsize":
+		if value.(int) <= 0 {
+			return "the maximum size of the log file has to be positive"
+		}
+	case "log.maxfiles":
+		if value.(int) < 0 {
+			return "the number of rotated log files can't be negative"
+		}
+	}
+	return ""
+}
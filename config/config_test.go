@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFile = `# node configuration
+datadir = "/var/lib/goblockchain"
+network = 'regtest'
+
+[rpc]
+addr = "0.0.0.0:8332" # all interfaces
+user = "operator"
+password = "pass#word"
+
+[mining]
+workers = 4
+sharedifficulty = 1_0
+
+[log]
+pow = "info"
+file = "/var/log/goblockchain.log"
+development = true
+`
+
+func TestLoadLayers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), DefaultFile)
+	if err := ioutil.WriteFile(file, []byte(testFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETWORK", "main")
+	t.Setenv("MINING_WORKERS", "2")
+	t.Setenv("LOG_LEVEL_DB", "error")
+
+	config, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.DataDir = "/var/lib/goblockchain"
+	expected.Network = "main"
+	expected.RPC.Addr = "0.0.0.0:8332"
+	expected.RPC.User, expected.RPC.Password = "operator", "pass#word"
+	expected.Mining.Workers = 2
+	expected.Mining.ShareDifficulty = 10
+	expected.Log.PoW, expected.Log.DB = "info", "error"
+	expected.Log.File = "/var/log/goblockchain.log"
+	expected.Log.DevelopmentLogger = true
+	if config != expected {
+		t.Fatalf("expected the environment to override the file overriding the defaults, got %+v", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the written file is read back into the same configuration
+	var buf bytes.Buffer
+	config.WriteFile(&buf)
+	read := Default()
+	if err := read.ReadFile(&buf); err != nil || read != config {
+		t.Fatalf("expected the written file to be read back, got %+v %v", read, err)
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	tests := []struct {
+		file     string
+		expected string
+	}{
+		{"datadir = ./tmp", "line 1: invalid value of datadir"},
+		{"[rpc]\nadr = \"localhost:8332\"", "line 2: unknown key rpc.adr"},
+		{"\n[mining]\nworkers = \"4\"", "line 3: mining.workers has to be an integer"},
+		{"[log]\ndevelopment = 1", "line 2: log.development has to be true or false"},
+		{"network = \"main\"\nnetwork = \"regtest\"", "line 2: network is set twice"},
+		{"[rpc\naddr = \"localhost:8332\"", "line 1: invalid table"},
+		{"output", "line 1: expected KEY = VALUE"},
+	}
+
+	for _, test := range tests {
+		config := Default()
+		err := config.ReadFile(strings.NewReader(test.file))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected error %q, got %v", test.file, test.expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		change   func(c *Config)
+		expected string
+	}{
+		{func(c *Config) { c.DataDir = "" }, "invalid datadir (env DATA_DIR)"},
+		{func(c *Config) { c.Output = "xml" }, "invalid output (env OUTPUT_FORMAT)"},
+		{func(c *Config) { c.RPC.Addr = "8332" }, "invalid rpc.addr (env RPC_ADDR)"},
+		{func(c *Config) { c.RPC.URL = "localhost:8332" }, "invalid rpc.url (env RPC_URL)"},
+		{func(c *Config) { c.RPC.User = "operator" }, "invalid rpc.password (env RPC_PASSWORD)"},
+		{func(c *Config) { c.Mining.Workers = -1 }, "invalid mining.workers (env MINING_WORKERS)"},
+		{func(c *Config) { c.Mining.ShareDifficulty = 0 }, "invalid mining.sharedifficulty (env SHARE_DIFFICULTY)"},
+		{func(c *Config) { c.Log.Level = "verbose" }, "invalid log.level (env LOG_LEVEL)"},
+		{func(c *Config) { c.Log.RPC = "trace" }, "invalid log.rpc (env LOG_LEVEL_RPC)"},
+		{func(c *Config) { c.Log.MaxSize = 0 }, "invalid log.maxsize (env LOG_MAX_SIZE)"},
+		{func(c *Config) { c.Log.MaxFiles = -1 }, "invalid log.maxfiles (env LOG_MAX_FILES)"},
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+	for _, test := range tests {
+		config := Default()
+		test.change(&config)
+		if err := config.Validate(); err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A value of a key of a config file with the line it was set on.
+type fileValue struct {
+	value interface{} // string, int64 or bool
+	line  int
+}
+
+// Parses a config file in a subset of TOML: tables, e.g. [rpc], keys set to strings, integers or booleans,
+// and comments. Keys of tables are prefixed with the table name, e.g. rpc.addr.
+func parseTOML(r io.Reader) (map[string]fileValue, error) {
+	values := make(map[string]fileValue)
+	table := ""
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, errors.Errorf("line %d: invalid table %s", line, text)
+			}
+			table = strings.TrimSpace(text[1 : len(text)-1])
+			if !isBareKey(table) {
+				return nil, errors.Errorf("line %d: invalid table name %q", line, table)
+			}
+			continue
+		}
+
+		eq := strings.Index(text, "=")
+		if eq < 0 {
+			return nil, errors.Errorf("line %d: expected KEY = VALUE, got %s", line, text)
+		}
+		key := strings.TrimSpace(text[:eq])
+		if !isBareKey(key) {
+			return nil, errors.Errorf("line %d: invalid key %q", line, key)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, ok := values[key]; ok {
+			return nil, errors.Errorf("line %d: %s is set twice", line, key)
+		}
+		value, err := parseValue(strings.TrimSpace(text[eq+1:]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d: invalid value of %s", line, key)
+		}
+		values[key] = fileValue{value, line}
+	}
+	return values, errors.Wrap(scanner.Err(), "failed to read config file")
+}
+
+// Removes a comment from a line, # within strings doesn't start one.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Checks if a key consists of letters, digits, underscores and dashes.
+func isBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses a value: a basic "string", a literal 'string', an integer or a boolean.
+func parseValue(text string) (interface{}, error) {
+	switch {
+	case text == "":
+		return nil, errors.New("missing value")
+	case strings.HasPrefix(text, "\""):
+		value, err := strconv.Unquote(text)
+		return value, errors.Wrap(err, "invalid string")
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") || strings.Contains(text[1:len(text)-1], "'") {
+			return nil, errors.New("invalid string")
+		}
+		return text[1 : len(text)-1], nil
+	case text == "true" || text == "false":
+		return text == "true", nil
+	}
+	value, err := strconv.ParseInt(strings.Replace(text, "_", "", -1), 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("%s is not a string, an integer or a boolean", text)
+	}
+	return value, nil
+}
+
+// Sets the fields of a config struct to the values of a config file, keys are the toml tags of the fields.
+// Unknown keys and values of the wrong type are errors naming the key.
+func decodeFile(values map[string]fileValue, config interface{}) error {
+	known := make(map[string]bool)
+	var err error
+	visit(reflect.ValueOf(config).Elem(), "", func(key string, field reflect.Value, _ reflect.StructField) {
+		known[key] = true
+		value, ok := values[key]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := setField(field, value.value); setErr != nil {
+			err = errors.Errorf("line %d: %s %s", value.line, key, setErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var unknown []string
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errors.Errorf("line %d: unknown key %s", values[unknown[0]].line, unknown[0])
+	}
+	return nil
+}
+
+// Calls fn with the key of every field of a config struct, fields of nested structs are prefixed
+// with the key of the struct, e.g. rpc.addr.
+func visit(v reflect.Value, prefix string, fn func(key string, field reflect.Value, sf reflect.StructField)) {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		key := sf.Tag.Get("toml")
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			visit(v.Field(i), key, fn)
+			continue
+		}
+		fn(key, v.Field(i), sf)
+	}
+}
+
+// Sets a field to a value of a config file.
+func setField(field reflect.Value, value interface{}) error {
+	switch field.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return errors.New("has to be a string")
+		}
+		field.SetString(s)
+	case reflect.Int:
+		n, ok := value.(int64)
+		if !ok {
+			return errors.New("has to be an integer")
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return errors.New("has to be true or false")
+		}
+		field.SetBool(b)
+	default:
+		panic(fmt.Sprintf("unsupported config field type %s", field.Type()))
+	}
+	return nil
+}
+
+// Writes a config struct as a config file, keys of nested structs form tables.
+func encodeTOML(w io.Writer, config interface{}) {
+	table := ""
+	visit(reflect.ValueOf(config), "", func(key string, field reflect.Value, _ reflect.StructField) {
+		if dot := strings.LastIndex(key, "."); dot >= 0 {
+			if key[:dot] != table {
+				table = key[:dot]
+				fmt.Fprintf(w, "\n[%s]\n", table)
+			}
+			key = key[dot+1:]
+		}
+		fmt.Fprintf(w, "%s = %s\n", key, formatValue(field))
+	})
+}
+
+// Formats the value of a field in TOML.
+func formatValue(field reflect.Value) string {
+	if field.Kind() == reflect.String {
+		return strconv.Quote(field.String())
+	}
+	return fmt.Sprint(field.Interface())
+}
@@ -0,0 +1,312 @@
+// Package console reads lines of an interactive shell, edited in place with history and tab completion.
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Maximum number of lines kept in the history.
+const maxHistory = 1000
+
+// Returned by ReadLine when the line is interrupted with Ctrl-C.
+var ErrInterrupt = errors.New("interrupted")
+
+// A Completer gets the candidates completing word, the word at the cursor, following the words of args.
+// Candidates not starting with word are ignored.
+type Completer func(args []string, word string) []string
+
+// An Editor reads lines of a terminal in raw mode, so they can be edited in place: arrows move the cursor
+// and browse the history, tab completes the word at the cursor. Lines of other readers, e.g. pipes,
+// are read as they are without a prompt.
+type Editor struct {
+	Prompt   string
+	Complete Completer
+
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int  // file descriptor of the terminal, -1 if in is not a terminal
+	interactive bool // lines are edited in place
+	history     []string
+}
+
+// Creates an Editor reading lines from in and echoing them to out if in is a terminal.
+func NewEditor(in io.Reader, out io.Writer) *Editor {
+	e := &Editor{in: bufio.NewReader(in), out: out, fd: -1}
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		e.fd = int(f.Fd())
+		e.interactive = true
+	}
+	return e
+}
+
+// Sets the history browsed by the arrows, oldest line first.
+func (e *Editor) SetHistory(lines []string) {
+	e.history = nil
+	for _, line := range lines {
+		e.AddHistory(line)
+	}
+}
+
+// Adds a line to the history, empty lines and repeats of the last line are skipped.
+func (e *Editor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// Gets the history, oldest line first.
+func (e *Editor) History() []string {
+	return e.history
+}
+
+// Reads a line without its line ending. Returns io.EOF at the end of the input or on Ctrl-D
+// on an empty line, and ErrInterrupt on Ctrl-C.
+func (e *Editor) ReadLine() (string, error) {
+	if !e.interactive {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	if e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to set the terminal to raw mode")
+		}
+		defer restore()
+	}
+	return e.edit()
+}
+
+// The state of a line being edited.
+type lineState struct {
+	line    []rune
+	pos     int    // position of the cursor in line
+	histIdx int    // index of the history line shown, len(history) for the new line
+	saved   []rune // the new line while the history is browsed
+}
+
+// Edits a line read from a terminal in raw mode.
+func (e *Editor) edit() (string, error) {
+	s := &lineState{histIdx: len(e.history)}
+	fmt.Fprint(e.out, e.Prompt)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(s.line) > 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return string(s.line), nil
+			}
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(s.line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupt
+		case 4: // Ctrl-D
+			if len(s.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			s.delete()
+		case 127, 8: // backspace
+			if s.pos > 0 {
+				s.pos--
+				s.delete()
+			}
+		case 1: // Ctrl-A
+			s.pos = 0
+		case 5: // Ctrl-E
+			s.pos = len(s.line)
+		case 2: // Ctrl-B
+			s.left()
+		case 6: // Ctrl-F
+			s.right()
+		case 11: // Ctrl-K
+			s.line = s.line[:s.pos]
+		case 21: // Ctrl-U
+			s.line = append([]rune{}, s.line[s.pos:]...)
+			s.pos = 0
+		case 16: // Ctrl-P
+			e.previous(s)
+		case 14: // Ctrl-N
+			e.next(s)
+		case '\t':
+			e.complete(s)
+		case 27:
+			e.escape(s)
+		default:
+			if unicode.IsPrint(r) {
+				s.insert([]rune{r})
+			}
+		}
+		e.refresh(s)
+	}
+}
+
+// Handles an escape sequence of a key, e.g. an arrow.
+func (e *Editor) escape(s *lineState) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+	// parameters of the sequence, e.g. 3 of the delete key ESC [ 3 ~
+	var param []rune
+	for {
+		if r, _, err = e.in.ReadRune(); err != nil {
+			return
+		}
+		if r < '0' || r > '9' {
+			break
+		}
+		param = append(param, r)
+	}
+
+	switch r {
+	case 'A':
+		e.previous(s)
+	case 'B':
+		e.next(s)
+	case 'C':
+		s.right()
+	case 'D':
+		s.left()
+	case 'H':
+		s.pos = 0
+	case 'F':
+		s.pos = len(s.line)
+	case '~':
+		switch string(param) {
+		case "1", "7":
+			s.pos = 0
+		case "4", "8":
+			s.pos = len(s.line)
+		case "3":
+			s.delete()
+		}
+	}
+}
+
+// Shows the previous history line.
+func (e *Editor) previous(s *lineState) {
+	if s.histIdx == 0 {
+		return
+	}
+	if s.histIdx == len(e.history) {
+		s.saved = s.line
+	}
+	s.histIdx--
+	s.line = []rune(e.history[s.histIdx])
+	s.pos = len(s.line)
+}
+
+// Shows the next history line, or the new line after the last one.
+func (e *Editor) next(s *lineState) {
+	if s.histIdx == len(e.history) {
+		return
+	}
+	s.histIdx++
+	if s.histIdx == len(e.history) {
+		s.line = s.saved
+	} else {
+		s.line = []rune(e.history[s.histIdx])
+	}
+	s.pos = len(s.line)
+}
+
+// Completes the word at the cursor. A single candidate is completed followed by a space, otherwise
+// the common prefix of the candidates is completed or, if there is none to add, they are listed.
+func (e *Editor) complete(s *lineState) {
+	if e.Complete == nil {
+		return
+	}
+	args := strings.Fields(string(s.line[:s.pos]))
+	word := ""
+	if s.pos > 0 && !unicode.IsSpace(s.line[s.pos-1]) {
+		word, args = args[len(args)-1], args[:len(args)-1]
+	}
+
+	var candidates []string
+	for _, candidate := range e.Complete(args, word) {
+		if strings.HasPrefix(candidate, word) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	switch {
+	case len(candidates) == 0:
+		fmt.Fprint(e.out, "\a")
+	case len(candidates) == 1:
+		s.insert([]rune(candidates[0][len(word):] + " "))
+	default:
+		prefix := commonPrefix(candidates)
+		if len(prefix) > len(word) {
+			s.insert([]rune(prefix[len(word):]))
+			return
+		}
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+}
+
+// Gets the longest common prefix of strings.
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// Redraws the line and moves the cursor to its position.
+func (e *Editor) refresh(s *lineState) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.Prompt, string(s.line))
+	if back := len(s.line) - s.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// Inserts runes at the cursor.
+func (s *lineState) insert(runes []rune) {
+	line := make([]rune, 0, len(s.line)+len(runes))
+	line = append(line, s.line[:s.pos]...)
+	line = append(line, runes...)
+	s.line = append(line, s.line[s.pos:]...)
+	s.pos += len(runes)
+}
+
+// Deletes the rune at the cursor.
+func (s *lineState) delete() {
+	if s.pos < len(s.line) {
+		s.line = append(s.line[:s.pos:s.pos], s.line[s.pos+1:]...)
+	}
+}
+
+func (s *lineState) left() {
+	if s.pos > 0 {
+		s.pos--
+	}
+}
+
+func (s *lineState) right() {
+	if s.pos < len(s.line) {
+		s.pos++
+	}
+}
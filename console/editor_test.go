@@ -0,0 +1,88 @@
+package console
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Creates an Editor editing lines typed as keys.
+func newTestEditor(keys string) *Editor {
+	e := NewEditor(strings.NewReader(keys), &bytes.Buffer{})
+	e.interactive = true
+	e.Complete = func(args []string, word string) []string {
+		if len(args) == 0 {
+			return []string{"getblock", "getblockhash", "getbalance", "help"}
+		}
+		return []string{"1437h8BZEGY32iDbQTnAkjtHMTKqaNtvvw"}
+	}
+	return e
+}
+
+func TestEditLine(t *testing.T) {
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"help\r", "help"},
+		{"hepl\x7f\x7flp\r", "help"},
+		{"elp\x1b[D\x1b[D\x1b[Dh\r", "help"},
+		{"lp\x01he\x05 x\x02\x1b[3~\r", "help "},
+		{"help me\x1b[D\x1b[D\x0b\r", "help "},
+		{"h\t\r", "help "},
+		{"getb\ta\t1\t\r", "getbalance 1437h8BZEGY32iDbQTnAkjtHMTKqaNtvvw "},
+		{"getblock\t\r", "getblock"},
+		{"x\x15help\r", "help"},
+	}
+
+	for _, test := range tests {
+		line, err := newTestEditor(test.keys).ReadLine()
+		if err != nil || line != test.expected {
+			t.Errorf("%q: expected line %q, got %q %v", test.keys, test.expected, line, err)
+		}
+	}
+}
+
+func TestEditHistory(t *testing.T) {
+	e := newTestEditor("\x1b[A\x1b[A\r" + "new\x1b[A\x1b[B\r" + "\x10\x10\x10\x0e\r")
+	e.SetHistory([]string{"getblockcount", "help", "help", ""})
+	if len(e.History()) != 2 {
+		t.Fatalf("expected repeated and empty lines to be skipped, got %q", e.History())
+	}
+
+	for _, expected := range []string{"getblockcount", "new", "help"} {
+		line, err := e.ReadLine()
+		if err != nil || line != expected {
+			t.Fatalf("expected line %q, got %q %v", expected, line, err)
+		}
+	}
+}
+
+func TestEditControlKeys(t *testing.T) {
+	e := newTestEditor("hel\x03\x04")
+	if _, err := e.ReadLine(); err != ErrInterrupt {
+		t.Fatalf("expected Ctrl-C to interrupt the line, got %v", err)
+	}
+	if _, err := e.ReadLine(); err != io.EOF {
+		t.Fatalf("expected Ctrl-D on an empty line to end the input, got %v", err)
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	// lines of readers other than terminals are read as they are
+	var out bytes.Buffer
+	e := NewEditor(strings.NewReader("help\r\ngetblockcount"), &out)
+	e.Prompt = "> "
+	for _, expected := range []string{"help", "getblockcount"} {
+		if line, err := e.ReadLine(); err != nil || line != expected {
+			t.Fatalf("expected line %q, got %q %v", expected, line, err)
+		}
+	}
+	if _, err := e.ReadLine(); err != io.EOF {
+		t.Fatalf("expected the end of the input, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected no prompt without a terminal, got %q", out.String())
+	}
+}
@@ -0,0 +1,126 @@
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Size of a screen which is not a terminal or whose size is unknown.
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// A Screen draws frames filling a terminal, in its alternate buffer so the shell is restored when the
+// Screen is closed. Keys are read in raw mode. Frames of other writers, e.g. pipes, are written one
+// after another without escape sequences.
+type Screen struct {
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int  // file descriptor of the terminal of out, -1 if it isn't a terminal
+	interactive bool // in and out are terminals
+	restore     func()
+}
+
+// Creates a Screen reading keys from in and drawing to out, terminals are switched to the alternate
+// buffer in raw mode. The Screen has to be closed to restore them.
+func NewScreen(in io.Reader, out io.Writer) (*Screen, error) {
+	s := &Screen{in: bufio.NewReader(in), out: out, fd: -1}
+	if f, ok := out.(*os.File); ok && isTerminal(int(f.Fd())) {
+		s.fd = int(f.Fd())
+	}
+	f, ok := in.(*os.File)
+	if !ok || s.fd < 0 || !isTerminal(int(f.Fd())) {
+		return s, nil
+	}
+
+	restore, err := makeRaw(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	s.interactive = true
+	// alternate buffer and hidden cursor
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	s.restore = func() {
+		fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+		restore()
+	}
+	return s, nil
+}
+
+// Checks if the Screen is a terminal redrawn in place.
+func (s *Screen) Interactive() bool {
+	return s.interactive
+}
+
+// Gets the number of columns and rows of the Screen.
+func (s *Screen) Size() (int, int) {
+	if s.fd >= 0 {
+		if width, height, err := terminalSize(s.fd); err == nil && width > 0 && height > 0 {
+			return width, height
+		}
+	}
+	return defaultWidth, defaultHeight
+}
+
+// Draws a frame of lines, they are cut to the width of the Screen and the lines below its height
+// are dropped. A terminal is redrawn from its top left corner.
+func (s *Screen) Draw(lines []string) {
+	width, height := s.Size()
+	if !s.interactive {
+		for _, line := range lines {
+			fmt.Fprintln(s.out, truncate(line, width))
+		}
+		return
+	}
+
+	var frame strings.Builder
+	frame.WriteString("\x1b[H")
+	for i := 0; i < height; i++ {
+		if i < len(lines) {
+			frame.WriteString(truncate(lines[i], width))
+		}
+		// the last line isn't ended, so the terminal doesn't scroll, raw mode keeps translating newlines
+		frame.WriteString("\x1b[K")
+		if i < height-1 {
+			frame.WriteString("\n")
+		}
+	}
+	fmt.Fprint(s.out, frame.String())
+}
+
+// Reads the keys pressed until the input ends, the channel is closed then.
+func (s *Screen) Keys() <-chan rune {
+	keys := make(chan rune)
+	go func() {
+		defer close(keys)
+		for {
+			r, _, err := s.in.ReadRune()
+			if err != nil {
+				return
+			}
+			keys <- r
+		}
+	}()
+	return keys
+}
+
+// Restores the terminal of the Screen.
+func (s *Screen) Close() {
+	if s.restore != nil {
+		s.restore()
+		s.restore = nil
+	}
+}
+
+// Cuts a line to a number of runes.
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
@@ -0,0 +1,28 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDrawFrame(t *testing.T) {
+	var out bytes.Buffer
+	s, err := NewScreen(strings.NewReader("q"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Interactive() {
+		t.Fatal("expected a buffer not to be a terminal")
+	}
+
+	s.Draw([]string{"tip", strings.Repeat("─", 100)})
+	expected := "tip\n" + strings.Repeat("─", defaultWidth) + "\n"
+	if out.String() != expected {
+		t.Fatalf("expected the lines cut to the width of the screen, got %q", out.String())
+	}
+	if key := <-s.Keys(); key != 'q' {
+		t.Fatalf("expected key q, got %q", key)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package console
+
+import "github.com/pkg/errors"
+
+// Terminals are not supported, lines are read as they are.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw mode is not supported")
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errors.New("terminals are not supported")
+}
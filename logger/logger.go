@@ -2,38 +2,164 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/pkgerrors"
 
 	"github.com/michaljirman/goblockchain/config"
+)
 
-	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+// Subsystems logging with levels of their own.
+const (
+	Chain  = "chain"  // blocks, transactions and the UTXO set
+	Wallet = "wallet" // keys and wallet files
+	PoW    = "pow"    // mining and mining pools
+	DB     = "db"     // storage and its migrations, including Badger's own logs
+	Net    = "net"    // outgoing notifications, e.g. webhooks
+	RPC    = "rpc"    // the JSON-RPC server and its subscribers
+)
+
+var (
+	mu sync.Mutex
+	// writers of the loggers of subsystems, filtering their events by level
+	writers = make(map[string]*levelWriter)
+	loggers = make(map[string]*zerolog.Logger)
+	// the output of all loggers, stderr until SetupLogger is called
+	output atomic.Value
+	// the file output, closed when the output changes
+	file io.Closer
+	// set by Discard, 1 when the output of all loggers is discarded
+	discarded int32
 )
 
-// Setups up a global logger based on a logging configuration provided.
+func init() {
+	output.Store(writerHolder{os.Stderr})
+}
+
+// Holds an output, atomic.Value requires values of the same concrete type.
+type writerHolder struct {
+	io.Writer
+}
+
+// A levelWriter writes the events of a subsystem at or above its level to the output.
+type levelWriter struct {
+	level int32 // zerolog.Level
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	return output.Load().(writerHolder).Write(p)
+}
+
+func (w *levelWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < zerolog.Level(atomic.LoadInt32(&w.level)) {
+		return len(p), nil
+	}
+	return w.Write(p)
+}
+
+// Gets the logger of a subsystem, packages keep it in a variable. Its level and output are set by
+// SetupLogger, which can be called at any time, until then it logs every level to stderr.
+func Get(subsystem string) *zerolog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := loggers[subsystem]; !ok {
+		register(subsystem)
+	}
+	return loggers[subsystem]
+}
+
+// Creates the logger of a subsystem, mu has to be held.
+func register(subsystem string) {
+	w := &levelWriter{level: int32(zerolog.DebugLevel)}
+	logger := zerolog.New(w).With().Timestamp().Str("subsystem", subsystem).Caller().Stack().Logger()
+	writers[subsystem], loggers[subsystem] = w, &logger
+}
+
+// Gets the levels of the subsystems of a logging configuration, subsystems without a level of their own
+// log at the default level.
+func subsystemLevels(cfg *config.LogConf) map[string]string {
+	levels := map[string]string{
+		Chain:  cfg.Chain,
+		Wallet: cfg.Wallet,
+		PoW:    cfg.PoW,
+		DB:     cfg.DB,
+		Net:    cfg.Net,
+		RPC:    cfg.RPC,
+	}
+	for subsystem, level := range levels {
+		if level == "" {
+			levels[subsystem] = cfg.Level
+		}
+	}
+	return levels
+}
+
+// Sets up the loggers of all subsystems based on a logging configuration provided: their levels and
+// their output, stderr or a file rotated by size.
 func SetupLogger(cfg *config.LogConf) error {
-	zerolog.TimeFieldFormat = ""
-	level, err := zerolog.ParseLevel(cfg.Level)
-	if err != nil {
-		log.Error().Err(err).Msg("error returned when parsing log level")
-		return err
+	levels := make(map[string]zerolog.Level)
+	for subsystem, name := range subsystemLevels(cfg) {
+		level, err := zerolog.ParseLevel(name)
+		if err != nil {
+			return fmt.Errorf("invalid log level %q of %s", name, subsystem)
+		}
+		levels[subsystem] = level
 	}
-	zerolog.SetGlobalLevel(level)
 
-	if cfg.DevelopmentLogger {
+	var out io.Writer = os.Stderr
+	var closer io.Closer
+	if atomic.LoadInt32(&discarded) == 1 {
+		out = io.Discard
+	} else if cfg.File != "" {
+		rotating, err := OpenRotatingFile(cfg.File, int64(cfg.MaxSize)<<20, cfg.MaxFiles)
+		if err != nil {
+			return err
+		}
+		out, closer = rotating, rotating
+	}
+
+	zerolog.TimeFieldFormat = ""
+	if cfg.DevelopmentLogger && out != io.Discard {
 		zerolog.ErrorStackMarshaler = func(err error) interface{} {
-			fmt.Println(string(debug.Stack()))
+			fmt.Fprintln(os.Stderr, string(debug.Stack()))
 			return nil
 		}
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}).With().Timestamp().Caller().Stack().Logger()
+		out = zerolog.ConsoleWriter{Out: out, NoColor: cfg.File != "", TimeFormat: time.RFC3339}
 	} else {
 		zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
-		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Caller().Stack().Logger()
+	}
 
+	mu.Lock()
+	defer mu.Unlock()
+	output.Store(writerHolder{out})
+	if file != nil {
+		file.Close()
+	}
+	file = closer
+	for subsystem := range levels {
+		if _, ok := writers[subsystem]; !ok {
+			register(subsystem)
+		}
+		atomic.StoreInt32(&writers[subsystem].level, int32(levels[subsystem]))
 	}
 	return nil
 }
+
+// Discards the output of all loggers from now on, SetupLogger then sets only their levels. Tests of
+// packages logging call it from TestMain, to keep their output readable.
+func Discard() {
+	mu.Lock()
+	defer mu.Unlock()
+	atomic.StoreInt32(&discarded, 1)
+	output.Store(writerHolder{io.Discard})
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+}
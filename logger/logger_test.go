@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/config"
+)
+
+func TestSubsystemLevels(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "node.log")
+	cfg := config.Default().Log
+	cfg.Level, cfg.PoW, cfg.DB, cfg.File = "warn", "debug", "error", file
+	if err := SetupLogger(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer SetupLogger(&config.LogConf{Level: "debug"})
+
+	Get(Chain).Info().Msg("hidden")
+	Get(Chain).Warn().Msg("chain warning")
+	Get(PoW).Debug().Msg("pow debug")
+	Get(DB).Warn().Msg("hidden")
+	Get(DB).Error().Msg("db error")
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry struct{ Subsystem, Message string }
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		logged = append(logged, entry.Subsystem+": "+entry.Message)
+	}
+	expected := "chain: chain warning,pow: pow debug,db: db error"
+	if strings.Join(logged, ",") != expected {
+		t.Fatalf("expected %s, got %v", expected, logged)
+	}
+
+	if err := SetupLogger(&config.LogConf{Level: "info", Net: "verbose"}); err == nil {
+		t.Fatal("expected an invalid level of a subsystem to fail")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	r, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, entry := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every entry overflows the 10 bytes of the previous one, the first is removed beyond 2 rotated files
+	for name, expected := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil || string(data) != expected {
+			t.Errorf("expected %s to contain %q, got %q %v", name, expected, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no third rotated file, got %v", err)
+	}
+
+	// a reopened file continues from its size
+	r.Close()
+	if r, err = OpenRotatingFile(path, 10, 2); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("fifth\n"))
+	if data, _ := ioutil.ReadFile(path); string(data) != "fifth\n" {
+		t.Errorf("expected the reopened file to be rotated, got %q", data)
+	}
+}
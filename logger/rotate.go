@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A RotatingFile is a log file rotated when it reaches its maximum size: FILE is renamed to FILE.1,
+// FILE.1 to FILE.2 and so on, the oldest file beyond the number of rotated files kept is removed.
+type RotatingFile struct {
+	path     string
+	maxSize  int64 // bytes, the file isn't rotated if not positive
+	maxFiles int   // number of rotated files kept
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+}
+
+// Opens a log file appending to it, it is rotated once it exceeds maxSize bytes keeping maxFiles rotated files.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to open log file")
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Writes a log entry, the file is rotated first if the entry doesn't fit into it.
+// An entry larger than the maximum size is written to an empty file.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, errors.New("log file is closed")
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Renames the rotated files to make room for the current file, removes the oldest one
+// and opens a new file.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close log file")
+	}
+	r.f = nil
+	if r.maxFiles > 0 {
+		os.Remove(r.rotated(r.maxFiles))
+		for n := r.maxFiles - 1; n > 0; n-- {
+			if err := os.Rename(r.rotated(n), r.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "failed to rotate log file")
+			}
+		}
+		if err := os.Rename(r.path, r.rotated(1)); err != nil {
+			return errors.Wrap(err, "failed to rotate log file")
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return errors.Wrap(err, "failed to rotate log file")
+	}
+	return r.open()
+}
+
+// Gets the path of the nth rotated file.
+func (r *RotatingFile) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
+
+// Closes the file, later writes fail.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -17,8 +17,11 @@ import (
 
 //DEVELOPMENT_LOGGER=TRUE DEBUG=TRUE go run main.go createwallet
 //DEVELOPMENT_LOGGER=TRUE DEBUG=TRUE go run main.go listaddresses
+
+//DEVELOPMENT_LOGGER=TRUE DEBUG=TRUE go run main.go createrawtx -from "John" -to "fred" -amount 50 -file tx.json
+//DEVELOPMENT_LOGGER=TRUE DEBUG=TRUE go run main.go signrawtx -file tx.json
+//DEVELOPMENT_LOGGER=TRUE DEBUG=TRUE go run main.go submitrawtx -file tx.json
 func main() {
-	defer os.Exit(0)
 	cmd := cli.CommandLine{}
-	cmd.Run()
+	os.Exit(cmd.Run(os.Args[1:]))
 }
@@ -0,0 +1,195 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+)
+
+// A Client calls methods of a JSON-RPC Server.
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+	// credentials of HTTP basic authentication, sent if User is not empty
+	User     string
+	Password string
+	nextID   uint64
+}
+
+// Creates a new Client of a server at url, e.g. http://localhost:8332.
+func NewClient(url string) *Client {
+	return &Client{
+		URL:        url,
+		HTTPClient: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Calls a method with params and decodes its result into result, unless result is nil.
+// A failed call returns an *Error.
+func (c *Client) Call(method string, result interface{}, params ...interface{}) error {
+	rawParams := make([]json.RawMessage, 0, len(params))
+	for _, param := range params {
+		encoded, err := json.Marshal(param)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode %s params", method)
+		}
+		rawParams = append(rawParams, encoded)
+	}
+
+	id, _ := json.Marshal(atomic.AddUint64(&c.nextID, 1))
+	body, err := json.Marshal(Request{JSONRPC: "1.0", ID: id, Method: method, Params: rawParams})
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode %s request", method)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "invalid server URL")
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.User != "" {
+		httpReq.SetBasicAuth(c.User, c.Password)
+	}
+	httpResp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call %s", method)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode == http.StatusUnauthorized {
+		return errors.Errorf("failed to call %s: the server requires an RPC user and password", method)
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return errors.Wrapf(err, "failed to decode %s response", method)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(resp.Result, result), "failed to decode %s result", method)
+}
+
+// Gets the hash of the last block of the chain.
+func (c *Client) GetBestBlockHash() ([]byte, error) {
+	var hash string
+	if err := c.Call("getbestblockhash", &hash); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(hash)
+}
+
+// Gets the height of the last block of the chain.
+func (c *Client) GetBlockCount() (int, error) {
+	var height int
+	err := c.Call("getblockcount", &height)
+	return height, err
+}
+
+// Gets the names of the methods of the server.
+func (c *Client) Help() ([]string, error) {
+	var methods []string
+	err := c.Call("help", &methods)
+	return methods, err
+}
+
+// Gets the hash of the block at a height of the chain.
+func (c *Client) GetBlockHash(height int) (string, error) {
+	var hash string
+	err := c.Call("getblockhash", &hash, height)
+	return hash, err
+}
+
+// Gets a block of the chain by its hex encoded hash.
+func (c *Client) GetBlock(hash string) (*BlockResult, error) {
+	var result BlockResult
+	if err := c.Call("getblock", &result, hash); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Gets a transaction of the chain or the mempool by its hex encoded ID.
+func (c *Client) GetTransaction(txID string) (*TxResult, error) {
+	var result TxResult
+	if err := c.Call("gettransaction", &result, txID); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Gets the balance of an address.
+func (c *Client) GetBalance(address string) (*BalanceResult, error) {
+	var result BalanceResult
+	if err := c.Call("getbalance", &result, address); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Gets the IDs of the mempool transactions.
+func (c *Client) GetMempoolInfo() (*MempoolInfoResult, error) {
+	var result MempoolInfoResult
+	if err := c.Call("getmempoolinfo", &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Gets the state of mining, e.g. the network hash rate.
+func (c *Client) GetMiningInfo() (*MiningInfoResult, error) {
+	var result MiningInfoResult
+	if err := c.Call("getmininginfo", &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Gets the clients subscribed to chain events of the server.
+func (c *Client) GetPeerInfo() ([]*PeerResult, error) {
+	var result []*PeerResult
+	err := c.Call("getpeerinfo", &result)
+	return result, err
+}
+
+// Gets a template of the next block to be mined.
+func (c *Client) GetBlockTemplate() (*blockchain.BlockTemplate, error) {
+	var result BlockTemplateResult
+	if err := c.Call("getblocktemplate", &result); err != nil {
+		return nil, err
+	}
+	return result.BlockTemplate()
+}
+
+// Submits a mined block, the server connects it to the chain if it is valid.
+func (c *Client) SubmitBlock(block *blockchain.Block) error {
+	return c.Call("submitblock", nil, hex.EncodeToString(block.Serialize()))
+}
+
+// Sends a signed transaction to the server mempool, returns its ID.
+func (c *Client) SendRawTransaction(tx *blockchain.Transaction) (string, error) {
+	var txID string
+	err := c.Call("sendrawtransaction", &txID, hex.EncodeToString(tx.Serialize()))
+	return txID, err
+}
+
+// Registers a webhook notified when an address receives coins, the result carries the secret signing payloads.
+func (c *Client) WatchAddress(address, webhookURL string) (*WatchResult, error) {
+	var result WatchResult
+	if err := c.Call("watchaddress", &result, address, webhookURL); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,187 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+)
+
+const (
+	// Path of the WebSocket endpoint streaming chain events.
+	EventsPath = "/events"
+	// Number of events buffered for a WebSocket subscriber before events are dropped.
+	eventsBuffer = 256
+	// How long sending an event to a WebSocket subscriber may take.
+	eventWriteTimeout = 10 * time.Second
+)
+
+// An eventFilter selects the events sent to a WebSocket subscriber.
+type eventFilter struct {
+	types     map[string]bool // all types if empty
+	addresses map[string]bool // activity of all addresses if empty
+}
+
+// Parses the filter from the query of a subscription request,
+// e.g. ?types=blockconnected,addressactivity&address=ADDRESS1&address=ADDRESS2.
+func parseEventFilter(query url.Values) eventFilter {
+	filter := eventFilter{types: make(map[string]bool), addresses: make(map[string]bool)}
+	for _, types := range query["types"] {
+		for _, eventType := range strings.Split(types, ",") {
+			if eventType != "" {
+				filter.types[eventType] = true
+			}
+		}
+	}
+	for _, address := range query["address"] {
+		filter.addresses[address] = true
+	}
+	return filter
+}
+
+func (f eventFilter) match(event blockchain.Event) bool {
+	if len(f.types) > 0 && !f.types[string(event.Type)] {
+		return false
+	}
+	if event.Type == blockchain.AddressActivity && len(f.addresses) > 0 && !f.addresses[event.Address] {
+		return false
+	}
+	return true
+}
+
+// Lists the keys of a set sorted.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Registers a WebSocket subscriber as a peer listed by getpeerinfo.
+func (s *Server) addPeer(ws *websocket.Conn, filter eventFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers[ws] = &PeerResult{
+		Addr:      ws.Request().RemoteAddr,
+		ConnTime:  time.Now().Unix(),
+		Types:     sortedKeys(filter.types),
+		Addresses: sortedKeys(filter.addresses),
+	}
+}
+
+func (s *Server) removePeer(ws *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.peers, ws)
+}
+
+// Streams chain events matching the filter of the request to a WebSocket subscriber as JSON messages.
+func (s *Server) serveEvents(ws *websocket.Conn) {
+	defer ws.Close()
+
+	filter := parseEventFilter(ws.Request().URL.Query())
+	sub := s.chain.Subscribe(eventsBuffer)
+	defer sub.Unsubscribe()
+	s.addPeer(ws, filter)
+	defer s.removePeer(ws)
+	log.Debug().Str("remote", ws.Request().RemoteAddr).Msg("events subscriber connected")
+
+	// subscribers don't send anything, reading detects when they disconnect
+	disconnected := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, ws)
+		close(disconnected)
+	}()
+
+	for {
+		select {
+		case event := <-sub.C:
+			if !filter.match(event) {
+				continue
+			}
+			ws.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := websocket.JSON.Send(ws, NewEventNotification(event)); err != nil {
+				log.Debug().Err(err).Msg("failed to send event")
+				return
+			}
+		case <-disconnected:
+			log.Debug().Str("remote", ws.Request().RemoteAddr).Msg("events subscriber disconnected")
+			return
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// Subscribes to chain events of the server, types and addresses filter the events when they are not empty.
+// Notifications are received until ctx is cancelled or the connection fails, the channel is closed then.
+func (c *Client) SubscribeEvents(ctx context.Context, types []string, addresses []string) (<-chan *EventNotification, error) {
+	wsURL, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid server URL")
+	}
+	origin := *wsURL
+	switch wsURL.Scheme {
+	case "https":
+		wsURL.Scheme = "wss"
+	default:
+		wsURL.Scheme = "ws"
+	}
+	wsURL.Path = EventsPath
+	query := url.Values{}
+	if len(types) > 0 {
+		query.Set("types", strings.Join(types, ","))
+	}
+	for _, address := range addresses {
+		query.Add("address", address)
+	}
+	wsURL.RawQuery = query.Encode()
+
+	wsConfig, err := websocket.NewConfig(wsURL.String(), origin.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid server URL")
+	}
+	if c.User != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(c.User + ":" + c.Password))
+		wsConfig.Header.Set("Authorization", "Basic "+credentials)
+	}
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to subscribe to events")
+	}
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	notifications := make(chan *EventNotification)
+	go func() {
+		defer close(notifications)
+		for {
+			var notification EventNotification
+			if err := websocket.JSON.Receive(ws, &notification); err != nil {
+				if ctx.Err() == nil {
+					log.Debug().Err(err).Msg("events subscription failed")
+				}
+				return
+			}
+			select {
+			case notifications <- &notification:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return notifications, nil
+}
@@ -0,0 +1,134 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// Receives the next notification of a subscription, failing if none arrives.
+func nextNotification(t *testing.T, notifications <-chan *EventNotification) *EventNotification {
+	t.Helper()
+	select {
+	case notification, ok := <-notifications:
+		if !ok {
+			t.Fatal("subscription closed unexpectedly")
+		}
+		return notification
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a notification")
+	}
+	return nil
+}
+
+// Waits until the chain has a number of event subscribers.
+func waitForSubscribers(t *testing.T, server *testServer, subscribers int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.chain.Subscribers() != subscribers {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers, got %d", subscribers, server.chain.Subscribers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all, err := server.client.SubscribeEvents(ctx, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocksCtx, cancelBlocks := context.WithCancel(context.Background())
+	defer cancelBlocks()
+	blocks, err := server.client.SubscribeEvents(blocksCtx, []string{"blockconnected"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscribers(t, server, 2)
+
+	receiver := string(wallet.MakeWallet().Address())
+	rawTx, err := server.chain.CreateRawTransaction(server.address, receiver, 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawTx.Sign(server.wallets)
+	txID, err := server.client.SendRawTransaction(&rawTx.Tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := nextNotification(t, all); n.Type != "txaccepted" || n.TxID != txID {
+		t.Fatalf("expected transaction %s to be accepted, got %+v", txID, n)
+	}
+	for _, address := range []string{server.address, receiver} {
+		if n := nextNotification(t, all); n.Type != "addressactivity" || n.Address != address || n.BlockHash != "" {
+			t.Fatalf("expected mempool activity of %s, got %+v", address, n)
+		}
+	}
+
+	template, err := server.client.GetBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := mineTemplate(t, template)
+	if err := server.client.SubmitBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	blockHash := hex.EncodeToString(block.Hash)
+	for _, notifications := range []<-chan *EventNotification{all, blocks} {
+		if n := nextNotification(t, notifications); n.Type != "blockconnected" || n.BlockHash != blockHash || n.Height != 1 {
+			t.Fatalf("expected block %s to be connected, got %+v", blockHash, n)
+		}
+	}
+	if n := nextNotification(t, all); n.Type != "addressactivity" || n.BlockHash != blockHash {
+		t.Fatalf("expected activity of the block's coinbase, got %+v", n)
+	}
+
+	peers, err := server.client.GetPeerInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("expected both subscribers to be peers, got %d", len(peers))
+	}
+
+	// disconnecting releases the subscription of the chain's event bus
+	cancelBlocks()
+	waitForSubscribers(t, server, 1)
+	cancel()
+	waitForSubscribers(t, server, 0)
+	peers, err = server.client.GetPeerInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("expected disconnected subscribers to be removed from the peers, got %d", len(peers))
+	}
+}
+
+func TestServerCloseReleasesSubscriptions(t *testing.T) {
+	server := newTestServer(t)
+
+	notifications, err := server.client.SubscribeEvents(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscribers(t, server, 1)
+
+	close(server.closing)
+	waitForSubscribers(t, server, 0)
+	select {
+	case _, ok := <-notifications:
+		if ok {
+			t.Error("expected no notifications after the server closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the subscription to be closed with the server")
+	}
+}
@@ -0,0 +1,5 @@
+package rpc
+
+import "github.com/michaljirman/goblockchain/logger"
+
+var log = logger.Get(logger.RPC)
@@ -0,0 +1,13 @@
+package rpc
+
+import (
+	"os"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Discard()
+	os.Exit(m.Run())
+}
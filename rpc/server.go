@@ -0,0 +1,392 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/wallet"
+	"github.com/michaljirman/goblockchain/webhook"
+)
+
+// Maximum size of a request body.
+const maxRequestSize = 32 << 20
+
+// A handler executes a JSON-RPC method with its params.
+type handler func(s *Server, params []json.RawMessage) (interface{}, error)
+
+// Methods served by the Server.
+var handlers = map[string]handler{
+	"getbestblockhash":   handleGetBestBlockHash,
+	"getblockcount":      handleGetBlockCount,
+	"getblockhash":       handleGetBlockHash,
+	"getblock":           handleGetBlock,
+	"gettransaction":     handleGetTransaction,
+	"getbalance":         handleGetBalance,
+	"getmempoolinfo":     handleGetMempoolInfo,
+	"getmininginfo":      handleGetMiningInfo,
+	"getpeerinfo":        handleGetPeerInfo,
+	"getblocktemplate":   handleGetBlockTemplate,
+	"submitblock":        handleSubmitBlock,
+	"sendrawtransaction": handleSendRawTransaction,
+	"watchaddress":       handleWatchAddress,
+}
+
+func init() {
+	// help lists the handlers, so it can't be part of their initialization
+	handlers["help"] = handleHelp
+}
+
+// A Server exposes a blockchain and its mempool over JSON-RPC on HTTP.
+// Chain events are streamed to WebSocket subscribers at EventsPath.
+type Server struct {
+	chain   *blockchain.BlockChain
+	mempool *blockchain.Mempool
+	mu      sync.Mutex    // guards peers, the chain and the mempool are safe for concurrent use
+	closing chan struct{} // closed when the server stops, disconnects WebSocket subscribers
+	peers   map[*websocket.Conn]*PeerResult
+	// credentials of HTTP basic authentication, requests aren't authenticated if user is empty
+	user, password string
+}
+
+// Creates a new Server for a chain with an empty mempool.
+func NewServer(chain *blockchain.BlockChain) *Server {
+	return &Server{
+		chain:   chain,
+		mempool: blockchain.NewMempool(),
+		closing: make(chan struct{}),
+		peers:   make(map[*websocket.Conn]*PeerResult),
+	}
+}
+
+// Requires requests and subscribers to authenticate with HTTP basic authentication.
+func (s *Server) RequireAuth(user, password string) {
+	s.user, s.password = user, password
+}
+
+// Gets the HTTP handler of JSON-RPC requests and of WebSocket subscribers at EventsPath.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", s)
+	// subscribers aren't browsers only, so their origin isn't checked
+	mux.Handle(EventsPath, websocket.Server{Handler: s.serveEvents})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goblockchain"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Checks the credentials of a request if the server requires authentication.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.user == "" {
+		return true
+	}
+	user, password, ok := r.BasicAuth()
+	// both are compared, so the time taken doesn't tell which one is wrong
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+	return ok && userOK && passwordOK
+}
+
+// Serves JSON-RPC on an address until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler()}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	log.Info().Str("addr", addr).Msg("RPC server started")
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "RPC server failed")
+	case <-ctx.Done():
+	}
+	// hijacked WebSocket connections are not closed by Shutdown
+	close(s.closing)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return errors.Wrap(server.Shutdown(shutdownCtx), "failed to stop RPC server")
+}
+
+// Handles a single JSON-RPC request sent with POST.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req Request
+	var resp Response
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		resp.Error = &Error{ErrCodeParse, "failed to parse request"}
+	} else {
+		resp.ID = req.ID
+		resp.Result, resp.Error = s.call(req.Method, req.Params)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error().Err(err).Msg("failed to write RPC response")
+	}
+}
+
+// Calls a method, errors which are not JSON-RPC errors are reported as internal errors.
+func (s *Server) call(method string, params []json.RawMessage) (interface{}, *Error) {
+	h, ok := handlers[method]
+	if !ok {
+		return nil, &Error{ErrCodeMethodNotFound, "method not found: " + method}
+	}
+
+	result, err := h(s, params)
+	if err != nil {
+		log.Debug().Err(err).Str("method", method).Msg("RPC call failed")
+		if rpcErr, ok := err.(*Error); ok {
+			return nil, rpcErr
+		}
+		return nil, &Error{ErrCodeInternal, err.Error()}
+	}
+	return result, nil
+}
+
+// Decodes a string parameter.
+func stringParam(params []json.RawMessage, idx int, name string) (string, error) {
+	if idx >= len(params) {
+		return "", &Error{ErrCodeInvalidParams, "missing parameter " + name}
+	}
+	var value string
+	if err := json.Unmarshal(params[idx], &value); err != nil {
+		return "", &Error{ErrCodeInvalidParams, name + " must be a string"}
+	}
+	return value, nil
+}
+
+// Decodes an integer parameter.
+func intParam(params []json.RawMessage, idx int, name string) (int, error) {
+	if idx >= len(params) {
+		return 0, &Error{ErrCodeInvalidParams, "missing parameter " + name}
+	}
+	var value int
+	if err := json.Unmarshal(params[idx], &value); err != nil {
+		return 0, &Error{ErrCodeInvalidParams, name + " must be an integer"}
+	}
+	return value, nil
+}
+
+// Decodes a hex encoded string parameter.
+func hexParam(params []json.RawMessage, idx int, name string) ([]byte, error) {
+	encoded, err := stringParam(params, idx, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, name + " must be hex encoded"}
+	}
+	return data, nil
+}
+
+func handleGetBestBlockHash(s *Server, params []json.RawMessage) (interface{}, error) {
+	return hex.EncodeToString(s.chain.LastBlock().Hash), nil
+}
+
+func handleGetBlockCount(s *Server, params []json.RawMessage) (interface{}, error) {
+	return s.chain.LastBlock().Height, nil
+}
+
+// Lists the methods of the server.
+func handleHelp(s *Server, params []json.RawMessage) (interface{}, error) {
+	methods := make([]string, 0, len(handlers))
+	for method := range handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods, nil
+}
+
+// Gets the hash of the block at a height, the headers of pruned blocks are searched as well.
+func handleGetBlockHash(s *Server, params []json.RawMessage) (interface{}, error) {
+	height, err := intParam(params, 0, "height")
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.chain.HashAtHeight(height)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, err.Error()}
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// Gets a block by its hash with the IDs of its transactions.
+func handleGetBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+	hash, err := hexParam(params, 0, "hash")
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.chain.GetBlock(hash)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, err.Error()}
+	}
+	return NewBlockResult(block, s.chain.Confirmations(block.Height)), nil
+}
+
+// Gets a transaction of the chain or the mempool by its ID with the outputs it spends.
+func handleGetTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+	txID, err := hexParam(params, 0, "txid")
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range s.mempool.Transactions() {
+		if bytes.Equal(tx.ID, txID) {
+			return NewTxResult(tx, s.chain.PrevOutputs(tx), nil, 0), nil
+		}
+	}
+	tx, block, err := s.chain.FindTransactionBlock(txID)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, err.Error()}
+	}
+	return NewTxResult(&tx, s.chain.PrevOutputs(&tx), block, s.chain.Confirmations(block.Height)), nil
+}
+
+// Gets the balance of an address, coinbase rewards which can't be spent yet are immature.
+func handleGetBalance(s *Server, params []json.RawMessage) (interface{}, error) {
+	address, err := stringParam(params, 0, "address")
+	if err != nil {
+		return nil, err
+	}
+	lockingScript, err := blockchain.LockingScriptForAddress(address)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, "invalid address " + address}
+	}
+	balance, immature := s.chain.GetBalance(lockingScript)
+	return &BalanceResult{address, balance, immature}, nil
+}
+
+// Gets the IDs of the mempool transactions in the order they were accepted.
+func handleGetMempoolInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+	result := &MempoolInfoResult{Transactions: []string{}}
+	for _, tx := range s.mempool.Transactions() {
+		result.Transactions = append(result.Transactions, hex.EncodeToString(tx.ID))
+	}
+	result.Size = len(result.Transactions)
+	return result, nil
+}
+
+// Number of blocks the network hash rate of getmininginfo is estimated from.
+const hashRateBlocks = 120
+
+// Gets the state of mining: the last block, the network hash rate and the size of the mempool.
+func handleGetMiningInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+	hashRate, err := s.chain.NetworkHashRate(hashRateBlocks)
+	if err != nil {
+		return nil, err
+	}
+	last := s.chain.LastBlock()
+	return &MiningInfoResult{
+		Network:     s.chain.Params.Name,
+		Consensus:   s.chain.Params.Consensus,
+		Blocks:      last.Height,
+		Difficulty:  last.Difficulty,
+		NetworkHash: hashRate,
+		PooledTx:    len(s.mempool.Transactions()),
+	}, nil
+}
+
+// Lists the clients subscribed to chain events, the node has no other peers.
+func handleGetPeerInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*PeerResult, 0, len(s.peers))
+	for _, peer := range s.peers {
+		result = append(result, peer)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ConnTime < result[j].ConnTime })
+	return result, nil
+}
+
+func handleGetBlockTemplate(s *Server, params []json.RawMessage) (interface{}, error) {
+	if _, ok := s.chain.Engine.(*blockchain.ProofOfWorkEngine); !ok {
+		return nil, &Error{ErrCodeInvalidRequest, "blocks of a " + s.chain.Params.Consensus + " chain are not mined"}
+	}
+	return NewBlockTemplateResult(s.chain.NewBlockTemplate(s.mempool)), nil
+}
+
+// Validates a mined block, connects it to the chain and removes its transactions from the mempool.
+func handleSubmitBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+	data, err := hexParam(params, 0, "block")
+	if err != nil {
+		return nil, err
+	}
+	block, err := blockchain.DecodeBlock(data)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, err.Error()}
+	}
+
+	if err := s.chain.SubmitBlock(block); err != nil {
+		// a proposer signing a block conflicting with the chain is slashed by the next block
+		if evidence, _ := s.chain.FindDoubleSign(block); evidence != nil {
+			if err := s.chain.AddEvidence(evidence); err == nil {
+				log.Warn().Hex("signer", block.Signer).Int("height", block.Height).Msg("double sign evidence recorded")
+			}
+		}
+		return nil, &Error{ErrCodeVerify, "block rejected: " + err.Error()}
+	}
+	s.mempool.Remove(block.Transactions)
+	log.Info().Int("height", block.Height).Hex("hash", block.Hash).Msg("block submitted")
+	return nil, nil
+}
+
+// Validates a signed transaction and adds it to the mempool, returns the transaction ID.
+func handleSendRawTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+	data, err := hexParam(params, 0, "transaction")
+	if err != nil {
+		return nil, err
+	}
+	tx, err := blockchain.DecodeTransaction(data)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, err.Error()}
+	}
+
+	if err := s.mempool.Add(tx, s.chain); err != nil {
+		return nil, &Error{ErrCodeVerify, "transaction rejected: " + err.Error()}
+	}
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// Registers a webhook notified when an address receives coins, returns the watch with its signing secret.
+func handleWatchAddress(s *Server, params []json.RawMessage) (interface{}, error) {
+	address, err := stringParam(params, 0, "address")
+	if err != nil {
+		return nil, err
+	}
+	webhookURL, err := stringParam(params, 1, "url")
+	if err != nil {
+		return nil, err
+	}
+	if !wallet.ValidateAddress(address) {
+		return nil, &Error{ErrCodeInvalidParams, "invalid address " + address}
+	}
+
+	watch, err := webhook.AddWatch(s.chain.Database, address, webhookURL)
+	if err != nil {
+		return nil, &Error{ErrCodeInvalidParams, err.Error()}
+	}
+	return &WatchResult{watch.Address, watch.URL, watch.Secret}, nil
+}
@@ -0,0 +1,195 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+const (
+	testUser     = "miner"
+	testPassword = "secret"
+)
+
+// A testServer serves a regtest chain whose Genesis reward belongs to a wallet of the test.
+type testServer struct {
+	*Server
+	client  *Client
+	url     string
+	wallets *wallet.Wallets
+	address string // receives the Genesis reward
+}
+
+// Creates a chain with spendable coinbase outputs and serves it over HTTP with basic authentication.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	w := wallet.MakeWallet()
+	address := string(w.Address())
+	params := blockchain.RegTestParams
+	params.CoinbaseMaturity = 1
+	chain, err := blockchain.NewBlockChain(storage.NewMemoryStore(), address, params)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+
+	server := NewServer(chain)
+	server.RequireAuth(testUser, testPassword)
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+
+	client := NewClient(httpServer.URL)
+	client.User, client.Password = testUser, testPassword
+	return &testServer{
+		Server:  server,
+		client:  client,
+		url:     httpServer.URL,
+		wallets: &wallet.Wallets{Wallets: map[string]*wallet.Wallet{address: w}},
+		address: address,
+	}
+}
+
+// Mines a block of a template paying the reward to a new address.
+func mineTemplate(t *testing.T, template *blockchain.BlockTemplate) *blockchain.Block {
+	t.Helper()
+	block, err := template.NewBlock(string(wallet.MakeWallet().Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blockchain.NewMiner().Mine(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// Checks that err is a JSON-RPC error with a code.
+func expectRPCError(t *testing.T, err error, code int, contains string) {
+	t.Helper()
+	rpcErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected a JSON-RPC error, got %v", err)
+	}
+	if rpcErr.Code != code || !strings.Contains(rpcErr.Message, contains) {
+		t.Errorf("expected error code %d containing %q, got %v", code, contains, rpcErr)
+	}
+}
+
+func TestSubmitMinedTemplate(t *testing.T) {
+	server := newTestServer(t)
+
+	rawTx, err := server.chain.CreateRawTransaction(server.address, string(wallet.MakeWallet().Address()), 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawTx.Sign(server.wallets)
+	txID, err := server.client.SendRawTransaction(&rawTx.Tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.client.SendRawTransaction(&rawTx.Tx); err == nil {
+		t.Error("expected a transaction already in the mempool to be rejected")
+	} else {
+		expectRPCError(t, err, ErrCodeVerify, "transaction rejected")
+	}
+
+	template, err := server.client.GetBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.Height != 1 || len(template.Transactions) != 1 {
+		t.Fatalf("expected a template at height 1 with the sent transaction, got height %d with %d transactions",
+			template.Height, len(template.Transactions))
+	}
+	if !bytes.Equal(template.Transactions[0].ID, rawTx.Tx.ID) {
+		t.Errorf("expected the template to select transaction %s", txID)
+	}
+
+	block := mineTemplate(t, template)
+	if err := server.client.SubmitBlock(block); err != nil {
+		t.Fatalf("expected the mined template to be accepted, got %v", err)
+	}
+
+	hash, err := server.client.GetBestBlockHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, block.Hash) {
+		t.Errorf("expected the submitted block %x to be the tip, got %x", block.Hash, hash)
+	}
+	mempool, err := server.client.GetMempoolInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mempool.Size != 0 {
+		t.Errorf("expected the mined transaction to leave the mempool, got %v", mempool.Transactions)
+	}
+}
+
+func TestSubmitRejectedBlocks(t *testing.T) {
+	server := newTestServer(t)
+
+	template, err := server.client.GetBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := mineTemplate(t, template), mineTemplate(t, template)
+	if err := server.client.SubmitBlock(first); err != nil {
+		t.Fatal(err)
+	}
+	// the second block was mined on the previous tip
+	expectRPCError(t, server.client.SubmitBlock(second), ErrCodeVerify, "block rejected")
+
+	template, err = server.client.GetBlockTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.CoinbaseValue++
+	expectRPCError(t, server.client.SubmitBlock(mineTemplate(t, template)), ErrCodeVerify, "block rejected")
+
+	expectRPCError(t, server.client.Call("submitblock", nil, "not hex"), ErrCodeInvalidParams, "must be hex encoded")
+	expectRPCError(t, server.client.Call("submitblock", nil, "00"), ErrCodeInvalidParams, "")
+	expectRPCError(t, server.client.Call("sendrawtransaction", nil, "00"), ErrCodeInvalidParams, "")
+
+	height, err := server.client.GetBlockCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 1 {
+		t.Errorf("expected rejected blocks to leave the chain at height 1, got %d", height)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server := newTestServer(t)
+
+	client := NewClient(server.url)
+	client.User, client.Password = testUser, "wrong"
+	if _, err := client.GetBlockTemplate(); err == nil || !strings.Contains(err.Error(), "requires an RPC user and password") {
+		t.Errorf("expected a wrong password to be refused, got %v", err)
+	}
+	client.User, client.Password = "", ""
+	if _, err := client.GetBlockCount(); err == nil {
+		t.Error("expected a request without credentials to be refused")
+	}
+
+	resp, err := http.Post(server.url, "application/json", strings.NewReader(`{"method":"getblockcount"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("expected an authentication challenge, got status %d", resp.StatusCode)
+	}
+
+	if _, err := server.client.GetBlockCount(); err != nil {
+		t.Errorf("expected valid credentials to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+)
+
+// Error codes of JSON-RPC errors.
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+	ErrCodeVerify         = -25 // a submitted block or transaction was rejected
+)
+
+// A Request is a JSON-RPC request, params are passed by position.
+type Request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// A Response is a JSON-RPC response carrying either a result or an error.
+type Response struct {
+	Result interface{}     `json:"result"`
+	Error  *Error          `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// An Error is returned by a failed JSON-RPC call.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// A BlockTemplateResult is the result of getblocktemplate, byte fields are hex encoded.
+type BlockTemplateResult struct {
+	PreviousBlockHash string                `json:"previousblockhash"`
+	Height            int                   `json:"height"`
+	CurTime           int64                 `json:"curtime"`
+	Difficulty        int                   `json:"difficulty"`
+	Target            string                `json:"target"`
+	CoinbaseValue     int                   `json:"coinbasevalue"`
+	Transactions      []TemplateTransaction `json:"transactions"`
+}
+
+// A TemplateTransaction is a mempool transaction selected into a block template.
+type TemplateTransaction struct {
+	Data string `json:"data"` // serialized transaction
+	TxID string `json:"txid"`
+	Fee  int    `json:"fee"`
+}
+
+// Encodes a block template into the getblocktemplate result.
+func NewBlockTemplateResult(template *blockchain.BlockTemplate) *BlockTemplateResult {
+	result := &BlockTemplateResult{
+		PreviousBlockHash: hex.EncodeToString(template.PrevHash),
+		Height:            template.Height,
+		CurTime:           template.Timestamp,
+		Difficulty:        template.Difficulty,
+		Target:            fmt.Sprintf("%064x", template.Target),
+		CoinbaseValue:     template.CoinbaseValue,
+		Transactions:      []TemplateTransaction{},
+	}
+	for i, tx := range template.Transactions {
+		result.Transactions = append(result.Transactions, TemplateTransaction{
+			Data: hex.EncodeToString(tx.Serialize()),
+			TxID: hex.EncodeToString(tx.ID),
+			Fee:  template.Fees[i],
+		})
+	}
+	return result
+}
+
+// Decodes the getblocktemplate result into a block template.
+func (result *BlockTemplateResult) BlockTemplate() (*blockchain.BlockTemplate, error) {
+	prevHash, err := hex.DecodeString(result.PreviousBlockHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid previous block hash")
+	}
+	target, ok := new(big.Int).SetString(result.Target, 16)
+	if !ok {
+		return nil, errors.Errorf("invalid target %s", result.Target)
+	}
+
+	template := &blockchain.BlockTemplate{
+		PrevHash:      prevHash,
+		Height:        result.Height,
+		Timestamp:     result.CurTime,
+		Difficulty:    result.Difficulty,
+		Target:        target,
+		CoinbaseValue: result.CoinbaseValue,
+	}
+	for _, templateTx := range result.Transactions {
+		data, err := hex.DecodeString(templateTx.Data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid transaction %s", templateTx.TxID)
+		}
+		tx, err := blockchain.DecodeTransaction(data)
+		if err != nil {
+			return nil, err
+		}
+		template.Transactions = append(template.Transactions, tx)
+		template.Fees = append(template.Fees, templateTx.Fee)
+	}
+	return template, nil
+}
+
+// A BlockResult is the result of getblock, hashes are hex encoded.
+type BlockResult struct {
+	Hash          string   `json:"hash"`
+	PrevHash      string   `json:"previousblockhash"`
+	Height        int      `json:"height"`
+	Time          int64    `json:"time"`
+	Difficulty    int      `json:"difficulty"`
+	Nonce         int      `json:"nonce"`
+	Signer        string   `json:"signer,omitempty"` // public key of the proposer of a signed block
+	Confirmations int      `json:"confirmations"`
+	Transactions  []string `json:"tx"` // IDs of the transactions
+}
+
+// Encodes a block of the chain into the getblock result.
+func NewBlockResult(block *blockchain.Block, confirmations int) *BlockResult {
+	result := &BlockResult{
+		Hash:          hex.EncodeToString(block.Hash),
+		PrevHash:      hex.EncodeToString(block.PrevHash),
+		Height:        block.Height,
+		Time:          block.Timestamp,
+		Difficulty:    block.Difficulty,
+		Nonce:         block.Nonce,
+		Confirmations: confirmations,
+		Transactions:  []string{},
+	}
+	if block.Signer != nil {
+		result.Signer = hex.EncodeToString(block.Signer)
+	}
+	for _, tx := range block.Transactions {
+		result.Transactions = append(result.Transactions, hex.EncodeToString(tx.ID))
+	}
+	return result
+}
+
+// A TxResult is the result of gettransaction. A transaction of the mempool has no block.
+type TxResult struct {
+	TxID          string           `json:"txid"`
+	BlockHash     string           `json:"blockhash,omitempty"`
+	Height        int              `json:"height,omitempty"`
+	Confirmations int              `json:"confirmations"`
+	LockTime      uint32           `json:"locktime"`
+	Coinbase      bool             `json:"coinbase"`
+	Inputs        []TxInputResult  `json:"vin"`
+	Outputs       []TxOutputResult `json:"vout"`
+	Fee           *int             `json:"fee,omitempty"` // unknown if an output spent by an input was pruned
+}
+
+// A TxInputResult is an input of a transaction with the output it spends, if it is known.
+type TxInputResult struct {
+	TxID    string `json:"txid"`
+	Out     int    `json:"vout"`
+	Value   *int   `json:"value,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// A TxOutputResult is an output of a transaction, the address is empty unless it pays a standard address.
+type TxOutputResult struct {
+	Value   int    `json:"value"`
+	Address string `json:"address,omitempty"`
+}
+
+// Encodes a transaction into the gettransaction result, prevOuts are the outputs spent by its inputs,
+// see BlockChain.PrevOutputs. The block is nil for a mempool transaction.
+func NewTxResult(tx *blockchain.Transaction, prevOuts []*blockchain.TxOutput, block *blockchain.Block, confirmations int) *TxResult {
+	result := &TxResult{
+		TxID:     hex.EncodeToString(tx.ID),
+		LockTime: tx.LockTime,
+		Coinbase: tx.IsCoinbase(),
+		Inputs:   []TxInputResult{},
+		Outputs:  []TxOutputResult{},
+	}
+	if block != nil {
+		result.BlockHash, result.Height, result.Confirmations = hex.EncodeToString(block.Hash), block.Height, confirmations
+	}
+	for inIdx, in := range tx.Inputs {
+		input := TxInputResult{TxID: hex.EncodeToString(in.ID), Out: in.Out}
+		if prevOut := prevOuts[inIdx]; prevOut != nil {
+			value := prevOut.Value
+			input.Value = &value
+			input.Address, _ = prevOut.Address()
+		}
+		result.Inputs = append(result.Inputs, input)
+	}
+	for _, out := range tx.Outputs {
+		output := TxOutputResult{Value: out.Value}
+		output.Address, _ = out.Address()
+		result.Outputs = append(result.Outputs, output)
+	}
+	if fee, ok := blockchain.Fee(tx, prevOuts); ok {
+		result.Fee = &fee
+	}
+	return result
+}
+
+// A BalanceResult is the result of getbalance.
+type BalanceResult struct {
+	Address  string `json:"address"`
+	Balance  int    `json:"balance"`
+	Immature int    `json:"immature"` // coinbase rewards which can't be spent yet
+}
+
+// A MempoolInfoResult is the result of getmempoolinfo.
+type MempoolInfoResult struct {
+	Size         int      `json:"size"`
+	Transactions []string `json:"tx"` // IDs of the transactions in the order they were accepted
+}
+
+// A MiningInfoResult is the result of getmininginfo.
+type MiningInfoResult struct {
+	Network     string  `json:"network"`
+	Consensus   string  `json:"consensus"`
+	Blocks      int     `json:"blocks"`     // height of the last block
+	Difficulty  int     `json:"difficulty"` // difficulty of the last block
+	NetworkHash float64 `json:"networkhashps"`
+	PooledTx    int     `json:"pooledtx"`
+}
+
+// A PeerResult is a client subscribed to chain events, an item of the getpeerinfo result.
+type PeerResult struct {
+	Addr      string   `json:"addr"`
+	ConnTime  int64    `json:"conntime"` // unix time of the subscription
+	Types     []string `json:"types"`    // types of the events sent, all types if empty
+	Addresses []string `json:"addresses"`
+}
+
+// A WatchResult is the result of watchaddress.
+type WatchResult struct {
+	Address string `json:"address"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret"` // hex encoded key of the HMAC-SHA256 signature of payloads
+}
+
+// An EventNotification is a chain event sent to WebSocket subscribers, hashes are hex encoded.
+type EventNotification struct {
+	Type      string `json:"type"`
+	BlockHash string `json:"blockhash,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	TxID      string `json:"txid,omitempty"`
+	Address   string `json:"address,omitempty"`
+}
+
+// Encodes a chain event into its notification.
+func NewEventNotification(event blockchain.Event) *EventNotification {
+	notification := &EventNotification{Type: string(event.Type), Address: event.Address}
+	if event.Block != nil {
+		notification.BlockHash = hex.EncodeToString(event.Block.Hash)
+		notification.Height = event.Block.Height
+	}
+	if event.Tx != nil {
+		notification.TxID = hex.EncodeToString(event.Tx.ID)
+	}
+	return notification
+}
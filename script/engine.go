@@ -0,0 +1,437 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const (
+	// Maximum number of items on the stack.
+	MaxStackSize = 1000
+	// Maximum number of public keys in OP_CHECKMULTISIG.
+	MaxPubKeysPerMultiSig = 20
+	// Maximum number of public keys of a script created by MultiSigScript, n is pushed by OP_1 - OP_16.
+	MaxPubKeysPerMultiSigScript = 16
+	// Maximum size of a number operand, lock times use up to 5 bytes.
+	maxNumSize = 5
+)
+
+// A Checker provides the script engine with the context of the transaction being validated.
+type Checker interface {
+	// Checks a signature of a public key over the transaction, where subscript is the script being executed.
+	CheckSig(sig, pubKey []byte, subscript Script) bool
+	// Checks that the transaction can't be mined before lockTime.
+	CheckLockTime(lockTime int64) error
+}
+
+// The script engine state while executing a single script.
+type engine struct {
+	stack     [][]byte
+	condStack []bool
+	checker   Checker
+}
+
+// Executes an unlocking script followed by the locking script of the output it spends.
+// The unlocking script may only push data. If the locking script is a pay-to-script-hash
+// script, the last item pushed by the unlocking script is executed as the redeem script.
+// Returns nil if the scripts succeed and leave a true value on the top of the stack.
+func Execute(unlocking, locking Script, checker Checker) error {
+	if !unlocking.IsPushOnly() {
+		return errors.New("unlocking script may only push data")
+	}
+
+	vm := &engine{checker: checker}
+	if err := vm.run(unlocking); err != nil {
+		return errors.Wrap(err, "unlocking script failed")
+	}
+	p2shStack := append([][]byte{}, vm.stack...)
+
+	if err := vm.run(locking); err != nil {
+		return errors.Wrap(err, "locking script failed")
+	}
+	if err := vm.checkResult(); err != nil {
+		return err
+	}
+
+	if !IsPayToScriptHash(locking) {
+		return nil
+	}
+
+	// the locking script verified the hash of the redeem script, now the redeem script itself has to succeed
+	if len(p2shStack) == 0 {
+		return errors.New("missing redeem script")
+	}
+	redeem := Script(p2shStack[len(p2shStack)-1])
+	vm = &engine{stack: p2shStack[:len(p2shStack)-1], checker: checker}
+	if err := vm.run(redeem); err != nil {
+		return errors.Wrap(err, "redeem script failed")
+	}
+	return vm.checkResult()
+}
+
+// Checks if a script left a true value on the top of the stack.
+func (vm *engine) checkResult() error {
+	if len(vm.stack) == 0 {
+		return errors.New("script finished with an empty stack")
+	}
+	if !asBool(vm.stack[len(vm.stack)-1]) {
+		return errors.New("script finished with a false value on the stack")
+	}
+	return nil
+}
+
+// Checks if the current branch of the script is being executed.
+func (vm *engine) executing() bool {
+	for _, cond := range vm.condStack {
+		if !cond {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs a single script with the current stack.
+func (vm *engine) run(s Script) error {
+	instructions, err := s.Parse()
+	if err != nil {
+		return err
+	}
+
+	vm.condStack = nil
+	for _, ins := range instructions {
+		if err := vm.step(ins, s); err != nil {
+			return errors.Wrapf(err, "%s", opcodeName(ins))
+		}
+		if len(vm.stack) > MaxStackSize {
+			return errors.New("stack size limit exceeded")
+		}
+	}
+	if len(vm.condStack) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+// Executes a single instruction.
+func (vm *engine) step(ins Instruction, s Script) error {
+	// conditionals have to be tracked even in branches which are not executed
+	switch ins.Opcode {
+	case OP_IF, OP_NOTIF:
+		cond := false
+		if vm.executing() {
+			top, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			cond = asBool(top) == (ins.Opcode == OP_IF)
+		}
+		vm.condStack = append(vm.condStack, cond)
+		return nil
+	case OP_ELSE:
+		if len(vm.condStack) == 0 {
+			return errors.New("OP_ELSE without OP_IF")
+		}
+		vm.condStack[len(vm.condStack)-1] = !vm.condStack[len(vm.condStack)-1]
+		return nil
+	case OP_ENDIF:
+		if len(vm.condStack) == 0 {
+			return errors.New("OP_ENDIF without OP_IF")
+		}
+		vm.condStack = vm.condStack[:len(vm.condStack)-1]
+		return nil
+	}
+
+	if !vm.executing() {
+		return nil
+	}
+
+	if ins.Data != nil {
+		if len(ins.Data) > MaxElementSize {
+			return errors.Errorf("element size %d exceeds the limit of %d bytes", len(ins.Data), MaxElementSize)
+		}
+		vm.push(ins.Data)
+		return nil
+	}
+
+	switch op := ins.Opcode; {
+	case op == OP_0:
+		vm.push(nil)
+	case op == OP_1NEGATE:
+		vm.push(encodeNum(-1))
+	case op >= OP_1 && op <= OP_16:
+		vm.push(encodeNum(int64(op - OP_1 + 1)))
+	case op == OP_NOP:
+	case op == OP_VERIFY:
+		return vm.verify()
+	case op == OP_RETURN:
+		return errors.New("script marked as unspendable")
+	case op == OP_DROP:
+		_, err := vm.pop()
+		return err
+	case op == OP_DUP:
+		top, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		vm.push(top)
+	case op == OP_SWAP:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(a)
+		vm.push(b)
+	case op == OP_EQUAL, op == OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.pushBool(bytes.Equal(a, b))
+		if op == OP_EQUALVERIFY {
+			return vm.verify()
+		}
+	case op == OP_SHA256, op == OP_HASH160, op == OP_HASH256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case OP_SHA256:
+			hash := sha256.Sum256(data)
+			vm.push(hash[:])
+		case OP_HASH160:
+			vm.push(Hash160(data))
+		case OP_HASH256:
+			first := sha256.Sum256(data)
+			hash := sha256.Sum256(first[:])
+			vm.push(hash[:])
+		}
+	case op == OP_CHECKSIG, op == OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.pushBool(vm.checker.CheckSig(sig, pubKey, s))
+		if op == OP_CHECKSIGVERIFY {
+			return vm.verify()
+		}
+	case op == OP_CHECKMULTISIG, op == OP_CHECKMULTISIGVERIFY:
+		ok, err := vm.checkMultiSig(s)
+		if err != nil {
+			return err
+		}
+		vm.pushBool(ok)
+		if op == OP_CHECKMULTISIGVERIFY {
+			return vm.verify()
+		}
+	case op == OP_CHECKLOCKTIMEVERIFY:
+		// the lock time is left on the stack, scripts usually follow it with OP_DROP
+		top, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		lockTime, err := decodeNum(top)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 {
+			return errors.New("negative lock time")
+		}
+		return vm.checker.CheckLockTime(lockTime)
+	default:
+		return errors.New("unknown opcode")
+	}
+	return nil
+}
+
+// Pops <m> <sig>... <n> <pubkey>... from the stack and checks that m of the signatures
+// belong to the public keys. Signatures must be in the same order as their public keys.
+// Unlike Bitcoin no extra dummy element is consumed.
+func (vm *engine) checkMultiSig(s Script) (bool, error) {
+	n, err := vm.popInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > MaxPubKeysPerMultiSig {
+		return false, errors.Errorf("invalid number of public keys %d", n)
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := vm.popInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, errors.Errorf("invalid number of signatures %d for %d public keys", m, n)
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if sigs[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	keyIdx := 0
+	for _, sig := range sigs {
+		for keyIdx < len(pubKeys) && !vm.checker.CheckSig(sig, pubKeys[keyIdx], s) {
+			keyIdx++
+		}
+		if keyIdx == len(pubKeys) {
+			return false, nil
+		}
+		keyIdx++
+	}
+	return true, nil
+}
+
+func (vm *engine) push(data []byte) {
+	vm.stack = append(vm.stack, data)
+}
+
+func (vm *engine) pushBool(value bool) {
+	if value {
+		vm.push([]byte{1})
+	} else {
+		vm.push(nil)
+	}
+}
+
+func (vm *engine) pop() ([]byte, error) {
+	top, err := vm.peek(0)
+	if err != nil {
+		return nil, err
+	}
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return top, nil
+}
+
+func (vm *engine) popInt() (int, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	num, err := decodeNum(data)
+	return int(num), err
+}
+
+// Returns the stack item at depth idx, where 0 is the top of the stack.
+func (vm *engine) peek(idx int) ([]byte, error) {
+	if idx >= len(vm.stack) {
+		return nil, errors.New("stack underflow")
+	}
+	return vm.stack[len(vm.stack)-1-idx], nil
+}
+
+func (vm *engine) verify() error {
+	top, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if !asBool(top) {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// Interprets a stack item as a boolean, any non zero value (except negative zero) is true.
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			// negative zero is false
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+	return false
+}
+
+// Encodes a number as a little-endian byte slice with the sign in the most significant bit.
+func encodeNum(num int64) []byte {
+	if num == 0 {
+		return nil
+	}
+
+	negative := num < 0
+	abs := uint64(num)
+	if negative {
+		abs = uint64(-num)
+	}
+
+	var result []byte
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	// an extra byte is needed if the most significant bit is already used by the value
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// Decodes a number encoded by encodeNum.
+func decodeNum(data []byte) (int64, error) {
+	if len(data) > maxNumSize {
+		return 0, errors.Errorf("number of %d bytes exceeds the limit of %d bytes", len(data), maxNumSize)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var result int64
+	for i, b := range data {
+		result |= int64(b) << uint(8*i)
+	}
+
+	// the most significant bit of the last byte is the sign
+	if data[len(data)-1]&0x80 != 0 {
+		result &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		return -result, nil
+	}
+	return result, nil
+}
+
+// Hashes data with SHA-256 followed by RIPEMD-160.
+func Hash160(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	hasher := ripemd160.New()
+	hasher.Write(hash[:])
+	return hasher.Sum(nil)
+}
+
+// Returns a name of an instruction used in error messages.
+func opcodeName(ins Instruction) string {
+	if ins.Data != nil {
+		return "push"
+	}
+	if name, ok := opcodeNames[ins.Opcode]; ok {
+		return name
+	}
+	return Script{ins.Opcode}.String()
+}
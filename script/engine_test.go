@@ -0,0 +1,184 @@
+package script
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseTruncatedPushes(t *testing.T) {
+	tests := []struct {
+		script   Script
+		expected string
+	}{
+		{Script{OP_DATA_1 + 2, 0x01}, "push of 3 bytes exceeds the script"},
+		{Script{OP_PUSHDATA1}, "script ends in the middle of OP_PUSHDATA1"},
+		{Script{OP_PUSHDATA1, 2, 0x01}, "push of 2 bytes exceeds the script"},
+		{Script{OP_PUSHDATA2, 0x01}, "script ends in the middle of OP_PUSHDATA2"},
+		{Script{OP_PUSHDATA2, 0x00, 0x01}, "push of 256 bytes exceeds the script"},
+		{Script{OP_PUSHDATA4, 0x01, 0x00, 0x00}, "script ends in the middle of OP_PUSHDATA4"},
+		{Script{OP_PUSHDATA4, 0xff, 0xff, 0xff, 0xff}, "push of 4294967295 bytes exceeds the script"},
+		{make(Script, MaxScriptSize+1), "script size 10001 exceeds the limit of 10000 bytes"},
+	}
+	for _, test := range tests {
+		_, err := test.script.Parse()
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%x: expected error %q, got %v", []byte(test.script), test.expected, err)
+		}
+	}
+
+	instructions, err := Script{OP_DUP, OP_DATA_1 + 1, 0xaa, 0xbb, OP_PUSHDATA1, 1, 0xcc, OP_0}.Parse()
+	if err != nil || len(instructions) != 4 || !bytes.Equal(instructions[1].Data, []byte{0xaa, 0xbb}) ||
+		!bytes.Equal(instructions[2].Data, []byte{0xcc}) || instructions[3].Opcode != OP_0 {
+		t.Errorf("expected 4 instructions, got %+v %v", instructions, err)
+	}
+}
+
+func TestNumEncoding(t *testing.T) {
+	tests := []struct {
+		num     int64
+		encoded []byte
+	}{
+		{0, nil},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{256, []byte{0x00, 0x01}},
+		{-256, []byte{0x00, 0x81}},
+		{500000000, []byte{0x00, 0x65, 0xcd, 0x1d}},
+		{math.MaxUint32, []byte{0xff, 0xff, 0xff, 0xff, 0x00}},
+	}
+	for _, test := range tests {
+		encoded := encodeNum(test.num)
+		if !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("%d: expected the minimal encoding %x, got %x", test.num, test.encoded, encoded)
+		}
+		if decoded, err := decodeNum(encoded); err != nil || decoded != test.num {
+			t.Errorf("%d: expected %x to decode back, got %d %v", test.num, encoded, decoded, err)
+		}
+	}
+
+	// non-minimal encodings still decode, negative zero is zero
+	for encoded, expected := range map[string]int64{"\x01\x00": 1, "\x80": 0, "\x00\x80": 0} {
+		if decoded, err := decodeNum([]byte(encoded)); err != nil || decoded != expected {
+			t.Errorf("%x: expected %d, got %d %v", encoded, expected, decoded, err)
+		}
+	}
+	if _, err := decodeNum(make([]byte, maxNumSize+1)); err == nil {
+		t.Error("expected a number longer than the limit to be rejected")
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []struct {
+		locking  Script
+		expected string // empty if the script succeeds
+	}{
+		{Script{OP_1, OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF}, ""},
+		{Script{OP_0, OP_IF, OP_0, OP_ELSE, OP_1, OP_ENDIF}, ""},
+		{Script{OP_0, OP_NOTIF, OP_1, OP_ENDIF}, ""},
+		// nested conditionals in a branch which isn't executed don't pop the stack
+		{Script{OP_0, OP_IF, OP_IF, OP_RETURN, OP_ENDIF, OP_ELSE, OP_1, OP_ENDIF}, ""},
+		{Script{OP_1, OP_IF, OP_0, OP_IF, OP_RETURN, OP_ELSE, OP_1, OP_ENDIF, OP_ENDIF}, ""},
+		{Script{OP_1, OP_ELSE}, "OP_ELSE without OP_IF"},
+		{Script{OP_1, OP_ENDIF}, "OP_ENDIF without OP_IF"},
+		{Script{OP_1, OP_IF, OP_1}, "unbalanced conditional"},
+		{Script{OP_1, OP_IF, OP_ENDIF, OP_ENDIF}, "OP_ENDIF without OP_IF"},
+		{Script{OP_IF, OP_ENDIF}, "stack underflow"},
+		{Script{OP_1, OP_IF, OP_RETURN, OP_ENDIF}, "script marked as unspendable"},
+	}
+	for _, test := range tests {
+		err := Execute(nil, test.locking, testChecker{})
+		if test.expected == "" && err != nil {
+			t.Errorf("%s: expected success, got %v", test.locking, err)
+		}
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Errorf("%s: expected error %q, got %v", test.locking, test.expected, err)
+		}
+	}
+}
+
+func TestPayToPubKeyHash(t *testing.T) {
+	pubKey := testPubKeys(1)[0]
+	locking := PayToPubKeyHash(Hash160(pubKey))
+	if hash, ok := ExtractPubKeyHash(locking); !ok || !bytes.Equal(hash, Hash160(pubKey)) {
+		t.Fatalf("expected the public key hash to be extracted from %s", locking)
+	}
+
+	other := testPubKeys(2)[1]
+	tests := []struct {
+		unlocking Script
+		expected  string
+	}{
+		{PayToPubKeyHashUnlock(testSig(pubKey), pubKey), ""},
+		{PayToPubKeyHashUnlock(testSig(other), pubKey), "script finished with a false value on the stack"},
+		{PayToPubKeyHashUnlock(testSig(other), other), "OP_EQUALVERIFY: verification failed"},
+		{NewBuilder().AddData(pubKey).Script(), "stack underflow"},
+		{append(PayToPubKeyHashUnlock(testSig(pubKey), pubKey), OP_DUP), "unlocking script may only push data"},
+	}
+	for _, test := range tests {
+		err := Execute(test.unlocking, locking, testChecker{})
+		if test.expected == "" && err != nil {
+			t.Errorf("%s: expected success, got %v", test.unlocking, err)
+		}
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Errorf("%s: expected error %q, got %v", test.unlocking, test.expected, err)
+		}
+	}
+}
+
+func TestPayToScriptHash(t *testing.T) {
+	// the redeem script requires a preimage of a hash
+	preimage := []byte("secret")
+	hash := Hash160(preimage)
+	redeem := NewBuilder().AddOp(OP_HASH160).AddData(hash).AddOp(OP_EQUAL).Script()
+	locking := PayToScriptHash(Hash160(redeem))
+
+	tests := []struct {
+		unlocking Script
+		expected  string
+	}{
+		{NewBuilder().AddData(preimage).AddData(redeem).Script(), ""},
+		{NewBuilder().AddData([]byte("guess")).AddData(redeem).Script(), "script finished with a false value"},
+		{NewBuilder().AddData(preimage).AddData(append(redeem, OP_NOP)).Script(), "script finished with a false value"},
+		{NewBuilder().AddData(redeem).Script(), "redeem script failed"},
+		{nil, "locking script failed"},
+	}
+	for _, test := range tests {
+		err := Execute(test.unlocking, locking, testChecker{})
+		if test.expected == "" && err != nil {
+			t.Errorf("%s: expected success, got %v", test.unlocking, err)
+		}
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Errorf("%s: expected error %q, got %v", test.unlocking, test.expected, err)
+		}
+	}
+}
+
+func TestCheckLockTimeVerify(t *testing.T) {
+	const lockTime = 1000
+	pubKey := testPubKeys(1)[0]
+	locking := NewBuilder().AddInt(lockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddData(pubKey).AddOp(OP_CHECKSIG).Script()
+	unlocking := NewBuilder().AddData(testSig(pubKey)).Script()
+
+	if err := Execute(unlocking, locking, testChecker{lockTime - 1}); err == nil ||
+		!strings.Contains(err.Error(), "OP_CHECKLOCKTIMEVERIFY: lock time 1000 is not reached") {
+		t.Errorf("expected the spend below the lock time to fail, got %v", err)
+	}
+	if err := Execute(unlocking, locking, testChecker{lockTime}); err != nil {
+		t.Errorf("expected the spend at the lock time to succeed, got %v", err)
+	}
+
+	negative := NewBuilder().AddInt(-1).AddOp(OP_CHECKLOCKTIMEVERIFY).Script()
+	if err := Execute(nil, negative, testChecker{lockTime}); err == nil || !strings.Contains(err.Error(), "negative lock time") {
+		t.Errorf("expected a negative lock time to fail, got %v", err)
+	}
+	if err := Execute(nil, Script{OP_CHECKLOCKTIMEVERIFY}, testChecker{lockTime}); err == nil {
+		t.Error("expected OP_CHECKLOCKTIMEVERIFY on an empty stack to fail")
+	}
+}
@@ -0,0 +1,172 @@
+package script
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Maximum size of a script in bytes.
+	MaxScriptSize = 10000
+	// Maximum size of a single element pushed onto the stack.
+	MaxElementSize = 520
+)
+
+// A Script is a program in the stack-based script language used to lock and unlock transaction outputs.
+type Script []byte
+
+// An Instruction is a single parsed opcode of a script together with the data it pushes.
+type Instruction struct {
+	Opcode byte
+	Data   []byte
+}
+
+// Checks if an instruction pushes data (or a small number) onto the stack.
+func (ins Instruction) IsPush() bool {
+	return ins.Opcode <= OP_16 && ins.Opcode != 0x50
+}
+
+// Parses a script into its instructions.
+func (s Script) Parse() ([]Instruction, error) {
+	var instructions []Instruction
+
+	if len(s) > MaxScriptSize {
+		return nil, errors.Errorf("script size %d exceeds the limit of %d bytes", len(s), MaxScriptSize)
+	}
+
+	for pos := 0; pos < len(s); {
+		opcode := s[pos]
+		pos++
+
+		size := -1
+		switch {
+		case opcode >= OP_DATA_1 && opcode <= OP_DATA_75:
+			size = int(opcode)
+		case opcode == OP_PUSHDATA1:
+			if pos+1 > len(s) {
+				return nil, errors.New("script ends in the middle of OP_PUSHDATA1")
+			}
+			size = int(s[pos])
+			pos++
+		case opcode == OP_PUSHDATA2:
+			if pos+2 > len(s) {
+				return nil, errors.New("script ends in the middle of OP_PUSHDATA2")
+			}
+			size = int(binary.LittleEndian.Uint16(s[pos:]))
+			pos += 2
+		case opcode == OP_PUSHDATA4:
+			if pos+4 > len(s) {
+				return nil, errors.New("script ends in the middle of OP_PUSHDATA4")
+			}
+			size = int(binary.LittleEndian.Uint32(s[pos:]))
+			pos += 4
+		}
+
+		if size >= 0 {
+			if size > len(s)-pos {
+				return nil, errors.Errorf("push of %d bytes exceeds the script", size)
+			}
+			instructions = append(instructions, Instruction{opcode, s[pos : pos+size]})
+			pos += size
+			continue
+		}
+		instructions = append(instructions, Instruction{opcode, nil})
+	}
+
+	return instructions, nil
+}
+
+// Checks if a script contains only data pushes.
+func (s Script) IsPushOnly() bool {
+	instructions, err := s.Parse()
+	if err != nil {
+		return false
+	}
+	for _, ins := range instructions {
+		if !ins.IsPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// Disassembles a script into a human readable form, e.g. "OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG".
+func (s Script) String() string {
+	instructions, err := s.Parse()
+	if err != nil {
+		return fmt.Sprintf("[invalid script %x]", []byte(s))
+	}
+
+	var parts []string
+	for _, ins := range instructions {
+		switch {
+		case ins.Data != nil:
+			parts = append(parts, fmt.Sprintf("%x", ins.Data))
+		case ins.Opcode >= OP_1 && ins.Opcode <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", ins.Opcode-OP_1+1))
+		case opcodeNames[ins.Opcode] != "":
+			parts = append(parts, opcodeNames[ins.Opcode])
+		default:
+			parts = append(parts, fmt.Sprintf("OP_UNKNOWN_%#02x", ins.Opcode))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// A Builder allows to compose a script from opcodes and data pushes.
+type Builder struct {
+	script Script
+}
+
+// Creates a new empty script builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Appends an opcode to the script.
+func (b *Builder) AddOp(opcode byte) *Builder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// Appends a data push to the script using the smallest push opcode able to hold the data.
+func (b *Builder) AddData(data []byte) *Builder {
+	size := len(data)
+	switch {
+	case size == 0:
+		b.script = append(b.script, OP_0)
+	case size <= OP_DATA_75:
+		b.script = append(b.script, byte(size))
+	case size <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(size))
+	case size <= 0xffff:
+		b.script = append(b.script, OP_PUSHDATA2, 0, 0)
+		binary.LittleEndian.PutUint16(b.script[len(b.script)-2:], uint16(size))
+	default:
+		b.script = append(b.script, OP_PUSHDATA4, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b.script[len(b.script)-4:], uint32(size))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+// Appends a number to the script, using OP_0 - OP_16 for small numbers.
+func (b *Builder) AddInt(num int64) *Builder {
+	switch {
+	case num == 0:
+		return b.AddOp(OP_0)
+	case num == -1:
+		return b.AddOp(OP_1NEGATE)
+	case num >= 1 && num <= 16:
+		return b.AddOp(byte(OP_1 + num - 1))
+	}
+	return b.AddData(encodeNum(num))
+}
+
+// Returns the built script.
+func (b *Builder) Script() Script {
+	return append(Script{}, b.script...)
+}
@@ -0,0 +1,152 @@
+package script
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// Marker of stake locking scripts, it is dropped when the script is executed.
+var stakeMarker = []byte("stake")
+
+// Creates a standard pay-to-public-key-hash locking script:
+// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHash(pubKeyHash []byte) Script {
+	return NewBuilder().
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubKeyHash).AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// Creates an unlocking script spending a pay-to-public-key-hash output: <sig> <pubKey>
+func PayToPubKeyHashUnlock(sig, pubKey []byte) Script {
+	return NewBuilder().AddData(sig).AddData(pubKey).Script()
+}
+
+// Creates a pay-to-script-hash locking script: OP_HASH160 <scriptHash> OP_EQUAL
+// The output is spent by pushing the redeem script, whose hash is scriptHash, and the data it needs.
+func PayToScriptHash(scriptHash []byte) Script {
+	return NewBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// Extracts the public key hash from a pay-to-public-key-hash locking script.
+func ExtractPubKeyHash(s Script) ([]byte, bool) {
+	if !IsPayToPubKeyHash(s) {
+		return nil, false
+	}
+	return s[3:23], true
+}
+
+// Extracts the script hash from a pay-to-script-hash locking script.
+func ExtractScriptHash(s Script) ([]byte, bool) {
+	if !IsPayToScriptHash(s) {
+		return nil, false
+	}
+	return s[2:22], true
+}
+
+// Checks if a script is a standard pay-to-public-key-hash locking script.
+func IsPayToPubKeyHash(s Script) bool {
+	return len(s) == 25 && s[0] == OP_DUP && s[1] == OP_HASH160 && s[2] == OP_DATA_1+19 &&
+		s[23] == OP_EQUALVERIFY && s[24] == OP_CHECKSIG
+}
+
+// Checks if a script is a pay-to-script-hash locking script.
+func IsPayToScriptHash(s Script) bool {
+	return len(s) == 23 && s[0] == OP_HASH160 && s[1] == OP_DATA_1+19 && s[22] == OP_EQUAL
+}
+
+// Creates a locking script registering the value of an output as the stake of a public key:
+// "stake" OP_DROP <pubKey> OP_CHECKSIG
+// The public key has to be known to verify blocks it signs, so stakes don't lock to a public key hash.
+// The stake is withdrawn by spending the output with a signature of the key.
+func PayToStake(pubKey []byte) Script {
+	return NewBuilder().AddData(stakeMarker).AddOp(OP_DROP).AddData(pubKey).AddOp(OP_CHECKSIG).Script()
+}
+
+// Creates an unlocking script spending a stake output: <sig>
+func PayToStakeUnlock(sig []byte) Script {
+	return NewBuilder().AddData(sig).Script()
+}
+
+// Extracts the public key from a stake locking script.
+func ExtractStakePubKey(s Script) ([]byte, bool) {
+	if !IsPayToStake(s) {
+		return nil, false
+	}
+	return s[8:72], true
+}
+
+// Checks if a script is a stake locking script of a 64 byte public key.
+func IsPayToStake(s Script) bool {
+	return len(s) == 73 && s[0] == OP_DATA_1+4 && bytes.Equal(s[1:6], stakeMarker) && s[6] == OP_DROP &&
+		s[7] == OP_DATA_1+63 && s[72] == OP_CHECKSIG
+}
+
+// Creates a multisignature script requiring m signatures of the public keys:
+// <m> <pubKey>... <n> OP_CHECKMULTISIG
+// It is used as the redeem script of a pay-to-script-hash output, so it has to fit into a single element
+// pushed by the unlocking script, and n is at most 16 to be recognized by ExtractMultiSig.
+func MultiSigScript(m int, pubKeys [][]byte) (Script, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > MaxPubKeysPerMultiSigScript {
+		return nil, errors.Errorf("number of public keys must be between 1 and %d", MaxPubKeysPerMultiSigScript)
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, errors.Errorf("number of required signatures must be between 1 and %d", len(pubKeys))
+	}
+
+	builder := NewBuilder().AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		builder.AddData(pubKey)
+	}
+	s := builder.AddInt(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script()
+	if len(s) > MaxElementSize {
+		return nil, errors.Errorf("redeem script of %d public keys is %d bytes, the limit is %d bytes",
+			len(pubKeys), len(s), MaxElementSize)
+	}
+	return s, nil
+}
+
+// Extracts the number of required signatures and the public keys from a multisignature script.
+func ExtractMultiSig(s Script) (int, [][]byte, bool) {
+	instructions, err := s.Parse()
+	if err != nil || len(instructions) < 4 || instructions[len(instructions)-1].Opcode != OP_CHECKMULTISIG {
+		return 0, nil, false
+	}
+
+	m, ok := smallInt(instructions[0])
+	if !ok {
+		return 0, nil, false
+	}
+	n, ok := smallInt(instructions[len(instructions)-2])
+	if !ok || n != len(instructions)-3 || m > n {
+		return 0, nil, false
+	}
+
+	var pubKeys [][]byte
+	for _, ins := range instructions[1 : len(instructions)-2] {
+		if ins.Data == nil {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, ins.Data)
+	}
+	return m, pubKeys, true
+}
+
+// Creates an unlocking script spending a pay-to-script-hash multisignature output:
+// <sig>... <redeemScript>
+// Signatures must be in the same order as their public keys in the redeem script.
+func MultiSigUnlock(sigs [][]byte, redeemScript Script) Script {
+	builder := NewBuilder()
+	for _, sig := range sigs {
+		builder.AddData(sig)
+	}
+	return builder.AddData(redeemScript).Script()
+}
+
+// Returns a value of an OP_1 - OP_16 instruction.
+func smallInt(ins Instruction) (int, bool) {
+	if ins.Data != nil || ins.Opcode < OP_1 || ins.Opcode > OP_16 {
+		return 0, false
+	}
+	return int(ins.Opcode-OP_1) + 1, true
+}
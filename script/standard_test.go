@@ -0,0 +1,89 @@
+package script
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// A testChecker accepts a signature equal to the reversed public key and lock times up to its lock time.
+type testChecker struct {
+	lockTime int64
+}
+
+func (c testChecker) CheckSig(sig, pubKey []byte, subscript Script) bool {
+	return bytes.Equal(sig, testSig(pubKey))
+}
+
+func (c testChecker) CheckLockTime(lockTime int64) error {
+	if lockTime > c.lockTime {
+		return errors.Errorf("lock time %d is not reached", lockTime)
+	}
+	return nil
+}
+
+// Creates the signature of a public key accepted by testChecker.
+func testSig(pubKey []byte) []byte {
+	sig := make([]byte, len(pubKey))
+	for i := range pubKey {
+		sig[len(sig)-1-i] = pubKey[i]
+	}
+	return sig
+}
+
+// Creates n distinct public keys of 64 bytes.
+func testPubKeys(n int) [][]byte {
+	var pubKeys [][]byte
+	for i := 0; i < n; i++ {
+		pubKeys = append(pubKeys, bytes.Repeat([]byte{byte(i + 1)}, 64))
+	}
+	return pubKeys
+}
+
+func TestMultiSigScriptLimits(t *testing.T) {
+	tests := []struct {
+		m, n     int
+		expected string
+	}{
+		{1, 0, "number of public keys must be between 1 and 16"},
+		{1, 17, "number of public keys must be between 1 and 16"},
+		{0, 3, "number of required signatures must be between 1 and 3"},
+		{4, 3, "number of required signatures must be between 1 and 3"},
+		{1, 8, "redeem script of 8 public keys is 523 bytes, the limit is 520 bytes"},
+	}
+	for _, test := range tests {
+		_, err := MultiSigScript(test.m, testPubKeys(test.n))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%d of %d: expected error %q, got %v", test.m, test.n, test.expected, err)
+		}
+	}
+}
+
+func TestMultiSigSpend(t *testing.T) {
+	for _, n := range []int{3, 7} {
+		pubKeys := testPubKeys(n)
+		redeemScript, err := MultiSigScript(2, pubKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, extracted, ok := ExtractMultiSig(redeemScript); !ok || m != 2 || len(extracted) != n {
+			t.Fatalf("expected 2 of %d to be extracted, got %d of %d %v", n, m, len(extracted), ok)
+		}
+		locking := PayToScriptHash(Hash160(redeemScript))
+
+		// signatures have to be in the order of their public keys
+		sigs := [][]byte{testSig(pubKeys[0]), testSig(pubKeys[n-1])}
+		if err := Execute(MultiSigUnlock(sigs, redeemScript), locking, testChecker{}); err != nil {
+			t.Errorf("2 of %d: expected the spend to succeed, got %v", n, err)
+		}
+		sigs[0], sigs[1] = sigs[1], sigs[0]
+		if err := Execute(MultiSigUnlock(sigs, redeemScript), locking, testChecker{}); err == nil {
+			t.Errorf("2 of %d: expected signatures out of order to fail", n)
+		}
+		if err := Execute(MultiSigUnlock(sigs[:1], redeemScript), locking, testChecker{}); err == nil {
+			t.Errorf("2 of %d: expected a single signature to fail", n)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/michaljirman/goblockchain/logger"
+)
+
+// BadgerStore stores keys in a Badger DB.
+type BadgerStore struct {
+	db *badger.DB
+}
+
+// Opens a Badger DB in a directory, creating it if it doesn't exist.
+func OpenBadger(dir string) (*BadgerStore, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(badgerLogger{logger.Get(logger.DB)}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open badger DB")
+	}
+	return &BadgerStore{db}, nil
+}
+
+// Routes the logs of Badger to the logger of the DB subsystem.
+type badgerLogger struct {
+	log *zerolog.Logger
+}
+
+func (l badgerLogger) Errorf(format string, args ...interface{}) {
+	l.log.Error().Msg(strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (l badgerLogger) Warningf(format string, args ...interface{}) {
+	l.log.Warn().Msg(strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (l badgerLogger) Infof(format string, args ...interface{}) {
+	l.log.Info().Msg(strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (l badgerLogger) Debugf(format string, args ...interface{}) {
+	l.log.Debug().Msg(strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (s *BadgerStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = badgerBatch{txn}.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *BadgerStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(item.KeyCopy(nil), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BadgerStore) Update(fn func(batch Batch) error) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return fn(badgerBatch{txn})
+	})
+}
+
+func (s *BadgerStore) Close() error {
+	return s.db.Close()
+}
+
+// A badgerBatch is a read-write Badger transaction.
+type badgerBatch struct {
+	txn *badger.Txn
+}
+
+func (b badgerBatch) Get(key []byte) ([]byte, error) {
+	item, err := b.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (b badgerBatch) Put(key, value []byte) error {
+	return b.txn.Set(key, value)
+}
+
+func (b badgerBatch) Delete(key []byte) error {
+	return b.txn.Delete(key)
+}
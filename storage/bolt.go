@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Name of the bbolt DB file in its directory.
+const boltFile = "chain.db"
+
+// Bucket holding all keys.
+var boltBucket = []byte("chain")
+
+// BoltStore stores keys in a single bucket of a bbolt DB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// Opens a bbolt DB in a directory, creating it if it doesn't exist.
+func OpenBolt(dir string) (*BoltStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create bolt DB directory")
+	}
+	// bbolt locks the file, the timeout prevents waiting forever for another process
+	db, err := bolt.Open(filepath.Join(dir, boltFile), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open bolt DB")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to create bolt bucket")
+	}
+	return &BoltStore{db}, nil
+}
+
+func (s *BoltStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		value, err = boltBatch{tx.Bucket(boltBucket)}.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *BoltStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			// bbolt's slices are valid only during the transaction
+			if err := fn(append([]byte{}, k...), append([]byte{}, v...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Update(fn func(batch Batch) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltBatch{tx.Bucket(boltBucket)})
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// A boltBatch reads and writes the bucket of a bbolt transaction.
+type boltBatch struct {
+	bucket *bolt.Bucket
+}
+
+func (b boltBatch) Get(key []byte) ([]byte, error) {
+	value := b.bucket.Get(key)
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (b boltBatch) Put(key, value []byte) error {
+	return b.bucket.Put(key, value)
+}
+
+func (b boltBatch) Delete(key []byte) error {
+	return b.bucket.Delete(key)
+}
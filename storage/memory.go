@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps keys in memory, it is meant for tests and short-lived chains.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+// Creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (s *MemoryStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	// fn is called on a snapshot, so it may update the store
+	s.mu.RLock()
+	var keys []string
+	values := make(map[string][]byte)
+	for key, value := range s.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+			values[key] = append([]byte{}, value...)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn([]byte(key), values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Update(fn func(batch Batch) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := &memoryBatch{store: s, writes: make(map[string][]byte)}
+	if err := fn(batch); err != nil {
+		return err
+	}
+	for key, value := range batch.writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// A memoryBatch collects writes until its update succeeds, deleted keys have nil values.
+type memoryBatch struct {
+	store  *MemoryStore
+	writes map[string][]byte
+}
+
+func (b *memoryBatch) Get(key []byte) ([]byte, error) {
+	value, ok := b.writes[string(key)]
+	if !ok {
+		value, ok = b.store.data[string(key)]
+	}
+	if !ok || value == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (b *memoryBatch) Put(key, value []byte) error {
+	b.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.writes[string(key)] = nil
+	return nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Names of storage backends.
+const (
+	BadgerBackend = "badger"
+	BoltBackend   = "bolt"
+	MemoryBackend = "memory"
+)
+
+// Returned when a key doesn't exist.
+var ErrNotFound = errors.New("key not found")
+
+// A Store is a key-value store holding a blockchain, its blocks are stored under their hashes,
+// the tip and indexes under their own keys. Values returned by a store are copies owned by the caller.
+type Store interface {
+	// Gets the value of a key, returns ErrNotFound if it doesn't exist.
+	Get(key []byte) ([]byte, error)
+	// Calls fn for every key with a prefix in key order, stops at the first error returned by fn.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+	// Calls fn with a new batch, its writes are applied atomically if fn returns nil and discarded otherwise.
+	Update(fn func(batch Batch) error) error
+	// Closes the store.
+	Close() error
+}
+
+// A Batch reads and writes keys of a store within a single atomic update, its reads see its own writes.
+type Batch interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// Opens the store of a backend in a directory, creating it if it doesn't exist.
+// The directory is not used by the memory backend.
+func Open(backend, dir string) (Store, error) {
+	switch backend {
+	case BadgerBackend, "":
+		return OpenBadger(dir)
+	case BoltBackend:
+		return OpenBolt(dir)
+	case MemoryBackend:
+		return NewMemoryStore(), nil
+	default:
+		return nil, errors.Errorf("unknown storage backend %s", backend)
+	}
+}
+
+// Number of keys written in a single update by Copy.
+const copyBatchSize = 1000
+
+// Copies every key of a store into another store, e.g. to back up a store into a store of another directory.
+// Keys are written in batches, so dst is left incomplete if copying fails.
+func Copy(dst, src Store) error {
+	type entry struct{ key, value []byte }
+	var entries []entry
+	flush := func() error {
+		err := dst.Update(func(batch Batch) error {
+			for _, entry := range entries {
+				if err := batch.Put(entry.key, entry.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		entries = entries[:0]
+		return err
+	}
+
+	err := src.Iterate(nil, func(key, value []byte) error {
+		entries = append(entries, entry{append([]byte{}, key...), append([]byte{}, value...)})
+		if len(entries) < copyBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(entries) > 0 {
+		err = flush()
+	}
+	return errors.Wrap(err, "failed to copy store")
+}
+
+// Detects the backend of a store in a directory, returns an empty string if there is no store.
+func Detect(dir string) string {
+	if fileExists(filepath.Join(dir, "MANIFEST")) {
+		return BadgerBackend
+	}
+	if fileExists(filepath.Join(dir, boltFile)) {
+		return BoltBackend
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
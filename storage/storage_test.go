@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// Opens a store of every backend in temporary directories.
+func openStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	stores := make(map[string]Store)
+	for _, backend := range []string{BadgerBackend, BoltBackend, MemoryBackend} {
+		store, err := Open(backend, t.TempDir())
+		if err != nil {
+			t.Fatalf("failed to open %s store: %v", backend, err)
+		}
+		t.Cleanup(func() { store.Close() })
+		stores[backend] = store
+	}
+	return stores
+}
+
+func put(t *testing.T, store Store, keys ...string) {
+	t.Helper()
+
+	err := store.Update(func(batch Batch) error {
+		for _, key := range keys {
+			if err := batch.Put([]byte(key), []byte("value-"+key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to put keys: %v", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	for backend, store := range openStores(t) {
+		put(t, store, "a")
+
+		value, err := store.Get([]byte("a"))
+		if err != nil || !bytes.Equal(value, []byte("value-a")) {
+			t.Errorf("%s: got %q, %v", backend, value, err)
+		}
+		if _, err := store.Get([]byte("b")); err != ErrNotFound {
+			t.Errorf("%s: expected ErrNotFound for a missing key, got %v", backend, err)
+		}
+	}
+}
+
+func TestIterate(t *testing.T) {
+	for backend, store := range openStores(t) {
+		put(t, store, "p-2", "q-1", "p-1", "p-3")
+
+		var keys []string
+		err := store.Iterate([]byte("p-"), func(key, value []byte) error {
+			if !bytes.Equal(value, []byte("value-"+string(key))) {
+				t.Errorf("%s: unexpected value %q of %s", backend, value, key)
+			}
+			keys = append(keys, string(key))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to iterate: %v", backend, err)
+		}
+		if len(keys) != 3 || keys[0] != "p-1" || keys[1] != "p-2" || keys[2] != "p-3" {
+			t.Errorf("%s: expected keys p-1, p-2 and p-3 in order, got %v", backend, keys)
+		}
+	}
+}
+
+func TestUpdateIsAtomic(t *testing.T) {
+	for backend, store := range openStores(t) {
+		put(t, store, "a")
+
+		failure := errors.New("failure")
+		err := store.Update(func(batch Batch) error {
+			if err := batch.Put([]byte("b"), []byte("value-b")); err != nil {
+				return err
+			}
+			if err := batch.Delete([]byte("a")); err != nil {
+				return err
+			}
+			return failure
+		})
+		if err != failure {
+			t.Fatalf("%s: expected the error of the update, got %v", backend, err)
+		}
+		if _, err := store.Get([]byte("a")); err != nil {
+			t.Errorf("%s: deleted key of a failed update is gone: %v", backend, err)
+		}
+		if _, err := store.Get([]byte("b")); err != ErrNotFound {
+			t.Errorf("%s: put key of a failed update exists: %v", backend, err)
+		}
+	}
+}
+
+func TestBatchReadsItsWrites(t *testing.T) {
+	for backend, store := range openStores(t) {
+		put(t, store, "a")
+
+		err := store.Update(func(batch Batch) error {
+			if err := batch.Put([]byte("b"), []byte("value-b")); err != nil {
+				return err
+			}
+			if err := batch.Delete([]byte("a")); err != nil {
+				return err
+			}
+			if value, err := batch.Get([]byte("b")); err != nil || !bytes.Equal(value, []byte("value-b")) {
+				t.Errorf("%s: batch doesn't see its put: %q, %v", backend, value, err)
+			}
+			if _, err := batch.Get([]byte("a")); err != ErrNotFound {
+				t.Errorf("%s: batch sees a key it deleted: %v", backend, err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to update: %v", backend, err)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	stores := openStores(t)
+	var keys []string
+	for i := 0; i < copyBatchSize+1; i++ {
+		keys = append(keys, string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+	put(t, stores[MemoryBackend], keys...)
+
+	for _, backend := range []string{BadgerBackend, BoltBackend} {
+		if err := Copy(stores[backend], stores[MemoryBackend]); err != nil {
+			t.Fatalf("%s: failed to copy store: %v", backend, err)
+		}
+		copied := 0
+		err := stores[backend].Iterate(nil, func(key, value []byte) error {
+			copied++
+			if !bytes.Equal(value, []byte("value-"+string(key))) {
+				return errors.Errorf("unexpected value %q of %q", value, key)
+			}
+			return nil
+		})
+		if err != nil || copied != len(keys) {
+			t.Errorf("%s: expected %d keys to be copied, got %d, %v", backend, len(keys), copied, err)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, backend := range []string{BadgerBackend, BoltBackend} {
+		dir := t.TempDir()
+		if detected := Detect(dir); detected != "" {
+			t.Errorf("detected %s in an empty directory", detected)
+		}
+		store, err := Open(backend, dir)
+		if err != nil {
+			t.Fatalf("failed to open %s store: %v", backend, err)
+		}
+		store.Close()
+		if detected := Detect(dir); detected != backend {
+			t.Errorf("expected %s, detected %q", backend, detected)
+		}
+	}
+}
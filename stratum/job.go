@@ -0,0 +1,194 @@
+package stratum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+)
+
+const (
+	// Size of the extra nonce part assigned by the server to every connection.
+	ExtraNonce1Size = 4
+	// Size of the extra nonce part rolled by workers, both parts fill the coinbase extra nonce.
+	ExtraNonce2Size = blockchain.ExtraNonceSize - ExtraNonce1Size
+)
+
+// A Job is a block template distributed to workers. The serialized coinbase is split around its extra nonce,
+// so every worker hashes different blocks by filling in its own extra nonce. Unlike Bitcoin the block commits
+// to the hash of all transaction IDs, so TxIDs lists the IDs following the coinbase instead of a merkle branch.
+type Job struct {
+	ID         string
+	PrevHash   []byte
+	Coinbase1  []byte // serialized coinbase up to the extra nonce
+	Coinbase2  []byte // serialized coinbase after the extra nonce
+	TxIDs      [][]byte
+	Height     int
+	Timestamp  int64
+	Difficulty int
+	CleanJobs  bool // workers have to abandon previous jobs
+
+	txs []*blockchain.Transaction // known only to the server
+}
+
+// Creates a job from a block template with a coinbase paying to the pool address.
+func newJob(id string, template *blockchain.BlockTemplate, poolAddress string, cleanJobs bool) (*Job, error) {
+	block, err := template.NewBlock(poolAddress)
+	if err != nil {
+		return nil, err
+	}
+	coinbase := block.Transactions[0]
+	zeros, err := coinbaseData(coinbase, bytes.Repeat([]byte{0x00}, blockchain.ExtraNonceSize))
+	if err != nil {
+		return nil, err
+	}
+	ones, err := coinbaseData(coinbase, bytes.Repeat([]byte{0xff}, blockchain.ExtraNonceSize))
+	if err != nil {
+		return nil, err
+	}
+
+	// the serializations differ only in the extra nonce
+	start := 0
+	for start < len(zeros) && zeros[start] == ones[start] {
+		start++
+	}
+	end := start + blockchain.ExtraNonceSize
+	if len(zeros) != len(ones) || end > len(zeros) || !bytes.Equal(zeros[end:], ones[end:]) {
+		return nil, errors.New("failed to locate the extra nonce in the coinbase")
+	}
+
+	job := &Job{
+		ID:         id,
+		PrevHash:   template.PrevHash,
+		Coinbase1:  zeros[:start],
+		Coinbase2:  zeros[end:],
+		Height:     template.Height,
+		Timestamp:  template.Timestamp,
+		Difficulty: template.Difficulty,
+		CleanJobs:  cleanJobs,
+		txs:        template.Transactions,
+	}
+	for _, tx := range template.Transactions {
+		job.TxIDs = append(job.TxIDs, tx.ID)
+	}
+	return job, nil
+}
+
+// Serializes a coinbase transaction with an extra nonce, the hash of the serialization is the coinbase ID.
+func coinbaseData(coinbase *blockchain.Transaction, extraNonce []byte) ([]byte, error) {
+	if err := coinbase.SetExtraNonce(extraNonce); err != nil {
+		return nil, err
+	}
+	txCopy := *coinbase
+	txCopy.ID = nil
+	return txCopy.Serialize(), nil
+}
+
+// Creates the block of a job with an extra nonce, a timestamp and a nonce.
+// The server's blocks contain all transactions, workers only know the transaction IDs which is enough for hashing.
+func (job *Job) Block(extraNonce []byte, timestamp int64, nonce int) (*blockchain.Block, error) {
+	if len(extraNonce) != blockchain.ExtraNonceSize {
+		return nil, errors.Errorf("extra nonce must have %d bytes", blockchain.ExtraNonceSize)
+	}
+
+	data := make([]byte, 0, len(job.Coinbase1)+len(extraNonce)+len(job.Coinbase2))
+	data = append(append(append(data, job.Coinbase1...), extraNonce...), job.Coinbase2...)
+	coinbase, err := blockchain.DecodeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	coinbase.SetID()
+
+	txs := []*blockchain.Transaction{coinbase}
+	if job.txs != nil {
+		txs = append(txs, job.txs...)
+	} else {
+		for _, txID := range job.TxIDs {
+			txs = append(txs, &blockchain.Transaction{ID: txID})
+		}
+	}
+
+	block := blockchain.NewBlock(txs, job.PrevHash, job.Height, job.Difficulty)
+	block.Timestamp = timestamp
+	block.Nonce = nonce
+	return block, nil
+}
+
+// Encodes the job as params of mining.notify:
+// job_id, prevhash, coinb1, coinb2, txids, height, difficulty, ntime, clean_jobs.
+func (job *Job) notifyParams() []interface{} {
+	txIDs := []string{}
+	for _, txID := range job.TxIDs {
+		txIDs = append(txIDs, hex.EncodeToString(txID))
+	}
+	return []interface{}{
+		job.ID,
+		hex.EncodeToString(job.PrevHash),
+		hex.EncodeToString(job.Coinbase1),
+		hex.EncodeToString(job.Coinbase2),
+		txIDs,
+		fmt.Sprintf("%08x", job.Height),
+		fmt.Sprintf("%08x", job.Difficulty),
+		fmt.Sprintf("%08x", job.Timestamp),
+		job.CleanJobs,
+	}
+}
+
+// Decodes a job from params of mining.notify.
+func parseNotifyParams(params []json.RawMessage) (*Job, error) {
+	if len(params) < 9 {
+		return nil, errors.New("mining.notify has too few params")
+	}
+
+	var id, prevHash, coinbase1, coinbase2, height, difficulty, timestamp string
+	var txIDs []string
+	var cleanJobs bool
+	targets := []interface{}{&id, &prevHash, &coinbase1, &coinbase2, &txIDs, &height, &difficulty, &timestamp, &cleanJobs}
+	for i, target := range targets {
+		if err := json.Unmarshal(params[i], target); err != nil {
+			return nil, errors.Wrapf(err, "invalid mining.notify param %d", i)
+		}
+	}
+
+	job := &Job{ID: id, CleanJobs: cleanJobs}
+	var err error
+	if job.PrevHash, err = hex.DecodeString(prevHash); err != nil {
+		return nil, errors.Wrap(err, "invalid prevhash")
+	}
+	if job.Coinbase1, err = hex.DecodeString(coinbase1); err != nil {
+		return nil, errors.Wrap(err, "invalid coinb1")
+	}
+	if job.Coinbase2, err = hex.DecodeString(coinbase2); err != nil {
+		return nil, errors.Wrap(err, "invalid coinb2")
+	}
+	for _, txID := range txIDs {
+		decoded, err := hex.DecodeString(txID)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid transaction id")
+		}
+		job.TxIDs = append(job.TxIDs, decoded)
+	}
+	if job.Height, err = parseHexInt(height); err != nil {
+		return nil, errors.Wrap(err, "invalid height")
+	}
+	if job.Difficulty, err = parseHexInt(difficulty); err != nil {
+		return nil, errors.Wrap(err, "invalid difficulty")
+	}
+	ntime, err := parseHexInt(timestamp)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ntime")
+	}
+	job.Timestamp = int64(ntime)
+	return job, nil
+}
+
+// Parses a hex encoded unsigned 32 bit number.
+func parseHexInt(s string) (int, error) {
+	value, err := strconv.ParseUint(s, 16, 32)
+	return int(value), err
+}
@@ -0,0 +1,5 @@
+package stratum
+
+import "github.com/michaljirman/goblockchain/logger"
+
+var log = logger.Get(logger.PoW)
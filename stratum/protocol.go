@@ -0,0 +1,116 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Stratum error codes.
+const (
+	ErrCodeOther         = 20
+	ErrCodeJobNotFound   = 21
+	ErrCodeDuplicate     = 22
+	ErrCodeLowDifficulty = 23
+	ErrCodeUnauthorized  = 24
+	ErrCodeNotSubscribed = 25
+)
+
+// Maximum size of a single message.
+const maxMessageSize = 1 << 20
+
+// A message is a request, a response or a notification (a request without an ID) sent as a single JSON line.
+type message struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method,omitempty"`
+	Params []json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  *Error            `json:"error,omitempty"`
+}
+
+// An outgoing message, params and results are encoded when sent.
+type outMessage struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method,omitempty"`
+	Params []interface{} `json:"params,omitempty"`
+	Result interface{}   `json:"result"`
+	Error  *Error        `json:"error"`
+}
+
+// An Error is encoded as [code, message, traceback] in Stratum responses.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || len(fields) < 2 {
+		return errors.New("invalid stratum error")
+	}
+	if err := json.Unmarshal(fields[0], &e.Code); err != nil {
+		return errors.Wrap(err, "invalid stratum error code")
+	}
+	return errors.Wrap(json.Unmarshal(fields[1], &e.Message), "invalid stratum error message")
+}
+
+// A conn reads and writes line delimited JSON messages, writes are safe for concurrent use.
+type conn struct {
+	netConn net.Conn
+	scanner *bufio.Scanner
+	mu      sync.Mutex
+}
+
+func newConn(netConn net.Conn) *conn {
+	scanner := bufio.NewScanner(netConn)
+	scanner.Buffer(make([]byte, 4096), maxMessageSize)
+	return &conn{netConn: netConn, scanner: scanner}
+}
+
+// Reads the next message.
+func (c *conn) read() (*message, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("connection closed")
+	}
+	var msg message
+	if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+		return nil, errors.Wrap(err, "invalid message")
+	}
+	return &msg, nil
+}
+
+// Writes a message followed by a newline.
+func (c *conn) write(msg outMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.netConn.Write(append(data, '\n'))
+	return err
+}
+
+func (c *conn) notify(method string, params ...interface{}) error {
+	return c.write(outMessage{Method: method, Params: params})
+}
+
+func (c *conn) close() error {
+	return c.netConn.Close()
+}
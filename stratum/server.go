@@ -0,0 +1,454 @@
+package stratum
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+)
+
+const (
+	// How often the server checks for a new last block.
+	tipPollInterval = 2 * time.Second
+	// How often a new job with current mempool transactions is sent even without a new block.
+	jobRefreshInterval = 30 * time.Second
+	// How far in the future a share timestamp may be.
+	maxFutureShareTime = 2 * time.Hour
+)
+
+// A WorkSource provides block templates and accepts mined blocks, e.g. a node's RPC client.
+type WorkSource interface {
+	GetBestBlockHash() ([]byte, error)
+	GetBlockTemplate() (*blockchain.BlockTemplate, error)
+	SubmitBlock(block *blockchain.Block) error
+}
+
+// WorkerStats account the shares of a worker for payout calculations.
+type WorkerStats struct {
+	Accepted  int
+	Rejected  int
+	Stale     int     // shares of jobs abandoned after a new block
+	Blocks    int     // shares which were valid blocks
+	Work      float64 // expected number of hashes behind the accepted shares
+	LastShare time.Time
+}
+
+// A Server is a Stratum v1 mining pool server. It distributes jobs built from templates of a work source,
+// assigns every connection its own extra nonce, validates shares of an easier difficulty than blocks
+// and submits shares which are valid blocks to the work source.
+type Server struct {
+	source          WorkSource
+	poolAddress     string
+	shareDifficulty int
+
+	mu          sync.Mutex
+	job         *Job
+	jobs        map[string]*Job // jobs which can still be submitted
+	jobTime     time.Time
+	nextJobID   uint64
+	extraNonce1 uint32
+	sessions    map[*session]bool
+	shares      map[string]bool // submitted shares of current jobs
+	stats       map[string]*WorkerStats
+	rewards     int // coinbase value of all blocks found by the pool
+}
+
+// A session is a connection of a worker process, which may authorize several workers.
+type session struct {
+	conn        *conn
+	extraNonce1 []byte
+	subscribed  bool
+	workers     map[string]bool
+}
+
+// Creates a new Server paying block rewards to the pool address and accepting shares with shareDifficulty
+// leading zero bits, shares of blocks with a lower difficulty need the block difficulty.
+func NewServer(source WorkSource, poolAddress string, shareDifficulty int) *Server {
+	return &Server{
+		source:          source,
+		poolAddress:     poolAddress,
+		shareDifficulty: shareDifficulty,
+		jobs:            make(map[string]*Job),
+		sessions:        make(map[*session]bool),
+		shares:          make(map[string]bool),
+		stats:           make(map[string]*WorkerStats),
+	}
+}
+
+// Accepts worker connections on an address until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if err := s.updateJob(true); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to start stratum server")
+	}
+	log.Info().Str("addr", addr).Msg("Stratum server started")
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		s.mu.Lock()
+		for sess := range s.sessions {
+			sess.conn.close()
+		}
+		s.mu.Unlock()
+	}()
+	go s.pollWork(ctx)
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, "failed to accept connection")
+		}
+		go s.serve(newConn(netConn))
+	}
+}
+
+// Sends a new job whenever the last block changes and refreshes jobs with new mempool transactions.
+func (s *Server) pollWork(ctx context.Context) {
+	ticker := time.NewTicker(tipPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		hash, err := s.source.GetBestBlockHash()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get the last block")
+			continue
+		}
+
+		s.mu.Lock()
+		newTip := !bytes.Equal(hash, s.job.PrevHash)
+		refresh := time.Since(s.jobTime) > jobRefreshInterval
+		s.mu.Unlock()
+
+		if newTip || refresh {
+			if err := s.updateJob(newTip); err != nil {
+				log.Error().Err(err).Msg("failed to update job")
+			}
+		}
+	}
+}
+
+// Creates a job from a new template and sends it to all subscribed sessions.
+// Clean jobs replace all previous jobs, shares of previous jobs are stale.
+func (s *Server) updateJob(clean bool) error {
+	template, err := s.source.GetBlockTemplate()
+	if err != nil {
+		return errors.Wrap(err, "failed to get block template")
+	}
+
+	s.mu.Lock()
+	s.nextJobID++
+	job, err := newJob(strconv.FormatUint(s.nextJobID, 16), template, s.poolAddress, clean)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if clean {
+		s.jobs = make(map[string]*Job)
+		s.shares = make(map[string]bool)
+	}
+	s.job = job
+	s.jobs[job.ID] = job
+	s.jobTime = time.Now()
+
+	var sessions []*session
+	for sess := range s.sessions {
+		if sess.subscribed {
+			sessions = append(sessions, sess)
+		}
+	}
+	difficulty := s.jobShareDifficulty(job)
+	s.mu.Unlock()
+
+	log.Debug().Str("job", job.ID).Int("height", job.Height).Bool("clean", clean).Msg("new job")
+	for _, sess := range sessions {
+		sess.conn.notify("mining.set_difficulty", difficulty)
+		sess.conn.notify("mining.notify", job.notifyParams()...)
+	}
+	return nil
+}
+
+// Gets the share difficulty of a job, which is never above the block difficulty.
+func (s *Server) jobShareDifficulty(job *Job) int {
+	if s.shareDifficulty < job.Difficulty {
+		return s.shareDifficulty
+	}
+	return job.Difficulty
+}
+
+// Handles messages of a connection until it is closed.
+func (s *Server) serve(c *conn) {
+	s.mu.Lock()
+	s.extraNonce1++
+	sess := &session{
+		conn:        c,
+		extraNonce1: make([]byte, ExtraNonce1Size),
+		workers:     make(map[string]bool),
+	}
+	binary.BigEndian.PutUint32(sess.extraNonce1, s.extraNonce1)
+	s.sessions[sess] = true
+	s.mu.Unlock()
+
+	remote := c.netConn.RemoteAddr().String()
+	log.Info().Str("remote", remote).Msg("worker connected")
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+		c.close()
+		log.Info().Str("remote", remote).Msg("worker disconnected")
+	}()
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return
+		}
+
+		result, err := s.handle(sess, msg)
+		resp := outMessage{ID: msg.ID, Result: result}
+		if err != nil {
+			if stratumErr, ok := err.(*Error); ok {
+				resp.Error = stratumErr
+			} else {
+				resp.Error = &Error{ErrCodeOther, err.Error()}
+			}
+		}
+		if err := c.write(resp); err != nil {
+			return
+		}
+
+		if msg.Method == "mining.subscribe" && err == nil {
+			s.mu.Lock()
+			job := s.job
+			difficulty := s.jobShareDifficulty(job)
+			s.mu.Unlock()
+			c.notify("mining.set_difficulty", difficulty)
+			c.notify("mining.notify", job.notifyParams()...)
+		}
+	}
+}
+
+// Handles a request of a session.
+func (s *Server) handle(sess *session, msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "mining.subscribe":
+		s.mu.Lock()
+		sess.subscribed = true
+		s.mu.Unlock()
+		subscriptionID := hex.EncodeToString(sess.extraNonce1)
+		subscriptions := [][]string{{"mining.set_difficulty", subscriptionID}, {"mining.notify", subscriptionID}}
+		return []interface{}{subscriptions, hex.EncodeToString(sess.extraNonce1), ExtraNonce2Size}, nil
+	case "mining.authorize":
+		var name string
+		if len(msg.Params) == 0 || json.Unmarshal(msg.Params[0], &name) != nil || name == "" {
+			return nil, &Error{ErrCodeUnauthorized, "missing worker name"}
+		}
+		s.mu.Lock()
+		sess.workers[name] = true
+		if _, ok := s.stats[name]; !ok {
+			s.stats[name] = &WorkerStats{}
+		}
+		s.mu.Unlock()
+		log.Info().Str("worker", name).Msg("worker authorized")
+		return true, nil
+	case "mining.submit":
+		return s.submit(sess, msg.Params)
+	default:
+		return nil, &Error{ErrCodeOther, "unknown method " + msg.Method}
+	}
+}
+
+// Validates a share: [worker name, job id, extranonce2, ntime, nonce].
+// Shares meeting the block target are submitted to the work source as blocks.
+func (s *Server) submit(sess *session, params []json.RawMessage) (interface{}, error) {
+	var fields [5]string
+	if len(params) < len(fields) {
+		return nil, &Error{ErrCodeOther, "mining.submit has too few params"}
+	}
+	for i := range fields {
+		if err := json.Unmarshal(params[i], &fields[i]); err != nil {
+			return nil, &Error{ErrCodeOther, fmt.Sprintf("invalid mining.submit param %d", i)}
+		}
+	}
+
+	block, err := s.acceptShare(sess, fields)
+	if err != nil {
+		return nil, err
+	}
+	if block != nil {
+		// the work source may be a remote node, so the server isn't locked while the block is submitted
+		s.submitBlock(fields[0], block)
+	}
+	return true, nil
+}
+
+// Validates and accounts a share, returns its block if the share meets the block target.
+func (s *Server) acceptShare(sess *session, fields [5]string) (*blockchain.Block, error) {
+	name, jobID := fields[0], fields[1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !sess.subscribed {
+		return nil, &Error{ErrCodeNotSubscribed, "not subscribed"}
+	}
+	if !sess.workers[name] {
+		return nil, &Error{ErrCodeUnauthorized, "unauthorized worker " + name}
+	}
+	stats := s.stats[name]
+
+	job, ok := s.jobs[jobID]
+	if !ok {
+		stats.Stale++
+		return nil, &Error{ErrCodeJobNotFound, "job not found"}
+	}
+
+	extraNonce2, err := hex.DecodeString(fields[2])
+	if err != nil || len(extraNonce2) != ExtraNonce2Size {
+		stats.Rejected++
+		return nil, &Error{ErrCodeOther, fmt.Sprintf("extranonce2 must have %d bytes", ExtraNonce2Size)}
+	}
+	ntime, err := parseHexInt(fields[3])
+	if err != nil || int64(ntime) < job.Timestamp || int64(ntime) > time.Now().Add(maxFutureShareTime).Unix() {
+		stats.Rejected++
+		return nil, &Error{ErrCodeOther, "ntime out of range"}
+	}
+	nonce, err := strconv.ParseUint(fields[4], 16, 32)
+	if err != nil {
+		stats.Rejected++
+		return nil, &Error{ErrCodeOther, "invalid nonce"}
+	}
+
+	shareKey := fmt.Sprintf("%s:%x:%x:%s:%s", jobID, sess.extraNonce1, extraNonce2, fields[3], fields[4])
+	if s.shares[shareKey] {
+		stats.Rejected++
+		return nil, &Error{ErrCodeDuplicate, "duplicate share"}
+	}
+
+	extraNonce := append(append([]byte{}, sess.extraNonce1...), extraNonce2...)
+	block, err := job.Block(extraNonce, int64(ntime), int(nonce))
+	if err != nil {
+		stats.Rejected++
+		return nil, err
+	}
+	pow := blockchain.NewProof(block)
+	difficulty := s.jobShareDifficulty(job)
+	if !pow.ValidateTarget(blockchain.TargetForDifficulty(difficulty)) {
+		stats.Rejected++
+		return nil, &Error{ErrCodeLowDifficulty, "low difficulty share"}
+	}
+
+	s.shares[shareKey] = true
+	stats.Accepted++
+	stats.Work += math.Pow(2, float64(difficulty))
+	stats.LastShare = time.Now()
+
+	if !pow.Validate() {
+		return nil, nil
+	}
+	block.Hash = pow.Hash()
+	return block, nil
+}
+
+// Submits a block found by a worker to the work source and sends a new job if it is accepted.
+func (s *Server) submitBlock(name string, block *blockchain.Block) {
+	if err := s.source.SubmitBlock(block); err != nil {
+		log.Error().Err(err).Str("worker", name).Msg("block rejected")
+		return
+	}
+
+	s.mu.Lock()
+	s.stats[name].Blocks++
+	for _, out := range block.Transactions[0].Outputs {
+		s.rewards += out.Value
+	}
+	s.mu.Unlock()
+	log.Info().Str("worker", name).Int("height", block.Height).Hex("hash", block.Hash).Msg("block found")
+
+	go func() {
+		if err := s.updateJob(true); err != nil {
+			log.Error().Err(err).Msg("failed to update job")
+		}
+	}()
+}
+
+// Gets a copy of the share accounting of every worker.
+func (s *Server) Stats() map[string]WorkerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]WorkerStats)
+	for name, workerStats := range s.stats {
+		stats[name] = *workerStats
+	}
+	return stats
+}
+
+// Gets the coinbase value of all blocks found by the pool.
+func (s *Server) Rewards() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rewards
+}
+
+// Splits an amount between workers proportionally to the work of their accepted shares.
+// Amounts are rounded down, the remainder goes to the workers with the most work.
+func (s *Server) Payouts(amount int) map[string]int {
+	stats := s.Stats()
+
+	var names []string
+	totalWork := 0.0
+	for name, workerStats := range stats {
+		if workerStats.Work > 0 {
+			names = append(names, name)
+			totalWork += workerStats.Work
+		}
+	}
+	payouts := make(map[string]int)
+	if totalWork == 0 {
+		return payouts
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if stats[names[i]].Work != stats[names[j]].Work {
+			return stats[names[i]].Work > stats[names[j]].Work
+		}
+		return names[i] < names[j]
+	})
+
+	paid := 0
+	for _, name := range names {
+		payouts[name] = int(float64(amount) * stats[name].Work / totalWork)
+		paid += payouts[name]
+	}
+	for i := 0; paid < amount; i++ {
+		payouts[names[i%len(names)]]++
+		paid++
+	}
+	return payouts
+}
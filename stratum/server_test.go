@@ -0,0 +1,307 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// A fakeSource hands out templates on top of a fixed block and records submitted blocks.
+type fakeSource struct {
+	difficulty int
+	onSubmit   func() // called while a block is submitted, if set
+
+	mu     sync.Mutex
+	blocks []*blockchain.Block
+}
+
+func (f *fakeSource) GetBestBlockHash() ([]byte, error) {
+	return []byte("tip"), nil
+}
+
+func (f *fakeSource) GetBlockTemplate() (*blockchain.BlockTemplate, error) {
+	return &blockchain.BlockTemplate{
+		PrevHash:      []byte("tip"),
+		Height:        1,
+		Timestamp:     time.Now().Unix(),
+		Difficulty:    f.difficulty,
+		Target:        blockchain.TargetForDifficulty(f.difficulty),
+		CoinbaseValue: blockchain.BlockReward,
+	}, nil
+}
+
+func (f *fakeSource) SubmitBlock(block *blockchain.Block) error {
+	if f.onSubmit != nil {
+		f.onSubmit()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks = append(f.blocks, block)
+	return nil
+}
+
+func (f *fakeSource) submitted() []*blockchain.Block {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*blockchain.Block{}, f.blocks...)
+}
+
+// Creates a server of a fake source with its first job and accepts connections on a local port.
+func newTestServer(t *testing.T, source *fakeSource, shareDifficulty int) (*Server, string) {
+	t.Helper()
+	server := NewServer(source, string(wallet.MakeWallet().Address()), shareDifficulty)
+	if err := server.updateJob(true); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(newConn(netConn))
+		}
+	}()
+	return server, listener.Addr().String()
+}
+
+// A testWorker speaks the Stratum protocol to a server, keeping the last job it was notified of.
+type testWorker struct {
+	conn        *conn
+	nextID      int
+	extraNonce1 []byte
+	job         *Job
+}
+
+// Connects a worker, subscribes and authorizes it with a name.
+func newTestWorker(t *testing.T, addr, name string) *testWorker {
+	t.Helper()
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { netConn.Close() })
+	w := &testWorker{conn: newConn(netConn)}
+
+	result, err := w.call(t, "mining.subscribe", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.extraNonce1, err = parseSubscribeResult(result); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.call(t, "mining.authorize", name, ""); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// Sends a request and waits for its response, notifications received meanwhile update the job.
+func (w *testWorker) call(t *testing.T, method string, params ...interface{}) (json.RawMessage, error) {
+	t.Helper()
+	w.nextID++
+	if err := w.conn.write(outMessage{ID: w.nextID, Method: method, Params: params}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg := w.read(t)
+		if msg.Method != "" {
+			continue
+		}
+		if id, ok := msg.ID.(float64); !ok || int(id) != w.nextID {
+			t.Fatalf("unexpected response %+v", msg)
+		}
+		if msg.Error != nil {
+			return nil, msg.Error
+		}
+		return msg.Result, nil
+	}
+}
+
+// Reads the next message, keeping the job of mining.notify.
+func (w *testWorker) read(t *testing.T) *message {
+	t.Helper()
+	w.conn.netConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := w.conn.read()
+	if err != nil {
+		t.Fatalf("failed to read from the server: %v", err)
+	}
+	if msg.Method == "mining.notify" {
+		if w.job, err = parseNotifyParams(msg.Params); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msg
+}
+
+// Waits for the next job of the server.
+func (w *testWorker) nextJob(t *testing.T) *Job {
+	t.Helper()
+	for {
+		if msg := w.read(t); msg.Method == "mining.notify" {
+			return w.job
+		}
+	}
+}
+
+// Finds a nonce of the worker's current job whose block meets or, if valid is false, misses a difficulty.
+func (w *testWorker) findNonce(t *testing.T, extraNonce2 []byte, ntime int64, difficulty int, valid bool) uint32 {
+	t.Helper()
+	block, err := w.job.Block(append(append([]byte{}, w.extraNonce1...), extraNonce2...), ntime, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := blockchain.TargetForDifficulty(difficulty)
+	for nonce := 0; nonce <= math.MaxUint32; nonce++ {
+		block.Nonce = nonce
+		if blockchain.NewProof(block).ValidateTarget(target) == valid {
+			return uint32(nonce)
+		}
+	}
+	t.Fatal("no nonce found")
+	return 0
+}
+
+// Submits a share of the worker's current job.
+func (w *testWorker) submit(t *testing.T, name string, extraNonce2 []byte, ntime int64, nonce uint32) error {
+	t.Helper()
+	_, err := w.call(t, "mining.submit", name, w.job.ID,
+		hex.EncodeToString(extraNonce2), fmt.Sprintf("%08x", ntime), fmt.Sprintf("%08x", nonce))
+	return err
+}
+
+// Checks that err is a Stratum error with a code.
+func expectStratumError(t *testing.T, err error, code int) {
+	t.Helper()
+	if stratumErr, ok := err.(*Error); !ok || stratumErr.Code != code {
+		t.Errorf("expected error code %d, got %v", code, err)
+	}
+}
+
+func TestSharesAreValidated(t *testing.T) {
+	const shareDifficulty = 4
+	// shares never meet the block difficulty
+	source := &fakeSource{difficulty: 255}
+	server, addr := newTestServer(t, source, shareDifficulty)
+	w := newTestWorker(t, addr, "alice")
+
+	extraNonce2 := make([]byte, ExtraNonce2Size)
+	ntime := w.job.Timestamp
+	nonce := w.findNonce(t, extraNonce2, ntime, shareDifficulty, true)
+	if err := w.submit(t, "alice", extraNonce2, ntime, nonce); err != nil {
+		t.Fatalf("expected a valid share to be accepted, got %v", err)
+	}
+	expectStratumError(t, w.submit(t, "alice", extraNonce2, ntime, nonce), ErrCodeDuplicate)
+
+	lowNonce := w.findNonce(t, extraNonce2, ntime, shareDifficulty, false)
+	expectStratumError(t, w.submit(t, "alice", extraNonce2, ntime, lowNonce), ErrCodeLowDifficulty)
+
+	for _, badTime := range []int64{ntime - 1, time.Now().Add(maxFutureShareTime + time.Hour).Unix()} {
+		nonce := w.findNonce(t, extraNonce2, badTime, shareDifficulty, true)
+		expectStratumError(t, w.submit(t, "alice", extraNonce2, badTime, nonce), ErrCodeOther)
+	}
+	expectStratumError(t, w.submit(t, "mallory", extraNonce2, ntime, nonce), ErrCodeUnauthorized)
+
+	// a clean job makes shares of the previous job stale
+	staleJob := w.job
+	if err := server.updateJob(true); err != nil {
+		t.Fatal(err)
+	}
+	currentJob := w.nextJob(t)
+	w.job = staleJob
+	expectStratumError(t, w.submit(t, "alice", extraNonce2, ntime, nonce), ErrCodeJobNotFound)
+	w.job = currentJob
+
+	stats := server.Stats()["alice"]
+	if stats.Accepted != 1 || stats.Rejected != 4 || stats.Stale != 1 || stats.Blocks != 0 {
+		t.Errorf("expected 1 accepted, 4 rejected and 1 stale share, got %+v", stats)
+	}
+	if stats.Work != math.Pow(2, shareDifficulty) {
+		t.Errorf("expected the work of one share, got %f", stats.Work)
+	}
+	if len(source.submitted()) != 0 {
+		t.Errorf("expected shares below the block difficulty not to be submitted")
+	}
+}
+
+func TestBlockSharesAreSubmitted(t *testing.T) {
+	const difficulty = 4
+	source := &fakeSource{difficulty: difficulty}
+	server, addr := newTestServer(t, source, difficulty)
+	// the server must not hold its lock while the work source is called, the share response would time out
+	source.onSubmit = func() { server.Stats() }
+	w := newTestWorker(t, addr, "alice")
+
+	extraNonce2 := make([]byte, ExtraNonce2Size)
+	nonce := w.findNonce(t, extraNonce2, w.job.Timestamp, difficulty, true)
+	if err := w.submit(t, "alice", extraNonce2, w.job.Timestamp, nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := source.submitted()
+	if len(blocks) != 1 || !blockchain.NewProof(blocks[0]).Validate() {
+		t.Fatalf("expected a valid block to be submitted, got %d blocks", len(blocks))
+	}
+	if stats := server.Stats()["alice"]; stats.Blocks != 1 || stats.Accepted != 1 {
+		t.Errorf("expected the share to count as a block, got %+v", stats)
+	}
+	if server.Rewards() != blockchain.BlockReward {
+		t.Errorf("expected rewards of %d, got %d", blockchain.BlockReward, server.Rewards())
+	}
+}
+
+func TestExtraNonce1PerConnection(t *testing.T) {
+	_, addr := newTestServer(t, &fakeSource{difficulty: 255}, 4)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		w := newTestWorker(t, addr, fmt.Sprintf("worker%d", i))
+		extraNonce1 := hex.EncodeToString(w.extraNonce1)
+		if seen[extraNonce1] {
+			t.Errorf("expected every connection to get its own extranonce1, %s is repeated", extraNonce1)
+		}
+		seen[extraNonce1] = true
+	}
+}
+
+func TestPayouts(t *testing.T) {
+	tests := []struct {
+		work     map[string]float64
+		amount   int
+		expected map[string]int
+	}{
+		{map[string]float64{"alice": 3, "bob": 1, "carol": 0}, 100, map[string]int{"alice": 75, "bob": 25}},
+		// the remainder goes to the workers with the most work, ties are broken by name
+		{map[string]float64{"alice": 2, "bob": 1}, 10, map[string]int{"alice": 7, "bob": 3}},
+		{map[string]float64{"carol": 1, "bob": 1, "alice": 1}, 101, map[string]int{"alice": 34, "bob": 34, "carol": 33}},
+		{map[string]float64{"alice": 0}, 100, map[string]int{}},
+	}
+
+	for _, test := range tests {
+		server := NewServer(&fakeSource{}, "", 1)
+		for name, work := range test.work {
+			server.stats[name] = &WorkerStats{Work: work}
+		}
+		// the result doesn't depend on the order of the stats map
+		for i := 0; i < 10; i++ {
+			payouts := server.Payouts(test.amount)
+			if fmt.Sprint(payouts) != fmt.Sprint(test.expected) {
+				t.Fatalf("expected payouts %v of work %v, got %v", test.expected, test.work, payouts)
+			}
+		}
+	}
+}
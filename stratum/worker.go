@@ -0,0 +1,228 @@
+package stratum
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+)
+
+// Request IDs of the worker, submits use increasing IDs above them.
+const (
+	subscribeID = 1
+	authorizeID = 2
+)
+
+// A Worker mines shares of jobs received from a Stratum server.
+type Worker struct {
+	hashes   uint64 // accessed atomically, kept first for 64-bit alignment
+	nextID   uint64
+	accepted uint64
+	rejected uint64
+
+	Name             string                 // worker name used for share accounting
+	Workers          int                    // number of concurrent mining workers
+	HashRateInterval time.Duration          // how often OnHashRate is called
+	OnHashRate       func(hashRate float64) // receives the hash rate in hashes per second, if set
+
+	cancelJob context.CancelFunc
+}
+
+// Creates a new Worker with a mining worker for every CPU core usable by the process.
+func NewWorker(name string) *Worker {
+	return &Worker{
+		Name:             name,
+		Workers:          runtime.GOMAXPROCS(0),
+		HashRateInterval: time.Second,
+		nextID:           authorizeID,
+	}
+}
+
+// Connects to a Stratum server and mines its jobs until ctx is cancelled or the connection is closed.
+func (w *Worker) Run(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to stratum server")
+	}
+	c := newConn(netConn)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		c.close()
+	}()
+	if w.OnHashRate != nil {
+		go w.reportHashRate(ctx)
+	}
+
+	if err := c.write(outMessage{ID: subscribeID, Method: "mining.subscribe", Params: []interface{}{"goblockchain"}}); err != nil {
+		return errors.Wrap(err, "failed to subscribe")
+	}
+	if err := c.write(outMessage{ID: authorizeID, Method: "mining.authorize", Params: []interface{}{w.Name, ""}}); err != nil {
+		return errors.Wrap(err, "failed to authorize")
+	}
+
+	var extraNonce1 []byte
+	difficulty := 0
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer w.stopJob()
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		switch {
+		case msg.Method == "mining.set_difficulty":
+			if len(msg.Params) == 0 || json.Unmarshal(msg.Params[0], &difficulty) != nil {
+				return errors.New("invalid mining.set_difficulty")
+			}
+		case msg.Method == "mining.notify":
+			job, err := parseNotifyParams(msg.Params)
+			if err != nil {
+				return err
+			}
+			if extraNonce1 == nil {
+				return errors.New("job received before subscription")
+			}
+			log.Debug().Str("job", job.ID).Int("height", job.Height).Int("difficulty", difficulty).Msg("new job")
+
+			w.stopJob()
+			jobCtx, cancelJob := context.WithCancel(ctx)
+			w.cancelJob = cancelJob
+			wg.Add(1)
+			go func(job *Job, difficulty int) {
+				defer wg.Done()
+				w.mineJob(jobCtx, c, job, extraNonce1, difficulty)
+			}(job, difficulty)
+		case msg.Method != "":
+			log.Debug().Str("method", msg.Method).Msg("unsupported stratum notification")
+		default:
+			if msg.Error != nil {
+				if id, ok := msg.ID.(float64); ok && id <= authorizeID {
+					return msg.Error
+				}
+				atomic.AddUint64(&w.rejected, 1)
+				log.Warn().Err(msg.Error).Msg("share rejected")
+				continue
+			}
+			if id, ok := msg.ID.(float64); ok && id == subscribeID {
+				if extraNonce1, err = parseSubscribeResult(msg.Result); err != nil {
+					return err
+				}
+			} else if ok && id > authorizeID {
+				atomic.AddUint64(&w.accepted, 1)
+				log.Info().Uint64("accepted", atomic.LoadUint64(&w.accepted)).Msg("share accepted")
+			}
+		}
+	}
+}
+
+// Gets the numbers of accepted and rejected shares.
+func (w *Worker) Shares() (uint64, uint64) {
+	return atomic.LoadUint64(&w.accepted), atomic.LoadUint64(&w.rejected)
+}
+
+// Stops mining of the current job.
+func (w *Worker) stopJob() {
+	if w.cancelJob != nil {
+		w.cancelJob()
+	}
+}
+
+// Mines shares of a job until it is replaced, every share is found with a new extranonce2.
+func (w *Worker) mineJob(ctx context.Context, c *conn, job *Job, extraNonce1 []byte, difficulty int) {
+	target := blockchain.TargetForDifficulty(difficulty)
+	extraNonce2 := make([]byte, ExtraNonce2Size)
+
+	for counter := uint32(0); ctx.Err() == nil; counter++ {
+		binary.BigEndian.PutUint32(extraNonce2, counter)
+		extraNonce := append(append([]byte{}, extraNonce1...), extraNonce2...)
+		block, err := job.Block(extraNonce, job.Timestamp, 0)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create block of a job")
+			return
+		}
+
+		pow := blockchain.NewProof(block)
+		pow.Target = target
+		nonce, _, err := pow.Run(ctx, w.Workers, &w.hashes)
+		if err == blockchain.ErrNonceSpaceExhausted {
+			continue
+		} else if err != nil {
+			return
+		}
+
+		err = c.write(outMessage{
+			ID:     atomic.AddUint64(&w.nextID, 1),
+			Method: "mining.submit",
+			Params: []interface{}{
+				w.Name,
+				job.ID,
+				hex.EncodeToString(extraNonce2),
+				fmt.Sprintf("%08x", job.Timestamp),
+				fmt.Sprintf("%08x", nonce),
+			},
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Decodes extranonce1 from the result of mining.subscribe: [subscriptions, extranonce1, extranonce2_size].
+func parseSubscribeResult(result json.RawMessage) ([]byte, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(result, &fields); err != nil || len(fields) < 3 {
+		return nil, errors.New("invalid mining.subscribe result")
+	}
+	var encoded string
+	var extraNonce2Size int
+	if json.Unmarshal(fields[1], &encoded) != nil || json.Unmarshal(fields[2], &extraNonce2Size) != nil {
+		return nil, errors.New("invalid mining.subscribe result")
+	}
+	extraNonce1, err := hex.DecodeString(encoded)
+	if err != nil || len(extraNonce1) != ExtraNonce1Size || extraNonce2Size != ExtraNonce2Size {
+		return nil, errors.Errorf("unsupported extranonce sizes %d and %d", len(extraNonce1), extraNonce2Size)
+	}
+	return extraNonce1, nil
+}
+
+// Periodically reports the hash rate until ctx is cancelled.
+func (w *Worker) reportHashRate(ctx context.Context) {
+	interval := w.HashRateInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, lastTime := atomic.LoadUint64(&w.hashes), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current := atomic.LoadUint64(&w.hashes)
+			w.OnHashRate(float64(current-last) / now.Sub(lastTime).Seconds())
+			last, lastTime = current, now
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package wallet
+
+import "github.com/michaljirman/goblockchain/logger"
+
+var log = logger.Get(logger.Wallet)
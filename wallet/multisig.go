@@ -0,0 +1,82 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/script"
+)
+
+// Maximum number of public keys of a multisignature address. Its redeem script <m> <pubKey>... <n> OP_CHECKMULTISIG
+// is pushed by the unlocking script spending it, so it has to fit into script.MaxElementSize.
+const MaxMultiSigKeys = (script.MaxElementSize - 3) / (1 + PublicKeyLength)
+
+// A MultiSig is an M-of-N multisignature address. Outputs paid to its address can be spent
+// only with signatures of M of its N public keys.
+type MultiSig struct {
+	M            int
+	PubKeys      [][]byte
+	RedeemScript script.Script
+}
+
+// Creates a multisignature address requiring m signatures of the public keys.
+// Public keys are sorted so that all signers end up with the same address regardless of their order.
+func NewMultiSig(m int, pubKeys [][]byte) (*MultiSig, error) {
+	if len(pubKeys) > MaxMultiSigKeys {
+		return nil, errors.Errorf("a multisig address has at most %d public keys, got %d", MaxMultiSigKeys, len(pubKeys))
+	}
+	for _, pubKey := range pubKeys {
+		if len(pubKey) != PublicKeyLength {
+			return nil, errors.Errorf("public key %x is not %d bytes", pubKey, PublicKeyLength)
+		}
+	}
+
+	sorted := append([][]byte{}, pubKeys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	redeemScript, err := script.MultiSigScript(m, sorted)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiSig{m, sorted, redeemScript}, nil
+}
+
+// Gets the pay-to-script-hash address of a multisignature redeem script.
+func (ms MultiSig) Address() []byte {
+	return ScriptAddress(script.Hash160(ms.RedeemScript))
+}
+
+// Adds a multisignature address to the wallets.
+func (ws *Wallets) AddMultiSig(m int, pubKeys [][]byte) (string, error) {
+	ms, err := NewMultiSig(m, pubKeys)
+	if err != nil {
+		return "", err
+	}
+	address := fmt.Sprintf("%s", ms.Address())
+	ws.MultiSigs[address] = ms
+	return address, nil
+}
+
+// Gets all stored multisignature addresses.
+func (ws *Wallets) GetMultiSigAddresses() []string {
+	var addresses []string
+	for address := range ws.MultiSigs {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// Finds a multisignature address by the hash of its redeem script.
+func (ws *Wallets) FindMultiSig(scriptHash []byte) (*MultiSig, bool) {
+	for _, ms := range ws.MultiSigs {
+		if bytes.Compare(script.Hash160(ms.RedeemScript), scriptHash) == 0 {
+			return ms, true
+		}
+	}
+	return nil, false
+}
@@ -1,8 +1,6 @@
 package wallet
 
 import (
-	"log"
-
 	"github.com/mr-tron/base58"
 )
 
@@ -14,7 +12,7 @@ func Base58Encode(input []byte) []byte {
 func Base58Decode(input []byte) []byte {
 	decode, err := base58.Decode(string(input))
 	if err != nil {
-		log.Panic(err)
+		panic(err)
 	}
 	return decode
 }
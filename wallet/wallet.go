@@ -6,15 +6,22 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/gob"
+	"math/big"
 
-	"github.com/rs/zerolog/log"
+	"github.com/mr-tron/base58"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
 	ChecksumLength = 4
-	version        = byte(0x00)
+	// Size of a public key serialized by PublicKeyBytes.
+	PublicKeyLength = 64
+	// Version of addresses of public keys.
+	PubKeyHashVersion = byte(0x00)
+	// Version of addresses of scripts, outputs paying to them are locked with pay-to-script-hash scripts.
+	ScriptHashVersion = byte(0x05)
 )
 
 type Wallet struct {
@@ -22,21 +29,63 @@ type Wallet struct {
 	PublicKey  []byte
 }
 
+// Encoded form of a wallet. Only the private scalar is stored because
+// the elliptic curve of a key can't be encoded by gob.
+type walletData struct {
+	PrivateKey []byte
+	PublicKey  []byte
+}
+
+// Encodes a wallet using gob encoder.
+func (w *Wallet) GobEncode() ([]byte, error) {
+	var content bytes.Buffer
+	data := walletData{w.PrivateKey.D.Bytes(), w.PublicKey}
+	err := gob.NewEncoder(&content).Encode(data)
+	return content.Bytes(), err
+}
+
+// Decodes a wallet encoded by GobEncode.
+func (w *Wallet) GobDecode(content []byte) error {
+	var data walletData
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data); err != nil {
+		return err
+	}
+
+	curve := elliptic.P256()
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(data.PrivateKey)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(data.PrivateKey)
+
+	w.PrivateKey = private
+	w.PublicKey = data.PublicKey
+	return nil
+}
+
 func (w Wallet) Address() []byte {
-	pubHash := PublicKeyHash(w.PublicKey)
-	versionedHash := append([]byte{version}, pubHash...)
+	return encodeAddress(PubKeyHashVersion, PublicKeyHash(w.PublicKey))
+}
+
+// Creates an address of a public key from the hash of the public key.
+func PubKeyHashAddress(pubKeyHash []byte) []byte {
+	return encodeAddress(PubKeyHashVersion, pubKeyHash)
+}
+
+// Creates an address of a script from the hash of the script.
+func ScriptAddress(scriptHash []byte) []byte {
+	return encodeAddress(ScriptHashVersion, scriptHash)
+}
+
+// Encodes a versioned hash with its checksum into an address.
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedHash := append([]byte{version}, hash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
-	address := Base58Encode(fullHash)
-
-	log.Debug().Msgf("pub key: %x", w.PublicKey)
-	log.Debug().Msgf("pub hash: %x", pubHash)
-	log.Debug().Msgf("address: %s", address)
-	return address
+	return Base58Encode(fullHash)
 }
 
 // Validates address by comparing checksum within an address with expected / computed checksum for the payload.
+// Malformed addresses and addresses of versions other than PubKeyHashVersion and ScriptHashVersion are not valid.
 //
 // Address:    14LErwM2aHhdsDym6PkyutyG9ZSm51UHXc
 // 			fmt.Printf("%x", wallet.Base58Decode([]byte("14LErwM2aHhdsDym6PkyutyG9ZSm51UHXc")))
@@ -45,10 +94,16 @@ func (w Wallet) Address() []byte {
 // PubKeyHash: 248bd9e7a51b7dd07aba9766a7c62d5020790280
 // Checksum:   2bc6c767
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
+	pubKeyHash, err := base58.Decode(address)
+	if err != nil || len(pubKeyHash) <= 1+ChecksumLength {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-ChecksumLength:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-ChecksumLength]
+	if version != PubKeyHashVersion && version != ScriptHashVersion {
+		return false
+	}
 	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 }
@@ -60,8 +115,12 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic().Err(err)
 	}
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pub
+	return *private, PublicKeyBytes(private.PublicKey)
+}
+
+// Serializes a public key as its x and y coordinates, each padded to 32 bytes.
+func PublicKeyBytes(pubKey ecdsa.PublicKey) []byte {
+	return append(pubKey.X.FillBytes(make([]byte, 32)), pubKey.Y.FillBytes(make([]byte, 32))...)
 }
 
 func MakeWallet() *Wallet {
@@ -86,3 +145,9 @@ func Checksum(payload []byte) []byte {
 	secondHash := sha256.Sum256(firstHash[:])
 	return secondHash[:ChecksumLength]
 }
+
+// Decodes an address into its version and the hash of a public key or a script.
+func DecodeAddress(address string) (byte, []byte) {
+	fullHash := Base58Decode([]byte(address))
+	return fullHash[0], fullHash[1 : len(fullHash)-ChecksumLength]
+}
@@ -2,27 +2,44 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"io/ioutil"
 	"os"
 
-	"github.com/rs/zerolog/log"
+	"github.com/pkg/errors"
 )
 
 // Wallets file name
 const walletFile = "./tmp/wallets.data"
 
-// Wallets struct with map of wallets
+// A wallets file starts with walletsMagic and the uint32 walletsVersion (big endian) followed by the gob encoded wallets.
+// Files written before the format was versioned hold only the gob encoded wallets.
+const (
+	walletsMagic   = "GOWALLET"
+	walletsVersion = 1
+)
+
+// Wallets struct with map of wallets and multisignature addresses
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets   map[string]*Wallet
+	MultiSigs map[string]*MultiSig
+
+	file string // file the wallets are loaded from and saved to
 }
 
 // Creates wallets struct containing wallets map.
 func CreateWallets() (*Wallets, error) {
-	wallets := Wallets{}
+	return LoadWallets(walletFile)
+}
+
+// Creates wallets struct with the wallets saved in a file, the file doesn't have to exist yet.
+// Returns an error satisfying os.IsNotExist together with empty wallets if it doesn't.
+func LoadWallets(file string) (*Wallets, error) {
+	wallets := Wallets{file: file}
 	wallets.Wallets = map[string]*Wallet{}
+	wallets.MultiSigs = map[string]*MultiSig{}
 	err := wallets.LoadFile()
 	return &wallets, err
 }
@@ -49,39 +66,79 @@ func (ws *Wallets) GetWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
+// Finds a wallet owning a public key hash.
+func (ws *Wallets) FindWallet(pubKeyHash []byte) (*Wallet, bool) {
+	for _, w := range ws.Wallets {
+		if bytes.Compare(PublicKeyHash(w.PublicKey), pubKeyHash) == 0 {
+			return w, true
+		}
+	}
+	return nil, false
+}
+
 // Loads a decoded wallets from a file.
 func (ws *Wallets) LoadFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	if _, err := os.Stat(ws.path()); os.IsNotExist(err) {
 		return err
 	}
 
 	var wallets Wallets
 
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := ioutil.ReadFile(ws.path())
 	if err != nil {
 		return err
 	}
 
-	gob.Register(elliptic.P256())
+	// unversioned files are read as they are and get a version when they are saved
+	versioned := bytes.HasPrefix(fileContent, []byte(walletsMagic))
+	if versioned {
+		if len(fileContent) < len(walletsMagic)+4 {
+			return errors.Errorf("wallets file %s is truncated", ws.path())
+		}
+		if version := binary.BigEndian.Uint32(fileContent[len(walletsMagic):]); version != walletsVersion {
+			return errors.Errorf("wallets file %s has unsupported version %d, it was written by a newer release", ws.path(), version)
+		}
+		fileContent = fileContent[len(walletsMagic)+4:]
+	}
+
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	if err = decoder.Decode(&wallets); err != nil {
-		return err
+		if !versioned {
+			return errors.Wrapf(err, "can't read wallets file %s, files of old releases storing keys with their elliptic curve are not supported", ws.path())
+		}
+		return errors.Wrapf(err, "failed to decode wallets file %s", ws.path())
 	}
 	ws.Wallets = wallets.Wallets
+	if wallets.MultiSigs != nil {
+		ws.MultiSigs = wallets.MultiSigs
+	}
 	return nil
 }
 
 // Saves an encoded wallets struct to the file
 func (ws *Wallets) SaveFile() {
 	var content bytes.Buffer
+	content.WriteString(walletsMagic)
+	binary.Write(&content, binary.BigEndian, uint32(walletsVersion))
 
-	gob.Register(elliptic.P256())
 	encoder := gob.NewEncoder(&content)
 	if err := encoder.Encode(ws); err != nil {
-		log.Panic().Err(err)
+		log.Panic().Err(err).Msg("failed to encode wallets")
 	}
 
-	if err := ioutil.WriteFile(walletFile, content.Bytes(), 0644); err != nil {
-		log.Panic().Err(err)
+	// the file holds private keys, only its owner may read it, WriteFile keeps the mode of existing files
+	if err := os.Chmod(ws.path(), 0600); err != nil && !os.IsNotExist(err) {
+		log.Panic().Err(err).Msg("failed to restrict access to wallets")
+	}
+	if err := ioutil.WriteFile(ws.path(), content.Bytes(), 0600); err != nil {
+		log.Panic().Err(err).Msg("failed to save wallets")
+	}
+}
+
+// Gets the file of the wallets, wallets created by other means than LoadWallets use the default file.
+func (ws *Wallets) path() string {
+	if ws.file == "" {
+		return walletFile
 	}
+	return ws.file
 }
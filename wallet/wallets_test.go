@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveAndLoadWallets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wallets.data")
+	wallets, err := LoadWallets(file)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a missing file to be reported, got %v", err)
+	}
+	address := wallets.AddWallet()
+	other := MakeWallet()
+	multiSig, err := wallets.AddMultiSig(1, [][]byte{wallets.Wallets[address].PublicKey, other.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an existing file readable by others is restricted to its owner
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wallets.SaveFile()
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("expected the wallets file to be readable by its owner only, got mode %o", mode)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte(walletsMagic)) || binary.BigEndian.Uint32(content[len(walletsMagic):]) != walletsVersion {
+		t.Errorf("expected the file to start with the format version")
+	}
+
+	loaded, err := LoadWallets(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, ok := loaded.Wallets[address]
+	if !ok || string(w.Address()) != address || w.PrivateKey.D.Cmp(wallets.Wallets[address].PrivateKey.D) != 0 {
+		t.Fatalf("expected wallet %s to be loaded with its key", address)
+	}
+	if !w.PrivateKey.PublicKey.Curve.IsOnCurve(w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y) {
+		t.Error("expected the public key to be restored from the private key")
+	}
+	if _, ok := loaded.MultiSigs[multiSig]; !ok {
+		t.Errorf("expected multisig address %s to be loaded", multiSig)
+	}
+}
+
+func TestLoadUnversionedWallets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wallets.data")
+	wallets := &Wallets{Wallets: map[string]*Wallet{}, MultiSigs: map[string]*MultiSig{}}
+	address := wallets.AddWallet()
+
+	var content bytes.Buffer
+	if err := gob.NewEncoder(&content).Encode(wallets); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, content.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadWallets(file)
+	if err != nil {
+		t.Fatalf("expected a file written before the format was versioned to be loaded, got %v", err)
+	}
+	if _, ok := loaded.Wallets[address]; !ok {
+		t.Fatalf("expected wallet %s to be loaded", address)
+	}
+
+	loaded.SaveFile()
+	converted, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(converted, []byte(walletsMagic)) {
+		t.Error("expected the file to get a version when it is saved")
+	}
+}
+
+func TestLoadUnsupportedWallets(t *testing.T) {
+	// old releases stored the keys with their curve
+	type legacyKey struct {
+		D *big.Int
+	}
+	type legacyWallet struct {
+		PrivateKey legacyKey
+		PublicKey  []byte
+	}
+	var legacy bytes.Buffer
+	err := gob.NewEncoder(&legacy).Encode(struct{ Wallets map[string]*legacyWallet }{
+		map[string]*legacyWallet{"address": {legacyKey{big.NewInt(1)}, []byte{1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newer bytes.Buffer
+	newer.WriteString(walletsMagic)
+	binary.Write(&newer, binary.BigEndian, uint32(walletsVersion+1))
+
+	tests := []struct {
+		content  []byte
+		expected string
+	}{
+		{legacy.Bytes(), "files of old releases storing keys with their elliptic curve are not supported"},
+		{newer.Bytes(), "unsupported version 2"},
+		{[]byte(walletsMagic), "is truncated"},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "wallets.data")
+		if err := ioutil.WriteFile(file, test.content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadWallets(file); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected loading to fail with %q, got %v", test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+const (
+	// Prefix of keys of receipts waiting for more confirmations, followed by the address and the transaction ID.
+	pendingPrefix = "webhook-pending-"
+	// Number of events buffered before the dispatcher falls behind and events are dropped.
+	eventsBuffer = 1024
+)
+
+// Events of payloads.
+const (
+	ReceivedEvent  = "received"  // an address received coins, sent once per transaction
+	ConfirmedEvent = "confirmed" // a transaction paying an address got another confirmation
+)
+
+// A Payload is the JSON body POSTed to webhooks.
+type Payload struct {
+	Event         string `json:"event"`
+	Address       string `json:"address"`
+	TxID          string `json:"txid"`
+	Amount        int    `json:"amount"`        // coins received by the address
+	Confirmations int    `json:"confirmations"` // zero for mempool transactions
+	BlockHash     string `json:"blockhash,omitempty"`
+	Height        int    `json:"height,omitempty"`
+}
+
+// A receipt is a transaction paying a watched address which is notified until it is confirmed deep enough.
+type receipt struct {
+	Address       string
+	TxID          []byte
+	Amount        int
+	BlockHash     []byte
+	Height        int // zero until the transaction is mined
+	Confirmations int // confirmations notified so far
+}
+
+// A Dispatcher notifies webhooks of watched addresses about coins they receive: once when a transaction
+// paying them is accepted into the mempool or mined, and on every confirmation up to Confirmations.
+// Failed deliveries are retried, every attempt is recorded in the delivery log.
+type Dispatcher struct {
+	chain         *blockchain.BlockChain
+	Confirmations int           // depth after which receipts are no longer notified
+	MaxAttempts   int           // attempts of a delivery before it is given up
+	RetryDelay    time.Duration // delay before the first retry, it doubles with every retry
+	Client        *http.Client
+
+	seq uint64 // orders log entries recorded at the same time
+	wg  sync.WaitGroup
+}
+
+// Creates a Dispatcher for webhooks of a chain notifying receipts until they have confirmations.
+func NewDispatcher(chain *blockchain.BlockChain, confirmations int) *Dispatcher {
+	return &Dispatcher{
+		chain:         chain,
+		Confirmations: confirmations,
+		MaxAttempts:   5,
+		RetryDelay:    time.Second,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Subscribes to chain events and dispatches notifications about them in the background until ctx is cancelled.
+// The returned channel is closed once pending deliveries finished, deliveries still being retried are given up.
+func (d *Dispatcher) Start(ctx context.Context) <-chan struct{} {
+	sub := d.chain.Subscribe(eventsBuffer)
+	log.Info().Int("confirmations", d.Confirmations).Msg("webhook dispatcher started")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case event := <-sub.C:
+				if err := d.handle(ctx, event); err != nil {
+					log.Error().Err(err).Str("event", string(event.Type)).Msg("failed to dispatch webhooks")
+				}
+			case <-ctx.Done():
+				d.wg.Wait()
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func (d *Dispatcher) handle(ctx context.Context, event blockchain.Event) error {
+	switch event.Type {
+	case blockchain.AddressActivity:
+		return d.handleActivity(ctx, event)
+	case blockchain.BlockConnected:
+		return d.handleBlock(ctx, event.Block)
+	}
+	return nil
+}
+
+// Notifies a transaction paying a watched address.
+func (d *Dispatcher) handleActivity(ctx context.Context, event blockchain.Event) error {
+	amount := 0
+	for _, out := range event.Tx.Outputs {
+		if address, ok := out.Address(); ok && address == event.Address {
+			amount += out.Value
+		}
+	}
+	if amount == 0 {
+		return nil
+	}
+	watches, err := Watches(d.chain.Database, event.Address)
+	if err != nil || len(watches) == 0 {
+		return err
+	}
+
+	store := d.chain.Database
+	key := pendingKey(event.Address, event.Tx.ID)
+	r, err := loadReceipt(store, key)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	found := err == nil
+	if found && (event.Block == nil || r.Height != 0) {
+		return nil
+	}
+
+	payloadEvent := ReceivedEvent
+	if found {
+		payloadEvent = ConfirmedEvent
+	} else {
+		r = &receipt{Address: event.Address, TxID: event.Tx.ID, Amount: amount}
+	}
+	if event.Block != nil {
+		r.BlockHash = event.Block.Hash
+		r.Height = event.Block.Height
+		r.Confirmations = 1
+	}
+
+	d.notify(ctx, watches, r, payloadEvent)
+	return d.saveReceipt(store, key, r)
+}
+
+// Notifies another confirmation of mined receipts.
+func (d *Dispatcher) handleBlock(ctx context.Context, block *blockchain.Block) error {
+	store := d.chain.Database
+	receipts := make(map[string]*receipt)
+	err := store.Iterate([]byte(pendingPrefix), func(key, value []byte) error {
+		var r receipt
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&r); err != nil {
+			return err
+		}
+		receipts[string(key)] = &r
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to load receipts")
+	}
+
+	for key, r := range receipts {
+		confirmations := block.Height - r.Height + 1
+		if r.Height == 0 || confirmations <= r.Confirmations {
+			continue
+		}
+		watches, err := Watches(store, r.Address)
+		if err != nil {
+			return err
+		}
+		r.Confirmations = confirmations
+		d.notify(ctx, watches, r, ConfirmedEvent)
+		if err := d.saveReceipt(store, []byte(key), r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stores a receipt until it is confirmed deep enough.
+func (d *Dispatcher) saveReceipt(store storage.Store, key []byte, r *receipt) error {
+	err := store.Update(func(batch storage.Batch) error {
+		if r.Confirmations >= d.Confirmations {
+			return batch.Delete(key)
+		}
+		var encoded bytes.Buffer
+		if err := gob.NewEncoder(&encoded).Encode(r); err != nil {
+			return err
+		}
+		return batch.Put(key, encoded.Bytes())
+	})
+	return errors.Wrap(err, "failed to store receipt")
+}
+
+func loadReceipt(store storage.Store, key []byte) (*receipt, error) {
+	value, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var r receipt
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&r); err != nil {
+		return nil, errors.Wrap(err, "failed to decode receipt")
+	}
+	return &r, nil
+}
+
+func pendingKey(address string, txID []byte) []byte {
+	return []byte(pendingPrefix + address + "-" + hex.EncodeToString(txID))
+}
+
+// Delivers the payload of a receipt to every watch in the background.
+func (d *Dispatcher) notify(ctx context.Context, watches []*Watch, r *receipt, event string) {
+	payload := Payload{
+		Event:         event,
+		Address:       r.Address,
+		TxID:          hex.EncodeToString(r.TxID),
+		Amount:        r.Amount,
+		Confirmations: r.Confirmations,
+		Height:        r.Height,
+	}
+	if r.BlockHash != nil {
+		payload.BlockHash = hex.EncodeToString(r.BlockHash)
+	}
+	for _, watch := range watches {
+		d.wg.Add(1)
+		go func(watch *Watch) {
+			defer d.wg.Done()
+			d.deliver(ctx, watch, payload)
+		}(watch)
+	}
+}
+
+// POSTs a signed payload to a webhook, retrying with a growing delay until it is accepted with a 2xx status.
+func (d *Dispatcher) deliver(ctx context.Context, watch *Watch, payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode webhook payload")
+		return
+	}
+
+	delay := d.RetryDelay
+	for attempt := 1; ; attempt++ {
+		entry := &Delivery{
+			Time:          time.Now().Unix(),
+			URL:           watch.URL,
+			Address:       payload.Address,
+			TxID:          payload.TxID,
+			Event:         payload.Event,
+			Confirmations: payload.Confirmations,
+			Attempt:       attempt,
+		}
+		entry.StatusCode, err = d.post(ctx, watch, body)
+		if err != nil {
+			entry.Error = err.Error()
+		} else if entry.StatusCode < 200 || entry.StatusCode >= 300 {
+			entry.Error = fmt.Sprintf("webhook responded with status %d", entry.StatusCode)
+		} else {
+			entry.Delivered = true
+		}
+		if err := d.record(entry); err != nil {
+			log.Error().Err(err).Msg("failed to record webhook delivery")
+		}
+
+		if entry.Delivered {
+			log.Debug().Str("url", watch.URL).Str("txid", payload.TxID).Int("confirmations", payload.Confirmations).Msg("webhook delivered")
+			return
+		}
+		if attempt >= d.MaxAttempts {
+			log.Warn().Str("url", watch.URL).Str("txid", payload.TxID).Str("error", entry.Error).Msg("webhook delivery given up")
+			return
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, watch *Watch, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, watch.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(watch.Secret, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Records a delivery attempt in the delivery log.
+func (d *Dispatcher) record(entry *Delivery) error {
+	return addDelivery(d.chain.Database, entry, atomic.AddUint64(&d.seq, 1))
+}
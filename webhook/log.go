@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+// Prefix of keys of the delivery log, followed by the time of the attempt, so keys are in order.
+const deliveryPrefix = "webhook-delivery-"
+
+// A Delivery is an attempt to deliver a payload to a webhook.
+type Delivery struct {
+	Time          int64
+	URL           string
+	Address       string
+	TxID          string
+	Event         string
+	Confirmations int
+	Attempt       int
+	StatusCode    int    // zero if no response was received
+	Error         string // why the attempt failed
+	Delivered     bool
+}
+
+func (entry Delivery) String() string {
+	result := "delivered"
+	if !entry.Delivered {
+		result = "failed: " + entry.Error
+	}
+	return fmt.Sprintf("%s %s %s %s of %s (%d confirmations), attempt %d %s",
+		time.Unix(entry.Time, 0).Format(time.RFC3339), entry.URL, entry.Event, entry.TxID, entry.Address,
+		entry.Confirmations, entry.Attempt, result)
+}
+
+func addDelivery(store storage.Store, entry *Delivery, seq uint64) error {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(entry); err != nil {
+		return errors.Wrap(err, "failed to encode delivery")
+	}
+	key := []byte(fmt.Sprintf("%s%020d-%010d", deliveryPrefix, time.Now().UnixNano(), seq))
+	return store.Update(func(batch storage.Batch) error {
+		return batch.Put(key, encoded.Bytes())
+	})
+}
+
+// Gets up to limit latest entries of the delivery log, oldest first. All entries are returned if limit isn't positive.
+func Deliveries(store storage.Store, limit int) ([]*Delivery, error) {
+	var entries []*Delivery
+	err := store.Iterate([]byte(deliveryPrefix), func(key, value []byte) error {
+		var entry Delivery
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&entry); err != nil {
+			return err
+		}
+		entries = append(entries, &entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load delivery log")
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
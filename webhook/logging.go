@@ -0,0 +1,5 @@
+package webhook
+
+import "github.com/michaljirman/goblockchain/logger"
+
+var log = logger.Get(logger.Net)
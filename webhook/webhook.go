@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/michaljirman/goblockchain/storage"
+)
+
+const (
+	// Prefix of keys of watches, followed by the watched address and the webhook URL.
+	watchPrefix = "webhook-watch-"
+	// Header carrying the signature of a payload.
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// A Watch registers a webhook URL notified when a watched address receives coins.
+// Payloads are signed with the secret of the watch.
+type Watch struct {
+	Address string
+	URL     string
+	Secret  string // hex encoded key of the HMAC-SHA256 signature of payloads
+	Created int64
+}
+
+// Registers a webhook URL of an address in a store, returns the watch with a new secret.
+// Registering a URL again replaces its secret.
+func AddWatch(store storage.Store, address, webhookURL string) (*Watch, error) {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.Errorf("invalid webhook URL %s", webhookURL)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "failed to create webhook secret")
+	}
+	watch := &Watch{address, webhookURL, hex.EncodeToString(secret), time.Now().Unix()}
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(watch); err != nil {
+		return nil, errors.Wrap(err, "failed to encode watch")
+	}
+	err = store.Update(func(batch storage.Batch) error {
+		return batch.Put(watchKey(address, webhookURL), encoded.Bytes())
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store watch")
+	}
+	return watch, nil
+}
+
+// Removes a webhook URL of an address from a store.
+func RemoveWatch(store storage.Store, address, webhookURL string) error {
+	err := store.Update(func(batch storage.Batch) error {
+		key := watchKey(address, webhookURL)
+		if _, err := batch.Get(key); err != nil {
+			return err
+		}
+		return batch.Delete(key)
+	})
+	if err == storage.ErrNotFound {
+		return errors.Errorf("%s is not watched by %s", address, webhookURL)
+	}
+	return errors.Wrap(err, "failed to remove watch")
+}
+
+// Gets the watches of an address, or of all addresses if address is empty.
+func Watches(store storage.Store, address string) ([]*Watch, error) {
+	prefix := watchPrefix
+	if address != "" {
+		prefix += address + "-"
+	}
+
+	var watches []*Watch
+	err := store.Iterate([]byte(prefix), func(key, value []byte) error {
+		var watch Watch
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&watch); err != nil {
+			return err
+		}
+		watches = append(watches, &watch)
+		return nil
+	})
+	return watches, errors.Wrap(err, "failed to load watches")
+}
+
+// Base58 addresses contain no dashes, so a dash ends the address in the key.
+func watchKey(address, webhookURL string) []byte {
+	return []byte(watchPrefix + address + "-" + webhookURL)
+}
+
+// Signs a payload with the secret of a watch, the signature is sent in SignatureHeader.
+func Sign(secret string, payload []byte) string {
+	key, _ := hex.DecodeString(secret)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifies the signature of a payload received by a webhook.
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/michaljirman/goblockchain/blockchain"
+	"github.com/michaljirman/goblockchain/storage"
+	"github.com/michaljirman/goblockchain/wallet"
+)
+
+// A webhookServer records payloads with valid signatures, failing the first request.
+type webhookServer struct {
+	*httptest.Server
+	secret   string
+	mu       sync.Mutex
+	requests int
+	payloads chan Payload
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	server := &webhookServer{payloads: make(chan Payload, 16)}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.requests++
+		first := server.requests == 1
+		secret := server.secret
+		server.mu.Unlock()
+		if first {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if !Verify(secret, body, r.Header.Get(SignatureHeader)) {
+			t.Errorf("invalid signature of %s", body)
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid payload %s", body)
+		}
+		server.payloads <- payload
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *webhookServer) next(t *testing.T) Payload {
+	t.Helper()
+	select {
+	case payload := <-server.payloads:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	return Payload{}
+}
+
+func TestDispatcher(t *testing.T) {
+	miner := wallet.MakeWallet()
+	receiver := string(wallet.MakeWallet().Address())
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{string(miner.Address()): miner}}
+
+	params := blockchain.RegTestParams
+	params.CoinbaseMaturity = 0
+	chain, err := blockchain.NewBlockChain(storage.NewMemoryStore(), string(miner.Address()), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newWebhookServer(t)
+	watch, err := AddWatch(chain.Database, receiver, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.secret = watch.Secret
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatcher := NewDispatcher(chain, 2)
+	dispatcher.RetryDelay = 10 * time.Millisecond
+	done := dispatcher.Start(ctx)
+
+	rawTx, err := chain.CreateRawTransaction(string(miner.Address()), receiver, 30, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawTx.Sign(wallets)
+	if err := blockchain.NewMempool().Add(&rawTx.Tx, chain); err != nil {
+		t.Fatal(err)
+	}
+	// the first attempt fails, so the receipt arrives once it is retried
+	if payload := server.next(t); payload.Event != ReceivedEvent || payload.Amount != 30 || payload.Confirmations != 0 {
+		t.Fatalf("expected the receipt of 30 coins, got %+v", payload)
+	}
+
+	for confirmations := 1; confirmations <= 3; confirmations++ {
+		var txs []*blockchain.Transaction
+		if confirmations == 1 {
+			txs = append(txs, &rawTx.Tx)
+		}
+		if _, err := chain.MineBlock(ctx, string(miner.Address()), txs); err != nil {
+			t.Fatal(err)
+		}
+		if confirmations > 2 {
+			break
+		}
+		if payload := server.next(t); payload.Event != ConfirmedEvent || payload.Confirmations != confirmations || payload.Height != 1 {
+			t.Fatalf("expected confirmation %d of block 1, got %+v", confirmations, payload)
+		}
+	}
+
+	cancel()
+	<-done
+	select {
+	case payload := <-server.payloads:
+		t.Fatalf("confirmation beyond the depth was notified: %+v", payload)
+	default:
+	}
+
+	deliveries, err := Deliveries(chain.Database, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 4 || deliveries[0].Delivered || deliveries[0].StatusCode != http.StatusServiceUnavailable || !deliveries[1].Delivered || deliveries[1].Attempt != 2 {
+		t.Fatalf("expected a failed attempt followed by 3 deliveries, got %v", deliveries)
+	}
+}
+
+func TestWatches(t *testing.T) {
+	store := storage.NewMemoryStore()
+	address := string(wallet.MakeWallet().Address())
+	if _, err := AddWatch(store, address, "ftp://example.com"); err == nil {
+		t.Fatal("expected a URL which isn't HTTP to be rejected")
+	}
+	for _, url := range []string{"http://example.com/a", "http://example.com/b"} {
+		if _, err := AddWatch(store, address, url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := AddWatch(store, string(wallet.MakeWallet().Address()), "http://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if watches, _ := Watches(store, address); len(watches) != 2 {
+		t.Fatalf("expected 2 watches of the address, got %d", len(watches))
+	}
+	if err := RemoveWatch(store, address, "http://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveWatch(store, address, "http://example.com/a"); err == nil {
+		t.Fatal("expected removing a missing watch to fail")
+	}
+	if watches, _ := Watches(store, ""); len(watches) != 2 {
+		t.Fatalf("expected 2 watches, got %d", len(watches))
+	}
+}